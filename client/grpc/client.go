@@ -0,0 +1,279 @@
+// Package grpc is a client of the grpc api of the shortening service, built on the generated protocol stubs
+package grpc
+
+import (
+	"context"
+	"github.com/norby7/shortening-service/interfaceAdapters/grpc/protocol"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"time"
+)
+
+// idempotentMethods are the calls that give the same result when they are sent again, the only ones retried
+var idempotentMethods = map[string]bool{
+	"/protocol.UrlService/Get":              true,
+	"/protocol.UrlService/GetByCode":        true,
+	"/protocol.UrlService/GetTrash":         true,
+	"/protocol.UrlService/GetRules":         true,
+	"/protocol.UrlService/GetVariants":      true,
+	"/protocol.UrlService/GetCounter":       true,
+	"/protocol.UrlService/GetCounterByCode": true,
+	"/protocol.UrlService/GetAuditEvents":   true,
+	"/protocol.UrlService/GetWebhooks":      true,
+	"/protocol.UrlService/GetDeadLetters":   true,
+	"/protocol.UrlService/Update":           true,
+	"/protocol.UrlService/Patch":            true,
+	"/protocol.UrlService/SetRules":         true,
+}
+
+// Client calls the url service over one grpc connection, the errors it returns are grpc status errors, e.g.
+// status.Code(err) is codes.NotFound for unknown urls. The idempotent calls that fail with Unavailable are retried,
+// the others, e.g. Create or Resolve, could be applied twice and are never retried
+type Client struct {
+	Conn    *grpc.ClientConn
+	Service protocol.UrlServiceClient
+	options options
+}
+
+// NewClient creates a client of the service at the target address, e.g. localhost:3001, in plaintext unless a TLS
+// option is given. The connection is opened on the first call
+func NewClient(target string, opts ...Option) (*Client, error) {
+	c := &Client{options: defaultOptions()}
+	for _, opt := range opts {
+		opt(&c.options)
+	}
+
+	if c.options.err != nil {
+		return nil, c.options.err
+	}
+
+	creds := c.options.creds
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+
+	dialOptions := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(c.unaryInterceptor),
+		grpc.WithChainStreamInterceptor(c.streamInterceptor),
+	}, c.options.dialOptions...)
+
+	conn, err := grpc.NewClient(target, dialOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Conn = conn
+	c.Service = protocol.NewUrlServiceClient(conn)
+
+	return c, nil
+}
+
+// Close closes the connection of the client
+func (c *Client) Close() error {
+	return c.Conn.Close()
+}
+
+// Create calls Add to create a new url and returns it, the code is generated if it's empty
+func (c *Client) Create(ctx context.Context, u *protocol.Url) (*protocol.Url, error) {
+	return c.Service.Add(ctx, u)
+}
+
+// Get returns the url with the given id
+func (c *Client) Get(ctx context.Context, id int64) (*protocol.Url, error) {
+	return c.Service.Get(ctx, &protocol.UrlId{Value: id})
+}
+
+// GetByCode returns the url with the given code
+func (c *Client) GetByCode(ctx context.Context, code string) (*protocol.Url, error) {
+	return c.Service.GetByCode(ctx, &protocol.Code{Value: code})
+}
+
+// Delete moves the url with the given id to the trash
+func (c *Client) Delete(ctx context.Context, id int64) error {
+	_, err := c.Service.Delete(ctx, &protocol.UrlId{Value: id})
+	return err
+}
+
+// Restore moves the deleted url with the given id out of the trash and returns it
+func (c *Client) Restore(ctx context.Context, id int64) (*protocol.Url, error) {
+	return c.Service.Restore(ctx, &protocol.UrlId{Value: id})
+}
+
+// GetTrash returns the deleted urls that can still be restored, the most recently deleted first
+func (c *Client) GetTrash(ctx context.Context) ([]*protocol.Url, error) {
+	l, err := c.Service.GetTrash(ctx, &protocol.VoidResponse{})
+	if err != nil {
+		return nil, err
+	}
+
+	return l.Urls, nil
+}
+
+// Update changes the fields of the url with the id of u that are set and returns the updated url, use Patch to clear
+// fields
+func (c *Client) Update(ctx context.Context, u *protocol.Url) (*protocol.Url, error) {
+	return c.Service.Update(ctx, u)
+}
+
+// Patch changes the fields of the url with the id of u whose paths are given, e.g. max_clicks, and returns the updated url
+// The fields of the paths that are not set in u are cleared
+func (c *Client) Patch(ctx context.Context, u *protocol.Url, paths ...string) (*protocol.Url, error) {
+	return c.Service.Patch(ctx, &protocol.PatchUrlRequest{Url: u, UpdateMask: &fieldmaskpb.FieldMask{Paths: paths}})
+}
+
+// GetRules returns the ordered redirect rules of the url with the given id
+func (c *Client) GetRules(ctx context.Context, id int64) ([]*protocol.Rule, error) {
+	r, err := c.Service.GetRules(ctx, &protocol.UrlId{Value: id})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Rules, nil
+}
+
+// SetRules replaces the redirect rules of the url with the given id and returns the updated url
+func (c *Client) SetRules(ctx context.Context, id int64, rules []*protocol.Rule) (*protocol.Url, error) {
+	return c.Service.SetRules(ctx, &protocol.UrlRules{Id: id, Rules: rules})
+}
+
+// GetVariants returns the weighted destinations of the url with the given id with their counters
+func (c *Client) GetVariants(ctx context.Context, id int64) ([]*protocol.Variant, error) {
+	v, err := c.Service.GetVariants(ctx, &protocol.UrlId{Value: id})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.Variants, nil
+}
+
+// SetVariants replaces the weighted destinations of the url with the given id and returns the updated url
+func (c *Client) SetVariants(ctx context.Context, id int64, variants []*protocol.Variant) (*protocol.Url, error) {
+	return c.Service.SetVariants(ctx, &protocol.UrlVariants{Id: id, Variants: variants})
+}
+
+// GetCounter returns the number of redirections of the url with the given id
+func (c *Client) GetCounter(ctx context.Context, id int64) (int64, error) {
+	counter, err := c.Service.GetCounter(ctx, &protocol.UrlId{Value: id})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter.Value, nil
+}
+
+// GetCounterByCode returns the number of redirections of the url with the given code
+func (c *Client) GetCounterByCode(ctx context.Context, code string) (int64, error) {
+	counter, err := c.Service.GetCounterByCode(ctx, &protocol.Code{Value: code})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter.Value, nil
+}
+
+// GetAuditEvents returns the audit events of the url changes matching the filter, the most recent first
+func (c *Client) GetAuditEvents(ctx context.Context, f *protocol.AuditFilter) ([]*protocol.AuditEvent, error) {
+	events, err := c.Service.GetAuditEvents(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return events.Events, nil
+}
+
+// Resolve resolves a short url request into the response a redirect server sends, the click is counted if it's recorded
+func (c *Client) Resolve(ctx context.Context, code *protocol.Code) (*protocol.Resolution, error) {
+	return c.Service.Resolve(ctx, code)
+}
+
+// WatchClicks streams the clicks counted from now on that match the filter, until the context is done
+// The stream isn't retried, a new one must be opened when it fails
+func (c *Client) WatchClicks(ctx context.Context, f *protocol.ClickFilter) (protocol.UrlService_WatchClicksClient, error) {
+	return c.Service.WatchClicks(ctx, f)
+}
+
+// AddWebhook subscribes a webhook to url events and returns it with its secret, the secret is generated if it's empty
+func (c *Client) AddWebhook(ctx context.Context, w *protocol.Webhook) (*protocol.Webhook, error) {
+	return c.Service.AddWebhook(ctx, w)
+}
+
+// GetWebhooks returns the webhooks, without their secrets
+func (c *Client) GetWebhooks(ctx context.Context) ([]*protocol.Webhook, error) {
+	w, err := c.Service.GetWebhooks(ctx, &protocol.VoidResponse{})
+	if err != nil {
+		return nil, err
+	}
+
+	return w.Webhooks, nil
+}
+
+// DeleteWebhook removes the webhook with the given id and its dead letters
+func (c *Client) DeleteWebhook(ctx context.Context, id int64) error {
+	_, err := c.Service.DeleteWebhook(ctx, &protocol.WebhookId{Value: id})
+	return err
+}
+
+// GetDeadLetters returns the events of the webhook with the given id that couldn't be delivered, of every webhook if
+// the id is 0
+func (c *Client) GetDeadLetters(ctx context.Context, webhookId int64) ([]*protocol.DeadLetter, error) {
+	d, err := c.Service.GetDeadLetters(ctx, &protocol.DeadLetterFilter{WebhookId: webhookId})
+	if err != nil {
+		return nil, err
+	}
+
+	return d.DeadLetters, nil
+}
+
+// ReplayDeadLetter delivers the dead letter with the given id again and removes it if the webhook accepts it
+func (c *Client) ReplayDeadLetter(ctx context.Context, id int64) error {
+	_, err := c.Service.ReplayDeadLetter(ctx, &protocol.DeadLetterId{Value: id})
+	return err
+}
+
+// unaryInterceptor adds the client metadata and deadline to the calls and retries the idempotent ones with a backoff
+// while they fail with Unavailable, until the attempts are exhausted or the context is done
+func (c *Client) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx = c.outgoingContext(ctx)
+
+	if _, ok := ctx.Deadline(); !ok && c.options.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.options.timeout)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil || status.Code(err) != codes.Unavailable || !idempotentMethods[method] || attempt >= c.options.maxAttempts {
+			return err
+		}
+
+		t := time.NewTimer(c.options.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// streamInterceptor adds the client metadata to the streams
+func (c *Client) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(c.outgoingContext(ctx), desc, cc, method, opts...)
+}
+
+// outgoingContext returns the context with the client metadata added to its outgoing metadata
+func (c *Client) outgoingContext(ctx context.Context) context.Context {
+	if len(c.options.md) == 0 {
+		return ctx
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+
+	return metadata.NewOutgoingContext(ctx, metadata.Join(c.options.md, md))
+}
@@ -0,0 +1,292 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/norby7/shortening-service/interfaceAdapters/certs/certstest"
+	"github.com/norby7/shortening-service/interfaceAdapters/grpc/protocol"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const bufSize = 1024 * 1024
+
+// fakeServer serves the url 1, fails the calls of the url 2 with Unavailable while failures is positive and never
+// answers the calls of the url 3. The urls created while failures is positive fail with Unavailable too
+type fakeServer struct {
+	protocol.UnimplementedUrlServiceServer
+	failures int32
+	calls    int32
+}
+
+func (s *fakeServer) Add(ctx context.Context, u *protocol.Url) (*protocol.Url, error) {
+	atomic.AddInt32(&s.calls, 1)
+
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		return nil, status.Error(codes.Unavailable, "storage unavailable")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("x-api-key"); len(v) > 0 {
+		u.Owner = v[0]
+	}
+
+	u.Id = 1
+	u.Code = "84gfj4i9"
+
+	return u, nil
+}
+
+func (s *fakeServer) Get(ctx context.Context, id *protocol.UrlId) (*protocol.Url, error) {
+	atomic.AddInt32(&s.calls, 1)
+
+	switch id.Value {
+	case 1:
+		return &protocol.Url{Id: 1, Code: "84gfj4i9", Url: "https://google.com"}, nil
+	case 2:
+		if atomic.AddInt32(&s.failures, -1) >= 0 {
+			return nil, status.Error(codes.Unavailable, "storage unavailable")
+		}
+
+		return &protocol.Url{Id: 2, Code: "a1b2c3d4", Url: "https://example.com"}, nil
+	case 3:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	return nil, status.Error(codes.NotFound, "url not found")
+}
+
+func (s *fakeServer) Delete(ctx context.Context, id *protocol.UrlId) (*protocol.VoidResponse, error) {
+	if id.Value != 1 {
+		return nil, status.Error(codes.NotFound, "url not found")
+	}
+
+	return &protocol.VoidResponse{}, nil
+}
+
+func (s *fakeServer) GetCounter(ctx context.Context, id *protocol.UrlId) (*protocol.Counter, error) {
+	return &protocol.Counter{Value: id.Value * 10}, nil
+}
+
+func (s *fakeServer) GetTrash(ctx context.Context, _ *protocol.VoidResponse) (*protocol.UrlList, error) {
+	return &protocol.UrlList{Urls: []*protocol.Url{{Id: 4}, {Id: 5}}}, nil
+}
+
+// newServer serves a fakeServer on an in-process listener until the test ends and returns a client of it
+func newServer(t *testing.T, serverOptions []grpc.ServerOption, opts ...Option) (*fakeServer, *Client) {
+	t.Helper()
+
+	lis := bufconn.Listen(bufSize)
+	fake := &fakeServer{}
+	s := grpc.NewServer(serverOptions...)
+	protocol.RegisterUrlServiceServer(s, fake)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	dialer := grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	})
+
+	c, err := NewClient("passthrough:///localhost", append(opts, WithDialOptions(dialer))...)
+	if err != nil {
+		t.Fatalf("unable to create the client: %s", err.Error())
+	}
+	t.Cleanup(func() { c.Close() })
+
+	return fake, c
+}
+
+func TestClient(t *testing.T) {
+	_, c := newServer(t, nil, WithAPIKey("secret"))
+	ctx := context.Background()
+
+	u, err := c.Create(ctx, &protocol.Url{Url: "https://google.com"})
+	if err != nil || u.Id != 1 || u.Owner != "secret" {
+		t.Errorf("expected the created url with the api key owner, got (%v) (%v)", u, err)
+	}
+
+	testCases := []struct {
+		name         string
+		id           int64
+		expectedCode codes.Code
+	}{
+		{name: "existing url", id: 1, expectedCode: codes.OK},
+		{name: "url not found", id: 9, expectedCode: codes.NotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := c.Get(ctx, tc.id)
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected code (%s), got (%v)", tc.expectedCode, err)
+			}
+
+			if err == nil && u.Id != tc.id {
+				t.Errorf("expected url (%d), got (%v)", tc.id, u)
+			}
+
+			if err = c.Delete(ctx, tc.id); status.Code(err) != tc.expectedCode {
+				t.Errorf("expected delete code (%s), got (%v)", tc.expectedCode, err)
+			}
+		})
+	}
+
+	if counter, err := c.GetCounter(ctx, 4); err != nil || counter != 40 {
+		t.Errorf("expected counter 40, got (%d) (%v)", counter, err)
+	}
+
+	if urls, err := c.GetTrash(ctx); err != nil || len(urls) != 2 {
+		t.Errorf("expected 2 deleted urls, got (%v) (%v)", urls, err)
+	}
+
+	if _, err = c.GetWebhooks(ctx); status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected an unimplemented call, got (%v)", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	testCases := []struct {
+		name          string
+		failures      int32
+		maxAttempts   int
+		expectedCode  codes.Code
+		expectedCalls int32
+	}{
+		{name: "no failure", maxAttempts: 3, expectedCode: codes.OK, expectedCalls: 1},
+		{name: "retried", failures: 2, maxAttempts: 3, expectedCode: codes.OK, expectedCalls: 3},
+		{name: "attempts exhausted", failures: 3, maxAttempts: 3, expectedCode: codes.Unavailable, expectedCalls: 3},
+		{name: "no retry", failures: 1, maxAttempts: 1, expectedCode: codes.Unavailable, expectedCalls: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake, c := newServer(t, nil, WithRetry(tc.maxAttempts, time.Millisecond, 2*time.Millisecond))
+			fake.failures = tc.failures
+
+			_, err := c.Get(context.Background(), 2)
+			if status.Code(err) != tc.expectedCode {
+				t.Errorf("expected code (%s), got (%v)", tc.expectedCode, err)
+			}
+
+			if calls := atomic.LoadInt32(&fake.calls); calls != tc.expectedCalls {
+				t.Errorf("expected (%d) calls, got (%d)", tc.expectedCalls, calls)
+			}
+		})
+	}
+
+	// the calls that aren't idempotent are never retried
+	fake, c := newServer(t, nil, WithRetry(3, time.Millisecond, 2*time.Millisecond))
+	fake.failures = 1
+
+	if _, err := c.Create(context.Background(), &protocol.Url{Url: "https://google.com"}); status.Code(err) != codes.Unavailable || atomic.LoadInt32(&fake.calls) != 1 {
+		t.Errorf("expected a single unavailable create, got (%v) after (%d) calls", err, fake.calls)
+	}
+
+	// the retries stop with the context
+	fake, c = newServer(t, nil, WithRetry(10, time.Hour, time.Hour))
+	fake.failures = 10
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.Get(ctx, 2); status.Code(err) != codes.Unavailable || atomic.LoadInt32(&fake.calls) != 1 {
+		t.Errorf("expected a single unavailable call, got (%v) after (%d) calls", err, fake.calls)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	_, c := newServer(t, nil, WithTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	if _, err := c.Get(context.Background(), 3); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected the call to exceed its deadline, got (%v)", err)
+	}
+
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected the call to stop after the timeout, took (%s)", d)
+	}
+
+	// the deadline of the context is kept
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, c = newServer(t, nil, WithTimeout(time.Hour))
+	if _, err := c.Get(ctx, 3); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("expected the call to exceed the context deadline, got (%v)", err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	ca := certstest.NewCA(t, "test ca")
+	serverCert, _, _ := ca.Issue(t, "localhost", true)
+	_, certPEM, keyPEM := ca.Issue(t, "client", false)
+
+	dir := t.TempDir()
+	caFile := certstest.WriteFile(t, dir, "ca.pem", ca.PEM)
+	certFile := certstest.WriteFile(t, dir, "client.pem", certPEM)
+	keyFile := certstest.WriteFile(t, dir, "client-key.pem", keyPEM)
+
+	serverTLS := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.Pool(),
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+
+	_, c := newServer(t, []grpc.ServerOption{grpc.Creds(serverTLS)}, WithTLSFiles(caFile, certFile, keyFile))
+	if u, err := c.Get(context.Background(), 1); err != nil || u.Id != 1 {
+		t.Errorf("expected the url over mutual tls, got (%v) (%v)", u, err)
+	}
+
+	// the server rejects the clients without a certificate
+	_, c = newServer(t, []grpc.ServerOption{grpc.Creds(serverTLS)}, WithTLSFiles(caFile, "", ""), WithRetry(1, 0, 0))
+	if _, err := c.Get(context.Background(), 1); err == nil {
+		t.Errorf("expected the call without a client certificate to fail")
+	}
+
+	testCases := []struct {
+		name string
+		opt  Option
+	}{
+		{name: "missing CA file", opt: WithTLSFiles(dir+"/missing.pem", "", "")},
+		{name: "invalid CA file", opt: WithTLSFiles(keyFile, "", "")},
+		{name: "missing key", opt: WithTLSFiles(caFile, certFile, "")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewClient("localhost:3001", tc.opt); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	o := options{retryBackoff: 100 * time.Millisecond, maxBackoff: time.Second}
+
+	testCases := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 1, expected: 100 * time.Millisecond},
+		{attempt: 2, expected: 200 * time.Millisecond},
+		{attempt: 4, expected: 800 * time.Millisecond},
+		{attempt: 5, expected: time.Second},
+		{attempt: 30, expected: time.Second},
+	}
+
+	for _, tc := range testCases {
+		if got := o.backoff(tc.attempt); got != tc.expected {
+			t.Errorf("attempt %d: expected (%s), got (%s)", tc.attempt, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"os"
+	"time"
+)
+
+// Default options of the client
+const (
+	DefaultTimeout      = time.Minute
+	DefaultMaxAttempts  = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff   = 2 * time.Second
+)
+
+// options are the connection and call options of a client
+type options struct {
+	creds        credentials.TransportCredentials
+	md           metadata.MD
+	timeout      time.Duration
+	maxAttempts  int
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+	dialOptions  []grpc.DialOption
+	err          error
+}
+
+// Option changes an option of the client
+type Option func(*options)
+
+// defaultOptions returns the options of a plaintext client with the default timeout and retries
+func defaultOptions() options {
+	return options{
+		md:           metadata.MD{},
+		timeout:      DefaultTimeout,
+		maxAttempts:  DefaultMaxAttempts,
+		retryBackoff: DefaultRetryBackoff,
+		maxBackoff:   DefaultMaxBackoff,
+	}
+}
+
+// WithTLS connects over TLS with the given configuration, set its Certificates to authenticate with a client certificate
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *options) {
+		o.creds = credentials.NewTLS(cfg)
+	}
+}
+
+// WithTLSFiles connects over TLS and verifies the server with the CA certificates of caFile, or the system ones if it's
+// empty. The client authenticates with the certificate of certFile and keyFile when they are set
+func WithTLSFiles(caFile, certFile, keyFile string) Option {
+	return func(o *options) {
+		cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+		if caFile != "" {
+			b, err := os.ReadFile(caFile)
+			if err != nil {
+				o.err = fmt.Errorf("unable to read the CA file: %s", err.Error())
+				return
+			}
+
+			cfg.RootCAs = x509.NewCertPool()
+			if !cfg.RootCAs.AppendCertsFromPEM(b) {
+				o.err = fmt.Errorf("no certificate found in the CA file (%s)", caFile)
+				return
+			}
+		}
+
+		if certFile != "" || keyFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				o.err = fmt.Errorf("unable to load the client certificate: %s", err.Error())
+				return
+			}
+
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+
+		o.creds = credentials.NewTLS(cfg)
+	}
+}
+
+// WithAPIKey sends the API key in the x-api-key metadata of every call, the changes made by the calls are recorded with
+// the actor of the key
+func WithAPIKey(key string) Option {
+	return WithMetadata("x-api-key", key)
+}
+
+// WithMetadata adds the key and value to the metadata of every call
+func WithMetadata(key, value string) Option {
+	return func(o *options) {
+		o.md.Append(key, value)
+	}
+}
+
+// WithTimeout sets the deadline of the calls whose context has none, retries included, 0 for no deadline
+// The WatchClicks streams have no deadline
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithRetry sets the number of attempts of the idempotent calls that fail with Unavailable, 1 to never retry them, and
+// the wait after the first failed attempt, doubled after every attempt up to maxBackoff
+func WithRetry(maxAttempts int, backoff, maxBackoff time.Duration) Option {
+	return func(o *options) {
+		o.maxAttempts = maxAttempts
+		o.retryBackoff = backoff
+		o.maxBackoff = maxBackoff
+	}
+}
+
+// WithDialOptions adds options to the connection, e.g. a custom dialer
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *options) {
+		o.dialOptions = append(o.dialOptions, opts...)
+	}
+}
+
+// backoff returns the wait after the given failed attempt, the retry backoff doubled on every attempt up to the
+// maximum backoff
+func (o *options) backoff(attempt int) time.Duration {
+	b := o.retryBackoff
+	for i := 1; i < attempt && b < o.maxBackoff; i++ {
+		b *= 2
+	}
+
+	if b > o.maxBackoff {
+		b = o.maxBackoff
+	}
+
+	return b
+}
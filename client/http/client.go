@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/go-playground/validator"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -46,7 +49,7 @@ func (c *Client) Create(r CreateRequest) (Url, error) {
 			return Url{}, err
 		}
 
-		return Url{}, fmt.Errorf("error calling the create endpoint: %s", errMsg)
+		return Url{}, fmt.Errorf("error calling the create endpoint: %s", errMsg.Message)
 	}
 
 	// decode response
@@ -59,7 +62,7 @@ func (c *Client) Create(r CreateRequest) (Url, error) {
 	return u, nil
 }
 
-// Delete calls the DELETE /api endpoint of the shortening service url that deletes the url with the given ID
+// Delete calls the DELETE /api endpoint of the shortening service url that moves the url with the given ID to the trash
 func (c *Client) Delete(id int64) error {
 	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/api/%d", c.BaseURL, id), nil)
 	if err != nil {
@@ -80,12 +83,304 @@ func (c *Client) Delete(id int64) error {
 			return err
 		}
 
-		return fmt.Errorf("error calling the delete endpoint: %s", errMsg)
+		return fmt.Errorf("error calling the delete endpoint: %s", errMsg.Message)
 	}
 
 	return nil
 }
 
+// Update calls the PATCH /api endpoint of the shortening service url that changes the fields of the url with the given ID
+// that are set in the request, the other fields keep their value. It returns an empty Url if the ID doesn't exist
+func (c *Client) Update(id int64, r UpdateRequest) (Url, error) {
+	// validate request
+	if err := r.Validate(); err != nil {
+		return Url{}, err
+	}
+
+	buf := new(bytes.Buffer)
+	err := r.ToJSON(buf)
+	if err != nil {
+		return Url{}, err
+	}
+
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/api/%d", c.BaseURL, id), buf)
+	if err != nil {
+		return Url{}, err
+	}
+
+	req.Header.Add("Content-type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return Url{}, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Url{}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errMsg ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&errMsg)
+		if err != nil {
+			return Url{}, err
+		}
+
+		return Url{}, fmt.Errorf("error calling the update endpoint: %s", errMsg.Message)
+	}
+
+	// decode response
+	var u Url
+	err = u.FromJSON(resp.Body)
+	if err != nil {
+		return Url{}, err
+	}
+
+	return u, nil
+}
+
+// SetRules calls the PUT /api/{id}/rules endpoint of the shortening service url that replaces the redirect rules of the url with the given ID
+// It returns an empty Url if the ID doesn't exist
+func (c *Client) SetRules(id int64, rules []Rule) (Url, error) {
+	// validate request
+	validate := validator.New()
+	for _, r := range rules {
+		if err := validate.Struct(r); err != nil {
+			return Url{}, err
+		}
+	}
+
+	if rules == nil {
+		rules = []Rule{}
+	}
+
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(rules)
+	if err != nil {
+		return Url{}, err
+	}
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/api/%d/rules", c.BaseURL, id), buf)
+	if err != nil {
+		return Url{}, err
+	}
+
+	req.Header.Add("Content-type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return Url{}, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Url{}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errMsg ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&errMsg)
+		if err != nil {
+			return Url{}, err
+		}
+
+		return Url{}, fmt.Errorf("error calling the rules endpoint: %s", errMsg.Message)
+	}
+
+	// decode response
+	var u Url
+	err = u.FromJSON(resp.Body)
+	if err != nil {
+		return Url{}, err
+	}
+
+	return u, nil
+}
+
+// SetVariants calls the PUT /api/{id}/variants endpoint of the shortening service url that replaces the weighted destinations of the url with the given ID
+// It returns an empty Url if the ID doesn't exist
+func (c *Client) SetVariants(id int64, variants []Variant) (Url, error) {
+	// validate request
+	validate := validator.New()
+	for _, v := range variants {
+		if err := validate.Struct(v); err != nil {
+			return Url{}, err
+		}
+	}
+
+	if variants == nil {
+		variants = []Variant{}
+	}
+
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(variants)
+	if err != nil {
+		return Url{}, err
+	}
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/api/%d/variants", c.BaseURL, id), buf)
+	if err != nil {
+		return Url{}, err
+	}
+
+	req.Header.Add("Content-type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return Url{}, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Url{}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errMsg ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&errMsg)
+		if err != nil {
+			return Url{}, err
+		}
+
+		return Url{}, fmt.Errorf("error calling the variants endpoint: %s", errMsg.Message)
+	}
+
+	// decode response
+	var u Url
+	err = u.FromJSON(resp.Body)
+	if err != nil {
+		return Url{}, err
+	}
+
+	return u, nil
+}
+
+// Restore calls the POST /api/{id}/restore endpoint of the shortening service url that moves the deleted url with the given ID out of the trash
+// It returns an empty Url if no deleted url exists with the ID
+func (c *Client) Restore(id int64) (Url, error) {
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/%d/restore", c.BaseURL, id), nil)
+	if err != nil {
+		return Url{}, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return Url{}, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return Url{}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errMsg ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&errMsg)
+		if err != nil {
+			return Url{}, err
+		}
+
+		return Url{}, fmt.Errorf("error calling the restore endpoint: %s", errMsg.Message)
+	}
+
+	// decode response
+	var u Url
+	err = u.FromJSON(resp.Body)
+	if err != nil {
+		return Url{}, err
+	}
+
+	return u, nil
+}
+
+// GetTrash calls the GET /api/trash endpoint of the shortening service url that returns the deleted urls that can still be restored
+func (c *Client) GetTrash() ([]Url, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/trash", c.BaseURL), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errMsg ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&errMsg)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("error calling the trash endpoint: %s", errMsg.Message)
+	}
+
+	// decode response
+	var urls []Url
+	err = json.NewDecoder(resp.Body).Decode(&urls)
+	if err != nil {
+		return nil, err
+	}
+
+	return urls, nil
+}
+
+// GetAuditEvents calls the GET /api/audit endpoint of the shortening service url that returns the recorded url changes matching the filter, the most recent first
+func (c *Client) GetAuditEvents(f AuditFilter) ([]AuditEvent, error) {
+	q := url.Values{}
+	if f.UrlId != 0 {
+		q.Set("urlId", strconv.FormatInt(f.UrlId, 10))
+	}
+
+	if f.Actor != "" {
+		q.Set("actor", f.Actor)
+	}
+
+	if f.From != nil {
+		q.Set("from", f.From.Format(time.RFC3339))
+	}
+
+	if f.Until != nil {
+		q.Set("until", f.Until.Format(time.RFC3339))
+	}
+
+	if f.Limit != 0 {
+		q.Set("limit", strconv.Itoa(f.Limit))
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/audit?%s", c.BaseURL, q.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errMsg ErrorResponse
+		err = json.NewDecoder(resp.Body).Decode(&errMsg)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("error calling the audit endpoint: %s", errMsg.Message)
+	}
+
+	// decode response
+	var events []AuditEvent
+	err = json.NewDecoder(resp.Body).Decode(&events)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
 // Get calls the GET /api endpoint of the shortening service url that returns the url object with the given ID
 func (c *Client) Get(id int64) (Url, error) {
 	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/%d", c.BaseURL, id), nil)
@@ -111,7 +406,7 @@ func (c *Client) Get(id int64) (Url, error) {
 			return Url{}, err
 		}
 
-		return Url{}, fmt.Errorf("error calling the delete endpoint: %s", errMsg)
+		return Url{}, fmt.Errorf("error calling the delete endpoint: %s", errMsg.Message)
 	}
 
 	// decode response
@@ -126,7 +421,7 @@ func (c *Client) Get(id int64) (Url, error) {
 
 // GetCounter calls the GET /counter endpoint of the shortening service url that returns the number of redirections for the given id
 func (c *Client) GetCounter(id int64) (int64, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/%d", c.BaseURL, id), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/counter/%d", c.BaseURL, id), nil)
 	if err != nil {
 		return 0, err
 	}
@@ -149,7 +444,7 @@ func (c *Client) GetCounter(id int64) (int64, error) {
 			return 0, err
 		}
 
-		return 0, fmt.Errorf("error calling the delete endpoint: %s", errMsg)
+		return 0, fmt.Errorf("error calling the delete endpoint: %s", errMsg.Message)
 	}
 
 	var cr CounterResponse
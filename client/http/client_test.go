@@ -6,6 +6,7 @@ import (
 	"path"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestCreate(t *testing.T) {
@@ -32,6 +33,8 @@ func TestCreate(t *testing.T) {
 
 	client := NewClient(svr.URL)
 
+	activeFrom := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
 	testCases := []struct {
 		name    string
 		input   CreateRequest
@@ -52,6 +55,26 @@ func TestCreate(t *testing.T) {
 			input:   CreateRequest{Url: "www.validUrl.com"},
 			isError: false,
 		},
+		{
+			name:    "negative max clicks",
+			input:   CreateRequest{Url: "www.validUrl.com", MaxClicks: -1},
+			isError: true,
+		},
+		{
+			name:    "one-time link request",
+			input:   CreateRequest{Url: "www.validUrl.com", BurnAfterReading: true},
+			isError: false,
+		},
+		{
+			name:    "scheduled request with fallback url",
+			input:   CreateRequest{Url: "www.validUrl.com", ActiveFrom: &activeFrom, FallbackUrl: "www.fallback.com"},
+			isError: false,
+		},
+		{
+			name:    "invalid fallback url",
+			input:   CreateRequest{Url: "www.validUrl.com", FallbackUrl: "a.b"},
+			isError: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -115,6 +138,312 @@ func TestDelete(t *testing.T){
 	}
 }
 
+func TestUpdate(t *testing.T){
+	svr := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-type", "application/json")
+
+		if r.Method != http.MethodPatch {
+			rw.WriteHeader(http.StatusMethodNotAllowed)
+			rw.Write([]byte(`{"code":12,"message":"Method Not Allowed"}`))
+			return
+		}
+
+		id, err := strconv.Atoi(path.Base(r.URL.String()))
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(`{"message": "invalid id value}`))
+			return
+		}
+
+		if id == -1 {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if id == 0 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(`{"message": "error updating id"}`))
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(`{"id":6,"code":"VgUJPzDN","url":"https://www.google.ro/search?q=some","shortUrl":"http://localhost:3000/VgUJPzDN","domain":"http://localhost:3000","counter":2,"redirectType":301}`))
+	}))
+
+	client := NewClient(svr.URL)
+
+	testCases := []struct {
+		name    string
+		id      int64
+		input   UpdateRequest
+		isError bool
+	}{
+		{
+			name:    "invalid redirect type",
+			id:      1,
+			input:   UpdateRequest{RedirectType: 303},
+			isError: true,
+		},
+		{
+			name:    "update request error",
+			id:      0,
+			input:   UpdateRequest{RedirectType: 301},
+			isError: true,
+		},
+		{
+			name:    "valid request",
+			id:      1,
+			input:   UpdateRequest{RedirectType: 301},
+			isError: false,
+		},
+		{
+			name:    "url not found",
+			id:      -1,
+			input:   UpdateRequest{RedirectType: 301},
+			isError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := client.Update(tc.id, tc.input)
+
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+		})
+	}
+}
+
+func TestSetRules(t *testing.T){
+	svr := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-type", "application/json")
+
+		id, err := strconv.Atoi(path.Base(path.Dir(r.URL.String())))
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(`{"message": "invalid id value}`))
+			return
+		}
+
+		if id == -1 {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if id == 0 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(`{"message": "error setting rules"}`))
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(`{"id":6,"code":"VgUJPzDN","url":"https://www.google.ro","shortUrl":"http://localhost:3000/VgUJPzDN","domain":"http://localhost:3000","counter":2,"rules":[{"url":"https://apps.apple.com/app","platforms":["ios"]}]}`))
+	}))
+
+	client := NewClient(svr.URL)
+
+	testCases := []struct {
+		name    string
+		id      int64
+		input   []Rule
+		isError bool
+	}{
+		{
+			name:    "invalid platform",
+			id:      1,
+			input:   []Rule{{Url: "https://apps.apple.com/app", Platforms: []string{"symbian"}}},
+			isError: true,
+		},
+		{
+			name:    "set rules request error",
+			id:      0,
+			input:   []Rule{{Url: "https://apps.apple.com/app", Platforms: []string{"ios"}}},
+			isError: true,
+		},
+		{
+			name:    "valid request",
+			id:      1,
+			input:   []Rule{{Url: "https://apps.apple.com/app", Platforms: []string{"ios"}}},
+			isError: false,
+		},
+		{
+			name:    "url not found",
+			id:      -1,
+			input:   nil,
+			isError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := client.SetRules(tc.id, tc.input)
+
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+		})
+	}
+}
+
+func TestSetVariants(t *testing.T){
+	svr := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-type", "application/json")
+
+		id, err := strconv.Atoi(path.Base(path.Dir(r.URL.String())))
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(`{"message": "invalid id value}`))
+			return
+		}
+
+		if id == -1 {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if id == 0 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(`{"message": "error setting variants"}`))
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(`{"id":6,"code":"VgUJPzDN","url":"https://www.google.ro","shortUrl":"http://localhost:3000/VgUJPzDN","domain":"http://localhost:3000","counter":2,"variants":[{"id":1,"url":"https://www.google.ro/a","weight":70,"counter":4},{"id":2,"url":"https://www.google.ro/b","weight":30}]}`))
+	}))
+
+	client := NewClient(svr.URL)
+
+	testCases := []struct {
+		name    string
+		id      int64
+		input   []Variant
+		isError bool
+	}{
+		{
+			name:    "negative weight",
+			id:      1,
+			input:   []Variant{{Url: "https://www.google.ro/a", Weight: -1}},
+			isError: true,
+		},
+		{
+			name:    "set variants request error",
+			id:      0,
+			input:   []Variant{{Url: "https://www.google.ro/a", Weight: 70}, {Url: "https://www.google.ro/b", Weight: 30}},
+			isError: true,
+		},
+		{
+			name:    "valid request",
+			id:      1,
+			input:   []Variant{{Url: "https://www.google.ro/a", Weight: 70}, {Url: "https://www.google.ro/b", Weight: 30}},
+			isError: false,
+		},
+		{
+			name:    "url not found",
+			id:      -1,
+			input:   nil,
+			isError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := client.SetVariants(tc.id, tc.input)
+
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T){
+	svr := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-type", "application/json")
+
+		id, err := strconv.Atoi(path.Base(path.Dir(r.URL.String())))
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(`{"message": "invalid id value}`))
+			return
+		}
+
+		if id == -1 {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if id == 0 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(`{"message": "error restoring id"}`))
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(`{"id":6,"code":"VgUJPzDN","url":"https://www.google.ro/search?q=some","shortUrl":"http://localhost:3000/VgUJPzDN","domain":"http://localhost:3000","counter":2}`))
+	}))
+
+	client := NewClient(svr.URL)
+
+	testCases := []struct {
+		name     string
+		input    int64
+		expected int64
+		isError  bool
+	}{
+		{
+			name:    "restore request error",
+			input:   0,
+			isError: true,
+		},
+		{
+			name:     "valid request",
+			input:    6,
+			expected: 6,
+			isError:  false,
+		},
+		{
+			name:     "deleted url not found",
+			input:    -1,
+			expected: 0,
+			isError:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := client.Restore(tc.input)
+
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+
+			if u.Id != tc.expected {
+				t.Errorf("expected url (%d), got (%d)", tc.expected, u.Id)
+			}
+		})
+	}
+}
+
+func TestGetTrash(t *testing.T){
+	svr := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-type", "application/json")
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(`[{"id":6,"code":"VgUJPzDN","url":"https://www.google.ro/search?q=some","shortUrl":"http://localhost:3000/VgUJPzDN","domain":"http://localhost:3000","counter":2,"deletedAt":"2022-01-01T00:00:00Z"}]`))
+	}))
+
+	client := NewClient(svr.URL)
+
+	urls, err := client.GetTrash()
+	if err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if len(urls) != 1 || urls[0].DeletedAt == nil {
+		t.Errorf("expected one deleted url, got (%v)", urls)
+	}
+}
+
 func TestGet(t *testing.T){
 	svr := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
 		rw.Header().Set("Content-type", "application/json")
@@ -198,6 +527,11 @@ func TestGetCounter(t *testing.T){
 			return
 		}
 
+		if path.Dir(r.URL.Path) != "/counter" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
 		rw.WriteHeader(http.StatusOK)
 		rw.Write([]byte(`{"counter":6}`))
 	}))
@@ -236,3 +570,33 @@ func TestGetCounter(t *testing.T){
 		})
 	}
 }
+
+func TestGetAuditEvents(t *testing.T){
+	svr := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-type", "application/json")
+		if r.URL.Query().Get("urlId") != "6" || r.URL.Query().Get("from") != "2022-01-01T00:00:00Z" || r.URL.Query().Get("limit") != "10" {
+			rw.WriteHeader(http.StatusBadRequest)
+			rw.Write([]byte(`{"message": "invalid audit filter"}`))
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(`[{"id":1,"urlId":6,"action":"update","actor":"anonymous","clientIp":"192.0.2.1","requestId":"req-1","before":{"id":6,"url":"https://google.com"},"after":{"id":6,"url":"https://google.ro"},"createdAt":"2022-01-02T00:00:00Z"}]`))
+	}))
+
+	client := NewClient(svr.URL)
+
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	events, err := client.GetAuditEvents(AuditFilter{UrlId: 6, From: &from, Limit: 10})
+	if err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if len(events) != 1 || events[0].Before.Url != "https://google.com" || events[0].After.Url != "https://google.ro" {
+		t.Errorf("expected one update event, got (%v)", events)
+	}
+
+	if _, err = client.GetAuditEvents(AuditFilter{}); err == nil {
+		t.Errorf("expected error for the rejected filter")
+	}
+}
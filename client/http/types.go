@@ -5,6 +5,7 @@ import (
 	"github.com/go-playground/validator"
 	"io"
 	"net/url"
+	"time"
 )
 
 type Url struct {
@@ -14,6 +15,29 @@ type Url struct {
 	ShortUrl string `json:"shortUrl"`
 	Domain string `json:"domain" validate:"required,min=8"`
 	Counter int64 `json:"counter" validate:"gte=0"`
+	CreatedAt time.Time `json:"createdAt"`
+	RedirectType int `json:"redirectType"`
+	ForwardQuery bool `json:"forwardQuery"`
+	PrefixMode bool `json:"prefixMode"`
+	Rules []Rule `json:"rules,omitempty"`
+	Variants []Variant `json:"variants,omitempty"`
+	MaxClicks int64 `json:"maxClicks"`
+	ActiveFrom *time.Time `json:"activeFrom,omitempty"`
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+	FallbackUrl string `json:"fallbackUrl,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	Owner string `json:"owner"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Rule is a conditional destination of a Url, evaluated in order before the default destination
+type Rule struct{
+	Url string `json:"url" validate:"required,min=8"`
+	Platforms []string `json:"platforms,omitempty" validate:"dive,oneof=ios android windows macos linux other"`
+	Languages []string `json:"languages,omitempty" validate:"dive,required"`
+	Countries []string `json:"countries,omitempty" validate:"dive,len=2"`
+	From *time.Time `json:"from,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
 }
 
 // Validate checks and validates each field of the Url object based on its definition
@@ -48,6 +72,15 @@ func (u *Url) FromJSON(r io.Reader) error {
 type CreateRequest struct{
 	Url string `json:"url" validate:"required,min=8"`
 	Code string `json:"code"`
+	RedirectType int `json:"redirectType,omitempty" validate:"omitempty,oneof=301 302 307 308"`
+	ForwardQuery bool `json:"forwardQuery,omitempty"`
+	PrefixMode bool `json:"prefixMode,omitempty"`
+	MaxClicks int64 `json:"maxClicks,omitempty" validate:"gte=0"`
+	BurnAfterReading bool `json:"burnAfterReading,omitempty"`
+	ActiveFrom *time.Time `json:"activeFrom,omitempty"`
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+	FallbackUrl string `json:"fallbackUrl,omitempty" validate:"omitempty,min=8"`
+	Tags []string `json:"tags,omitempty" validate:"max=20,dive,min=1,max=64"`
 }
 
 // ToJSON serializes the contents of the object to JSON
@@ -63,7 +96,64 @@ func (c *CreateRequest) Validate() error {
 	return validate.Struct(c)
 }
 
+// UpdateRequest contains the url fields to change, the fields that are not set keep their current value
+type UpdateRequest struct{
+	Url string `json:"url,omitempty" validate:"omitempty,min=8"`
+	RedirectType int `json:"redirectType,omitempty" validate:"omitempty,oneof=301 302 307 308"`
+	ForwardQuery *bool `json:"forwardQuery,omitempty"`
+	PrefixMode *bool `json:"prefixMode,omitempty"`
+	MaxClicks *int64 `json:"maxClicks,omitempty" validate:"omitempty,gte=0"`
+	ActiveFrom *time.Time `json:"activeFrom,omitempty"`
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+	FallbackUrl string `json:"fallbackUrl,omitempty" validate:"omitempty,min=8"`
+	Tags []string `json:"tags,omitempty" validate:"max=20,dive,min=1,max=64"`
+}
+
+// ToJSON serializes the contents of the object to JSON
+func (u *UpdateRequest) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(u)
+}
+
+// Validate checks and validates each field of the UpdateRequest object based on its definition
+func (u *UpdateRequest) Validate() error {
+	validate := validator.New()
+
+	return validate.Struct(u)
+}
+
+// Variant is a weighted destination of a Url, set Id to update an existing variant and keep its counter
+type Variant struct{
+	Id int64 `json:"id,omitempty"`
+	Url string `json:"url" validate:"required,min=8"`
+	Weight int `json:"weight" validate:"gte=0"`
+	Counter int64 `json:"counter,omitempty"`
+}
+
+// AuditEvent is a recorded change of a Url, Before is nil for create events
+type AuditEvent struct{
+	Id int64 `json:"id"`
+	UrlId int64 `json:"urlId"`
+	Action string `json:"action"`
+	Actor string `json:"actor"`
+	ClientIp string `json:"clientIp"`
+	RequestId string `json:"requestId"`
+	Before *Url `json:"before,omitempty"`
+	After *Url `json:"after,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AuditFilter selects the returned audit events, the empty fields match every event
+type AuditFilter struct{
+	UrlId int64
+	Actor string
+	From *time.Time
+	Until *time.Time
+	Limit int
+}
+
 type ErrorResponse struct{
+	Code int `json:"code"`
 	Message string `json:"message"`
 }
 
@@ -0,0 +1,446 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/norby7/shortening-service/interfaceAdapters/certs"
+	"github.com/norby7/shortening-service/interfaceAdapters/tracing"
+	"github.com/norby7/shortening-service/usecases/logging"
+	"github.com/norby7/shortening-service/usecases/repository"
+	ucService "github.com/norby7/shortening-service/usecases/service"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redacted replaces the secret values printed by Print
+const redacted = "********"
+
+// Config is the configuration shared by the servers
+// Every setting is named by its env tag in the environment and by its yaml and toml tags in a configuration file
+type Config struct {
+	// domain of the short urls, an absolute http or https url
+	Domain string `yaml:"domain" toml:"domain" env:"REDIRECT_DOMAIN"`
+	// time deleted urls are kept in the trash before they are purged
+	TrashRetention time.Duration  `yaml:"trashRetention" toml:"trashRetention" env:"TRASH_RETENTION"`
+	HTTP           HTTPConfig     `yaml:"http" toml:"http"`
+	GRPC           GRPCConfig     `yaml:"grpc" toml:"grpc"`
+	Database       DatabaseConfig `yaml:"database" toml:"database"`
+	Cache          CacheConfig    `yaml:"cache" toml:"cache"`
+	Counters       CountersConfig `yaml:"counters" toml:"counters"`
+	Timeouts       TimeoutsConfig `yaml:"timeouts" toml:"timeouts"`
+	Log            LogConfig      `yaml:"log" toml:"log"`
+	Tracing        TracingConfig  `yaml:"tracing" toml:"tracing"`
+	GeoIP          GeoIPConfig    `yaml:"geoip" toml:"geoip"`
+	TLS            TLSConfig      `yaml:"tls" toml:"tls"`
+	Webhooks       WebhooksConfig `yaml:"webhooks" toml:"webhooks"`
+}
+
+// HTTPConfig configures the http server
+type HTTPConfig struct {
+	// address the server binds to, every interface if empty
+	Address string `yaml:"address" toml:"address" env:"HTTP_ADDRESS"`
+	Port    int    `yaml:"port" toml:"port" env:"PORT"`
+}
+
+// GRPCConfig configures the grpc server
+type GRPCConfig struct {
+	// address the server binds to, every interface if empty
+	Address string `yaml:"address" toml:"address" env:"GRPC_ADDRESS"`
+	Port    int    `yaml:"port" toml:"port" env:"GRPC_PORT"`
+	// serve the grpc calls on the http port instead of the grpc port, when both servers run in the same process
+	Multiplex bool `yaml:"multiplex" toml:"multiplex" env:"GRPC_MULTIPLEX"`
+}
+
+// DatabaseConfig configures the sqlite storage
+type DatabaseConfig struct {
+	// path of the sqlite database file, created if it doesn't exist
+	Path string `yaml:"path" toml:"path" env:"DB_PATH"`
+	// maximum number of open sqlite connections
+	Workers int `yaml:"workers" toml:"workers" env:"COUNTER_WORKERS"`
+}
+
+// CacheConfig configures the redis cache, the service runs without it when redis is unavailable
+type CacheConfig struct {
+	Host     string `yaml:"host" toml:"host" env:"REDIS_HOSTNAME"`
+	Port     int    `yaml:"port" toml:"port" env:"REDIS_PORT"`
+	Password string `yaml:"password" toml:"password" env:"REDIS_PASSWORD" secret:"true"`
+}
+
+// CountersConfig configures how the clicks are queued and saved
+type CountersConfig struct {
+	QueueSize     int           `yaml:"queueSize" toml:"queueSize" env:"COUNTER_QUEUE_SIZE"`
+	FlushInterval time.Duration `yaml:"flushInterval" toml:"flushInterval" env:"COUNTER_FLUSH_INTERVAL"`
+	BatchSize     int           `yaml:"batchSize" toml:"batchSize" env:"COUNTER_BATCH_SIZE"`
+	// ucService.OverflowDrop or ucService.OverflowBlock
+	Overflow string `yaml:"overflow" toml:"overflow" env:"COUNTER_OVERFLOW"`
+	// ucService.CounterModeMemory or ucService.CounterModeRedis
+	Mode string `yaml:"mode" toml:"mode" env:"COUNTER_MODE"`
+	// number of click events buffered for every click feed subscriber before it's dropped
+	FeedBuffer int `yaml:"feedBuffer" toml:"feedBuffer" env:"CLICK_FEED_BUFFER"`
+}
+
+// TimeoutsConfig configures the maximum duration of a request and of its storage and cache calls
+type TimeoutsConfig struct {
+	Request time.Duration `yaml:"request" toml:"request" env:"REQUEST_TIMEOUT"`
+	Storage time.Duration `yaml:"storage" toml:"storage" env:"STORAGE_TIMEOUT"`
+	Cache   time.Duration `yaml:"cache" toml:"cache" env:"CACHE_TIMEOUT"`
+}
+
+// LogConfig configures the logger
+type LogConfig struct {
+	// logging.FormatJSON or logging.FormatText
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT"`
+	// debug, info, warn or error
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL"`
+}
+
+// TracingConfig configures the exported traces
+type TracingConfig struct {
+	// tracing.ExporterNone, tracing.ExporterOTLP or tracing.ExporterStdout
+	Exporter    string `yaml:"exporter" toml:"exporter" env:"OTEL_TRACES_EXPORTER"`
+	ServiceName string `yaml:"serviceName" toml:"serviceName" env:"OTEL_SERVICE_NAME"`
+}
+
+// GeoIPConfig configures the geoip database used by the country redirect rules
+type GeoIPConfig struct {
+	// path of the MaxMind database, the country rules never match if empty
+	DBPath string `yaml:"dbPath" toml:"dbPath" env:"GEOIP_DB_PATH"`
+}
+
+// TLSConfig configures the tls certificates of both servers, they serve in plaintext without a certificate
+// The files are loaded again when they change, without restarting the servers
+type TLSConfig struct {
+	CertFile string `yaml:"certFile" toml:"certFile" env:"TLS_CERT_FILE"`
+	KeyFile  string `yaml:"keyFile" toml:"keyFile" env:"TLS_KEY_FILE"`
+	// CA certificates of the api clients, the grpc and REST api clients must send a certificate signed by them if set
+	ClientCAFile string `yaml:"clientCAFile" toml:"clientCAFile" env:"TLS_CLIENT_CA_FILE"`
+	// comma separated identities of the client certificates allowed to call the grpc and REST apis, every client if empty
+	AllowedClients string `yaml:"allowedClients" toml:"allowedClients" env:"TLS_ALLOWED_CLIENTS"`
+	// time between two checks of the certificate files
+	ReloadInterval time.Duration `yaml:"reloadInterval" toml:"reloadInterval" env:"TLS_RELOAD_INTERVAL"`
+}
+
+// Enabled reports whether the servers serve over tls
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// MutualTLS reports whether the grpc and REST api clients are authenticated by their certificate
+func (c TLSConfig) MutualTLS() bool {
+	return c.ClientCAFile != ""
+}
+
+// Clients returns the identities of the allowed client certificates, empty if every client is allowed
+func (c TLSConfig) Clients() []string {
+	var clients []string
+	for _, name := range strings.Split(c.AllowedClients, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			clients = append(clients, name)
+		}
+	}
+
+	return clients
+}
+
+// WebhooksConfig configures the delivery of the url events to the webhooks
+type WebhooksConfig struct {
+	// number of concurrent deliveries
+	Workers int `yaml:"workers" toml:"workers" env:"WEBHOOK_WORKERS"`
+	// number of deliveries waiting for a worker, the events are dropped when it's full
+	QueueSize int `yaml:"queueSize" toml:"queueSize" env:"WEBHOOK_QUEUE_SIZE"`
+	// number of deliveries of an event before it's dead lettered
+	MaxAttempts int `yaml:"maxAttempts" toml:"maxAttempts" env:"WEBHOOK_MAX_ATTEMPTS"`
+	// wait before the first retry, doubled on every retry up to MaxBackoff
+	RetryBackoff time.Duration `yaml:"retryBackoff" toml:"retryBackoff" env:"WEBHOOK_RETRY_BACKOFF"`
+	MaxBackoff   time.Duration `yaml:"maxBackoff" toml:"maxBackoff" env:"WEBHOOK_MAX_BACKOFF"`
+	// maximum duration of a delivery
+	Timeout time.Duration `yaml:"timeout" toml:"timeout" env:"WEBHOOK_TIMEOUT"`
+}
+
+// Default returns the configuration used for the settings that are not set
+func Default() Config {
+	return Config{
+		Domain:         "http://localhost:3000",
+		TrashRetention: ucService.DefaultTrashRetention,
+		HTTP:           HTTPConfig{Port: 3000},
+		GRPC:           GRPCConfig{Address: "localhost", Port: 50051},
+		Database:       DatabaseConfig{Path: "./database/sqlite/urls.db", Workers: 10},
+		Cache:          CacheConfig{Port: 6379},
+		Counters: CountersConfig{
+			QueueSize:     ucService.DefaultCounterQueueSize,
+			FlushInterval: ucService.DefaultCounterFlushInterval,
+			BatchSize:     ucService.DefaultCounterBatchSize,
+			Overflow:      ucService.OverflowDrop,
+			Mode:          ucService.CounterModeMemory,
+			FeedBuffer:    ucService.DefaultClickFeedBuffer,
+		},
+		Timeouts: TimeoutsConfig{
+			Request: ucService.DefaultRequestTimeout,
+			Storage: repository.DefaultStorageTimeout,
+			Cache:   repository.DefaultCacheTimeout,
+		},
+		Log:     LogConfig{Format: logging.FormatJSON, Level: "info"},
+		Tracing: TracingConfig{Exporter: tracing.ExporterNone},
+		TLS:     TLSConfig{ReloadInterval: certs.DefaultReloadInterval},
+		Webhooks: WebhooksConfig{
+			Workers:      ucService.DefaultWebhookWorkers,
+			QueueSize:    ucService.DefaultWebhookQueueSize,
+			MaxAttempts:  ucService.DefaultWebhookMaxAttempts,
+			RetryBackoff: ucService.DefaultWebhookRetryBackoff,
+			MaxBackoff:   ucService.DefaultWebhookMaxBackoff,
+			Timeout:      ucService.DefaultWebhookTimeout,
+		},
+	}
+}
+
+// Load returns cfg overridden by the configuration file at path, when path isn't empty, and then by the environment
+// The variables of the .env file in the working directory are added to the environment without replacing the set ones
+// The configuration file is a yaml (.yaml or .yml) or toml (.toml) file, unknown keys are rejected
+// An error listing every invalid setting is returned if the configuration isn't valid
+func Load(path string, cfg Config) (Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, fmt.Errorf("unable to load .env file: %s", err.Error())
+	}
+
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+
+	var problems []string
+	loadEnv(reflect.ValueOf(&cfg).Elem(), &problems)
+	problems = append(problems, cfg.problems()...)
+
+	if len(problems) > 0 {
+		return cfg, fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+
+	return cfg, nil
+}
+
+// Print writes every setting as an env variable assignment, in the order of the configuration fields
+// The secrets that are set are replaced by asterisks
+func (c Config) Print(w io.Writer) error {
+	var err error
+	walk(reflect.ValueOf(&c).Elem(), func(name string, v reflect.Value, f reflect.StructField) {
+		value := format(v)
+		if f.Tag.Get("secret") == "true" && value != "" {
+			value = redacted
+		}
+
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%s=%s\n", name, value)
+		}
+	})
+
+	return err
+}
+
+// loadFile decodes the yaml or toml configuration file into cfg
+func loadFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open configuration file: %s", err.Error())
+	}
+
+	defer f.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		d := yaml.NewDecoder(f)
+		d.KnownFields(true)
+		if err = d.Decode(cfg); err != nil && err != io.EOF {
+			return fmt.Errorf("unable to decode configuration file %s: %s", path, err.Error())
+		}
+	case ".toml":
+		md, err := toml.NewDecoder(f).Decode(cfg)
+		if err != nil {
+			return fmt.Errorf("unable to decode configuration file %s: %s", path, err.Error())
+		}
+
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("unable to decode configuration file %s: unknown key %s", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("unsupported configuration file %s, expected a .yaml, .yml or .toml file", path)
+	}
+
+	return nil
+}
+
+// loadEnv sets the fields of v from their env variables that are set and not empty
+// The variables that can't be parsed into their field are added to problems
+func loadEnv(v reflect.Value, problems *[]string) {
+	walk(v, func(name string, field reflect.Value, _ reflect.StructField) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+
+		if err := parse(field, value); err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: %s", name, err.Error()))
+		}
+	})
+}
+
+// walk calls fn for every field of the struct v that has an env tag, descending into the nested structs
+func walk(v reflect.Value, fn func(name string, field reflect.Value, f reflect.StructField)) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if name := f.Tag.Get("env"); name != "" {
+			fn(name, v.Field(i), f)
+		} else if f.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), fn)
+		}
+	}
+}
+
+// parse sets the field to the parsed value
+func parse(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration (%s)", value)
+		}
+
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer (%s)", value)
+		}
+
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean (%s)", value)
+		}
+
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+
+	return nil
+}
+
+// format returns the value of a field as it's written in the environment
+func format(v reflect.Value) string {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	return fmt.Sprint(v.Interface())
+}
+
+// problems returns the description of every invalid setting
+func (c Config) problems() []string {
+	var problems []string
+	add := func(name, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s: %s", name, fmt.Sprintf(format, args...)))
+	}
+
+	if u, err := url.Parse(c.Domain); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("REDIRECT_DOMAIN", "expected an absolute http or https url, got (%s)", c.Domain)
+	}
+
+	for name, port := range map[string]int{"PORT": c.HTTP.Port, "GRPC_PORT": c.GRPC.Port, "REDIS_PORT": c.Cache.Port} {
+		if port < 1 || port > 65535 {
+			add(name, "expected a port between 1 and 65535, got (%d)", port)
+		}
+	}
+
+	if c.HTTP.Port == c.GRPC.Port && !c.GRPC.Multiplex {
+		add("GRPC_PORT", "must be different from PORT (%d)", c.HTTP.Port)
+	}
+
+	if c.Database.Path == "" {
+		add("DB_PATH", "must be set")
+	}
+
+	positive := map[string]int64{
+		"COUNTER_WORKERS":        int64(c.Database.Workers),
+		"COUNTER_QUEUE_SIZE":     int64(c.Counters.QueueSize),
+		"COUNTER_BATCH_SIZE":     int64(c.Counters.BatchSize),
+		"COUNTER_FLUSH_INTERVAL": int64(c.Counters.FlushInterval),
+		"CLICK_FEED_BUFFER":      int64(c.Counters.FeedBuffer),
+		"TRASH_RETENTION":        int64(c.TrashRetention),
+		"REQUEST_TIMEOUT":        int64(c.Timeouts.Request),
+		"STORAGE_TIMEOUT":        int64(c.Timeouts.Storage),
+		"CACHE_TIMEOUT":          int64(c.Timeouts.Cache),
+		"TLS_RELOAD_INTERVAL":    int64(c.TLS.ReloadInterval),
+		"WEBHOOK_WORKERS":        int64(c.Webhooks.Workers),
+		"WEBHOOK_QUEUE_SIZE":     int64(c.Webhooks.QueueSize),
+		"WEBHOOK_MAX_ATTEMPTS":   int64(c.Webhooks.MaxAttempts),
+		"WEBHOOK_RETRY_BACKOFF":  int64(c.Webhooks.RetryBackoff),
+		"WEBHOOK_MAX_BACKOFF":    int64(c.Webhooks.MaxBackoff),
+		"WEBHOOK_TIMEOUT":        int64(c.Webhooks.Timeout),
+	}
+	for name, value := range positive {
+		if value <= 0 {
+			add(name, "must be positive")
+		}
+	}
+
+	if c.Counters.Overflow != ucService.OverflowDrop && c.Counters.Overflow != ucService.OverflowBlock {
+		add("COUNTER_OVERFLOW", "expected %s or %s, got (%s)", ucService.OverflowDrop, ucService.OverflowBlock, c.Counters.Overflow)
+	}
+
+	if c.Counters.Mode != ucService.CounterModeMemory && c.Counters.Mode != ucService.CounterModeRedis {
+		add("COUNTER_MODE", "expected %s or %s, got (%s)", ucService.CounterModeMemory, ucService.CounterModeRedis, c.Counters.Mode)
+	}
+
+	if f := strings.ToLower(c.Log.Format); f != logging.FormatJSON && f != logging.FormatText {
+		add("LOG_FORMAT", "expected %s or %s, got (%s)", logging.FormatJSON, logging.FormatText, c.Log.Format)
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		add("LOG_LEVEL", "expected debug, info, warn or error, got (%s)", c.Log.Level)
+	}
+
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout:
+	default:
+		add("OTEL_TRACES_EXPORTER", "expected %s, %s or %s, got (%s)", tracing.ExporterNone, tracing.ExporterOTLP, tracing.ExporterStdout, c.Tracing.Exporter)
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		add("TLS_KEY_FILE", "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if c.TLS.MutualTLS() && !c.TLS.Enabled() {
+		add("TLS_CLIENT_CA_FILE", "requires TLS_CERT_FILE")
+	}
+
+	if c.TLS.AllowedClients != "" && !c.TLS.MutualTLS() {
+		add("TLS_ALLOWED_CLIENTS", "requires TLS_CLIENT_CA_FILE")
+	}
+
+	if c.Webhooks.MaxBackoff < c.Webhooks.RetryBackoff {
+		add("WEBHOOK_MAX_BACKOFF", "must not be shorter than WEBHOOK_RETRY_BACKOFF (%s)", c.Webhooks.RetryBackoff)
+	}
+
+	// report the problems in a stable order, the checks above iterate over maps
+	sort.Strings(problems)
+
+	return problems
+}
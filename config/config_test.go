@@ -0,0 +1,289 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	testCases := []struct {
+		name          string
+		env           map[string]string
+		file          string
+		fileContent   string
+		expected      func(c *Config)
+		expectedError string
+	}{
+		{name: "defaults", expected: func(c *Config) {}},
+		{
+			name: "environment",
+			env: map[string]string{
+				"PORT":                   "8080",
+				"REDIRECT_DOMAIN":        "https://sho.rt",
+				"COUNTER_FLUSH_INTERVAL": "5s",
+				"REDIS_HOSTNAME":         "cache",
+				"COUNTER_MODE":           "redis",
+				"CLICK_FEED_BUFFER":      "20",
+			},
+			expected: func(c *Config) {
+				c.HTTP.Port = 8080
+				c.Domain = "https://sho.rt"
+				c.Counters.FlushInterval = 5 * time.Second
+				c.Cache.Host = "cache"
+				c.Counters.Mode = "redis"
+				c.Counters.FeedBuffer = 20
+			},
+		},
+		{
+			name: "yaml file",
+			file: "config.yaml",
+			fileContent: "domain: https://sho.rt\ngrpc:\n  port: 9000\ntimeouts:\n  request: 3s\n" +
+				"database:\n  path: /data/urls.db\n",
+			expected: func(c *Config) {
+				c.Domain = "https://sho.rt"
+				c.GRPC.Port = 9000
+				c.Timeouts.Request = 3 * time.Second
+				c.Database.Path = "/data/urls.db"
+			},
+		},
+		{
+			name:        "toml file",
+			file:        "config.toml",
+			fileContent: "domain = \"https://sho.rt\"\n[cache]\nhost = \"cache\"\npassword = \"secret\"\n[counters]\nflushInterval = \"2s\"\n",
+			expected: func(c *Config) {
+				c.Domain = "https://sho.rt"
+				c.Cache.Host = "cache"
+				c.Cache.Password = "secret"
+				c.Counters.FlushInterval = 2 * time.Second
+			},
+		},
+		{
+			name:        "environment overrides file",
+			env:         map[string]string{"GRPC_PORT": "9100"},
+			file:        "config.yml",
+			fileContent: "grpc:\n  port: 9000\n",
+			expected: func(c *Config) {
+				c.GRPC.Port = 9100
+			},
+		},
+		{
+			name: "tls file",
+			file: "config.yaml",
+			fileContent: "http:\n  address: 0.0.0.0\ngrpc:\n  address: 10.0.0.2\ntls:\n  certFile: /certs/server.crt\n" +
+				"  keyFile: /certs/server.key\n  clientCAFile: /certs/ca.crt\n  allowedClients: admin, ops\n  reloadInterval: 1m\n",
+			expected: func(c *Config) {
+				c.HTTP.Address = "0.0.0.0"
+				c.GRPC.Address = "10.0.0.2"
+				c.TLS = TLSConfig{
+					CertFile:       "/certs/server.crt",
+					KeyFile:        "/certs/server.key",
+					ClientCAFile:   "/certs/ca.crt",
+					AllowedClients: "admin, ops",
+					ReloadInterval: time.Minute,
+				}
+			},
+		},
+		{
+			name:        "webhooks file",
+			file:        "config.toml",
+			fileContent: "[webhooks]\nworkers = 2\nmaxAttempts = 8\nretryBackoff = \"500ms\"\nmaxBackoff = \"5m\"\n",
+			expected: func(c *Config) {
+				c.Webhooks.Workers = 2
+				c.Webhooks.MaxAttempts = 8
+				c.Webhooks.RetryBackoff = 500 * time.Millisecond
+				c.Webhooks.MaxBackoff = 5 * time.Minute
+			},
+		},
+		{
+			name:          "webhook backoff longer than the maximum",
+			env:           map[string]string{"WEBHOOK_RETRY_BACKOFF": "2m"},
+			expectedError: "WEBHOOK_MAX_BACKOFF: must not be shorter than WEBHOOK_RETRY_BACKOFF (2m0s)",
+		},
+		{
+			name:          "tls certificate without key",
+			env:           map[string]string{"TLS_CERT_FILE": "/certs/server.crt"},
+			expectedError: "TLS_KEY_FILE: TLS_CERT_FILE and TLS_KEY_FILE must be set together",
+		},
+		{
+			name:          "client CA without certificate",
+			env:           map[string]string{"TLS_CLIENT_CA_FILE": "/certs/ca.crt"},
+			expectedError: "TLS_CLIENT_CA_FILE: requires TLS_CERT_FILE",
+		},
+		{
+			name:          "allowed clients without client CA",
+			env:           map[string]string{"TLS_CERT_FILE": "/certs/server.crt", "TLS_KEY_FILE": "/certs/server.key", "TLS_ALLOWED_CLIENTS": "admin"},
+			expectedError: "TLS_ALLOWED_CLIENTS: requires TLS_CLIENT_CA_FILE",
+		},
+		{
+			name:          "invalid port",
+			env:           map[string]string{"PORT": "abc"},
+			expectedError: "PORT: invalid integer (abc)",
+		},
+		{
+			name:          "same ports",
+			env:           map[string]string{"PORT": "3000", "GRPC_PORT": "3000"},
+			expectedError: "GRPC_PORT: must be different from PORT (3000)",
+		},
+		{
+			name:          "invalid duration",
+			env:           map[string]string{"REQUEST_TIMEOUT": "10"},
+			expectedError: "REQUEST_TIMEOUT: invalid duration (10)",
+		},
+		{
+			name:          "negative duration",
+			env:           map[string]string{"STORAGE_TIMEOUT": "-1s"},
+			expectedError: "STORAGE_TIMEOUT: must be positive",
+		},
+		{
+			name:          "invalid domain",
+			env:           map[string]string{"REDIRECT_DOMAIN": "localhost:3000"},
+			expectedError: "REDIRECT_DOMAIN: expected an absolute http or https url",
+		},
+		{
+			name:          "invalid counter mode",
+			env:           map[string]string{"COUNTER_MODE": "disk"},
+			expectedError: "COUNTER_MODE: expected memory or redis, got (disk)",
+		},
+		{
+			name:          "invalid log level",
+			env:           map[string]string{"LOG_LEVEL": "verbose"},
+			expectedError: "LOG_LEVEL: expected debug, info, warn or error, got (verbose)",
+		},
+		{
+			name:          "invalid exporter",
+			env:           map[string]string{"OTEL_TRACES_EXPORTER": "jaeger"},
+			expectedError: "OTEL_TRACES_EXPORTER: expected none, otlp or stdout, got (jaeger)",
+		},
+		{
+			name:          "unknown file key",
+			file:          "config.yaml",
+			fileContent:   "domian: https://sho.rt\n",
+			expectedError: "field domian not found",
+		},
+		{
+			name:          "unknown toml key",
+			file:          "config.toml",
+			fileContent:   "[cache]\nhostname = \"cache\"\n",
+			expectedError: "unknown key cache.hostname",
+		},
+		{
+			name:          "unsupported file",
+			file:          "config.json",
+			fileContent:   "{}",
+			expectedError: "unsupported configuration file",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			path := ""
+			if tc.file != "" {
+				path = filepath.Join(t.TempDir(), tc.file)
+				if err := os.WriteFile(path, []byte(tc.fileContent), 0600); err != nil {
+					t.Fatalf("unable to write configuration file: %s", err.Error())
+				}
+			}
+
+			cfg, err := Load(path, Default())
+			if tc.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error (%s), got (%v)", tc.expectedError, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			expected := Default()
+			tc.expected(&expected)
+			if cfg != expected {
+				t.Errorf("expected (%+v), got (%+v)", expected, cfg)
+			}
+		})
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("PORT", "0")
+	t.Setenv("COUNTER_OVERFLOW", "wait")
+
+	_, err := Load("", Default())
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	for _, problem := range []string{"PORT: expected a port between 1 and 65535, got (0)", "COUNTER_OVERFLOW: expected drop or block, got (wait)"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected the error to contain (%s), got (%s)", problem, err.Error())
+		}
+	}
+}
+
+func TestTLSConfigClients(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{name: "every client", input: "", expected: nil},
+		{name: "list", input: "admin, ops,,spiffe://example.org/ci ", expected: []string{"admin", "ops", "spiffe://example.org/ci"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := TLSConfig{AllowedClients: tc.input}.Clients()
+			if strings.Join(got, "|") != strings.Join(tc.expected, "|") {
+				t.Errorf("expected clients (%v), got (%v)", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	testCases := []struct {
+		name     string
+		password string
+		expected string
+	}{
+		{name: "redacted secret", password: "huRnD@csMipzvD8", expected: "REDIS_PASSWORD=********\n"},
+		{name: "empty secret", expected: "REDIS_PASSWORD=\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Default()
+			cfg.Cache.Password = tc.password
+
+			var buf bytes.Buffer
+			if err := cfg.Print(&buf); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			out := buf.String()
+			if !strings.Contains(out, tc.expected) {
+				t.Errorf("expected (%s) in (%s)", tc.expected, out)
+			}
+
+			if tc.password != "" && strings.Contains(out, tc.password) {
+				t.Errorf("expected the password to be redacted, got (%s)", out)
+			}
+
+			for _, line := range []string{"REDIRECT_DOMAIN=http://localhost:3000\n", "PORT=3000\n", "REQUEST_TIMEOUT=10s\n"} {
+				if !strings.Contains(out, line) {
+					t.Errorf("expected (%s) in (%s)", line, out)
+				}
+			}
+		})
+	}
+}
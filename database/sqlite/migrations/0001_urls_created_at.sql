@@ -0,0 +1,2 @@
+alter table urls
+    add createdAt datetime default '';
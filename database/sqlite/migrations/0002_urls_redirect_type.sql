@@ -0,0 +1,2 @@
+alter table urls
+    add redirectType integer default 302;
@@ -0,0 +1,5 @@
+alter table urls
+    add forwardQuery integer default 0;
+
+alter table urls
+    add prefixMode integer default 0;
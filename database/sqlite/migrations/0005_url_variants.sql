@@ -0,0 +1,13 @@
+create table url_variants
+(
+    id      integer
+        constraint url_variants_pk
+            primary key autoincrement,
+    urlId   integer not null,
+    url     text    default '',
+    weight  integer default 0,
+    counter integer default 0
+);
+
+create index url_variants_url_id_index
+    on url_variants (urlId);
@@ -0,0 +1,2 @@
+alter table urls
+    add maxClicks integer default 0;
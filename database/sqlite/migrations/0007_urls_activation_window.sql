@@ -0,0 +1,6 @@
+alter table urls
+    add activeFrom datetime default null;
+alter table urls
+    add activeUntil datetime default null;
+alter table urls
+    add fallbackUrl text default '';
@@ -0,0 +1,5 @@
+alter table urls
+    add deletedAt datetime default null;
+
+create index urls_deleted_at_index
+    on urls (deletedAt);
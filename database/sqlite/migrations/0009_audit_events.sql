@@ -0,0 +1,37 @@
+create table audit_events
+(
+    id        integer
+        constraint audit_events_pk
+            primary key autoincrement,
+    urlId     integer not null,
+    action    text    not null,
+    actor     text    default '',
+    clientIp  text    default '',
+    requestId text    default '',
+    before    text    default '',
+    after     text    default '',
+    createdAt datetime not null
+);
+
+create index audit_events_url_id_index
+    on audit_events (urlId);
+
+create index audit_events_actor_index
+    on audit_events (actor);
+
+create index audit_events_created_at_index
+    on audit_events (createdAt);
+
+create trigger audit_events_no_update
+    before update
+    on audit_events
+begin
+    select raise(abort, 'audit events are append-only');
+end;
+
+create trigger audit_events_no_delete
+    before delete
+    on audit_events
+begin
+    select raise(abort, 'audit events are append-only');
+end;
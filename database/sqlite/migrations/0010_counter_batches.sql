@@ -0,0 +1,10 @@
+create table counter_batches
+(
+    id        text
+        constraint counter_batches_pk
+            primary key,
+    appliedAt datetime not null
+);
+
+create index counter_batches_applied_at_index
+    on counter_batches (appliedAt);
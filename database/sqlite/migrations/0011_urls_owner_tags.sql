@@ -0,0 +1,5 @@
+alter table urls
+    add owner text default '';
+
+alter table urls
+    add tags text default '';
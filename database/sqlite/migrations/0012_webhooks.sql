@@ -0,0 +1,25 @@
+create table webhooks
+(
+    id        integer
+        constraint webhooks_pk
+            primary key autoincrement,
+    url       text     not null,
+    secret    text     not null,
+    events    text     default '',
+    createdAt datetime not null
+);
+
+create table webhook_dead_letters
+(
+    id        integer
+        constraint webhook_dead_letters_pk
+            primary key autoincrement,
+    webhookId integer  not null,
+    event     text     not null,
+    attempts  integer  default 0,
+    lastError text     default '',
+    failedAt  datetime not null
+);
+
+create index webhook_dead_letters_webhook_id_index
+    on webhook_dead_letters (webhookId);
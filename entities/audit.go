@@ -0,0 +1,100 @@
+package entities
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// audited url actions
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+// AnonymousActor is the actor name of the requests sent without an API key
+const AnonymousActor = "anonymous"
+
+// CLIActor is the actor name of the changes made by the shortener commands that operate directly on the storage
+const CLIActor = "cli"
+
+// CertActorPrefix prefixes the identity of the clients authenticated by a certificate in their actor name
+const CertActorPrefix = "cert:"
+
+// Actor identifies who sent a request that changes a url
+type Actor struct {
+	// API key identifier of the caller or AnonymousActor
+	Name string
+	// ip address of the caller
+	ClientIp string
+	// id used to correlate the change with the request logs
+	RequestId string
+}
+
+// AuditEvent is an append-only record of a change made to a url
+type AuditEvent struct {
+	// the id of the event
+	Id int64 `json:"id"`
+	// the id of the changed url
+	UrlId int64 `json:"urlId"`
+	// the change made to the url
+	//
+	// enum: ["create","update","delete","restore"]
+	Action string `json:"action"`
+	// API key identifier of the caller or anonymous
+	Actor string `json:"actor"`
+	// ip address of the caller
+	ClientIp string `json:"clientIp"`
+	// id of the request that made the change
+	RequestId string `json:"requestId"`
+	// the url before the change, empty for create events
+	Before *Url `json:"before,omitempty"`
+	// the url after the change
+	After *Url `json:"after,omitempty"`
+	// the date and time of the change
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AuditFilter selects the audit events returned by a search, the empty fields match every event
+type AuditFilter struct {
+	// id of the changed url
+	UrlId int64
+	// actor that made the change
+	Actor string
+	// events created at or after this time
+	From *time.Time
+	// events created before this time
+	Until *time.Time
+	// maximum number of returned events, the most recent first
+	Limit int
+}
+
+// NewActor returns the actor of a request sent with the given API key, client ip and request id
+// The API key is stored as a hash prefix so the audit log doesn't leak credentials,
+// a random request id is generated if the request doesn't have one
+func NewActor(apiKey, clientIp, requestId string) Actor {
+	name := AnonymousActor
+	if apiKey != "" {
+		sum := sha256.Sum256([]byte(apiKey))
+		name = "apikey:" + hex.EncodeToString(sum[:])[:16]
+	}
+
+	if requestId == "" {
+		requestId = NewRequestId()
+	}
+
+	return Actor{Name: name, ClientIp: clientIp, RequestId: requestId}
+}
+
+// NewRequestId returns a random request id, used for the requests that don't send one
+func NewRequestId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
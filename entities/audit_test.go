@@ -0,0 +1,52 @@
+package entities
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewActor(t *testing.T) {
+	testCases := []struct {
+		name         string
+		apiKey       string
+		requestId    string
+		expectedName string
+	}{
+		{
+			name:         "anonymous",
+			expectedName: AnonymousActor,
+		},
+		{
+			name:         "api key",
+			apiKey:       "secret",
+			requestId:    "req-1",
+			expectedName: "apikey:2bb80d537b1da3e3",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := NewActor(tc.apiKey, "192.0.2.1", tc.requestId)
+
+			if a.Name != tc.expectedName {
+				t.Errorf("expected name (%s), got (%s)", tc.expectedName, a.Name)
+			}
+
+			if strings.Contains(a.Name, "secret") {
+				t.Errorf("expected the api key to be hashed, got (%s)", a.Name)
+			}
+
+			if a.ClientIp != "192.0.2.1" {
+				t.Errorf("expected client ip (192.0.2.1), got (%s)", a.ClientIp)
+			}
+
+			if tc.requestId != "" && a.RequestId != tc.requestId {
+				t.Errorf("expected request id (%s), got (%s)", tc.requestId, a.RequestId)
+			}
+
+			if a.RequestId == "" {
+				t.Errorf("expected a generated request id")
+			}
+		})
+	}
+}
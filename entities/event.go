@@ -0,0 +1,66 @@
+package entities
+
+import (
+	"time"
+)
+
+// ClickEvent is a counted redirect of a short url, it is sent to the click feed subscribers
+type ClickEvent struct {
+	// the id of the redirected url
+	UrlId int64 `json:"urlId"`
+	// short url code
+	Code string `json:"code"`
+	// id of the variant the redirect was sent to, 0 if the url has no variants
+	VariantId int64 `json:"variantId"`
+	// owner of the redirected url
+	Owner string `json:"owner"`
+	// tags of the redirected url
+	Tags []string `json:"tags"`
+	// the date and time of the redirect
+	Time time.Time `json:"time"`
+}
+
+// ClickFilter selects the click events sent to a subscriber, the empty fields match every event
+type ClickFilter struct {
+	// id of the redirected url
+	UrlId int64
+	// owner of the redirected url
+	Owner string
+	// tag of the redirected url
+	Tag string
+}
+
+// NewClickEvent returns the event of a redirect of the url to the variant with the given id at the given time
+func NewClickEvent(u *Url, variantId int64, t time.Time) ClickEvent {
+	return ClickEvent{
+		UrlId:     u.Id,
+		Code:      u.Code,
+		VariantId: variantId,
+		Owner:     u.Owner,
+		Tags:      u.Tags,
+		Time:      t.UTC(),
+	}
+}
+
+// Matches checks if the event is selected by the filter
+func (f *ClickFilter) Matches(e *ClickEvent) bool {
+	if f.UrlId != 0 && f.UrlId != e.UrlId {
+		return false
+	}
+
+	if f.Owner != "" && f.Owner != e.Owner {
+		return false
+	}
+
+	if f.Tag != "" {
+		for _, t := range e.Tags {
+			if t == f.Tag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,71 @@
+package entities
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClickFilterMatches(t *testing.T) {
+	u := Url{Id: 1, Code: "84gfj4i9", Owner: "apikey:2bb80d537b1da3e3", Tags: []string{"newsletter", "spring"}}
+	e := NewClickEvent(&u, 10, time.Now())
+
+	testCases := []struct {
+		name     string
+		filter   ClickFilter
+		expected bool
+	}{
+		{
+			name:     "empty filter",
+			filter:   ClickFilter{},
+			expected: true,
+		},
+		{
+			name:     "url id",
+			filter:   ClickFilter{UrlId: 1},
+			expected: true,
+		},
+		{
+			name:     "other url id",
+			filter:   ClickFilter{UrlId: 2},
+			expected: false,
+		},
+		{
+			name:     "owner",
+			filter:   ClickFilter{Owner: "apikey:2bb80d537b1da3e3"},
+			expected: true,
+		},
+		{
+			name:     "other owner",
+			filter:   ClickFilter{Owner: AnonymousActor},
+			expected: false,
+		},
+		{
+			name:     "tag",
+			filter:   ClickFilter{Tag: "spring"},
+			expected: true,
+		},
+		{
+			name:     "missing tag",
+			filter:   ClickFilter{Tag: "autumn"},
+			expected: false,
+		},
+		{
+			name:     "every field",
+			filter:   ClickFilter{UrlId: 1, Owner: "apikey:2bb80d537b1da3e3", Tag: "newsletter"},
+			expected: true,
+		},
+		{
+			name:     "one field doesn't match",
+			filter:   ClickFilter{UrlId: 1, Owner: AnonymousActor, Tag: "newsletter"},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.Matches(&e); got != tc.expected {
+				t.Errorf("expected match (%v), got (%v)", tc.expected, got)
+			}
+		})
+	}
+}
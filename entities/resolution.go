@@ -0,0 +1,42 @@
+package entities
+
+import (
+	"net"
+	"net/url"
+)
+
+// ResolveRequest contains the attributes of a short url request its destination is resolved with
+type ResolveRequest struct {
+	// Path follows the code in the short url, it's only accepted for urls in prefix mode
+	Path string
+	// Query is the short url request query string, it is merged into the destination of the urls with ForwardQuery
+	Query url.Values
+	// Visitor is matched against the url rules, its country is looked up from ClientIp when a rule needs it
+	Visitor  Visitor
+	ClientIp net.IP
+	// VariantId is the variant the visitor was assigned to by a previous request, 0 for a new visitor
+	VariantId int64
+	// RecordClick counts the redirect, the clicks limit of a url is checked either way
+	RecordClick bool
+}
+
+// Resolution is the response to a short url request
+type Resolution struct {
+	// UrlId is the id of the resolved url, 0 if no url exists with the code
+	UrlId int64
+	// Status is the http status code of the response: the url redirect type, 302 to the fallback url, 404 or 410
+	Status int
+	// Location is the destination of the redirects, empty for the 404 and 410 responses
+	Location string
+	// CacheControl is the Cache-Control header value of the response, empty if the response has no caching policy
+	CacheControl string
+	// VariantId is the variant the visitor is sent to, 0 if the destination is not a variant
+	VariantId int64
+	// NewVariant is set when the visitor was assigned to the variant by this request, the assignment has to be stored
+	NewVariant bool
+}
+
+// IsRedirect checks if the resolution is a redirect to its location
+func (r *Resolution) IsRedirect() bool {
+	return r.Location != ""
+}
@@ -0,0 +1,104 @@
+package entities
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Platforms detected from the request user agent
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformWindows = "windows"
+	PlatformMacOS   = "macos"
+	PlatformLinux   = "linux"
+	PlatformOther   = "other"
+)
+
+// Rule defines a conditional destination for a Url
+// A rule matches a request when all of its conditions match, empty conditions match every request
+type Rule struct {
+	// destination url used when the rule matches
+	//
+	// min: 8
+	Url string `json:"url" validate:"required,min=8"`
+	// platforms detected from the user agent, any of ios, android, windows, macos, linux, other
+	Platforms []string `json:"platforms,omitempty" validate:"dive,oneof=ios android windows macos linux other"`
+	// languages from the Accept-Language header, e.g. "en" matches "en-US" and "en-GB"
+	Languages []string `json:"languages,omitempty" validate:"dive,required"`
+	// ISO 3166-1 alpha-2 country codes of the client ip address, e.g. "RO"
+	Countries []string `json:"countries,omitempty" validate:"dive,len=2"`
+	// the rule only matches requests made at or after this time
+	From *time.Time `json:"from,omitempty"`
+	// the rule only matches requests made before this time
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Visitor contains the request attributes the rules are matched against
+type Visitor struct {
+	Platform  string
+	Languages []string
+	Country   string
+	Time      time.Time
+}
+
+// Matches checks if all the rule conditions match the visitor
+func (r *Rule) Matches(v Visitor) bool {
+	if len(r.Platforms) > 0 && !containsFold(r.Platforms, v.Platform) {
+		return false
+	}
+
+	if len(r.Countries) > 0 && (v.Country == "" || !containsFold(r.Countries, v.Country)) {
+		return false
+	}
+
+	if len(r.Languages) > 0 && !r.matchesLanguage(v.Languages) {
+		return false
+	}
+
+	if r.From != nil && v.Time.Before(*r.From) {
+		return false
+	}
+
+	if r.Until != nil && !v.Time.Before(*r.Until) {
+		return false
+	}
+
+	return true
+}
+
+// validateWindow checks that the rule time window is not empty
+func (r *Rule) validateWindow() error {
+	if r.From != nil && r.Until != nil && !r.Until.After(*r.From) {
+		return fmt.Errorf("rule until (%s) must be after from (%s)", r.Until, r.From)
+	}
+
+	return nil
+}
+
+// matchesLanguage checks if any of the visitor language tags matches one of the rule languages
+// A rule language matches the tag itself or any tag that has it as primary language, "en" matches "en-US"
+func (r *Rule) matchesLanguage(tags []string) bool {
+	for _, tag := range tags {
+		primary := strings.SplitN(tag, "-", 2)[0]
+		for _, l := range r.Languages {
+			if strings.EqualFold(l, tag) || strings.EqualFold(l, primary) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// containsFold checks if the list contains the value, ignoring case
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+
+	return false
+}
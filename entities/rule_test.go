@@ -0,0 +1,94 @@
+package entities
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuleMatches(t *testing.T) {
+	now := time.Date(2022, 4, 10, 12, 0, 0, 0, time.UTC)
+	before := now.Add(-time.Hour)
+	after := now.Add(time.Hour)
+
+	testCases := []struct {
+		name     string
+		rule     Rule
+		visitor  Visitor
+		expected bool
+	}{
+		{
+			name:     "no conditions",
+			rule:     Rule{Url: "https://example.com"},
+			visitor:  Visitor{Time: now},
+			expected: true,
+		},
+		{
+			name:     "platform match",
+			rule:     Rule{Platforms: []string{PlatformIOS}},
+			visitor:  Visitor{Platform: PlatformIOS, Time: now},
+			expected: true,
+		},
+		{
+			name:     "platform mismatch",
+			rule:     Rule{Platforms: []string{PlatformIOS}},
+			visitor:  Visitor{Platform: PlatformAndroid, Time: now},
+			expected: false,
+		},
+		{
+			name:     "country match ignores case",
+			rule:     Rule{Countries: []string{"ro"}},
+			visitor:  Visitor{Country: "RO", Time: now},
+			expected: true,
+		},
+		{
+			name:     "unknown country",
+			rule:     Rule{Countries: []string{"RO"}},
+			visitor:  Visitor{Time: now},
+			expected: false,
+		},
+		{
+			name:     "primary language matches region tag",
+			rule:     Rule{Languages: []string{"en"}},
+			visitor:  Visitor{Languages: []string{"de-DE", "en-US"}, Time: now},
+			expected: true,
+		},
+		{
+			name:     "region language doesn't match other regions",
+			rule:     Rule{Languages: []string{"en-GB"}},
+			visitor:  Visitor{Languages: []string{"en-US"}, Time: now},
+			expected: false,
+		},
+		{
+			name:     "inside time window",
+			rule:     Rule{From: &before, Until: &after},
+			visitor:  Visitor{Time: now},
+			expected: true,
+		},
+		{
+			name:     "before time window",
+			rule:     Rule{From: &after},
+			visitor:  Visitor{Time: now},
+			expected: false,
+		},
+		{
+			name:     "after time window",
+			rule:     Rule{Until: &before},
+			visitor:  Visitor{Time: now},
+			expected: false,
+		},
+		{
+			name:     "all conditions must match",
+			rule:     Rule{Platforms: []string{PlatformAndroid}, Countries: []string{"DE"}},
+			visitor:  Visitor{Platform: PlatformAndroid, Country: "RO", Time: now},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.rule.Matches(tc.visitor) != tc.expected {
+				t.Errorf("expected match (%v), got (%v)", tc.expected, !tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package entities
+
+import (
+	"fmt"
+	"time"
+)
+
+// formats of the exported and imported urls
+const (
+	// FormatCSV is a csv file with a header row, the rules and variants columns hold JSON arrays and the tags column
+	// holds the tags separated by semicolons
+	FormatCSV = "csv"
+	// FormatJSONL is a file with one JSON url per line
+	FormatJSONL = "jsonl"
+)
+
+// policies used when an imported url has the code of an existing url
+const (
+	// ConflictSkip keeps the existing url and skips the imported one
+	ConflictSkip = "skip"
+	// ConflictOverwrite replaces the existing url, with its counters, by the imported one
+	ConflictOverwrite = "overwrite"
+	// ConflictFail imports nothing if any imported url has the code of an existing url
+	ConflictFail = "fail"
+)
+
+// outcomes of an imported url
+const (
+	ImportCreated     = "created"
+	ImportOverwritten = "overwritten"
+	ImportSkipped     = "skipped"
+	ImportFailed      = "failed"
+)
+
+// ExportFilter selects the exported urls, the empty fields match every url
+type ExportFilter struct {
+	// only the urls of this owner
+	Owner string
+	// only the urls with this tag
+	Tag string
+	// only the urls created at or after this time
+	From *time.Time
+	// only the urls created before this time
+	Until *time.Time
+	// export the urls in the trash too
+	Deleted bool
+}
+
+// Matches checks if the url is selected by the filter
+func (f *ExportFilter) Matches(u *Url) bool {
+	if f.Owner != "" && u.Owner != f.Owner {
+		return false
+	}
+
+	if f.Tag != "" && !u.HasTag(f.Tag) {
+		return false
+	}
+
+	if f.From != nil && u.CreatedAt.Before(*f.From) {
+		return false
+	}
+
+	if f.Until != nil && !u.CreatedAt.Before(*f.Until) {
+		return false
+	}
+
+	return f.Deleted || u.DeletedAt == nil
+}
+
+// ImportOptions configure an import
+type ImportOptions struct {
+	// FormatCSV or FormatJSONL
+	Format string
+	// ConflictSkip, ConflictOverwrite or ConflictFail, ConflictFail if empty
+	Conflict string
+	// check the urls and report what would be imported without saving them
+	DryRun bool
+}
+
+// Validate checks the format and conflict policy of the options
+func (o ImportOptions) Validate() error {
+	if o.Format != FormatCSV && o.Format != FormatJSONL {
+		return fmt.Errorf("format must be %s or %s, got (%s)", FormatCSV, FormatJSONL, o.Format)
+	}
+
+	switch o.Conflict {
+	case "", ConflictSkip, ConflictOverwrite, ConflictFail:
+		return nil
+	}
+
+	return fmt.Errorf("conflict must be %s, %s or %s, got (%s)", ConflictSkip, ConflictOverwrite, ConflictFail, o.Conflict)
+}
+
+// ImportResult is the outcome of an imported url
+type ImportResult struct {
+	// line of the url in the imported file, the csv header is line 1
+	Line int `json:"line"`
+	// the code of the url, generated if the imported url has none
+	Code string `json:"code"`
+	// created, overwritten, skipped or failed
+	//
+	// enum: ["created","overwritten","skipped","failed"]
+	Action string `json:"action"`
+	// why the url was skipped or failed
+	Error string `json:"error,omitempty"`
+}
+
+// ImportReport is the outcome of an import, with the result of every url
+type ImportReport struct {
+	// nothing was saved
+	DryRun      bool           `json:"dryRun"`
+	Total       int            `json:"total"`
+	Created     int            `json:"created"`
+	Overwritten int            `json:"overwritten"`
+	Skipped     int            `json:"skipped"`
+	Failed      int            `json:"failed"`
+	Results     []ImportResult `json:"results"`
+}
+
+// Add records the result of an imported url
+func (r *ImportReport) Add(result ImportResult) {
+	r.Total++
+	switch result.Action {
+	case ImportCreated:
+		r.Created++
+	case ImportOverwritten:
+		r.Overwritten++
+	case ImportSkipped:
+		r.Skipped++
+	case ImportFailed:
+		r.Failed++
+	}
+
+	r.Results = append(r.Results, result)
+}
@@ -0,0 +1,79 @@
+package entities
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExportFilterMatches(t *testing.T) {
+	created := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := created.Add(-time.Hour)
+	after := created.Add(time.Hour)
+	deletedAt := created.Add(24 * time.Hour)
+
+	u := Url{Code: "84gfj4i9", Owner: "apikey:1a2b3c", Tags: []string{"newsletter"}, CreatedAt: created}
+	deleted := u
+	deleted.DeletedAt = &deletedAt
+
+	testCases := []struct {
+		name     string
+		filter   ExportFilter
+		input    Url
+		expected bool
+	}{
+		{name: "empty filter", input: u, expected: true},
+		{name: "same owner", filter: ExportFilter{Owner: "apikey:1a2b3c"}, input: u, expected: true},
+		{name: "other owner", filter: ExportFilter{Owner: "anonymous"}, input: u},
+		{name: "same tag", filter: ExportFilter{Tag: "newsletter"}, input: u, expected: true},
+		{name: "other tag", filter: ExportFilter{Tag: "launch"}, input: u},
+		{name: "created at from", filter: ExportFilter{From: &created}, input: u, expected: true},
+		{name: "created before from", filter: ExportFilter{From: &after}, input: u},
+		{name: "created before until", filter: ExportFilter{Until: &after}, input: u, expected: true},
+		{name: "created at until", filter: ExportFilter{Until: &created}, input: u},
+		{name: "created in range", filter: ExportFilter{From: &before, Until: &after}, input: u, expected: true},
+		{name: "deleted url", input: deleted},
+		{name: "deleted url with deleted", filter: ExportFilter{Deleted: true}, input: deleted, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.Matches(&tc.input); got != tc.expected {
+				t.Errorf("expected (%t), got (%t)", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestImportOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   ImportOptions
+		isError bool
+	}{
+		{name: "csv", input: ImportOptions{Format: FormatCSV, Conflict: ConflictSkip}},
+		{name: "jsonl", input: ImportOptions{Format: FormatJSONL, Conflict: ConflictOverwrite}},
+		{name: "default conflict", input: ImportOptions{Format: FormatJSONL}},
+		{name: "no format", input: ImportOptions{Conflict: ConflictFail}, isError: true},
+		{name: "unknown format", input: ImportOptions{Format: "xml"}, isError: true},
+		{name: "unknown conflict", input: ImportOptions{Format: FormatCSV, Conflict: "merge"}, isError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.input.Validate(); (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got (%v)", tc.isError, err)
+			}
+		})
+	}
+}
+
+func TestImportReportAdd(t *testing.T) {
+	var r ImportReport
+	for _, action := range []string{ImportCreated, ImportCreated, ImportOverwritten, ImportSkipped, ImportFailed} {
+		r.Add(ImportResult{Action: action})
+	}
+
+	if r.Total != 5 || r.Created != 2 || r.Overwritten != 1 || r.Skipped != 1 || r.Failed != 1 || len(r.Results) != 5 {
+		t.Errorf("expected 5 results (2, 1, 1, 1), got (%+v)", r)
+	}
+}
@@ -2,13 +2,24 @@ package entities
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/go-playground/validator"
 	"io"
 	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Redirect types supported for a Url, they match the http status code sent on redirect
+const (
+	RedirectMovedPermanently = 301
+	RedirectFound            = 302
+	RedirectTemporary        = 307
+	RedirectPermanent        = 308
 )
 
 // Url defines the structure for the url object
-// swagger: model
 type Url struct {
 	// the id for this url
 	//
@@ -35,6 +46,42 @@ type Url struct {
 	//
 	// min: 0
 	Counter int64 `json:"counter" validate:"gte=0"`
+	// the date and time when the url was created
+	CreatedAt time.Time `json:"createdAt"`
+	// http status code used when redirecting to the original url, 302 if not set
+	//
+	// enum: [301,302,307,308]
+	RedirectType int `json:"redirectType" validate:"omitempty,oneof=301 302 307 308"`
+	// forward the query string of the short url request to the original url
+	ForwardQuery bool `json:"forwardQuery"`
+	// redirect /{code}/rest/of/path requests to the original url with the rest of the path appended
+	PrefixMode bool `json:"prefixMode"`
+	// ordered conditional destinations, the first rule that matches the request replaces the original url
+	Rules []Rule `json:"rules,omitempty" validate:"dive"`
+	// weighted destinations used instead of the original url to split the traffic, e.g. for A/B tests
+	Variants []Variant `json:"variants,omitempty" validate:"dive"`
+	// number of redirects after which the url stops working, 0 for no limit
+	//
+	// min: 0
+	MaxClicks int64 `json:"maxClicks" validate:"gte=0"`
+	// shortcut for maxClicks 1, only used when the url is created
+	BurnAfterReading bool `json:"burnAfterReading,omitempty"`
+	// the url starts redirecting at this time
+	ActiveFrom *time.Time `json:"activeFrom,omitempty"`
+	// the url stops redirecting at this time
+	ActiveUntil *time.Time `json:"activeUntil,omitempty"`
+	// url used for redirects before the url is active or after it expired
+	//
+	// min: 8
+	FallbackUrl string `json:"fallbackUrl,omitempty" validate:"omitempty,min=8"`
+	// the date and time when the url was moved to the trash, deleted urls stop redirecting until they are restored
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// actor that created the url, "anonymous" or the identifier of its API key
+	Owner string `json:"owner"`
+	// labels used to group the urls, e.g. by campaign, the click feed can be filtered by tag
+	//
+	// max items: 20
+	Tags []string `json:"tags,omitempty" validate:"max=20,dive,min=1,max=64"`
 }
 
 // Validate checks and validates each field of the Url object based on its definition
@@ -51,9 +98,153 @@ func (u *Url) Validate() error {
 		return err
 	}
 
+	for i := range u.Rules {
+		if _, err = url.Parse(u.Rules[i].Url); err != nil {
+			return err
+		}
+
+		if err = u.Rules[i].validateWindow(); err != nil {
+			return err
+		}
+	}
+
+	for i := range u.Variants {
+		if _, err = url.Parse(u.Variants[i].Url); err != nil {
+			return err
+		}
+	}
+
+	if err = u.validateVariants(); err != nil {
+		return err
+	}
+
+	if _, err = url.Parse(u.FallbackUrl); err != nil {
+		return err
+	}
+
+	if u.ActiveFrom != nil && u.ActiveUntil != nil && !u.ActiveUntil.After(*u.ActiveFrom) {
+		return fmt.Errorf("activeUntil (%s) must be after activeFrom (%s)", u.ActiveUntil, u.ActiveFrom)
+	}
+
 	return validate.Struct(u)
 }
 
+// HasCountryRules checks if any of the url rules depends on the visitor country
+func (u *Url) HasCountryRules() bool {
+	for i := range u.Rules {
+		if len(u.Rules[i].Countries) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MatchRules returns the url of the first rule that matches the visitor and true, or the original url and false if
+// none does
+func (u *Url) MatchRules(v Visitor) (string, bool) {
+	for i := range u.Rules {
+		if u.Rules[i].Matches(v) {
+			return u.Rules[i].Url, true
+		}
+	}
+
+	return u.Url, false
+}
+
+// HasTag checks if the url is labelled with the given tag
+func (u *Url) HasTag(tag string) bool {
+	for _, t := range u.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsClickLimited checks if the url stops working after a number of redirects
+func (u *Url) IsClickLimited() bool {
+	return u.MaxClicks > 0
+}
+
+// IsActive checks if the url redirects at the given time
+func (u *Url) IsActive(t time.Time) bool {
+	if u.ActiveFrom != nil && t.Before(*u.ActiveFrom) {
+		return false
+	}
+
+	return u.ActiveUntil == nil || t.Before(*u.ActiveUntil)
+}
+
+// IsExpired checks if the url activation window ended at the given time
+func (u *Url) IsExpired(t time.Time) bool {
+	return u.ActiveUntil != nil && !t.Before(*u.ActiveUntil)
+}
+
+// UntilWindowChange returns the duration from the given time until the url becomes active or expires,
+// 0 if the url active status doesn't change after the given time
+func (u *Url) UntilWindowChange(t time.Time) time.Duration {
+	if u.ActiveFrom != nil && t.Before(*u.ActiveFrom) {
+		return u.ActiveFrom.Sub(t)
+	}
+
+	if u.ActiveUntil != nil && t.Before(*u.ActiveUntil) {
+		return u.ActiveUntil.Sub(t)
+	}
+
+	return 0
+}
+
+// RedirectStatus returns the http status code used when redirecting to the original url
+func (u *Url) RedirectStatus() int {
+	if u.RedirectType == 0 {
+		return RedirectFound
+	}
+
+	return u.RedirectType
+}
+
+// IsPermanentRedirect checks if the url redirect can be cached by clients
+func (u *Url) IsPermanentRedirect() bool {
+	return u.RedirectType == RedirectMovedPermanently || u.RedirectType == RedirectPermanent
+}
+
+// Destination returns the url a request should be redirected to
+// p is the path that follows the code in the short url, it is appended to the original url in prefix mode
+// query is the short url request query string, it is merged into the original url query if ForwardQuery is set,
+// the parameters already present in the original url keep their values
+func (u *Url) Destination(p string, query url.Values) (string, error) {
+	dest, err := url.Parse(u.Url)
+	if err != nil {
+		return "", err
+	}
+
+	if u.PrefixMode && p != "" {
+		// clean the path so it can't climb above the original url path
+		rest := path.Join("/", p)
+		if strings.HasSuffix(p, "/") && rest != "/" {
+			rest += "/"
+		}
+
+		dest.Path = strings.TrimSuffix(dest.Path, "/") + rest
+		dest.RawPath = ""
+	}
+
+	if u.ForwardQuery && len(query) > 0 {
+		q := dest.Query()
+		for k, v := range query {
+			if _, ok := q[k]; !ok {
+				q[k] = v
+			}
+		}
+
+		dest.RawQuery = q.Encode()
+	}
+
+	return dest.String(), nil
+}
+
 // ToJSON serializes the contents of the object to JSON
 func (u *Url) ToJSON(w io.Writer) error {
 	e := json.NewEncoder(w)
@@ -1,8 +1,14 @@
 package entities
 
-import "testing"
+import (
+	"net/url"
+	"testing"
+	"time"
+)
 
 func TestValidateUrl(t *testing.T){
+	now := time.Now()
+
 	testCases := []struct{
 		name string
 		input Url
@@ -93,6 +99,122 @@ func TestValidateUrl(t *testing.T){
 			},
 			isError: true,
 		},
+		{
+			name:    "valid redirect type",
+			input:   Url{
+				Id:           1,
+				Code:         "84gfj4i9",
+				Url:          "https://google.com",
+				ShortUrl:     "http://localhost/84gfj4i9",
+				Domain:       "http://localhost",
+				RedirectType: RedirectPermanent,
+			},
+			isError: false,
+		},
+		{
+			name:    "valid rules",
+			input:   Url{
+				Code:     "84gfj4i9",
+				Url:      "https://google.com",
+				ShortUrl: "http://localhost/84gfj4i9",
+				Domain:   "http://localhost",
+				Rules: []Rule{
+					{Url: "https://apps.apple.com/app", Platforms: []string{PlatformIOS}},
+					{Url: "https://google.de", Countries: []string{"DE"}, Languages: []string{"de"}},
+				},
+			},
+			isError: false,
+		},
+		{
+			name:    "invalid rule platform",
+			input:   Url{
+				Code:     "84gfj4i9",
+				Url:      "https://google.com",
+				ShortUrl: "http://localhost/84gfj4i9",
+				Domain:   "http://localhost",
+				Rules:    []Rule{{Url: "https://apps.apple.com/app", Platforms: []string{"symbian"}}},
+			},
+			isError: true,
+		},
+		{
+			name:    "invalid rule country",
+			input:   Url{
+				Code:     "84gfj4i9",
+				Url:      "https://google.com",
+				ShortUrl: "http://localhost/84gfj4i9",
+				Domain:   "http://localhost",
+				Rules:    []Rule{{Url: "https://google.de", Countries: []string{"Germany"}}},
+			},
+			isError: true,
+		},
+		{
+			name:    "empty rule time window",
+			input:   Url{
+				Code:     "84gfj4i9",
+				Url:      "https://google.com",
+				ShortUrl: "http://localhost/84gfj4i9",
+				Domain:   "http://localhost",
+				Rules:    []Rule{{Url: "https://google.de", From: &now, Until: &now}},
+			},
+			isError: true,
+		},
+		{
+			name:    "empty activation window",
+			input:   Url{
+				Code:        "84gfj4i9",
+				Url:         "https://google.com",
+				ShortUrl:    "http://localhost/84gfj4i9",
+				Domain:      "http://localhost",
+				ActiveFrom:  &now,
+				ActiveUntil: &now,
+			},
+			isError: true,
+		},
+		{
+			name:    "invalid fallback url length",
+			input:   Url{
+				Code:        "84gfj4i9",
+				Url:         "https://google.com",
+				ShortUrl:    "http://localhost/84gfj4i9",
+				Domain:      "http://localhost",
+				FallbackUrl: "a.com",
+			},
+			isError: true,
+		},
+		{
+			name:    "invalid redirect type",
+			input:   Url{
+				Id:           1,
+				Code:         "84gfj4i9",
+				Url:          "https://google.com",
+				ShortUrl:     "http://localhost/84gfj4i9",
+				Domain:       "http://localhost",
+				RedirectType: 303,
+			},
+			isError: true,
+		},
+		{
+			name:    "valid tags",
+			input:   Url{
+				Code:     "84gfj4i9",
+				Url:      "https://google.com",
+				ShortUrl: "http://localhost/84gfj4i9",
+				Domain:   "http://localhost",
+				Tags:     []string{"spring-campaign", "newsletter"},
+			},
+			isError: false,
+		},
+		{
+			name:    "empty tag",
+			input:   Url{
+				Code:     "84gfj4i9",
+				Url:      "https://google.com",
+				ShortUrl: "http://localhost/84gfj4i9",
+				Domain:   "http://localhost",
+				Tags:     []string{"newsletter", ""},
+			},
+			isError: true,
+		},
 	}
 
 	for _, tc := range testCases{
@@ -104,4 +226,193 @@ func TestValidateUrl(t *testing.T){
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestDestination(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    Url
+		path     string
+		query    url.Values
+		expected string
+	}{
+		{
+			name:     "no options, path and query ignored",
+			input:    Url{Url: "https://google.com/search?q=go"},
+			path:     "extra",
+			query:    url.Values{"ref": {"newsletter"}},
+			expected: "https://google.com/search?q=go",
+		},
+		{
+			name:     "forward query",
+			input:    Url{Url: "https://google.com/search?q=go", ForwardQuery: true},
+			query:    url.Values{"ref": {"newsletter"}},
+			expected: "https://google.com/search?q=go&ref=newsletter",
+		},
+		{
+			name:     "forward query keeps original parameters",
+			input:    Url{Url: "https://google.com/search?q=go", ForwardQuery: true},
+			query:    url.Values{"q": {"rust"}, "ref": {"newsletter"}},
+			expected: "https://google.com/search?q=go&ref=newsletter",
+		},
+		{
+			name:     "prefix mode",
+			input:    Url{Url: "https://example.com/docs/", PrefixMode: true},
+			path:     "guide/intro",
+			expected: "https://example.com/docs/guide/intro",
+		},
+		{
+			name:     "prefix mode keeps trailing slash",
+			input:    Url{Url: "https://example.com/docs", PrefixMode: true},
+			path:     "guide/",
+			expected: "https://example.com/docs/guide/",
+		},
+		{
+			name:     "prefix mode can't climb above the original path",
+			input:    Url{Url: "https://example.com/docs", PrefixMode: true},
+			path:     "../../admin",
+			expected: "https://example.com/docs/admin",
+		},
+		{
+			name:     "prefix mode and forward query",
+			input:    Url{Url: "https://example.com/docs?lang=en", PrefixMode: true, ForwardQuery: true},
+			path:     "guide",
+			query:    url.Values{"ref": {"newsletter"}},
+			expected: "https://example.com/docs/guide?lang=en&ref=newsletter",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dest, err := tc.input.Destination(tc.path, tc.query)
+			if err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if dest != tc.expected {
+				t.Errorf("expected destination (%v), got (%v)", tc.expected, dest)
+			}
+		})
+	}
+}
+
+func TestMatchRules(t *testing.T) {
+	u := Url{
+		Url: "https://example.com",
+		Rules: []Rule{
+			{Url: "https://apps.apple.com/app", Platforms: []string{PlatformIOS}},
+			{Url: "https://play.google.com/store/apps", Platforms: []string{PlatformAndroid}},
+			{Url: "https://example.de", Countries: []string{"DE"}},
+			{Url: "https://example.com", Languages: []string{"en"}},
+		},
+	}
+
+	testCases := []struct {
+		name            string
+		input           Visitor
+		expected        string
+		expectedMatched bool
+	}{
+		{
+			name:            "first rule",
+			input:           Visitor{Platform: PlatformIOS, Country: "DE"},
+			expected:        "https://apps.apple.com/app",
+			expectedMatched: true,
+		},
+		{
+			name:            "second rule",
+			input:           Visitor{Platform: PlatformAndroid},
+			expected:        "https://play.google.com/store/apps",
+			expectedMatched: true,
+		},
+		{
+			name:            "country rule",
+			input:           Visitor{Platform: PlatformWindows, Country: "DE"},
+			expected:        "https://example.de",
+			expectedMatched: true,
+		},
+		{
+			name:            "rule of the original url",
+			input:           Visitor{Platform: PlatformWindows, Languages: []string{"en"}},
+			expected:        "https://example.com",
+			expectedMatched: true,
+		},
+		{
+			name:            "no rule matches",
+			input:           Visitor{Platform: PlatformWindows, Country: "RO"},
+			expected:        "https://example.com",
+			expectedMatched: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dest, matched := u.MatchRules(tc.input)
+			if dest != tc.expected {
+				t.Errorf("expected destination (%v), got (%v)", tc.expected, dest)
+			}
+
+			if matched != tc.expectedMatched {
+				t.Errorf("expected matched (%v), got (%v)", tc.expectedMatched, matched)
+			}
+		})
+	}
+}
+
+func TestActivationWindow(t *testing.T) {
+	now := time.Date(2022, 4, 10, 12, 0, 0, 0, time.UTC)
+	from := now.Add(time.Hour)
+	until := now.Add(2 * time.Hour)
+	past := now.Add(-time.Hour)
+
+	testCases := []struct {
+		name        string
+		input       Url
+		active      bool
+		expired     bool
+		untilChange time.Duration
+	}{
+		{
+			name:   "no window",
+			input:  Url{},
+			active: true,
+		},
+		{
+			name:        "not active yet",
+			input:       Url{ActiveFrom: &from, ActiveUntil: &until},
+			untilChange: time.Hour,
+		},
+		{
+			name:        "active until",
+			input:       Url{ActiveFrom: &past, ActiveUntil: &until},
+			active:      true,
+			untilChange: 2 * time.Hour,
+		},
+		{
+			name:    "expired",
+			input:   Url{ActiveUntil: &past},
+			expired: true,
+		},
+		{
+			name:   "active from the past",
+			input:  Url{ActiveFrom: &past},
+			active: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if active := tc.input.IsActive(now); active != tc.active {
+				t.Errorf("expected active (%v), got (%v)", tc.active, active)
+			}
+
+			if expired := tc.input.IsExpired(now); expired != tc.expired {
+				t.Errorf("expected expired (%v), got (%v)", tc.expired, expired)
+			}
+
+			if d := tc.input.UntilWindowChange(now); d != tc.untilChange {
+				t.Errorf("expected window change in (%v), got (%v)", tc.untilChange, d)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package entities
+
+import "fmt"
+
+// Variant is a weighted destination of a Url, the url traffic is split across its variants by weight
+type Variant struct {
+	// the id of the variant, leave it empty to add a new variant
+	Id int64 `json:"id"`
+	// destination url of the variant
+	//
+	// min: 8
+	Url string `json:"url" validate:"required,min=8"`
+	// relative share of the traffic sent to the variant, e.g. 70 and 30 for a 70/30 split, 0 stops sending new visitors
+	//
+	// min: 0
+	Weight int `json:"weight" validate:"gte=0"`
+	// number of redirects to the variant
+	//
+	// min: 0
+	Counter int64 `json:"counter" validate:"gte=0"`
+}
+
+// Click is a redirect of a short url that has to be counted
+type Click struct {
+	// short url code
+	Code string
+	// id of the variant the redirect was sent to, 0 if the url has no variants
+	VariantId int64
+}
+
+// TotalWeight returns the sum of the variant weights
+func (u *Url) TotalWeight() int {
+	total := 0
+	for i := range u.Variants {
+		total += u.Variants[i].Weight
+	}
+
+	return total
+}
+
+// PickVariant returns the variant that owns the n-th unit of the total weight, n must be in [0, TotalWeight)
+// It returns nil if n is out of range
+func (u *Url) PickVariant(n int) *Variant {
+	if n < 0 {
+		return nil
+	}
+
+	for i := range u.Variants {
+		if n < u.Variants[i].Weight {
+			return &u.Variants[i]
+		}
+
+		n -= u.Variants[i].Weight
+	}
+
+	return nil
+}
+
+// VariantById returns the url variant with the given id, or nil if the url has no such variant
+func (u *Url) VariantById(id int64) *Variant {
+	for i := range u.Variants {
+		if u.Variants[i].Id == id {
+			return &u.Variants[i]
+		}
+	}
+
+	return nil
+}
+
+// validateVariants checks that the traffic can be split across the variants
+func (u *Url) validateVariants() error {
+	if len(u.Variants) > 0 && u.TotalWeight() == 0 {
+		return fmt.Errorf("at least one variant must have a weight greater than 0")
+	}
+
+	ids := make(map[int64]bool)
+	for i := range u.Variants {
+		id := u.Variants[i].Id
+		if id != 0 && ids[id] {
+			return fmt.Errorf("duplicate variant id (%d)", id)
+		}
+
+		ids[id] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package entities
+
+import "testing"
+
+func TestPickVariant(t *testing.T) {
+	u := Url{
+		Url: "https://example.com",
+		Variants: []Variant{
+			{Id: 1, Url: "https://example.com/a", Weight: 70},
+			{Id: 2, Url: "https://example.com/off", Weight: 0},
+			{Id: 3, Url: "https://example.com/b", Weight: 30},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		input    int
+		expected int64
+	}{
+		{
+			name:     "first unit",
+			input:    0,
+			expected: 1,
+		},
+		{
+			name:     "last unit of the first variant",
+			input:    69,
+			expected: 1,
+		},
+		{
+			name:     "first unit of the last variant, zero weight skipped",
+			input:    70,
+			expected: 3,
+		},
+		{
+			name:     "last unit",
+			input:    99,
+			expected: 3,
+		},
+		{
+			name:     "out of range",
+			input:    100,
+			expected: 0,
+		},
+		{
+			name:     "negative",
+			input:    -1,
+			expected: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var id int64
+			if v := u.PickVariant(tc.input); v != nil {
+				id = v.Id
+			}
+
+			if id != tc.expected {
+				t.Errorf("expected variant (%v), got (%v)", tc.expected, id)
+			}
+		})
+	}
+}
+
+func TestValidateVariants(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   []Variant
+		isError bool
+	}{
+		{
+			name:    "no variants",
+			input:   nil,
+			isError: false,
+		},
+		{
+			name:    "valid split",
+			input:   []Variant{{Url: "https://example.com/a", Weight: 70}, {Url: "https://example.com/b", Weight: 30}},
+			isError: false,
+		},
+		{
+			name:    "zero total weight",
+			input:   []Variant{{Url: "https://example.com/a"}, {Url: "https://example.com/b"}},
+			isError: true,
+		},
+		{
+			name:    "duplicate id",
+			input:   []Variant{{Id: 1, Url: "https://example.com/a", Weight: 1}, {Id: 1, Url: "https://example.com/b", Weight: 1}},
+			isError: true,
+		},
+		{
+			name:    "negative weight",
+			input:   []Variant{{Url: "https://example.com/a", Weight: -1}, {Url: "https://example.com/b", Weight: 2}},
+			isError: true,
+		},
+		{
+			name:    "invalid url",
+			input:   []Variant{{Url: "a.com", Weight: 1}},
+			isError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := Url{
+				Code:     "84gfj4i9",
+				Url:      "https://google.com",
+				ShortUrl: "http://localhost/84gfj4i9",
+				Domain:   "http://localhost",
+				Variants: tc.input,
+			}
+
+			if err := u.Validate(); (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package entities
+
+import (
+	"strings"
+)
+
+// NewVisitor returns the visitor of a request with the given User-Agent and Accept-Language headers
+// The country and time of the visit are set by the caller
+func NewVisitor(userAgent, acceptLanguage string) Visitor {
+	return Visitor{
+		Platform:  platformFromUserAgent(userAgent),
+		Languages: parseAcceptLanguage(acceptLanguage),
+	}
+}
+
+// platformFromUserAgent detects the visitor platform from the User-Agent header
+func platformFromUserAgent(ua string) string {
+	ua = strings.ToLower(ua)
+
+	// the order matters, iOS user agents contain "mac os x" and Android user agents contain "linux"
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		return PlatformIOS
+	case strings.Contains(ua, "android"):
+		return PlatformAndroid
+	case strings.Contains(ua, "windows"):
+		return PlatformWindows
+	case strings.Contains(ua, "macintosh"), strings.Contains(ua, "mac os x"):
+		return PlatformMacOS
+	case strings.Contains(ua, "linux"), strings.Contains(ua, "x11"):
+		return PlatformLinux
+	default:
+		return PlatformOther
+	}
+}
+
+// parseAcceptLanguage returns the language tags of the Accept-Language header, in the order they were sent
+func parseAcceptLanguage(h string) []string {
+	var tags []string
+	for _, part := range strings.Split(h, ",") {
+		tag := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if tag != "" && tag != "*" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
@@ -0,0 +1,80 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlatformFromUserAgent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "iphone",
+			input:    "Mozilla/5.0 (iPhone; CPU iPhone OS 15_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.4 Mobile/15E148 Safari/604.1",
+			expected: PlatformIOS,
+		},
+		{
+			name:     "android",
+			input:    "Mozilla/5.0 (Linux; Android 12; Pixel 6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/100.0.4896.79 Mobile Safari/537.36",
+			expected: PlatformAndroid,
+		},
+		{
+			name:     "windows",
+			input:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/100.0.4896.75 Safari/537.36",
+			expected: PlatformWindows,
+		},
+		{
+			name:     "macos",
+			input:    "Mozilla/5.0 (Macintosh; Intel Mac OS X 12_3_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/15.4 Safari/605.1.15",
+			expected: PlatformMacOS,
+		},
+		{
+			name:     "linux",
+			input:    "Mozilla/5.0 (X11; Linux x86_64; rv:99.0) Gecko/20100101 Firefox/99.0",
+			expected: PlatformLinux,
+		},
+		{
+			name:     "other",
+			input:    "curl/7.81.0",
+			expected: PlatformOther,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if p := platformFromUserAgent(tc.input); p != tc.expected {
+				t.Errorf("expected platform (%v), got (%v)", tc.expected, p)
+			}
+		})
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "empty header",
+			input:    "",
+			expected: nil,
+		},
+		{
+			name:     "weighted languages",
+			input:    "ro-RO, en-US;q=0.9, en;q=0.8, *;q=0.5",
+			expected: []string{"ro-RO", "en-US", "en"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tags := parseAcceptLanguage(tc.input); !reflect.DeepEqual(tags, tc.expected) {
+				t.Errorf("expected languages (%v), got (%v)", tc.expected, tags)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package entities
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-playground/validator"
+	"net/url"
+	"time"
+)
+
+// webhook event types
+const (
+	// WebhookUrlCreated is sent when a url is created
+	WebhookUrlCreated = "url.created"
+	// WebhookUrlDeleted is sent when a url is moved to the trash
+	WebhookUrlDeleted = "url.deleted"
+	// WebhookUrlExpired is sent when a click limited url uses its last click
+	WebhookUrlExpired = "url.expired"
+	// WebhookUrlMilestone is sent when the counter of a url reaches one of the ClickMilestones
+	WebhookUrlMilestone = "url.milestone"
+)
+
+// WebhookEventTypes are the event types a webhook can subscribe to
+var WebhookEventTypes = []string{WebhookUrlCreated, WebhookUrlDeleted, WebhookUrlExpired, WebhookUrlMilestone}
+
+// ClickMilestones are the url counters that send a WebhookUrlMilestone event when they are reached
+var ClickMilestones = []int64{100, 1000, 10000, 100000, 1000000}
+
+// Webhook is a subscription of a downstream system to the url events, the events are posted to its url
+type Webhook struct {
+	// the id of the webhook
+	Id int64 `json:"id"`
+	// http or https url the events are posted to
+	//
+	// min: 8
+	Url string `json:"url" validate:"required,min=8"`
+	// key of the HMAC-SHA256 signature of the payloads, generated if empty, it's only returned when the webhook is created
+	Secret string `json:"secret,omitempty"`
+	// types of the events sent to the webhook
+	//
+	// enum: ["url.created","url.deleted","url.expired","url.milestone"]
+	Events []string `json:"events" validate:"required,min=1,dive,oneof=url.created url.deleted url.expired url.milestone"`
+	// the date and time when the webhook was created
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookEvent is the payload posted to the webhooks subscribed to its type
+type WebhookEvent struct {
+	// unique id of the event, the deliveries of an event that are retried or replayed have the same id
+	Id string `json:"id"`
+	// the type of the event
+	//
+	// enum: ["url.created","url.deleted","url.expired","url.milestone"]
+	Type string `json:"type"`
+	// the url of the event
+	Url Url `json:"url"`
+	// the reached counter of url.milestone events
+	Milestone int64 `json:"milestone,omitempty"`
+	// the date and time of the event
+	Time time.Time `json:"time"`
+}
+
+// DeadLetter is an event that couldn't be delivered to a webhook after every retry, it can be replayed
+type DeadLetter struct {
+	// the id of the dead letter
+	Id int64 `json:"id"`
+	// the id of the webhook the event wasn't delivered to
+	WebhookId int64 `json:"webhookId"`
+	// the undelivered event
+	Event WebhookEvent `json:"event"`
+	// number of failed deliveries
+	Attempts int `json:"attempts"`
+	// error of the last delivery
+	LastError string `json:"lastError"`
+	// the date and time of the last delivery
+	FailedAt time.Time `json:"failedAt"`
+}
+
+// Validate checks the webhook url and event types
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.Url)
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url (%s) must be an absolute http or https url", w.Url)
+	}
+
+	return validator.New().Struct(w)
+}
+
+// Subscribes checks if the webhook is sent the events of the given type
+func (w *Webhook) Subscribes(eventType string) bool {
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewWebhookEvent returns an event of the given type for the url at the given time, with a new id
+func NewWebhookEvent(eventType string, u Url, t time.Time) WebhookEvent {
+	return WebhookEvent{Id: NewRequestId(), Type: eventType, Url: u, Time: t.UTC()}
+}
+
+// NewWebhookSecret returns a random hex key for the signatures of a webhook
+func NewWebhookSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// CrossedMilestone returns the highest of the ClickMilestones reached when a counter went from before to after,
+// 0 if none was reached
+func CrossedMilestone(before, after int64) int64 {
+	var crossed int64
+	for _, m := range ClickMilestones {
+		if before < m && after >= m {
+			crossed = m
+		}
+	}
+
+	return crossed
+}
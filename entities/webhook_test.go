@@ -0,0 +1,93 @@
+package entities
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebhookValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		input   Webhook
+		isError bool
+	}{
+		{
+			name:  "valid webhook",
+			input: Webhook{Url: "https://hooks.example.com/urls", Events: []string{WebhookUrlCreated, WebhookUrlMilestone}},
+		},
+		{
+			name:    "relative url",
+			input:   Webhook{Url: "/hooks/urls/created", Events: []string{WebhookUrlCreated}},
+			isError: true,
+		},
+		{
+			name:    "unsupported scheme",
+			input:   Webhook{Url: "ftp://hooks.example.com", Events: []string{WebhookUrlCreated}},
+			isError: true,
+		},
+		{
+			name:    "no events",
+			input:   Webhook{Url: "https://hooks.example.com/urls"},
+			isError: true,
+		},
+		{
+			name:    "unknown event",
+			input:   Webhook{Url: "https://hooks.example.com/urls", Events: []string{"url.updated"}},
+			isError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.input.Validate(); (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got (%v)", tc.isError, err)
+			}
+		})
+	}
+}
+
+func TestCrossedMilestone(t *testing.T) {
+	testCases := []struct {
+		name     string
+		before   int64
+		after    int64
+		expected int64
+	}{
+		{name: "below the first milestone", before: 10, after: 99, expected: 0},
+		{name: "reached exactly", before: 99, after: 100, expected: 100},
+		{name: "crossed", before: 950, after: 1020, expected: 1000},
+		{name: "already past", before: 100, after: 150, expected: 0},
+		{name: "several crossed", before: 50, after: 20000, expected: 10000},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := CrossedMilestone(tc.before, tc.after); got != tc.expected {
+				t.Errorf("expected milestone (%d), got (%d)", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewWebhookEvent(t *testing.T) {
+	now := time.Now()
+	u := Url{Id: 1, Code: "84gfj4i9"}
+
+	e := NewWebhookEvent(WebhookUrlCreated, u, now)
+	other := NewWebhookEvent(WebhookUrlCreated, u, now)
+
+	if e.Id == "" || e.Id == other.Id {
+		t.Errorf("expected unique event ids, got (%s) and (%s)", e.Id, other.Id)
+	}
+
+	if e.Type != WebhookUrlCreated || e.Url.Code != "84gfj4i9" || !e.Time.Equal(now) {
+		t.Errorf("unexpected event (%+v)", e)
+	}
+}
+
+func TestNewWebhookSecret(t *testing.T) {
+	secret := NewWebhookSecret()
+	if len(secret) != 64 || secret == NewWebhookSecret() {
+		t.Errorf("expected a unique 64 characters secret, got (%s)", secret)
+	}
+}
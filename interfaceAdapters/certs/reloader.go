@@ -0,0 +1,201 @@
+// Package certs loads the tls certificates of the servers and reloads them when their files change
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval is the time between two checks of the certificate files when no interval is configured
+const DefaultReloadInterval = 10 * time.Second
+
+// Reloader serves the server certificate, and the client CA pool of the mutual tls connections, from their files
+// The files are checked on every interval tick and loaded again when one of them changed, the connections opened
+// after the reload use the new certificates. Files that can't be loaded are logged and the previous ones are kept
+type Reloader struct {
+	CertFile string
+	KeyFile  string
+	// CAFile contains the certificates the client certificates are verified with, empty without mutual tls
+	CAFile string
+	Logger *slog.Logger
+	// mu guards cert, clientCAs and versions
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	// versions are the modification times and sizes of the loaded files
+	versions []fileVersion
+}
+
+// fileVersion identifies the content of a file without reading it
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader returns a Reloader with the certificates of the given files loaded
+// An error is returned if they can't be loaded, the servers must not start with invalid certificates
+func NewReloader(certFile, keyFile, caFile string, l *slog.Logger) (*Reloader, error) {
+	r := &Reloader{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, Logger: l}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Watch reloads the certificates when their files change, checking them on every interval tick until the context is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+
+			if err := r.load(); err != nil {
+				r.Logger.Error("unable to reload the tls certificates, the previous ones are kept", "error", err.Error())
+				continue
+			}
+
+			r.Logger.Info("tls certificates reloaded", "cert", r.CertFile)
+		}
+	}
+}
+
+// GetCertificate returns the current server certificate, it's the tls.Config GetCertificate callback
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// ServerConfig returns the tls configuration of a server that authenticates its clients as given by clientAuth
+// The client certificates are verified with the current CA pool, clientAuth must be tls.NoClientCert without a CAFile
+func (r *Reloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	c := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: r.GetCertificate,
+		ClientAuth:     clientAuth,
+	}
+
+	if clientAuth == tls.NoClientCert {
+		return c
+	}
+
+	// every handshake gets the CA pool loaded last
+	c.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cc := c.Clone()
+		cc.GetConfigForClient = nil
+
+		r.mu.RLock()
+		cc.ClientCAs = r.clientCAs
+		r.mu.RUnlock()
+
+		return cc, nil
+	}
+
+	return c
+}
+
+// load reads the certificate files and replaces the current certificates
+func (r *Reloader) load() error {
+	versions, err := r.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load the tls certificate: %s", err.Error())
+	}
+
+	var pool *x509.CertPool
+	if r.CAFile != "" {
+		pem, err := os.ReadFile(r.CAFile)
+		if err != nil {
+			return fmt.Errorf("unable to read the client CA file: %s", err.Error())
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in the client CA file %s", r.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.versions = versions
+	r.mu.Unlock()
+
+	return nil
+}
+
+// changed checks if a certificate file changed since it was loaded, the files that can't be read are not changed
+func (r *Reloader) changed() bool {
+	versions, err := r.stat()
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for i := range versions {
+		if versions[i] != r.versions[i] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stat returns the versions of the certificate files
+func (r *Reloader) stat() ([]fileVersion, error) {
+	var versions []fileVersion
+	for _, f := range []string{r.CertFile, r.KeyFile, r.CAFile} {
+		if f == "" {
+			continue
+		}
+
+		info, err := os.Stat(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the tls file: %s", err.Error())
+		}
+
+		versions = append(versions, fileVersion{modTime: info.ModTime(), size: info.Size()})
+	}
+
+	return versions, nil
+}
+
+// Identity returns the identity of a client certificate: its common name, or its first URI or DNS name if it has none
+func Identity(cert *x509.Certificate) string {
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	}
+
+	return ""
+}
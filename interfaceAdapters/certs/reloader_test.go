@@ -0,0 +1,203 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/norby7/shortening-service/interfaceAdapters/certs/certstest"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// serverName returns the common name of the certificate served by the reloader
+func serverName(t *testing.T, r *Reloader) string {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("unable to get the certificate: %s", err.Error())
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("unable to parse the certificate: %s", err.Error())
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func TestNewReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "test ca")
+	_, certPEM, keyPEM := ca.Issue(t, "server", true)
+
+	certFile := certstest.WriteFile(t, dir, "server.crt", certPEM)
+	keyFile := certstest.WriteFile(t, dir, "server.key", keyPEM)
+	caFile := certstest.WriteFile(t, dir, "ca.crt", ca.PEM)
+	invalidFile := certstest.WriteFile(t, dir, "invalid.pem", []byte("not a certificate"))
+
+	testCases := []struct {
+		name    string
+		cert    string
+		key     string
+		ca      string
+		isError bool
+	}{
+		{name: "server certificate", cert: certFile, key: keyFile},
+		{name: "with client CA", cert: certFile, key: keyFile, ca: caFile},
+		{name: "missing key", cert: certFile, key: dir + "/missing.key", isError: true},
+		{name: "invalid certificate", cert: invalidFile, key: keyFile, isError: true},
+		{name: "invalid client CA", cert: certFile, key: keyFile, ca: invalidFile, isError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := NewReloader(tc.cert, tc.key, tc.ca, slog.Default())
+			if (err != nil) != tc.isError {
+				t.Fatalf("expected error (%v), got (%v)", tc.isError, err)
+			}
+
+			if err == nil && serverName(t, r) != "server" {
+				t.Errorf("expected certificate (server), got (%s)", serverName(t, r))
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "test ca")
+	_, certPEM, keyPEM := ca.Issue(t, "first", true)
+
+	certFile := certstest.WriteFile(t, dir, "server.crt", certPEM)
+	keyFile := certstest.WriteFile(t, dir, "server.key", keyPEM)
+
+	r, err := NewReloader(certFile, keyFile, "", slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	// an invalid certificate is not loaded, the modification time is moved so the change is seen
+	later := time.Now().Add(time.Minute)
+	certstest.WriteFile(t, dir, "server.crt", []byte("not a certificate"))
+	_ = os.Chtimes(certFile, later, later)
+	time.Sleep(50 * time.Millisecond)
+
+	if name := serverName(t, r); name != "first" {
+		t.Fatalf("expected the previous certificate (first), got (%s)", name)
+	}
+
+	_, certPEM, keyPEM = ca.Issue(t, "second", true)
+	certstest.WriteFile(t, dir, "server.crt", certPEM)
+	certstest.WriteFile(t, dir, "server.key", keyPEM)
+	later = later.Add(time.Minute)
+	_ = os.Chtimes(certFile, later, later)
+	_ = os.Chtimes(keyFile, later, later)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for serverName(t, r) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the reloaded certificate (second), got (%s)", serverName(t, r))
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "test ca")
+	other := certstest.NewCA(t, "other ca")
+	_, certPEM, keyPEM := ca.Issue(t, "server", true)
+	client, _, _ := ca.Issue(t, "admin", false)
+	stranger, _, _ := other.Issue(t, "stranger", false)
+
+	r, err := NewReloader(
+		certstest.WriteFile(t, dir, "server.crt", certPEM),
+		certstest.WriteFile(t, dir, "server.key", keyPEM),
+		certstest.WriteFile(t, dir, "ca.crt", ca.PEM),
+		slog.Default(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name             string
+		clientAuth       tls.ClientAuthType
+		clientCerts      []tls.Certificate
+		isError          bool
+		expectedIdentity string
+	}{
+		{name: "server authentication only", clientAuth: tls.NoClientCert},
+		{name: "verified client", clientAuth: tls.RequireAndVerifyClientCert, clientCerts: []tls.Certificate{client}, expectedIdentity: "admin"},
+		{name: "missing client certificate", clientAuth: tls.RequireAndVerifyClientCert, isError: true},
+		{name: "client of another CA", clientAuth: tls.RequireAndVerifyClientCert, clientCerts: []tls.Certificate{stranger}, isError: true},
+		{name: "optional client certificate", clientAuth: tls.VerifyClientCertIfGiven},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientConn, serverConn := net.Pipe()
+			defer clientConn.Close()
+			defer serverConn.Close()
+
+			// the pipe is synchronous, the client runs apart so the server alerts don't block the handshake
+			clientDone := make(chan struct{})
+			go func() {
+				c := tls.Client(clientConn, &tls.Config{ServerName: "localhost", RootCAs: ca.Pool(), Certificates: tc.clientCerts})
+				_ = c.Handshake()
+				_, _ = c.Read(make([]byte, 1))
+				close(clientDone)
+			}()
+
+			server := tls.Server(serverConn, r.ServerConfig(tc.clientAuth))
+			serverErr := server.Handshake()
+			_ = serverConn.Close()
+			<-clientDone
+
+			if (serverErr != nil) != tc.isError {
+				t.Fatalf("expected error (%v), got (%v)", tc.isError, serverErr)
+			}
+
+			var identity string
+			if chains := server.ConnectionState().VerifiedChains; len(chains) > 0 {
+				identity = Identity(chains[0][0])
+			}
+
+			if identity != tc.expectedIdentity {
+				t.Errorf("expected identity (%s), got (%s)", tc.expectedIdentity, identity)
+			}
+		})
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/admin")
+
+	testCases := []struct {
+		name     string
+		input    x509.Certificate
+		expected string
+	}{
+		{name: "common name", input: x509.Certificate{Subject: pkix.Name{CommonName: "admin"}, DNSNames: []string{"admin.example.org"}}, expected: "admin"},
+		{name: "uri", input: x509.Certificate{URIs: []*url.URL{spiffe}, DNSNames: []string{"admin.example.org"}}, expected: "spiffe://example.org/admin"},
+		{name: "dns name", input: x509.Certificate{DNSNames: []string{"admin.example.org"}}, expected: "admin.example.org"},
+		{name: "anonymous", input: x509.Certificate{}, expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Identity(&tc.input); got != tc.expected {
+				t.Errorf("expected identity (%s), got (%s)", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package geoip
+
+import (
+	"fmt"
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+)
+
+// MaxMindLocator finds the country of an ip address in a local MaxMind GeoIP2/GeoLite2 country or city database file
+type MaxMindLocator struct {
+	Reader *maxminddb.Reader
+}
+
+// countryRecord is the part of a MaxMind database record used by the locator
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// NewMaxMindLocator opens the MaxMind database file and returns a new MaxMindLocator object address
+func NewMaxMindLocator(p string) (*MaxMindLocator, error) {
+	reader, err := maxminddb.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open geoip database: %s", err.Error())
+	}
+
+	return &MaxMindLocator{Reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 alpha-2 country code of the ip address or an empty string if the ip isn't in the database
+func (l *MaxMindLocator) Country(ip net.IP) (string, error) {
+	var record countryRecord
+	if err := l.Reader.Lookup(ip, &record); err != nil {
+		return "", fmt.Errorf("unable to lookup ip address: %s", err.Error())
+	}
+
+	return record.Country.ISOCode, nil
+}
+
+// Close closes the database file
+func (l *MaxMindLocator) Close() error {
+	return l.Reader.Close()
+}
@@ -0,0 +1,10 @@
+package geoip
+
+import "testing"
+
+func TestInvalidNewMaxMindLocator(t *testing.T) {
+	_, err := NewMaxMindLocator("./missing.mmdb")
+	if err == nil {
+		t.Errorf("expected error opening missing geoip database, got nil")
+	}
+}
@@ -0,0 +1,71 @@
+package grpc
+
+import (
+	"context"
+	"github.com/norby7/shortening-service/interfaceAdapters/certs"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// ClientIdentity returns the identity of the verified client certificate of the call, or an empty string when the
+// client didn't send a certificate verified by the client CA
+func ClientIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return certs.Identity(info.State.VerifiedChains[0][0])
+}
+
+// AuthInterceptor returns a unary interceptor that only serves the calls of the clients with a verified certificate
+// The calls without one fail with Unauthenticated, and with PermissionDenied when the client identity isn't in
+// allowed. Every client verified by the client CA is allowed when allowed is empty
+func AuthInterceptor(allowed []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authorize(ctx, allowed); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// AuthStreamInterceptor returns a stream interceptor that only serves the streams of the allowed clients like AuthInterceptor
+func AuthStreamInterceptor(allowed []string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(ss.Context(), allowed); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// authorize returns the status error of a call whose client isn't verified or allowed
+func authorize(ctx context.Context, allowed []string) error {
+	identity := ClientIdentity(ctx)
+	if identity == "" {
+		return status.Error(codes.Unauthenticated, "a client certificate is required")
+	}
+
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	for _, a := range allowed {
+		if a == identity {
+			return nil
+		}
+	}
+
+	return status.Errorf(codes.PermissionDenied, "client (%s) is not allowed", identity)
+}
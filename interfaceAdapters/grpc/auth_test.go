@@ -0,0 +1,112 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/norby7/shortening-service/entities"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+)
+
+// tlsPeerContext returns the context of a call from a tls client verified with the certificate of the given common
+// name, or of a tls client without a certificate when name is empty
+func tlsPeerContext(name string) context.Context {
+	var state tls.ConnectionState
+	if name != "" {
+		state.VerifiedChains = [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: name}}}}
+	}
+
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+		AuthInfo: credentials.TLSInfo{State: state},
+	})
+}
+
+func TestClientIdentity(t *testing.T) {
+	testCases := []struct {
+		name          string
+		ctx           context.Context
+		expected      string
+		expectedActor string
+	}{
+		{name: "verified client", ctx: tlsPeerContext("admin"), expected: "admin", expectedActor: entities.CertActorPrefix + "admin"},
+		{name: "tls client without certificate", ctx: tlsPeerContext(""), expectedActor: entities.AnonymousActor},
+		{name: "plaintext client", ctx: peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1")}}), expectedActor: entities.AnonymousActor},
+		{name: "no peer", ctx: context.Background(), expectedActor: entities.AnonymousActor},
+		{
+			name:          "gateway identity",
+			ctx:           metadata.NewIncomingContext(context.Background(), metadata.Pairs(clientIdentityMetadata, "admin")),
+			expectedActor: entities.CertActorPrefix + "admin",
+		},
+		{
+			name:          "identity metadata sent by a client",
+			ctx:           metadata.NewIncomingContext(tlsPeerContext(""), metadata.Pairs(clientIdentityMetadata, "admin")),
+			expectedActor: entities.AnonymousActor,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ClientIdentity(tc.ctx); got != tc.expected {
+				t.Errorf("expected identity (%s), got (%s)", tc.expected, got)
+			}
+
+			if a := requestActor(tc.ctx); a.Name != tc.expectedActor {
+				t.Errorf("expected actor (%s), got (%s)", tc.expectedActor, a.Name)
+			}
+		})
+	}
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	testCases := []struct {
+		name         string
+		ctx          context.Context
+		allowed      []string
+		expectedCode codes.Code
+	}{
+		{name: "any verified client", ctx: tlsPeerContext("admin"), expectedCode: codes.OK},
+		{name: "allowed client", ctx: tlsPeerContext("admin"), allowed: []string{"ops", "admin"}, expectedCode: codes.OK},
+		{name: "client not allowed", ctx: tlsPeerContext("intruder"), allowed: []string{"ops", "admin"}, expectedCode: codes.PermissionDenied},
+		{name: "missing client certificate", ctx: tlsPeerContext(""), allowed: []string{"admin"}, expectedCode: codes.Unauthenticated},
+		{name: "no peer", ctx: context.Background(), expectedCode: codes.Unauthenticated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			}
+
+			_, err := AuthInterceptor(tc.allowed)(tc.ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/protocol.UrlService/Add"}, handler)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("expected code (%s), got (%s)", tc.expectedCode, code)
+			}
+
+			if called != (tc.expectedCode == codes.OK) {
+				t.Errorf("expected the handler to be called (%v), got (%v)", tc.expectedCode == codes.OK, called)
+			}
+
+			streamCalled := false
+			streamHandler := func(srv interface{}, ss grpc.ServerStream) error {
+				streamCalled = true
+				return nil
+			}
+
+			err = AuthStreamInterceptor(tc.allowed)(nil, &serverStream{ctx: tc.ctx}, &grpc.StreamServerInfo{FullMethod: "/protocol.UrlService/WatchClicks"}, streamHandler)
+			if code := status.Code(err); code != tc.expectedCode || streamCalled != (tc.expectedCode == codes.OK) {
+				t.Errorf("expected stream code (%s), got (%s)", tc.expectedCode, code)
+			}
+		})
+	}
+}
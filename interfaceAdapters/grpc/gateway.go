@@ -0,0 +1,104 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/norby7/shortening-service/interfaceAdapters/grpc/protocol"
+	httpC "github.com/norby7/shortening-service/interfaceAdapters/http"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"strings"
+)
+
+// gatewayHeaders are the request headers forwarded to the service as incoming metadata, besides the default ones
+var gatewayHeaders = map[string]bool{
+	"x-api-key":    true,
+	"x-request-id": true,
+}
+
+// NewGateway returns the http handler of the REST api mapped from the url-service.proto http annotations
+// The calls are served in-process by the given service, the grpc status of their errors is converted to the http status
+// The client identity verified by the http ClientAuth middleware is forwarded as the x-client-identity metadata
+// The messages use the proto3 JSON mapping with every field written, the 64 bit integers are written as numbers
+func NewGateway(us protocol.UrlServiceServer) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithMarshalerOption(runtime.MIMEWildcard, &numericJSONPb{runtime.JSONPb{
+			MarshalOptions:   protojson.MarshalOptions{EmitUnpopulated: true},
+			UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+		}}),
+		runtime.WithIncomingHeaderMatcher(incomingHeader),
+		runtime.WithForwardResponseOption(forwardResponse),
+		runtime.WithMetadata(nameSpan),
+		runtime.WithMetadata(clientIdentity),
+	)
+
+	if err := protocol.RegisterUrlServiceHandlerServer(context.Background(), mux, us); err != nil {
+		return nil, fmt.Errorf("unable to register the gateway handlers: %s", err.Error())
+	}
+
+	return mux, nil
+}
+
+// clientIdentityMetadata is the metadata of the client identity verified by the http ClientAuth middleware
+const clientIdentityMetadata = "x-client-identity"
+
+// gatewayMetadata is the metadata only the gateway sets, the values sent by the clients as Grpc-Metadata- headers are dropped
+var gatewayMetadata = map[string]bool{
+	"x-forwarded-for":      true,
+	clientIdentityMetadata: true,
+}
+
+// incomingHeader forwards the actor and request id headers to the service
+func incomingHeader(key string) (string, bool) {
+	if k := strings.ToLower(key); gatewayHeaders[k] {
+		return k, true
+	}
+
+	md, ok := runtime.DefaultHeaderMatcher(key)
+	if ok && gatewayMetadata[strings.ToLower(md)] {
+		return "", false
+	}
+
+	return md, ok
+}
+
+// forwardResponse answers a created url with 201 and its short url in the Location header, and a created webhook with 201
+func forwardResponse(ctx context.Context, rw http.ResponseWriter, m proto.Message) error {
+	switch method, _ := runtime.RPCMethod(ctx); method {
+	case "/protocol.UrlService/Add":
+		if u, ok := m.(*protocol.Url); ok {
+			rw.Header().Set("Location", u.ShortUrl)
+			rw.WriteHeader(http.StatusCreated)
+		}
+	case "/protocol.UrlService/AddWebhook":
+		rw.WriteHeader(http.StatusCreated)
+	}
+
+	return nil
+}
+
+// nameSpan names the request span after the annotated path of the call, the router only knows the path prefix
+// It's called with every call context before the service, it adds no metadata
+func nameSpan(ctx context.Context, r *http.Request) metadata.MD {
+	if pattern, ok := runtime.HTTPPathPattern(ctx); ok {
+		span := trace.SpanFromContext(ctx)
+		span.SetName(r.Method + " " + pattern)
+		span.SetAttributes(semconv.HTTPRoute(pattern))
+	}
+
+	return nil
+}
+
+// clientIdentity forwards the client identity verified by the http ClientAuth middleware to the service
+func clientIdentity(ctx context.Context, r *http.Request) metadata.MD {
+	if identity := httpC.VerifiedClient(r.Context()); identity != "" {
+		return metadata.Pairs(clientIdentityMetadata, identity)
+	}
+
+	return nil
+}
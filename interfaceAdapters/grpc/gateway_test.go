@@ -0,0 +1,723 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"github.com/norby7/shortening-service/entities"
+	httpC "github.com/norby7/shortening-service/interfaceAdapters/http"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// actorServiceMock records the actor of the deleted urls
+type actorServiceMock struct {
+	ServiceMock
+	actor entities.Actor
+}
+
+func (s *actorServiceMock) Delete(ctx context.Context, id int64, actor entities.Actor) error {
+	s.actor = actor
+	return nil
+}
+
+func TestGateway(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	gateway, err := NewGateway(NewUrlGrpcService(&ServiceMock{}, l))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	testCases := []struct {
+		name             string
+		method           string
+		path             string
+		body             string
+		expectedStatus   int
+		expectedLocation string
+		expectedBody     []string
+	}{
+		{
+			name:             "create",
+			method:           http.MethodPost,
+			path:             "/api",
+			body:             `{"code":"84gfj4i9","url":"https://google.com","redirectType":301}`,
+			expectedStatus:   http.StatusCreated,
+			expectedLocation: "http://localhost/84gfj4i9",
+			expectedBody:     []string{`"shortUrl":"http://localhost/84gfj4i9"`, `"redirectType":301`},
+		},
+		{
+			name:           "create existing code",
+			method:         http.MethodPost,
+			path:           "/api",
+			body:           `{"code":"d4jn8dsf","url":"https://google.com"}`,
+			expectedStatus: http.StatusConflict,
+			expectedBody:   []string{`"code":6`, `"reason":"CODE_ALREADY_EXISTS","domain":"shortening-service"`},
+		},
+		{
+			name:           "create invalid body",
+			method:         http.MethodPost,
+			path:           "/api",
+			body:           `{"url":`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "get",
+			method:         http.MethodGet,
+			path:           "/api/1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`"id":1`, `"counter":1`, `"rules":[]`, `"activeUntil":null`},
+		},
+		{
+			name:           "get missing url",
+			method:         http.MethodGet,
+			path:           "/api/2",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"code":5`, `"message":"url not found in the database"`},
+		},
+		{
+			name:           "get invalid id",
+			method:         http.MethodGet,
+			path:           "/api/abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "trash is not an id",
+			method:         http.MethodGet,
+			path:           "/api/trash",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`[{"id":3,"code":"d3l3t3d0"`},
+		},
+		{
+			name:           "audit events",
+			method:         http.MethodGet,
+			path:           "/api/audit?urlId=7",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`[{"id":1,"urlId":7,"action":"update"`},
+		},
+		{
+			name:           "audit events with every filter",
+			method:         http.MethodGet,
+			path:           "/api/audit?urlId=1&actor=anonymous&from=2022-01-01T00:00:00Z&until=2022-02-01T00:00:00Z&limit=10",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`[{"id":1,"urlId":1,"action":"update"`},
+		},
+		{
+			name:           "invalid audit filter",
+			method:         http.MethodGet,
+			path:           "/api/audit?from=2022-01-02T00:00:00Z&until=2022-01-01T00:00:00Z",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "rules are a bare array",
+			method:         http.MethodGet,
+			path:           "/api/1/rules",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`[]`},
+		},
+		{
+			name:           "set rules",
+			method:         http.MethodPut,
+			path:           "/api/1/rules",
+			body:           `[{"url":"https://apple.com","platforms":["ios"]}]`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`"rules":[{"url":"https://apple.com","platforms":["ios"]`},
+		},
+		{
+			name:           "set missing variant",
+			method:         http.MethodPut,
+			path:           "/api/1/variants",
+			body:           `[{"id":101,"url":"https://apple.com","weight":1}]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "update",
+			method:         http.MethodPut,
+			path:           "/api/1",
+			body:           `{"url":"https://google.ro"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`"url":"https://google.ro"`, `"maxClicks":0`},
+		},
+		{
+			name:           "patch the sent fields",
+			method:         http.MethodPatch,
+			path:           "/api/1",
+			body:           `{"maxClicks":5}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`"url":"https://google.com"`, `"maxClicks":5`},
+		},
+		{
+			name:           "patch missing url",
+			method:         http.MethodPatch,
+			path:           "/api/2",
+			body:           `{"maxClicks":5}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "restore",
+			method:         http.MethodPost,
+			path:           "/api/1/restore",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`"code":"84gfj4i9"`},
+		},
+		{
+			name:           "counter",
+			method:         http.MethodGet,
+			path:           "/counter/1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`{"counter":1}`},
+		},
+		{
+			name:           "get by code",
+			method:         http.MethodGet,
+			path:           "/api/code/84gfj4i9",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`"id":1`, `"code":"84gfj4i9"`},
+		},
+		{
+			name:           "get by missing code",
+			method:         http.MethodGet,
+			path:           "/api/code/notfound",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"reason":"URL_NOT_FOUND"`},
+		},
+		{
+			name:           "counter by code",
+			method:         http.MethodGet,
+			path:           "/api/code/84gfj4i9/counter",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`{"counter":1}`},
+		},
+		{
+			name:           "add webhook",
+			method:         http.MethodPost,
+			path:           "/api/webhooks",
+			body:           `{"url":"https://hooks.example.com","events":["url.created"]}`,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   []string{`"id":1`, `"secret":"g3n3rat3d"`},
+		},
+		{
+			name:           "add invalid webhook",
+			method:         http.MethodPost,
+			path:           "/api/webhooks",
+			body:           `{"url":"https://hooks.example.com","events":["url.updated"]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"reason":"INVALID_WEBHOOK"`},
+		},
+		{
+			name:           "webhooks are not an id",
+			method:         http.MethodGet,
+			path:           "/api/webhooks",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`[{"id":1,"url":"https://hooks.example.com"`},
+		},
+		{
+			name:           "delete webhook",
+			method:         http.MethodDelete,
+			path:           "/api/webhooks/1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "dead letters",
+			method:         http.MethodGet,
+			path:           "/api/webhooks/deadletters?webhookId=1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`[{"id":5,"webhookId":1`, `"lastError":"unexpected status (500)"`},
+		},
+		{
+			name:           "replay dead letter",
+			method:         http.MethodPost,
+			path:           "/api/webhooks/deadletters/5/replay",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "failed replay",
+			method:         http.MethodPost,
+			path:           "/api/webhooks/deadletters/6/replay",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   []string{`"reason":"WEBHOOK_DELIVERY_FAILED"`},
+		},
+		{
+			name:           "unknown path",
+			method:         http.MethodGet,
+			path:           "/api/1/unknown",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+
+			gateway.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("expected status (%d), got (%d) with body (%s)", tc.expectedStatus, rr.Code, rr.Body.String())
+			}
+
+			if location := rr.Header().Get("Location"); location != tc.expectedLocation {
+				t.Errorf("expected location (%s), got (%s)", tc.expectedLocation, location)
+			}
+
+			// protojson randomizes the whitespace of its output
+			var body bytes.Buffer
+			if err := json.Compact(&body, rr.Body.Bytes()); err != nil {
+				t.Fatalf("invalid json body (%s): %s", rr.Body.String(), err.Error())
+			}
+
+			for _, expected := range tc.expectedBody {
+				if !strings.Contains(body.String(), expected) {
+					t.Errorf("expected (%s) in body (%s)", expected, body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	gateway, err := NewGateway(NewUrlGrpcService(&ServiceMock{}, l))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   []string
+	}{
+		{
+			name:           "create invalid json object",
+			method:         http.MethodPost,
+			path:           "/api",
+			body:           `"url":"Where does the sun set?}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "create error",
+			method:         http.MethodPost,
+			path:           "/api",
+			body:           `{"url":"http://www.invalidUrl.com"}`,
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "delete without id",
+			method:         http.MethodDelete,
+			path:           "/api/",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "delete non integer id",
+			method:         http.MethodDelete,
+			path:           "/api/id",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "delete error",
+			method:         http.MethodDelete,
+			path:           "/api/0",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "delete negative id",
+			method:         http.MethodDelete,
+			path:           "/api/-1",
+			expectedStatus: http.StatusOK,
+			expectedBody:   []string{`{}`},
+		},
+		{
+			name:           "get non integer id",
+			method:         http.MethodGet,
+			path:           "/api/id",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "get error",
+			method:         http.MethodGet,
+			path:           "/api/0",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "get negative id",
+			method:         http.MethodGet,
+			path:           "/api/-1",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"code":5`, `"message":"url not found in the database"`},
+		},
+		{
+			name:           "update non integer id",
+			method:         http.MethodPut,
+			path:           "/api/id",
+			body:           `{"redirectType":301}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "update invalid json object",
+			method:         http.MethodPut,
+			path:           "/api/1",
+			body:           `"url":"Where does the sun set?}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "update error",
+			method:         http.MethodPut,
+			path:           "/api/0",
+			body:           `{"redirectType":301}`,
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "update missing url",
+			method:         http.MethodPut,
+			path:           "/api/2",
+			body:           `{"redirectType":301}`,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"code":5`, `"reason":"URL_NOT_FOUND"`},
+		},
+		{
+			name:           "get rules non integer id",
+			method:         http.MethodGet,
+			path:           "/api/id/rules",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "get rules error",
+			method:         http.MethodGet,
+			path:           "/api/0/rules",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "get rules of missing url",
+			method:         http.MethodGet,
+			path:           "/api/2/rules",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"code":5`},
+		},
+		{
+			name:           "set rules non integer id",
+			method:         http.MethodPut,
+			path:           "/api/id/rules",
+			body:           `[]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "set rules invalid json",
+			method:         http.MethodPut,
+			path:           "/api/1/rules",
+			body:           `{"url":"https://apps.apple.com/app"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "set rules error",
+			method:         http.MethodPut,
+			path:           "/api/0/rules",
+			body:           `[]`,
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "set rules of missing url",
+			method:         http.MethodPut,
+			path:           "/api/2/rules",
+			body:           `[]`,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"code":5`, `"reason":"URL_NOT_FOUND"`},
+		},
+		{
+			name:           "get variants non integer id",
+			method:         http.MethodGet,
+			path:           "/api/id/variants",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "get variants error",
+			method:         http.MethodGet,
+			path:           "/api/0/variants",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "get variants of missing url",
+			method:         http.MethodGet,
+			path:           "/api/2/variants",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"code":5`},
+		},
+		{
+			name:           "set variants non integer id",
+			method:         http.MethodPut,
+			path:           "/api/id/variants",
+			body:           `[]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "set variants invalid json",
+			method:         http.MethodPut,
+			path:           "/api/1/variants",
+			body:           `{"url":"https://example.com/a"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "set variants error",
+			method:         http.MethodPut,
+			path:           "/api/0/variants",
+			body:           `[]`,
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "set variants of missing url",
+			method:         http.MethodPut,
+			path:           "/api/2/variants",
+			body:           `[]`,
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"code":5`, `"reason":"URL_NOT_FOUND"`},
+		},
+		{
+			name:           "restore non integer id",
+			method:         http.MethodPost,
+			path:           "/api/id/restore",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "restore error",
+			method:         http.MethodPost,
+			path:           "/api/0/restore",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "restore missing deleted url",
+			method:         http.MethodPost,
+			path:           "/api/2/restore",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"code":5`, `"reason":"URL_NOT_FOUND"`},
+		},
+		{
+			name:           "audit invalid url id",
+			method:         http.MethodGet,
+			path:           "/api/audit?urlId=abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "audit invalid from",
+			method:         http.MethodGet,
+			path:           "/api/audit?from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "audit invalid until",
+			method:         http.MethodGet,
+			path:           "/api/audit?until=2022-01-01",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "audit negative limit",
+			method:         http.MethodGet,
+			path:           "/api/audit?limit=-1",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`, `"reason":"INVALID_AUDIT_FILTER"`},
+		},
+		{
+			name:           "audit error",
+			method:         http.MethodGet,
+			path:           "/api/audit?actor=invalidActor",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "counter non integer id",
+			method:         http.MethodGet,
+			path:           "/counter/id",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{`"code":3`},
+		},
+		{
+			name:           "counter error",
+			method:         http.MethodGet,
+			path:           "/counter/0",
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   []string{`"code":13`},
+		},
+		{
+			name:           "counter of missing url",
+			method:         http.MethodGet,
+			path:           "/counter/-1",
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   []string{`"code":5`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+
+			gateway.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedStatus {
+				t.Fatalf("expected status (%d), got (%d) with body (%s)", tc.expectedStatus, rr.Code, rr.Body.String())
+			}
+
+			if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("expected content type (application/json), got (%s)", contentType)
+			}
+
+			var body bytes.Buffer
+			if err := json.Compact(&body, rr.Body.Bytes()); err != nil {
+				t.Fatalf("invalid json body (%s): %s", rr.Body.String(), err.Error())
+			}
+
+			for _, expected := range tc.expectedBody {
+				if !strings.Contains(body.String(), expected) {
+					t.Errorf("expected (%s) in body (%s)", expected, body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestGatewayActor(t *testing.T) {
+	serviceMock := &actorServiceMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	gateway, err := NewGateway(NewUrlGrpcService(serviceMock, l))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// the forwarded addresses sent by the client are ignored, the gateway appends the address of the http client
+	req := httptest.NewRequest(http.MethodDelete, "/api/1", nil)
+	req.Header.Set("X-API-Key", "secret")
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.2")
+	req.Header.Set("Grpc-Metadata-X-Forwarded-For", "203.0.113.8")
+	req.RemoteAddr = "192.0.2.1:1234"
+	rr := httptest.NewRecorder()
+
+	gateway.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status (%d), got (%d) with body (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	if expected := entities.NewActor("secret", "", "").Name; serviceMock.actor.Name != expected {
+		t.Errorf("expected actor (%s), got (%s)", expected, serviceMock.actor.Name)
+	}
+
+	if serviceMock.actor.RequestId != "req-1" {
+		t.Errorf("expected request id (req-1), got (%s)", serviceMock.actor.RequestId)
+	}
+
+	if serviceMock.actor.ClientIp != "192.0.2.1" {
+		t.Errorf("expected client ip (192.0.2.1), got (%s)", serviceMock.actor.ClientIp)
+	}
+}
+
+func TestGatewayClientIdentity(t *testing.T) {
+	serviceMock := &actorServiceMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	gateway, err := NewGateway(NewUrlGrpcService(serviceMock, l))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "admin"}}}}}
+
+	testCases := []struct {
+		name          string
+		tls           *tls.ConnectionState
+		clientAuth    bool
+		expectedActor string
+	}{
+		{name: "verified client", tls: verified, clientAuth: true, expectedActor: entities.CertActorPrefix + "admin"},
+		{name: "without client auth", tls: verified, expectedActor: entities.AnonymousActor},
+		{name: "without tls", expectedActor: entities.AnonymousActor},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			serviceMock.actor = entities.Actor{}
+
+			// the identity sent by the client is never forwarded
+			req := httptest.NewRequest(http.MethodDelete, "/api/1", nil)
+			req.Header.Set("X-Client-Identity", "intruder")
+			req.Header.Set("Grpc-Metadata-X-Client-Identity", "intruder")
+			req.TLS = tc.tls
+			rr := httptest.NewRecorder()
+
+			handler := gateway
+			if tc.clientAuth {
+				handler = httpC.ClientAuth(nil)(gateway)
+			}
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected status (%d), got (%d) with body (%s)", http.StatusOK, rr.Code, rr.Body.String())
+			}
+
+			if serviceMock.actor.Name != tc.expectedActor {
+				t.Errorf("expected actor (%s), got (%s)", tc.expectedActor, serviceMock.actor.Name)
+			}
+		})
+	}
+}
+
+func TestGatewayPatchClearsFields(t *testing.T) {
+	serviceMock := &patchServiceMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	gateway, err := NewGateway(NewUrlGrpcService(serviceMock, l))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// the fields sent with their empty value are cleared
+	req := httptest.NewRequest(http.MethodPatch, "/api/1", strings.NewReader(`{"maxClicks":0,"fallbackUrl":"","activeUntil":null,"tags":[]}`))
+	rr := httptest.NewRecorder()
+
+	gateway.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status (%d), got (%d) with body (%s)", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	u := serviceMock.replaced
+	if u == nil || u.MaxClicks != 0 || u.ActiveUntil != nil || u.FallbackUrl != "" || len(u.Tags) != 0 {
+		t.Fatalf("expected the sent fields to be cleared, got (%+v)", u)
+	}
+
+	if u.Url != "https://google.com" || u.RedirectType != entities.RedirectPermanent {
+		t.Errorf("expected the fields that are not sent to be kept, got (%+v)", u)
+	}
+}
@@ -0,0 +1,213 @@
+package grpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// numericJSONPb is the gateway marshaler, it writes the proto3 JSON mapping with the 64 bit integers as numbers
+// protojson writes them as strings, the REST api answered them as numbers before the gateway
+type numericJSONPb struct {
+	runtime.JSONPb
+}
+
+// protoMessageType is the type of the proto.Message interface
+var protoMessageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+// Marshal writes v like JSONPb, then unquotes the 64 bit integers of the proto message or list of proto messages
+func (m *numericJSONPb) Marshal(v interface{}) ([]byte, error) {
+	b, err := m.JSONPb.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var out json.RawMessage
+	switch md, list := messageDescriptor(v); {
+	case md == nil:
+		return b, nil
+	case list:
+		out, err = numericList(b, func(e json.RawMessage) (json.RawMessage, error) {
+			return numericMessage(e, md)
+		})
+	default:
+		out, err = numericMessage(b, md)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to write the 64 bit integers as numbers: %s", err.Error())
+	}
+
+	return out, nil
+}
+
+// NewEncoder returns an encoder that writes the values like Marshal, each one followed by the delimiter
+func (m *numericJSONPb) NewEncoder(w io.Writer) runtime.Encoder {
+	return runtime.EncoderFunc(func(v interface{}) error {
+		b, err := m.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(append(b, m.Delimiter()...))
+		return err
+	})
+}
+
+// messageDescriptor returns the descriptor of a proto message, or of the elements of a list of proto messages
+// The lists are the response bodies of the calls that answer a single repeated field
+func messageDescriptor(v interface{}) (protoreflect.MessageDescriptor, bool) {
+	if pm, ok := v.(proto.Message); ok {
+		return pm.ProtoReflect().Descriptor(), false
+	}
+
+	if t := reflect.TypeOf(v); t != nil && t.Kind() == reflect.Slice && t.Elem().Implements(protoMessageType) {
+		pm := reflect.Zero(t.Elem()).Interface().(proto.Message)
+		return pm.ProtoReflect().Descriptor(), true
+	}
+
+	return nil, false
+}
+
+// numericMessage unquotes the 64 bit integer fields of the JSON object of a message, the object keeps its key order
+func numericMessage(b json.RawMessage, md protoreflect.MessageDescriptor) (json.RawMessage, error) {
+	return numericObject(b, func(key string, v json.RawMessage) (json.RawMessage, error) {
+		fd := md.Fields().ByJSONName(key)
+		if fd == nil {
+			return v, nil
+		}
+
+		return numericField(v, fd)
+	})
+}
+
+// numericField unquotes the 64 bit integers of the value of a field, a list or map field is done element by element
+func numericField(v json.RawMessage, fd protoreflect.FieldDescriptor) (json.RawMessage, error) {
+	switch {
+	case string(v) == "null":
+		return v, nil
+	case fd.IsList():
+		return numericList(v, func(e json.RawMessage) (json.RawMessage, error) {
+			return numericValue(e, fd)
+		})
+	case fd.IsMap():
+		return numericObject(v, func(_ string, e json.RawMessage) (json.RawMessage, error) {
+			return numericValue(e, fd.MapValue())
+		})
+	}
+
+	return numericValue(v, fd)
+}
+
+// numericValue unquotes a single value of a field if it's a 64 bit integer, or the 64 bit integers of a message value
+// The well-known google.protobuf messages keep their JSON mapping
+func numericValue(v json.RawMessage, fd protoreflect.FieldDescriptor) (json.RawMessage, error) {
+	switch fd.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return v, nil
+		}
+
+		return json.RawMessage(s), nil
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if string(v) == "null" || strings.HasPrefix(string(fd.Message().FullName()), "google.protobuf.") {
+			return v, nil
+		}
+
+		return numericMessage(v, fd.Message())
+	}
+
+	return v, nil
+}
+
+// numericObject calls f with every key and value of a JSON object and returns the object of the values it returns
+func numericObject(b json.RawMessage, f func(string, json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if t != json.Delim('{') {
+		return nil, fmt.Errorf("expected a JSON object, got %v", t)
+	}
+
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		key, _ := t.(string)
+		var v json.RawMessage
+		if err := dec.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		if v, err = f(key, v); err != nil {
+			return nil, err
+		}
+
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		if err := writeString(&out, key); err != nil {
+			return nil, err
+		}
+		out.WriteByte(':')
+		out.Write(v)
+	}
+	out.WriteByte('}')
+
+	return out.Bytes(), nil
+}
+
+// numericList calls f with every element of a JSON array and returns the array of the elements it returns
+func numericList(b json.RawMessage, f func(json.RawMessage) (json.RawMessage, error)) (json.RawMessage, error) {
+	var elems []json.RawMessage
+	if err := json.Unmarshal(b, &elems); err != nil {
+		return nil, err
+	}
+	if elems == nil {
+		return b, nil
+	}
+
+	var out bytes.Buffer
+	out.WriteByte('[')
+	for i, e := range elems {
+		v, err := f(e)
+		if err != nil {
+			return nil, err
+		}
+
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		out.Write(v)
+	}
+	out.WriteByte(']')
+
+	return out.Bytes(), nil
+}
+
+// writeString writes s as a JSON string without escaping the HTML characters, like protojson
+func writeString(w *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+
+	// the encoder ends every value with a newline
+	w.Truncate(w.Len() - 1)
+
+	return nil
+}
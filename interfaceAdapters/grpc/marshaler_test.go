@@ -0,0 +1,92 @@
+package grpc
+
+import (
+	"bytes"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/norby7/shortening-service/interfaceAdapters/grpc/protocol"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNumericJSONPbMarshal(t *testing.T) {
+	createdAt := timestamppb.New(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	testCases := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{
+			name:     "url",
+			value:    &protocol.Url{Id: 1, Url: "https://google.com/?a=1&b=2", Counter: 2, MaxClicks: 5, CreatedAt: createdAt},
+			expected: `{"id":1,"url":"https://google.com/?a=1&b=2","counter":2,"maxClicks":5,"createdAt":"2022-01-01T00:00:00Z"}`,
+		},
+		{
+			name:     "largest int64",
+			value:    &protocol.Url{Id: math.MaxInt64},
+			expected: `{"id":9223372036854775807}`,
+		},
+		{
+			name:     "json name",
+			value:    &protocol.Counter{Value: 7},
+			expected: `{"counter":7}`,
+		},
+		{
+			name:     "nested messages",
+			value:    &protocol.AuditEvent{Id: 1, UrlId: 6, Before: &protocol.Url{Id: 6}, After: &protocol.Url{Id: 6, Counter: 3}},
+			expected: `{"id":1,"urlId":6,"before":{"id":6},"after":{"id":6,"counter":3}}`,
+		},
+		{
+			name:     "repeated messages",
+			value:    &protocol.UrlVariants{Id: 6, Variants: []*protocol.Variant{{Id: 1, Counter: 4}, {Id: 2}}},
+			expected: `{"id":6,"variants":[{"id":1,"counter":4},{"id":2}]}`,
+		},
+		{
+			name:     "response body list",
+			value:    []*protocol.Url{{Id: 1}, {Id: 2, Counter: 3}},
+			expected: `[{"id":1},{"id":2,"counter":3}]`,
+		},
+		{
+			name:     "empty response body list",
+			value:    []*protocol.Url{},
+			expected: `[]`,
+		},
+		{
+			name:     "not a proto message",
+			value:    map[string]string{"id": "1"},
+			expected: `{"id":"1"}`,
+		},
+	}
+
+	m := &numericJSONPb{}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := m.Marshal(tc.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if string(b) != tc.expected {
+				t.Errorf("expected (%s), got (%s)", tc.expected, string(b))
+			}
+		})
+	}
+}
+
+func TestNumericJSONPbEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	m := &numericJSONPb{runtime.JSONPb{}}
+	enc := m.NewEncoder(&buf)
+
+	for _, c := range []int64{1, 2} {
+		if err := enc.Encode(&protocol.Counter{Value: c}); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+
+	if expected := "{\"counter\":1}\n{\"counter\":2}\n"; buf.String() != expected {
+		t.Errorf("expected (%q), got (%q)", expected, buf.String())
+	}
+}
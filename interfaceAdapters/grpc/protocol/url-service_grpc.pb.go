@@ -18,10 +18,61 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type UrlServiceClient interface {
+	// Creates a new url and returns it, the code is generated if it's empty
 	Add(ctx context.Context, in *Url, opts ...grpc.CallOption) (*Url, error)
+	// Moves a url to the trash, it stops redirecting and can be restored until the trash retention period ends
+	// The url code is not given to other urls while the url is in the trash
 	Delete(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*VoidResponse, error)
+	// Moves a deleted url out of the trash and returns it, the url redirects again
+	Restore(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*Url, error)
+	// Changes the fields of a url that are set, the fields left empty keep their value, use Patch to clear them,
+	// and returns the updated url
+	Update(ctx context.Context, in *Url, opts ...grpc.CallOption) (*Url, error)
+	// Changes the fields of a url that are in the update mask and returns the updated url, the masked fields
+	// that are not set are cleared. Over http the mask is made of the fields sent in the body
+	Patch(ctx context.Context, in *PatchUrlRequest, opts ...grpc.CallOption) (*Url, error)
+	// Replaces the ordered redirect rules of a url and returns the updated url, an empty list removes them
+	SetRules(ctx context.Context, in *UrlRules, opts ...grpc.CallOption) (*Url, error)
+	// Replaces the weighted destinations of a url and returns the updated url
+	// The variants missing from the list are removed, an empty list removes them all
+	SetVariants(ctx context.Context, in *UrlVariants, opts ...grpc.CallOption) (*Url, error)
+	// Returns a url
 	Get(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*Url, error)
+	// Returns the ordered redirect rules of a url
+	GetRules(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*UrlRules, error)
+	// Returns the weighted destinations of a url together with their redirections counters
+	GetVariants(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*UrlVariants, error)
+	// Returns the redirections counter of a url
 	GetCounter(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*Counter, error)
+	// Returns the deleted urls that can still be restored, the most recently deleted first
+	// The literal paths are declared after /api/{value} so the REST routes match them first
+	GetTrash(ctx context.Context, in *VoidResponse, opts ...grpc.CallOption) (*UrlList, error)
+	// Returns the audit events of the url changes matching the filter, the most recent first
+	GetAuditEvents(ctx context.Context, in *AuditFilter, opts ...grpc.CallOption) (*AuditEvents, error)
+	// Resolves a short url request into the response a redirect server sends, like the redirects of the http server,
+	// and counts the click if it's recorded. Unknown, inactive and exhausted urls are resolved to 404 or 410 responses
+	Resolve(ctx context.Context, in *Code, opts ...grpc.CallOption) (*Resolution, error)
+	// Returns the url with the given code
+	// The code paths are declared after /api/{value}/rules and /api/{value}/variants so the REST routes match them first
+	GetByCode(ctx context.Context, in *Code, opts ...grpc.CallOption) (*Url, error)
+	// Returns the redirections counter of the url with the given code
+	GetCounterByCode(ctx context.Context, in *Code, opts ...grpc.CallOption) (*Counter, error)
+	// Streams the clicks counted from now on that match the filter, over http they are served as server-sent events
+	// at /api/events. A subscriber that doesn't keep up with the clicks is dropped with Unavailable and the
+	// SLOW_CONSUMER reason, the stream ends with OK when the server shuts down
+	WatchClicks(ctx context.Context, in *ClickFilter, opts ...grpc.CallOption) (UrlService_WatchClicksClient, error)
+	// Subscribes a webhook to url events and returns it with its secret, the secret is generated if it's empty
+	// The webhook paths are declared after /api/{value} so the REST routes match them first
+	AddWebhook(ctx context.Context, in *Webhook, opts ...grpc.CallOption) (*Webhook, error)
+	// Returns the webhooks, without their secrets
+	GetWebhooks(ctx context.Context, in *VoidResponse, opts ...grpc.CallOption) (*Webhooks, error)
+	// Removes a webhook and its dead letters
+	DeleteWebhook(ctx context.Context, in *WebhookId, opts ...grpc.CallOption) (*VoidResponse, error)
+	// Returns the events that couldn't be delivered after every retry, the most recent failures first
+	GetDeadLetters(ctx context.Context, in *DeadLetterFilter, opts ...grpc.CallOption) (*DeadLetters, error)
+	// Delivers a dead letter again, once, and removes it if the webhook accepts it
+	// A failed delivery is counted in the dead letter and returns Unavailable
+	ReplayDeadLetter(ctx context.Context, in *DeadLetterId, opts ...grpc.CallOption) (*VoidResponse, error)
 }
 
 type urlServiceClient struct {
@@ -50,6 +101,51 @@ func (c *urlServiceClient) Delete(ctx context.Context, in *UrlId, opts ...grpc.C
 	return out, nil
 }
 
+func (c *urlServiceClient) Restore(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*Url, error) {
+	out := new(Url)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/Restore", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) Update(ctx context.Context, in *Url, opts ...grpc.CallOption) (*Url, error) {
+	out := new(Url)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/Update", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) Patch(ctx context.Context, in *PatchUrlRequest, opts ...grpc.CallOption) (*Url, error) {
+	out := new(Url)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/Patch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) SetRules(ctx context.Context, in *UrlRules, opts ...grpc.CallOption) (*Url, error) {
+	out := new(Url)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/SetRules", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) SetVariants(ctx context.Context, in *UrlVariants, opts ...grpc.CallOption) (*Url, error) {
+	out := new(Url)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/SetVariants", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *urlServiceClient) Get(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*Url, error) {
 	out := new(Url)
 	err := c.cc.Invoke(ctx, "/protocol.UrlService/Get", in, out, opts...)
@@ -59,6 +155,24 @@ func (c *urlServiceClient) Get(ctx context.Context, in *UrlId, opts ...grpc.Call
 	return out, nil
 }
 
+func (c *urlServiceClient) GetRules(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*UrlRules, error) {
+	out := new(UrlRules)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/GetRules", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) GetVariants(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*UrlVariants, error) {
+	out := new(UrlVariants)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/GetVariants", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *urlServiceClient) GetCounter(ctx context.Context, in *UrlId, opts ...grpc.CallOption) (*Counter, error) {
 	out := new(Counter)
 	err := c.cc.Invoke(ctx, "/protocol.UrlService/GetCounter", in, out, opts...)
@@ -68,14 +182,187 @@ func (c *urlServiceClient) GetCounter(ctx context.Context, in *UrlId, opts ...gr
 	return out, nil
 }
 
+func (c *urlServiceClient) GetTrash(ctx context.Context, in *VoidResponse, opts ...grpc.CallOption) (*UrlList, error) {
+	out := new(UrlList)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/GetTrash", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) GetAuditEvents(ctx context.Context, in *AuditFilter, opts ...grpc.CallOption) (*AuditEvents, error) {
+	out := new(AuditEvents)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/GetAuditEvents", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) Resolve(ctx context.Context, in *Code, opts ...grpc.CallOption) (*Resolution, error) {
+	out := new(Resolution)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/Resolve", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) GetByCode(ctx context.Context, in *Code, opts ...grpc.CallOption) (*Url, error) {
+	out := new(Url)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/GetByCode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) GetCounterByCode(ctx context.Context, in *Code, opts ...grpc.CallOption) (*Counter, error) {
+	out := new(Counter)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/GetCounterByCode", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) WatchClicks(ctx context.Context, in *ClickFilter, opts ...grpc.CallOption) (UrlService_WatchClicksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &UrlService_ServiceDesc.Streams[0], "/protocol.UrlService/WatchClicks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &urlServiceWatchClicksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UrlService_WatchClicksClient interface {
+	Recv() (*ClickEvent, error)
+	grpc.ClientStream
+}
+
+type urlServiceWatchClicksClient struct {
+	grpc.ClientStream
+}
+
+func (x *urlServiceWatchClicksClient) Recv() (*ClickEvent, error) {
+	m := new(ClickEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *urlServiceClient) AddWebhook(ctx context.Context, in *Webhook, opts ...grpc.CallOption) (*Webhook, error) {
+	out := new(Webhook)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/AddWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) GetWebhooks(ctx context.Context, in *VoidResponse, opts ...grpc.CallOption) (*Webhooks, error) {
+	out := new(Webhooks)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/GetWebhooks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) DeleteWebhook(ctx context.Context, in *WebhookId, opts ...grpc.CallOption) (*VoidResponse, error) {
+	out := new(VoidResponse)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/DeleteWebhook", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) GetDeadLetters(ctx context.Context, in *DeadLetterFilter, opts ...grpc.CallOption) (*DeadLetters, error) {
+	out := new(DeadLetters)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/GetDeadLetters", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *urlServiceClient) ReplayDeadLetter(ctx context.Context, in *DeadLetterId, opts ...grpc.CallOption) (*VoidResponse, error) {
+	out := new(VoidResponse)
+	err := c.cc.Invoke(ctx, "/protocol.UrlService/ReplayDeadLetter", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UrlServiceServer is the server API for UrlService service.
 // All implementations must embed UnimplementedUrlServiceServer
 // for forward compatibility
 type UrlServiceServer interface {
+	// Creates a new url and returns it, the code is generated if it's empty
 	Add(context.Context, *Url) (*Url, error)
+	// Moves a url to the trash, it stops redirecting and can be restored until the trash retention period ends
+	// The url code is not given to other urls while the url is in the trash
 	Delete(context.Context, *UrlId) (*VoidResponse, error)
+	// Moves a deleted url out of the trash and returns it, the url redirects again
+	Restore(context.Context, *UrlId) (*Url, error)
+	// Changes the fields of a url that are set, the fields left empty keep their value, use Patch to clear them,
+	// and returns the updated url
+	Update(context.Context, *Url) (*Url, error)
+	// Changes the fields of a url that are in the update mask and returns the updated url, the masked fields
+	// that are not set are cleared. Over http the mask is made of the fields sent in the body
+	Patch(context.Context, *PatchUrlRequest) (*Url, error)
+	// Replaces the ordered redirect rules of a url and returns the updated url, an empty list removes them
+	SetRules(context.Context, *UrlRules) (*Url, error)
+	// Replaces the weighted destinations of a url and returns the updated url
+	// The variants missing from the list are removed, an empty list removes them all
+	SetVariants(context.Context, *UrlVariants) (*Url, error)
+	// Returns a url
 	Get(context.Context, *UrlId) (*Url, error)
+	// Returns the ordered redirect rules of a url
+	GetRules(context.Context, *UrlId) (*UrlRules, error)
+	// Returns the weighted destinations of a url together with their redirections counters
+	GetVariants(context.Context, *UrlId) (*UrlVariants, error)
+	// Returns the redirections counter of a url
 	GetCounter(context.Context, *UrlId) (*Counter, error)
+	// Returns the deleted urls that can still be restored, the most recently deleted first
+	// The literal paths are declared after /api/{value} so the REST routes match them first
+	GetTrash(context.Context, *VoidResponse) (*UrlList, error)
+	// Returns the audit events of the url changes matching the filter, the most recent first
+	GetAuditEvents(context.Context, *AuditFilter) (*AuditEvents, error)
+	// Resolves a short url request into the response a redirect server sends, like the redirects of the http server,
+	// and counts the click if it's recorded. Unknown, inactive and exhausted urls are resolved to 404 or 410 responses
+	Resolve(context.Context, *Code) (*Resolution, error)
+	// Returns the url with the given code
+	// The code paths are declared after /api/{value}/rules and /api/{value}/variants so the REST routes match them first
+	GetByCode(context.Context, *Code) (*Url, error)
+	// Returns the redirections counter of the url with the given code
+	GetCounterByCode(context.Context, *Code) (*Counter, error)
+	// Streams the clicks counted from now on that match the filter, over http they are served as server-sent events
+	// at /api/events. A subscriber that doesn't keep up with the clicks is dropped with Unavailable and the
+	// SLOW_CONSUMER reason, the stream ends with OK when the server shuts down
+	WatchClicks(*ClickFilter, UrlService_WatchClicksServer) error
+	// Subscribes a webhook to url events and returns it with its secret, the secret is generated if it's empty
+	// The webhook paths are declared after /api/{value} so the REST routes match them first
+	AddWebhook(context.Context, *Webhook) (*Webhook, error)
+	// Returns the webhooks, without their secrets
+	GetWebhooks(context.Context, *VoidResponse) (*Webhooks, error)
+	// Removes a webhook and its dead letters
+	DeleteWebhook(context.Context, *WebhookId) (*VoidResponse, error)
+	// Returns the events that couldn't be delivered after every retry, the most recent failures first
+	GetDeadLetters(context.Context, *DeadLetterFilter) (*DeadLetters, error)
+	// Delivers a dead letter again, once, and removes it if the webhook accepts it
+	// A failed delivery is counted in the dead letter and returns Unavailable
+	ReplayDeadLetter(context.Context, *DeadLetterId) (*VoidResponse, error)
 	mustEmbedUnimplementedUrlServiceServer()
 }
 
@@ -89,12 +376,66 @@ func (UnimplementedUrlServiceServer) Add(context.Context, *Url) (*Url, error) {
 func (UnimplementedUrlServiceServer) Delete(context.Context, *UrlId) (*VoidResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
 }
+func (UnimplementedUrlServiceServer) Restore(context.Context, *UrlId) (*Url, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Restore not implemented")
+}
+func (UnimplementedUrlServiceServer) Update(context.Context, *Url) (*Url, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedUrlServiceServer) Patch(context.Context, *PatchUrlRequest) (*Url, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Patch not implemented")
+}
+func (UnimplementedUrlServiceServer) SetRules(context.Context, *UrlRules) (*Url, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRules not implemented")
+}
+func (UnimplementedUrlServiceServer) SetVariants(context.Context, *UrlVariants) (*Url, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetVariants not implemented")
+}
 func (UnimplementedUrlServiceServer) Get(context.Context, *UrlId) (*Url, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
 }
+func (UnimplementedUrlServiceServer) GetRules(context.Context, *UrlId) (*UrlRules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRules not implemented")
+}
+func (UnimplementedUrlServiceServer) GetVariants(context.Context, *UrlId) (*UrlVariants, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetVariants not implemented")
+}
 func (UnimplementedUrlServiceServer) GetCounter(context.Context, *UrlId) (*Counter, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetCounter not implemented")
 }
+func (UnimplementedUrlServiceServer) GetTrash(context.Context, *VoidResponse) (*UrlList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTrash not implemented")
+}
+func (UnimplementedUrlServiceServer) GetAuditEvents(context.Context, *AuditFilter) (*AuditEvents, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAuditEvents not implemented")
+}
+func (UnimplementedUrlServiceServer) Resolve(context.Context, *Code) (*Resolution, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resolve not implemented")
+}
+func (UnimplementedUrlServiceServer) GetByCode(context.Context, *Code) (*Url, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetByCode not implemented")
+}
+func (UnimplementedUrlServiceServer) GetCounterByCode(context.Context, *Code) (*Counter, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCounterByCode not implemented")
+}
+func (UnimplementedUrlServiceServer) WatchClicks(*ClickFilter, UrlService_WatchClicksServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchClicks not implemented")
+}
+func (UnimplementedUrlServiceServer) AddWebhook(context.Context, *Webhook) (*Webhook, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddWebhook not implemented")
+}
+func (UnimplementedUrlServiceServer) GetWebhooks(context.Context, *VoidResponse) (*Webhooks, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetWebhooks not implemented")
+}
+func (UnimplementedUrlServiceServer) DeleteWebhook(context.Context, *WebhookId) (*VoidResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteWebhook not implemented")
+}
+func (UnimplementedUrlServiceServer) GetDeadLetters(context.Context, *DeadLetterFilter) (*DeadLetters, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDeadLetters not implemented")
+}
+func (UnimplementedUrlServiceServer) ReplayDeadLetter(context.Context, *DeadLetterId) (*VoidResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplayDeadLetter not implemented")
+}
 func (UnimplementedUrlServiceServer) mustEmbedUnimplementedUrlServiceServer() {}
 
 // UnsafeUrlServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -144,6 +485,96 @@ func _UrlService_Delete_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UrlService_Restore_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UrlId)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).Restore(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/Restore",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).Restore(ctx, req.(*UrlId))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Url)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/Update",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).Update(ctx, req.(*Url))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_Patch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PatchUrlRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).Patch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/Patch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).Patch(ctx, req.(*PatchUrlRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_SetRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UrlRules)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).SetRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/SetRules",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).SetRules(ctx, req.(*UrlRules))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_SetVariants_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UrlVariants)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).SetVariants(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/SetVariants",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).SetVariants(ctx, req.(*UrlVariants))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _UrlService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UrlId)
 	if err := dec(in); err != nil {
@@ -162,6 +593,42 @@ func _UrlService_Get_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UrlService_GetRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UrlId)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).GetRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/GetRules",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).GetRules(ctx, req.(*UrlId))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_GetVariants_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UrlId)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).GetVariants(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/GetVariants",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).GetVariants(ctx, req.(*UrlId))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _UrlService_GetCounter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(UrlId)
 	if err := dec(in); err != nil {
@@ -180,6 +647,207 @@ func _UrlService_GetCounter_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UrlService_GetTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoidResponse)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).GetTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/GetTrash",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).GetTrash(ctx, req.(*VoidResponse))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_GetAuditEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuditFilter)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).GetAuditEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/GetAuditEvents",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).GetAuditEvents(ctx, req.(*AuditFilter))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_Resolve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Code)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).Resolve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/Resolve",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).Resolve(ctx, req.(*Code))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_GetByCode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Code)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).GetByCode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/GetByCode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).GetByCode(ctx, req.(*Code))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_GetCounterByCode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Code)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).GetCounterByCode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/GetCounterByCode",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).GetCounterByCode(ctx, req.(*Code))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_WatchClicks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ClickFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UrlServiceServer).WatchClicks(m, &urlServiceWatchClicksServer{stream})
+}
+
+type UrlService_WatchClicksServer interface {
+	Send(*ClickEvent) error
+	grpc.ServerStream
+}
+
+type urlServiceWatchClicksServer struct {
+	grpc.ServerStream
+}
+
+func (x *urlServiceWatchClicksServer) Send(m *ClickEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _UrlService_AddWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Webhook)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).AddWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/AddWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).AddWebhook(ctx, req.(*Webhook))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_GetWebhooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VoidResponse)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).GetWebhooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/GetWebhooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).GetWebhooks(ctx, req.(*VoidResponse))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_DeleteWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WebhookId)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).DeleteWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/DeleteWebhook",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).DeleteWebhook(ctx, req.(*WebhookId))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_GetDeadLetters_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeadLetterFilter)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).GetDeadLetters(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/GetDeadLetters",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).GetDeadLetters(ctx, req.(*DeadLetterFilter))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UrlService_ReplayDeadLetter_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeadLetterId)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UrlServiceServer).ReplayDeadLetter(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/protocol.UrlService/ReplayDeadLetter",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UrlServiceServer).ReplayDeadLetter(ctx, req.(*DeadLetterId))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // UrlService_ServiceDesc is the grpc.ServiceDesc for UrlService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -195,15 +863,89 @@ var UrlService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Delete",
 			Handler:    _UrlService_Delete_Handler,
 		},
+		{
+			MethodName: "Restore",
+			Handler:    _UrlService_Restore_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _UrlService_Update_Handler,
+		},
+		{
+			MethodName: "Patch",
+			Handler:    _UrlService_Patch_Handler,
+		},
+		{
+			MethodName: "SetRules",
+			Handler:    _UrlService_SetRules_Handler,
+		},
+		{
+			MethodName: "SetVariants",
+			Handler:    _UrlService_SetVariants_Handler,
+		},
 		{
 			MethodName: "Get",
 			Handler:    _UrlService_Get_Handler,
 		},
+		{
+			MethodName: "GetRules",
+			Handler:    _UrlService_GetRules_Handler,
+		},
+		{
+			MethodName: "GetVariants",
+			Handler:    _UrlService_GetVariants_Handler,
+		},
 		{
 			MethodName: "GetCounter",
 			Handler:    _UrlService_GetCounter_Handler,
 		},
+		{
+			MethodName: "GetTrash",
+			Handler:    _UrlService_GetTrash_Handler,
+		},
+		{
+			MethodName: "GetAuditEvents",
+			Handler:    _UrlService_GetAuditEvents_Handler,
+		},
+		{
+			MethodName: "Resolve",
+			Handler:    _UrlService_Resolve_Handler,
+		},
+		{
+			MethodName: "GetByCode",
+			Handler:    _UrlService_GetByCode_Handler,
+		},
+		{
+			MethodName: "GetCounterByCode",
+			Handler:    _UrlService_GetCounterByCode_Handler,
+		},
+		{
+			MethodName: "AddWebhook",
+			Handler:    _UrlService_AddWebhook_Handler,
+		},
+		{
+			MethodName: "GetWebhooks",
+			Handler:    _UrlService_GetWebhooks_Handler,
+		},
+		{
+			MethodName: "DeleteWebhook",
+			Handler:    _UrlService_DeleteWebhook_Handler,
+		},
+		{
+			MethodName: "GetDeadLetters",
+			Handler:    _UrlService_GetDeadLetters_Handler,
+		},
+		{
+			MethodName: "ReplayDeadLetter",
+			Handler:    _UrlService_ReplayDeadLetter_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchClicks",
+			Handler:       _UrlService_WatchClicks_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "interfaceAdapters/grpc/protocol/url-service.proto",
 }
@@ -0,0 +1,90 @@
+package grpc
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// tracer creates the spans of the grpc calls
+var tracer = otel.Tracer("github.com/norby7/shortening-service/interfaceAdapters/grpc")
+
+// metadataCarrier adapts the incoming metadata of a call to the propagation.TextMapCarrier interface
+type metadataCarrier metadata.MD
+
+// Get returns the first value of the key
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+// Set replaces the values of the key
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// Keys returns the metadata keys
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// TraceInterceptor returns a unary interceptor that serves every call in a server span named after its method,
+// continuing the trace of the W3C traceparent metadata when there is one. The span has the short url code and the
+// status code attributes and is marked as failed for the internal and unknown errors
+func TraceInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+		}
+
+		service, method := splitMethod(info.FullMethod)
+		ctx, span := tracer.Start(ctx, strings.TrimPrefix(info.FullMethod, "/"),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCService(service), semconv.RPCMethod(method)),
+		)
+		defer span.End()
+
+		if c, ok := req.(interface{ GetCode() string }); ok && c.GetCode() != "" {
+			span.SetAttributes(attribute.String("code", c.GetCode()))
+		}
+
+		res, err := handler(ctx, req)
+
+		st := status.Code(err)
+		span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(st)))
+		if err != nil {
+			span.RecordError(err)
+			if st == grpcCodes.Internal || st == grpcCodes.Unknown {
+				span.SetStatus(codes.Error, err.Error())
+			}
+		}
+
+		return res, err
+	}
+}
+
+// splitMethod splits a /package.Service/Method grpc method name into its service and method
+func splitMethod(fullMethod string) (string, string) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+
+	return name, ""
+}
@@ -0,0 +1,107 @@
+package grpc
+
+import (
+	"context"
+	"github.com/norby7/shortening-service/interfaceAdapters/grpc/protocol"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpcCodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestTraceInterceptor(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	testCases := []struct {
+		name           string
+		traceparent    string
+		err            error
+		expectedTrace  string
+		expectedCode   grpcCodes.Code
+		expectedStatus codes.Code
+	}{
+		{name: "new trace", expectedCode: grpcCodes.OK, expectedStatus: codes.Unset},
+		{
+			name:           "propagated trace",
+			traceparent:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expectedTrace:  "4bf92f3577b34da6a3ce929d0e0e4736",
+			expectedCode:   grpcCodes.OK,
+			expectedStatus: codes.Unset,
+		},
+		{
+			name:           "not found",
+			err:            status.Error(grpcCodes.NotFound, "not found"),
+			expectedCode:   grpcCodes.NotFound,
+			expectedStatus: codes.Unset,
+		},
+		{
+			name:           "internal error",
+			err:            status.Error(grpcCodes.Internal, "failed"),
+			expectedCode:   grpcCodes.Internal,
+			expectedStatus: codes.Error,
+		},
+	}
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/protocol.UrlService/Update"}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter.Reset()
+
+			ctx := context.Background()
+			if tc.traceparent != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("traceparent", tc.traceparent))
+			}
+
+			var handlerSpan trace.SpanContext
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				handlerSpan = trace.SpanContextFromContext(ctx)
+				return nil, tc.err
+			}
+
+			_, _ = TraceInterceptor()(ctx, &protocol.Url{Code: "84gfj4i9"}, info, handler)
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected (1) span, got (%d)", len(spans))
+			}
+
+			span := spans[0]
+			if span.Name != "protocol.UrlService/Update" || span.SpanKind != trace.SpanKindServer {
+				t.Errorf("expected server span (protocol.UrlService/Update), got (%s) (%s)", span.SpanKind, span.Name)
+			}
+
+			if handlerSpan.SpanID() != span.SpanContext.SpanID() {
+				t.Errorf("expected the handler context to carry the call span")
+			}
+
+			if tc.expectedTrace != "" && span.SpanContext.TraceID().String() != tc.expectedTrace {
+				t.Errorf("expected trace id (%s), got (%s)", tc.expectedTrace, span.SpanContext.TraceID())
+			}
+
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, a := range span.Attributes {
+				attrs[a.Key] = a.Value
+			}
+
+			if attrs["code"].AsString() != "84gfj4i9" || attrs["rpc.method"].AsString() != "Update" ||
+				attrs["rpc.grpc.status_code"].AsInt64() != int64(tc.expectedCode) {
+				t.Errorf("expected code (84gfj4i9), method (Update) and status (%d), got (%v)", tc.expectedCode, span.Attributes)
+			}
+
+			if span.Status.Code != tc.expectedStatus {
+				t.Errorf("expected span status (%v), got (%v)", tc.expectedStatus, span.Status.Code)
+			}
+		})
+	}
+}
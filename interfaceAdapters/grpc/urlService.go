@@ -2,54 +2,284 @@ package grpc
 
 import (
 	"context"
+	"errors"
 	"github.com/norby7/shortening-service/entities"
 	"github.com/norby7/shortening-service/interfaceAdapters/grpc/protocol"
+	"github.com/norby7/shortening-service/usecases/logging"
 	"github.com/norby7/shortening-service/usecases/service"
-	"log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
 )
 
 type UrlGrpcService struct {
 	Service service.Interactor
-	Logger  *log.Logger
+	Logger  *slog.Logger
+	// Geo locates the client country for the redirect rules of Resolve, country rules never match if it's nil
+	Geo service.GeoLocator
 	protocol.UnimplementedUrlServiceServer
 }
 
 // NewUrlGrpcService returns a new UrlGrpcService object address
-func NewUrlGrpcService(s service.Interactor, l *log.Logger) *UrlGrpcService {
+func NewUrlGrpcService(s service.Interactor, l *slog.Logger) *UrlGrpcService {
 	return &UrlGrpcService{Service: s, Logger: l}
 }
 
+// AccessLogInterceptor returns a unary interceptor that gives every call a request id, taken from the x-request-id
+// metadata or generated, sends it back in the x-request-id header and adds it to the call context so the service,
+// repository and storage log lines of the call carry it. Once the call is served it logs its method, short url code,
+// status code and latency, at the error level for the internal and unknown errors
+func AccessLogInterceptor(l *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+
+		requestId := metadataValue(ctx, "x-request-id")
+		if requestId == "" {
+			requestId = entities.NewRequestId()
+		}
+
+		ctx = logging.WithRequestId(ctx, requestId)
+		_ = grpc.SetHeader(ctx, metadata.Pairs("x-request-id", requestId))
+
+		res, err := handler(ctx, req)
+
+		var code string
+		if c, ok := req.(interface{ GetCode() string }); ok {
+			code = c.GetCode()
+		}
+
+		st := status.Code(err)
+		level := slog.LevelInfo
+		if st == codes.Internal || st == codes.Unknown {
+			level = slog.LevelError
+		}
+
+		l.LogAttrs(ctx, level, "grpc call",
+			slog.String("method", info.FullMethod),
+			slog.String("code", code),
+			slog.String("status", st.String()),
+			slog.Duration("latency", time.Since(start)),
+		)
+
+		return res, err
+	}
+}
+
+// serverStream is a grpc.ServerStream with the context given by a stream interceptor
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the stream
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// AccessLogStreamInterceptor returns a stream interceptor that gives every streaming call a request id like
+// AccessLogInterceptor and logs its method, status code and duration once the stream ends
+func AccessLogStreamInterceptor(l *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx := ss.Context()
+
+		requestId := metadataValue(ctx, "x-request-id")
+		if requestId == "" {
+			requestId = entities.NewRequestId()
+		}
+
+		ctx = logging.WithRequestId(ctx, requestId)
+		_ = ss.SetHeader(metadata.Pairs("x-request-id", requestId))
+
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+
+		st := status.Code(err)
+		level := slog.LevelInfo
+		if st == codes.Internal || st == codes.Unknown {
+			level = slog.LevelError
+		}
+
+		l.LogAttrs(ctx, level, "grpc stream",
+			slog.String("method", info.FullMethod),
+			slog.String("status", st.String()),
+			slog.Duration("latency", time.Since(start)),
+		)
+
+		return err
+	}
+}
+
+// TimeoutInterceptor returns a unary interceptor that limits every call to the given duration,
+// the deadline sent by the client is kept when it's earlier. A duration of 0 only uses the client deadline
+func TimeoutInterceptor(d time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if d <= 0 {
+			return handler(ctx, req)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+
+		return handler(ctx, req)
+	}
+}
+
 // Add creates a new Url and inserts it into the database
 func (us *UrlGrpcService) Add(ctx context.Context, u *protocol.Url) (*protocol.Url, error) {
-	us.Logger.Println("UrlGrpcService:Add called")
+	us.Logger.DebugContext(ctx, "UrlGrpcService:Add called")
 
 	url := ProtoUrlToUrl(u)
 
-	err := us.Service.Create(url)
+	err := us.Service.Create(ctx, url, requestActor(ctx))
 	if err != nil {
-		return &protocol.Url{}, err
+		return &protocol.Url{}, statusError(err)
 	}
 
 	return UrlToProtoUrl(url), nil
 }
 
-// Delete removes a url from the database based on the given ID
+// Delete moves the url with the given ID to the trash
 func (us *UrlGrpcService) Delete(ctx context.Context, id *protocol.UrlId) (*protocol.VoidResponse, error) {
-	us.Logger.Println("UrlGrpcService:Delete called")
+	us.Logger.DebugContext(ctx, "UrlGrpcService:Delete called")
 
-	err := us.Service.Delete(id.Value)
+	err := us.Service.Delete(ctx, id.Value, requestActor(ctx))
 	if err != nil {
-		return &protocol.VoidResponse{}, err
+		return &protocol.VoidResponse{}, statusError(err)
 	}
 
 	return &protocol.VoidResponse{}, nil
 }
 
-// Get returns a url from the database based on the given ID
+// Restore moves the deleted url with the given ID out of the trash and returns it
+func (us *UrlGrpcService) Restore(ctx context.Context, id *protocol.UrlId) (*protocol.Url, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:Restore called")
+
+	u, err := us.Service.Restore(ctx, id.Value, requestActor(ctx))
+	if err != nil {
+		return &protocol.Url{}, statusError(err)
+	}
+
+	return UrlToProtoUrl(&u), nil
+}
+
+// GetTrash returns the deleted urls that can still be restored
+func (us *UrlGrpcService) GetTrash(ctx context.Context, _ *protocol.VoidResponse) (*protocol.UrlList, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:GetTrash called")
+
+	urls, err := us.Service.GetDeleted(ctx)
+	if err != nil {
+		return &protocol.UrlList{}, statusError(err)
+	}
+
+	list := &protocol.UrlList{}
+	for i := range urls {
+		list.Urls = append(list.Urls, UrlToProtoUrl(&urls[i]))
+	}
+
+	return list, nil
+}
+
+// Update changes the fields of the url with the given ID that are set, the other fields keep their value
+func (us *UrlGrpcService) Update(ctx context.Context, u *protocol.Url) (*protocol.Url, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:Update called")
+
+	url := ProtoUrlToUrl(u)
+
+	err := us.Service.Update(ctx, url, requestActor(ctx))
+	if err != nil {
+		return &protocol.Url{}, statusError(err)
+	}
+
+	return UrlToProtoUrl(url), nil
+}
+
+// Patch changes the fields of the url with the given ID that are in the update mask, the masked fields that are not set
+// are cleared. An empty mask changes the fields that are set like Update. Only the top level fields of the paths are
+// used, the fields Replace doesn't change are ignored
+func (us *UrlGrpcService) Patch(ctx context.Context, r *protocol.PatchUrlRequest) (*protocol.Url, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:Patch called")
+
+	if r.Url == nil {
+		return &protocol.Url{}, invalidArgument("url", "url is required")
+	}
+
+	if len(r.UpdateMask.GetPaths()) == 0 {
+		return us.Update(ctx, r.Url)
+	}
+
+	current, err := us.Service.GetById(ctx, r.Url.Id)
+	if err != nil {
+		return &protocol.Url{}, statusError(err)
+	}
+
+	if current.Id == 0 {
+		return &protocol.Url{}, statusError(service.ErrUrlNotFound)
+	}
+
+	// copy the masked fields of the request over the current url
+	merged := UrlToProtoUrl(&current)
+	src, dst := r.Url.ProtoReflect(), merged.ProtoReflect()
+	for _, p := range r.UpdateMask.GetPaths() {
+		fd := dst.Descriptor().Fields().ByName(protoreflect.Name(strings.SplitN(p, ".", 2)[0]))
+		if fd == nil {
+			return &protocol.Url{}, invalidArgument("update_mask", "unknown path "+p)
+		}
+
+		if src.Has(fd) {
+			dst.Set(fd, src.Get(fd))
+		} else {
+			dst.Clear(fd)
+		}
+	}
+
+	url := ProtoUrlToUrl(merged)
+	if err = us.Service.Replace(ctx, url, requestActor(ctx)); err != nil {
+		return &protocol.Url{}, statusError(err)
+	}
+
+	return UrlToProtoUrl(url), nil
+}
+
+// SetRules replaces the conditional redirect rules of the url with the given ID
+func (us *UrlGrpcService) SetRules(ctx context.Context, r *protocol.UrlRules) (*protocol.Url, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:SetRules called")
+
+	u, err := us.Service.SetRules(ctx, r.Id, ProtoRulesToRules(r.Rules), requestActor(ctx))
+	if err != nil {
+		return &protocol.Url{}, statusError(err)
+	}
+
+	return UrlToProtoUrl(&u), nil
+}
+
+// SetVariants replaces the weighted destinations of the url with the given ID
+func (us *UrlGrpcService) SetVariants(ctx context.Context, v *protocol.UrlVariants) (*protocol.Url, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:SetVariants called")
+
+	u, err := us.Service.SetVariants(ctx, v.Id, ProtoVariantsToVariants(v.Variants), requestActor(ctx))
+	if err != nil {
+		return &protocol.Url{}, statusError(err)
+	}
+
+	return UrlToProtoUrl(&u), nil
+}
+
+// Get returns a url from the database based on the given ID, NotFound if it doesn't exist
 func (us *UrlGrpcService) Get(ctx context.Context, id *protocol.UrlId) (*protocol.Url, error) {
-	us.Logger.Println("UrlGrpcService:Get called")
+	us.Logger.DebugContext(ctx, "UrlGrpcService:Get called")
 
-	u, err := us.Service.GetById(id.Value)
+	u, err := us.getById(ctx, id.Value)
 	if err != nil {
 		return &protocol.Url{}, err
 	}
@@ -57,11 +287,90 @@ func (us *UrlGrpcService) Get(ctx context.Context, id *protocol.UrlId) (*protoco
 	return UrlToProtoUrl(&u), nil
 }
 
+// GetRules returns the redirect rules of the url with the given ID
+func (us *UrlGrpcService) GetRules(ctx context.Context, id *protocol.UrlId) (*protocol.UrlRules, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:GetRules called")
+
+	u, err := us.getById(ctx, id.Value)
+	if err != nil {
+		return &protocol.UrlRules{}, err
+	}
+
+	return &protocol.UrlRules{Id: u.Id, Rules: RulesToProtoRules(u.Rules)}, nil
+}
+
+// GetVariants returns the variants of the url with the given ID
+func (us *UrlGrpcService) GetVariants(ctx context.Context, id *protocol.UrlId) (*protocol.UrlVariants, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:GetVariants called")
+
+	u, err := us.getById(ctx, id.Value)
+	if err != nil {
+		return &protocol.UrlVariants{}, err
+	}
+
+	return &protocol.UrlVariants{Id: u.Id, Variants: VariantsToProtoVariants(u.Variants)}, nil
+}
+
 // GetCounter returns the redirections counter for the given ID
 func (us *UrlGrpcService) GetCounter(ctx context.Context, id *protocol.UrlId) (*protocol.Counter, error) {
-	us.Logger.Println("UrlGrpcService:GetCounter called")
+	us.Logger.DebugContext(ctx, "UrlGrpcService:GetCounter called")
+
+	u, err := us.getById(ctx, id.Value)
+	if err != nil {
+		return &protocol.Counter{}, err
+	}
+
+	return &protocol.Counter{Value: u.Counter}, nil
+}
+
+// Resolve returns the response to a request for the short url with the given code and counts the click if it's recorded
+func (us *UrlGrpcService) Resolve(ctx context.Context, code *protocol.Code) (*protocol.Resolution, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:Resolve called")
 
-	u, err := us.Service.GetById(id.Value)
+	query, err := url.ParseQuery(code.Query)
+	if err != nil {
+		return &protocol.Resolution{}, invalidArgument("query", err.Error())
+	}
+
+	res, err := service.NewResolver(us.Service, us.Geo, us.Logger).Resolve(ctx, code.Value, entities.ResolveRequest{
+		Path:        code.Path,
+		Query:       query,
+		Visitor:     entities.NewVisitor(code.UserAgent, code.AcceptLanguage),
+		ClientIp:    net.ParseIP(code.ClientIp),
+		VariantId:   code.VariantId,
+		RecordClick: code.RecordClick,
+	})
+	if err != nil {
+		return &protocol.Resolution{}, statusError(err)
+	}
+
+	return &protocol.Resolution{
+		UrlId:        res.UrlId,
+		Status:       int32(res.Status),
+		Location:     res.Location,
+		CacheControl: res.CacheControl,
+		VariantId:    res.VariantId,
+		NewVariant:   res.NewVariant,
+	}, nil
+}
+
+// GetByCode returns the url with the given code, NotFound if it doesn't exist
+func (us *UrlGrpcService) GetByCode(ctx context.Context, code *protocol.Code) (*protocol.Url, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:GetByCode called")
+
+	u, err := us.getByCode(ctx, code.Value)
+	if err != nil {
+		return &protocol.Url{}, err
+	}
+
+	return UrlToProtoUrl(&u), nil
+}
+
+// GetCounterByCode returns the redirections counter of the url with the given code
+func (us *UrlGrpcService) GetCounterByCode(ctx context.Context, code *protocol.Code) (*protocol.Counter, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:GetCounterByCode called")
+
+	u, err := us.getByCode(ctx, code.Value)
 	if err != nil {
 		return &protocol.Counter{}, err
 	}
@@ -69,26 +378,468 @@ func (us *UrlGrpcService) GetCounter(ctx context.Context, id *protocol.UrlId) (*
 	return &protocol.Counter{Value: u.Counter}, nil
 }
 
+// WatchClicks streams the clicks matching the filter until the call is cancelled, the subscriber is dropped
+// because it didn't keep up with the clicks, or the server shuts down
+func (us *UrlGrpcService) WatchClicks(f *protocol.ClickFilter, stream protocol.UrlService_WatchClicksServer) error {
+	ctx := stream.Context()
+	us.Logger.DebugContext(ctx, "UrlGrpcService:WatchClicks called")
+
+	sub := us.Service.SubscribeClicks(ctx, entities.ClickFilter{UrlId: f.UrlId, Owner: f.Owner, Tag: f.Tag})
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return statusError(ctx.Err())
+		case e, ok := <-sub.Events():
+			if !ok {
+				if err := sub.Err(); err != nil {
+					return statusError(err)
+				}
+
+				return nil
+			}
+
+			if err := stream.Send(ClickEventToProtoClickEvent(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// getByCode returns the url with the given code, or a NotFound error if it doesn't exist
+func (us *UrlGrpcService) getByCode(ctx context.Context, code string) (entities.Url, error) {
+	u, err := us.Service.GetByCode(ctx, code)
+	if err != nil {
+		return u, statusError(err)
+	}
+
+	if u.Id == 0 {
+		return u, statusError(service.ErrUrlNotFound)
+	}
+
+	return u, nil
+}
+
+// getById returns the url with the given ID, or a NotFound error if it doesn't exist
+func (us *UrlGrpcService) getById(ctx context.Context, id int64) (entities.Url, error) {
+	u, err := us.Service.GetById(ctx, id)
+	if err != nil {
+		return u, statusError(err)
+	}
+
+	if u.Id == 0 {
+		return u, statusError(service.ErrUrlNotFound)
+	}
+
+	return u, nil
+}
+
+// GetAuditEvents returns the audit events of the url changes matching the filter, the most recent first
+func (us *UrlGrpcService) GetAuditEvents(ctx context.Context, f *protocol.AuditFilter) (*protocol.AuditEvents, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:GetAuditEvents called")
+
+	events, err := us.Service.GetAuditEvents(ctx, entities.AuditFilter{
+		UrlId: f.UrlId,
+		Actor: f.Actor,
+		From:  protoTimeToTime(f.From),
+		Until: protoTimeToTime(f.Until),
+		Limit: int(f.Limit),
+	})
+	if err != nil {
+		return &protocol.AuditEvents{}, statusError(err)
+	}
+
+	result := &protocol.AuditEvents{}
+	for _, e := range events {
+		result.Events = append(result.Events, AuditEventToProtoAuditEvent(e))
+	}
+
+	return result, nil
+}
+
+// AddWebhook subscribes a webhook to url events and returns it with its secret
+func (us *UrlGrpcService) AddWebhook(ctx context.Context, w *protocol.Webhook) (*protocol.Webhook, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:AddWebhook called")
+
+	webhook := ProtoWebhookToWebhook(w)
+
+	if err := us.Service.AddWebhook(ctx, webhook); err != nil {
+		return &protocol.Webhook{}, statusError(err)
+	}
+
+	return WebhookToProtoWebhook(*webhook), nil
+}
+
+// GetWebhooks returns the webhooks without their secrets
+func (us *UrlGrpcService) GetWebhooks(ctx context.Context, _ *protocol.VoidResponse) (*protocol.Webhooks, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:GetWebhooks called")
+
+	webhooks, err := us.Service.GetWebhooks(ctx)
+	if err != nil {
+		return &protocol.Webhooks{}, statusError(err)
+	}
+
+	result := &protocol.Webhooks{}
+	for _, w := range webhooks {
+		result.Webhooks = append(result.Webhooks, WebhookToProtoWebhook(w))
+	}
+
+	return result, nil
+}
+
+// DeleteWebhook removes the webhook with the given ID and its dead letters
+func (us *UrlGrpcService) DeleteWebhook(ctx context.Context, id *protocol.WebhookId) (*protocol.VoidResponse, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:DeleteWebhook called")
+
+	if err := us.Service.DeleteWebhook(ctx, id.Value); err != nil {
+		return &protocol.VoidResponse{}, statusError(err)
+	}
+
+	return &protocol.VoidResponse{}, nil
+}
+
+// GetDeadLetters returns the events that couldn't be delivered to the webhooks, the most recent failures first
+func (us *UrlGrpcService) GetDeadLetters(ctx context.Context, f *protocol.DeadLetterFilter) (*protocol.DeadLetters, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:GetDeadLetters called")
+
+	letters, err := us.Service.GetDeadLetters(ctx, f.WebhookId)
+	if err != nil {
+		return &protocol.DeadLetters{}, statusError(err)
+	}
+
+	result := &protocol.DeadLetters{}
+	for _, d := range letters {
+		result.DeadLetters = append(result.DeadLetters, DeadLetterToProtoDeadLetter(d))
+	}
+
+	return result, nil
+}
+
+// ReplayDeadLetter delivers the dead letter with the given ID again and removes it if it's delivered
+func (us *UrlGrpcService) ReplayDeadLetter(ctx context.Context, id *protocol.DeadLetterId) (*protocol.VoidResponse, error) {
+	us.Logger.DebugContext(ctx, "UrlGrpcService:ReplayDeadLetter called")
+
+	if err := us.Service.ReplayDeadLetter(ctx, id.Value); err != nil {
+		return &protocol.VoidResponse{}, statusError(err)
+	}
+
+	return &protocol.VoidResponse{}, nil
+}
+
+// statusCodes are the grpc status codes of the service error kinds
+var statusCodes = map[service.Kind]codes.Code{
+	service.KindInternal:           codes.Internal,
+	service.KindInvalidArgument:    codes.InvalidArgument,
+	service.KindNotFound:           codes.NotFound,
+	service.KindAlreadyExists:      codes.AlreadyExists,
+	service.KindFailedPrecondition: codes.FailedPrecondition,
+	service.KindUnavailable:        codes.Unavailable,
+}
+
+// statusError converts an error into a grpc status error
+// The service errors get the status code of their kind, an ErrorInfo detail with their reason and a BadRequest detail
+// with their field violations. The context errors get the Canceled or DeadlineExceeded codes and the other errors Internal
+func statusError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var e *service.Error
+	if !errors.As(err, &e) {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return status.FromContextError(err).Err()
+		}
+
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: e.Reason, Domain: service.ErrorDomain}}
+	if len(e.Violations) > 0 {
+		br := &errdetails.BadRequest{}
+		for _, v := range e.Violations {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: v.Field, Description: v.Description})
+		}
+
+		details = append(details, br)
+	}
+
+	return withDetails(status.New(statusCodes[e.Kind], err.Error()), details...)
+}
+
+// invalidArgument returns an InvalidArgument status error with a BadRequest detail for the invalid field of a request
+func invalidArgument(field, description string) error {
+	br := &errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: description}}}
+
+	return withDetails(status.New(codes.InvalidArgument, field+": "+description), br)
+}
+
+// withDetails returns the status error with the details, or without them if they can't be encoded
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	if sd, err := st.WithDetails(details...); err == nil {
+		return sd.Err()
+	}
+
+	return st.Err()
+}
+
+// requestActor returns the actor of a call from its x-api-key metadata, request id and peer address
+// The clients authenticated by a certificate are named by the certificate identity instead of their API key, for the
+// REST calls it's the identity the gateway forwards from the ClientAuth middleware
+// The request id is the one given by AccessLogInterceptor, or the x-request-id metadata if the call didn't go through it
+// The calls served in-process by the gateway have no peer, their client ip is the last x-forwarded-for address, the
+// one the gateway appends for the http client, the previous ones are sent by the client and can be forged
+func requestActor(ctx context.Context) entities.Actor {
+	var ip, identity string
+	requestId := logging.RequestId(ctx)
+	if requestId == "" {
+		requestId = metadataValue(ctx, "x-request-id")
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+
+		identity = ClientIdentity(ctx)
+	} else {
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("x-forwarded-for")) > 0 {
+			fwd := md.Get("x-forwarded-for")
+			addrs := strings.Split(fwd[len(fwd)-1], ",")
+			ip = strings.TrimSpace(addrs[len(addrs)-1])
+		}
+
+		// the calls with a peer can't set the identity, only the gateway does
+		identity = metadataValue(ctx, clientIdentityMetadata)
+	}
+
+	actor := entities.NewActor(metadataValue(ctx, "x-api-key"), ip, requestId)
+	if identity != "" {
+		actor.Name = entities.CertActorPrefix + identity
+	}
+
+	return actor
+}
+
+// metadataValue returns the first value of the given key in the incoming metadata of the call
+func metadataValue(ctx context.Context, key string) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+	}
+
+	return ""
+}
+
+// AuditEventToProtoAuditEvent converts an entities.AuditEvent object into a *protocol.AuditEvent object
+func AuditEventToProtoAuditEvent(e entities.AuditEvent) *protocol.AuditEvent {
+	event := &protocol.AuditEvent{
+		Id:        e.Id,
+		UrlId:     e.UrlId,
+		Action:    e.Action,
+		Actor:     e.Actor,
+		ClientIp:  e.ClientIp,
+		RequestId: e.RequestId,
+		CreatedAt: timestamppb.New(e.CreatedAt),
+	}
+
+	if e.Before != nil {
+		event.Before = UrlToProtoUrl(e.Before)
+	}
+
+	if e.After != nil {
+		event.After = UrlToProtoUrl(e.After)
+	}
+
+	return event
+}
+
+// ClickEventToProtoClickEvent converts an entities.ClickEvent object into a *protocol.ClickEvent object
+func ClickEventToProtoClickEvent(e entities.ClickEvent) *protocol.ClickEvent {
+	return &protocol.ClickEvent{
+		UrlId:     e.UrlId,
+		Code:      e.Code,
+		VariantId: e.VariantId,
+		Owner:     e.Owner,
+		Tags:      e.Tags,
+		Time:      timestamppb.New(e.Time),
+	}
+}
+
+// ProtoWebhookToWebhook converts a *protocol.Webhook object into a *entities.Webhook object
+func ProtoWebhookToWebhook(w *protocol.Webhook) *entities.Webhook {
+	return &entities.Webhook{
+		Id:     w.Id,
+		Url:    w.Url,
+		Secret: w.Secret,
+		Events: w.Events,
+	}
+}
+
+// WebhookToProtoWebhook converts an entities.Webhook object into a *protocol.Webhook object
+func WebhookToProtoWebhook(w entities.Webhook) *protocol.Webhook {
+	return &protocol.Webhook{
+		Id:        w.Id,
+		Url:       w.Url,
+		Secret:    w.Secret,
+		Events:    w.Events,
+		CreatedAt: timestamppb.New(w.CreatedAt),
+	}
+}
+
+// DeadLetterToProtoDeadLetter converts an entities.DeadLetter object into a *protocol.DeadLetter object
+func DeadLetterToProtoDeadLetter(d entities.DeadLetter) *protocol.DeadLetter {
+	return &protocol.DeadLetter{
+		Id:        d.Id,
+		WebhookId: d.WebhookId,
+		Event: &protocol.WebhookEvent{
+			Id:        d.Event.Id,
+			Type:      d.Event.Type,
+			Url:       UrlToProtoUrl(&d.Event.Url),
+			Milestone: d.Event.Milestone,
+			Time:      timestamppb.New(d.Event.Time),
+		},
+		Attempts:  int32(d.Attempts),
+		LastError: d.LastError,
+		FailedAt:  timestamppb.New(d.FailedAt),
+	}
+}
+
 // ProtoUrlToUrl converts a *protocol.Url object into a *entities.Url object
 func ProtoUrlToUrl(u *protocol.Url) *entities.Url {
 	return &entities.Url{
-		Id:       u.Id,
-		Code:     u.Code,
-		Url:      u.Url,
-		ShortUrl: u.ShortUrl,
-		Domain:   u.Domain,
-		Counter:  u.Counter,
+		Id:               u.Id,
+		Code:             u.Code,
+		Url:              u.Url,
+		ShortUrl:         u.ShortUrl,
+		Domain:           u.Domain,
+		Counter:          u.Counter,
+		RedirectType:     int(u.RedirectType),
+		ForwardQuery:     u.ForwardQuery,
+		PrefixMode:       u.PrefixMode,
+		Rules:            ProtoRulesToRules(u.Rules),
+		Variants:         ProtoVariantsToVariants(u.Variants),
+		MaxClicks:        u.MaxClicks,
+		BurnAfterReading: u.BurnAfterReading,
+		ActiveFrom:       protoTimeToTime(u.ActiveFrom),
+		ActiveUntil:      protoTimeToTime(u.ActiveUntil),
+		FallbackUrl:      u.FallbackUrl,
+		Owner:            u.Owner,
+		Tags:             u.Tags,
 	}
 }
 
 // UrlToProtoUrl converts a *entities.Url object into a *protocol.Url object
 func UrlToProtoUrl(u *entities.Url) *protocol.Url {
-	return &protocol.Url{
-		Id:       u.Id,
-		Code:     u.Code,
-		Url:      u.Url,
-		ShortUrl: u.ShortUrl,
-		Domain:   u.Domain,
-		Counter:  u.Counter,
+	pu := &protocol.Url{
+		Id:           u.Id,
+		Code:         u.Code,
+		Url:          u.Url,
+		ShortUrl:     u.ShortUrl,
+		Domain:       u.Domain,
+		Counter:      u.Counter,
+		RedirectType: int32(u.RedirectType),
+		ForwardQuery: u.ForwardQuery,
+		PrefixMode:   u.PrefixMode,
+		Rules:        RulesToProtoRules(u.Rules),
+		Variants:     VariantsToProtoVariants(u.Variants),
+		MaxClicks:    u.MaxClicks,
+		ActiveFrom:   timeToProtoTime(u.ActiveFrom),
+		ActiveUntil:  timeToProtoTime(u.ActiveUntil),
+		FallbackUrl:  u.FallbackUrl,
+		DeletedAt:    timeToProtoTime(u.DeletedAt),
+		Owner:        u.Owner,
+		Tags:         u.Tags,
+	}
+
+	if !u.CreatedAt.IsZero() {
+		pu.CreatedAt = timestamppb.New(u.CreatedAt)
 	}
+
+	return pu
+}
+
+// ProtoRulesToRules converts a list of *protocol.Rule objects into a list of entities.Rule objects
+func ProtoRulesToRules(rules []*protocol.Rule) []entities.Rule {
+	var result []entities.Rule
+	for _, r := range rules {
+		result = append(result, entities.Rule{
+			Url:       r.Url,
+			Platforms: r.Platforms,
+			Languages: r.Languages,
+			Countries: r.Countries,
+			From:      protoTimeToTime(r.From),
+			Until:     protoTimeToTime(r.Until),
+		})
+	}
+
+	return result
+}
+
+// RulesToProtoRules converts a list of entities.Rule objects into a list of *protocol.Rule objects
+func RulesToProtoRules(rules []entities.Rule) []*protocol.Rule {
+	var result []*protocol.Rule
+	for _, r := range rules {
+		result = append(result, &protocol.Rule{
+			Url:       r.Url,
+			Platforms: r.Platforms,
+			Languages: r.Languages,
+			Countries: r.Countries,
+			From:      timeToProtoTime(r.From),
+			Until:     timeToProtoTime(r.Until),
+		})
+	}
+
+	return result
+}
+
+// ProtoVariantsToVariants converts a list of *protocol.Variant objects into a list of entities.Variant objects
+func ProtoVariantsToVariants(variants []*protocol.Variant) []entities.Variant {
+	var result []entities.Variant
+	for _, v := range variants {
+		result = append(result, entities.Variant{
+			Id:      v.Id,
+			Url:     v.Url,
+			Weight:  int(v.Weight),
+			Counter: v.Counter,
+		})
+	}
+
+	return result
+}
+
+// VariantsToProtoVariants converts a list of entities.Variant objects into a list of *protocol.Variant objects
+func VariantsToProtoVariants(variants []entities.Variant) []*protocol.Variant {
+	var result []*protocol.Variant
+	for _, v := range variants {
+		result = append(result, &protocol.Variant{
+			Id:      v.Id,
+			Url:     v.Url,
+			Weight:  int32(v.Weight),
+			Counter: v.Counter,
+		})
+	}
+
+	return result
+}
+
+// protoTimeToTime converts an optional protobuf timestamp into an optional time
+func protoTimeToTime(t *timestamppb.Timestamp) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	v := t.AsTime()
+	return &v
+}
+
+// timeToProtoTime converts an optional time into an optional protobuf timestamp
+func timeToProtoTime(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+
+	return timestamppb.New(*t)
 }
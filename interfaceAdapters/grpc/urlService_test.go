@@ -1,17 +1,31 @@
 package grpc
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/norby7/shortening-service/entities"
 	"github.com/norby7/shortening-service/interfaceAdapters/grpc/protocol"
+	"github.com/norby7/shortening-service/usecases/logging"
 	"github.com/norby7/shortening-service/usecases/service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"testing"
+	"time"
 )
 
 const bufSize = 1024 * 1024
@@ -20,13 +34,14 @@ var (
 	lis          *bufconn.Listener
 	createError  = fmt.Errorf("unable to create the url")
 	deleteError  = fmt.Errorf("unable to delete the url")
+	updateError  = fmt.Errorf("unable to update the url")
 	getError     = fmt.Errorf("unable to fetch the url")
 	counterError = fmt.Errorf("unable to increment counter")
 )
 
 type ServiceMock struct{}
 
-func (s *ServiceMock) Create(u *entities.Url) error {
+func (s *ServiceMock) Create(ctx context.Context, u *entities.Url, actor entities.Actor) error {
 	if u.Url == "http://www.invalidUrl.com" || u.Url == "" {
 		return createError
 	}
@@ -35,30 +50,81 @@ func (s *ServiceMock) Create(u *entities.Url) error {
 		return service.ErrCodeAlreadyExists
 	}
 
+	u.ShortUrl = "http://localhost/" + u.Code
+
 	return nil
 }
 
-func (s *ServiceMock) Delete(id int64) error {
-	if id == 0 {
+func (s *ServiceMock) Delete(ctx context.Context, id int64, actor entities.Actor) error {
+	if id == 0 || actor.Name == "" || actor.RequestId == "" {
 		return deleteError
 	}
 
 	return nil
 }
 
-func (s *ServiceMock) GetUrlByCode(code string) (string, error) {
+func (s *ServiceMock) Restore(ctx context.Context, id int64, actor entities.Actor) (entities.Url, error) {
+	if id == 0 {
+		return entities.Url{}, updateError
+	}
+
+	if id != 1 {
+		return entities.Url{}, service.ErrUrlNotFound
+	}
+
+	return entities.Url{Id: 1, Code: "84gfj4i9", Url: "https://google.com"}, nil
+}
+
+func (s *ServiceMock) GetDeleted(ctx context.Context) ([]entities.Url, error) {
+	deletedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []entities.Url{{Id: 3, Code: "d3l3t3d0", Url: "https://google.com", DeletedAt: &deletedAt}}, nil
+}
+
+func (s *ServiceMock) Update(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	if u.Id == 0 {
+		return updateError
+	}
+
+	if u.Id != 1 {
+		return service.ErrUrlNotFound
+	}
+
+	return nil
+}
+
+func (s *ServiceMock) Replace(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	return s.Update(ctx, u, actor)
+}
+
+func (s *ServiceMock) SetRules(ctx context.Context, id int64, rules []entities.Rule, actor entities.Actor) (entities.Url, error) {
+	if id == 0 {
+		return entities.Url{}, updateError
+	}
+
+	if id != 1 {
+		return entities.Url{}, service.ErrUrlNotFound
+	}
+
+	return entities.Url{Id: 1, Code: "84gfj4i9", Url: "https://google.com", Rules: rules}, nil
+}
+
+func (s *ServiceMock) GetUrlByCode(ctx context.Context, code string) (entities.Url, error) {
 	if code == "invalidCode" {
-		return "", getError
+		return entities.Url{}, getError
+	}
+
+	if code == "exhau5te" || code == "c0unterr" {
+		return entities.Url{Id: 2, Code: code, Url: "https://google.com", MaxClicks: 1}, nil
 	}
 
 	if code != "84gfj4i9" {
-		return "", nil
+		return entities.Url{}, nil
 	}
 
-	return "https://google.com", nil
+	return entities.Url{Id: 1, Code: code, Url: "https://google.com"}, nil
 }
 
-func (s *ServiceMock) GetById(id int64) (entities.Url, error) {
+func (s *ServiceMock) GetById(ctx context.Context, id int64) (entities.Url, error) {
 	if id == 0 {
 		return entities.Url{}, getError
 	}
@@ -77,13 +143,188 @@ func (s *ServiceMock) GetById(id int64) (entities.Url, error) {
 	}, nil
 }
 
-func (s *ServiceMock) IncrementCounter(string) {
+func (s *ServiceMock) GetByCode(ctx context.Context, code string) (entities.Url, error) {
+	if code == "invalidCode" {
+		return entities.Url{}, getError
+	}
+
+	if code != "84gfj4i9" {
+		return entities.Url{}, nil
+	}
+
+	return entities.Url{
+		Id:       1,
+		Code:     "84gfj4i9",
+		Url:      "https://google.com",
+		ShortUrl: "http://localhost/84gfj4i9",
+		Domain:   "http://localhost",
+		Counter:  1,
+	}, nil
+}
+
+func (s *ServiceMock) SetVariants(ctx context.Context, id int64, variants []entities.Variant, actor entities.Actor) (entities.Url, error) {
+	if id == 0 {
+		return entities.Url{}, updateError
+	}
+
+	if id != 1 {
+		return entities.Url{}, service.ErrUrlNotFound
+	}
+
+	for i := range variants {
+		if variants[i].Id > 100 {
+			return entities.Url{}, service.ErrVariantNotFound
+		}
+	}
+
+	return entities.Url{Id: 1, Code: "84gfj4i9", Url: "https://google.com", Variants: variants}, nil
+}
+
+func (s *ServiceMock) ConsumeClick(ctx context.Context, click entities.Click) error {
+	if click.Code == "exhau5te" {
+		return service.ErrClicksExhausted
+	}
+
+	if click.Code == "c0unterr" {
+		return counterError
+	}
+
+	return nil
+}
+
+func (s *ServiceMock) RemainingClicks(ctx context.Context, code string) (int64, error) {
+	if code == "exhau5te" {
+		return 0, nil
+	}
+
+	if code == "c0unterr" {
+		return 0, counterError
+	}
+
+	return 1, nil
+}
+
+func (s *ServiceMock) GetAuditEvents(ctx context.Context, filter entities.AuditFilter) ([]entities.AuditEvent, error) {
+	if filter.Actor == "invalidActor" {
+		return nil, getError
+	}
+
+	if filter.From != nil && filter.Until != nil && !filter.Until.After(*filter.From) {
+		return nil, service.ErrInvalidAuditFilter
+	}
+
+	if filter.Limit < 0 {
+		return nil, service.ErrInvalidAuditLimit
+	}
+
+	return []entities.AuditEvent{{
+		Id:        1,
+		UrlId:     filter.UrlId,
+		Action:    entities.AuditUpdate,
+		Actor:     entities.AnonymousActor,
+		Before:    &entities.Url{Id: filter.UrlId, Url: "https://google.com"},
+		After:     &entities.Url{Id: filter.UrlId, Url: "https://google.ro"},
+		CreatedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+	}}, nil
+}
+
+func (s *ServiceMock) IncrementCounter(context.Context, entities.Click) bool {
+	return true
+}
+
+// clickFeed is the feed of the clicks published by the ServiceMock, subscribed receives a value on every subscription to it
+var clickFeed = service.NewClickFeed(0)
+var subscribed = make(chan struct{}, 1)
+
+func (s *ServiceMock) PublishClick(ctx context.Context, e entities.ClickEvent) {
+	clickFeed.Publish(e)
+}
+
+func (s *ServiceMock) SubscribeClicks(ctx context.Context, filter entities.ClickFilter) *service.ClickSubscription {
+	switch filter.Tag {
+	case "slow":
+		// the subscriber keeps one event and is dropped on the next one
+		f := service.NewClickFeed(1)
+		sub := f.Subscribe(filter)
+		for i := 0; i < 2; i++ {
+			f.Publish(entities.ClickEvent{UrlId: 1, Code: "84gfj4i9", Tags: []string{"slow"}})
+		}
+
+		return sub
+	case "closed":
+		f := service.NewClickFeed(1)
+		defer f.Close()
+
+		return f.Subscribe(filter)
+	}
+
+	sub := clickFeed.Subscribe(filter)
+	subscribed <- struct{}{}
+
+	return sub
+}
+
+func (s *ServiceMock) AddWebhook(ctx context.Context, w *entities.Webhook) error {
+	if err := w.Validate(); err != nil {
+		return service.ErrInvalidWebhook.Wrap(err)
+	}
+
+	w.Id = 1
+	if w.Secret == "" {
+		w.Secret = "g3n3rat3d"
+	}
+
+	return nil
+}
+
+func (s *ServiceMock) GetWebhooks(ctx context.Context) ([]entities.Webhook, error) {
+	return []entities.Webhook{{Id: 1, Url: "https://hooks.example.com", Events: []string{entities.WebhookUrlCreated}}}, nil
+}
+
+func (s *ServiceMock) DeleteWebhook(ctx context.Context, id int64) error {
+	if id != 1 {
+		return service.ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (s *ServiceMock) GetDeadLetters(ctx context.Context, webhookId int64) ([]entities.DeadLetter, error) {
+	if webhookId > 1 {
+		return nil, nil
+	}
 
+	return []entities.DeadLetter{{
+		Id:        5,
+		WebhookId: 1,
+		Event:     entities.WebhookEvent{Id: "e1", Type: entities.WebhookUrlCreated, Url: entities.Url{Id: 1, Code: "84gfj4i9"}},
+		Attempts:  5,
+		LastError: "unexpected status (500)",
+	}}, nil
+}
+
+func (s *ServiceMock) ReplayDeadLetter(ctx context.Context, id int64) error {
+	switch id {
+	case 5:
+		return nil
+	case 6:
+		return service.ErrWebhookDeliveryFailed
+	}
+
+	return service.ErrDeadLetterNotFound
+}
+
+func (s *ServiceMock) Export(ctx context.Context, filter entities.ExportFilter, format string, w io.Writer) (int, error) {
+	return 0, nil
+}
+
+func (s *ServiceMock) Import(ctx context.Context, r io.Reader, o entities.ImportOptions, actor entities.Actor) (entities.ImportReport, error) {
+	return entities.ImportReport{}, nil
 }
 
 func init() {
 	serviceMock := ServiceMock{}
-	l := log.New(os.Stdout, "urls-api", log.LstdFlags)
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	urlService := NewUrlGrpcService(&serviceMock, l)
 
 	lis = bufconn.Listen(bufSize)
@@ -117,17 +358,17 @@ func TestAdd(t *testing.T) {
 
 	testCases := []struct {
 		name          string
-		input         protocol.Url
+		input         *protocol.Url
 		expectedError bool
 	}{
 		{
 			name:          "empty url",
-			input:         protocol.Url{},
+			input:         &protocol.Url{},
 			expectedError: true,
 		},
 		{
 			name: "create service error",
-			input: protocol.Url{
+			input: &protocol.Url{
 				Id:       1,
 				Code:     "84gfj4i9",
 				Url:      "http://www.invalidUrl.com",
@@ -139,7 +380,7 @@ func TestAdd(t *testing.T) {
 		},
 		{
 			name: "valid request",
-			input: protocol.Url{
+			input: &protocol.Url{
 				Id:       1,
 				Code:     "84gfj4i9",
 				Url:      "https://google.com",
@@ -153,7 +394,7 @@ func TestAdd(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			resp, err := client.Add(ctx, &tc.input)
+			resp, err := client.Add(ctx, tc.input)
 
 			if (err != nil) != tc.expectedError {
 				t.Errorf("expected error (%v), got (%v) with response: (%v)", tc.expectedError, err, resp.String())
@@ -179,29 +420,29 @@ func TestDelete(t *testing.T) {
 
 	testCases := []struct {
 		name          string
-		input         protocol.UrlId
+		input         *protocol.UrlId
 		expectedError bool
 	}{
 		{
 			name:          "empty url id",
-			input:         protocol.UrlId{},
+			input:         &protocol.UrlId{},
 			expectedError: true,
 		},
 		{
 			name:          "delete service error",
-			input:         protocol.UrlId{Value: 0},
+			input:         &protocol.UrlId{Value: 0},
 			expectedError: true,
 		},
 		{
 			name:          "valid request",
-			input:         protocol.UrlId{Value: 1},
+			input:         &protocol.UrlId{Value: 1},
 			expectedError: false,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			resp, err := client.Delete(ctx, &tc.input)
+			resp, err := client.Delete(ctx, tc.input)
 
 			if (err != nil) != tc.expectedError {
 				t.Errorf("expected error (%v), got (%v) with response: (%v)", tc.expectedError, err, resp.String())
@@ -227,29 +468,34 @@ func TestGet(t *testing.T) {
 
 	testCases := []struct {
 		name          string
-		input         protocol.UrlId
+		input         *protocol.UrlId
 		expectedError bool
 	}{
 		{
 			name:          "empty url id",
-			input:         protocol.UrlId{},
+			input:         &protocol.UrlId{},
 			expectedError: true,
 		},
 		{
 			name:          "get service error",
-			input:         protocol.UrlId{Value: 0},
+			input:         &protocol.UrlId{Value: 0},
+			expectedError: true,
+		},
+		{
+			name:          "url not found",
+			input:         &protocol.UrlId{Value: 2},
 			expectedError: true,
 		},
 		{
 			name:          "valid request",
-			input:         protocol.UrlId{Value: 1},
+			input:         &protocol.UrlId{Value: 1},
 			expectedError: false,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			resp, err := client.Get(ctx, &tc.input)
+			resp, err := client.Get(ctx, tc.input)
 
 			if (err != nil) != tc.expectedError {
 				t.Errorf("expected error (%v), got (%v) with response: (%v)", tc.expectedError, err, resp.String())
@@ -275,29 +521,29 @@ func TestGetCounter(t *testing.T) {
 
 	testCases := []struct {
 		name          string
-		input         protocol.UrlId
+		input         *protocol.UrlId
 		expectedError bool
 	}{
 		{
 			name:          "empty url id",
-			input:         protocol.UrlId{},
+			input:         &protocol.UrlId{},
 			expectedError: true,
 		},
 		{
 			name:          "get service error",
-			input:         protocol.UrlId{Value: 0},
+			input:         &protocol.UrlId{Value: 0},
 			expectedError: true,
 		},
 		{
 			name:          "valid request",
-			input:         protocol.UrlId{Value: 1},
+			input:         &protocol.UrlId{Value: 1},
 			expectedError: false,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			resp, err := client.GetCounter(ctx, &tc.input)
+			resp, err := client.GetCounter(ctx, tc.input)
 
 			if (err != nil) != tc.expectedError {
 				t.Errorf("expected error (%v), got (%v) with response: (%v)", tc.expectedError, err, resp.String())
@@ -305,3 +551,1027 @@ func TestGetCounter(t *testing.T) {
 		})
 	}
 }
+
+func TestGetByCode(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	testCases := []struct {
+		name            string
+		input           *protocol.Code
+		expectedCode    codes.Code
+		expectedCounter int64
+	}{
+		{
+			name:         "get service error",
+			input:        &protocol.Code{Value: "invalidCode"},
+			expectedCode: codes.Internal,
+		},
+		{
+			name:         "url not found",
+			input:        &protocol.Code{Value: "notfound"},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:            "valid request",
+			input:           &protocol.Code{Value: "84gfj4i9"},
+			expectedCode:    codes.OK,
+			expectedCounter: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := client.GetByCode(ctx, tc.input)
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected code (%v), got (%v) with response: (%v)", tc.expectedCode, status.Code(err), u.String())
+			}
+
+			if err == nil && u.Code != tc.input.Value {
+				t.Errorf("expected url code (%s), got (%s)", tc.input.Value, u.Code)
+			}
+
+			counter, err := client.GetCounterByCode(ctx, tc.input)
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected counter code (%v), got (%v) with response: (%v)", tc.expectedCode, status.Code(err), counter.String())
+			}
+
+			if counter.GetValue() != tc.expectedCounter {
+				t.Errorf("expected counter (%d), got (%d)", tc.expectedCounter, counter.GetValue())
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	testCases := []struct {
+		name             string
+		input            *protocol.Code
+		expectedCode     codes.Code
+		expectedStatus   int32
+		expectedLocation string
+	}{
+		{
+			name:         "get service error",
+			input:        &protocol.Code{Value: "invalidCode"},
+			expectedCode: codes.Internal,
+		},
+		{
+			name:         "invalid query",
+			input:        &protocol.Code{Value: "84gfj4i9", Query: "a=%zz"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:           "url not found",
+			input:          &protocol.Code{Value: "notfound"},
+			expectedCode:   codes.OK,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "path not accepted",
+			input:          &protocol.Code{Value: "84gfj4i9", Path: "docs"},
+			expectedCode:   codes.OK,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:             "valid request",
+			input:            &protocol.Code{Value: "84gfj4i9", UserAgent: "Mozilla/5.0 (iPhone)", ClientIp: "127.0.0.1", RecordClick: true},
+			expectedCode:     codes.OK,
+			expectedStatus:   http.StatusFound,
+			expectedLocation: "https://google.com",
+		},
+		{
+			name:           "clicks exhausted",
+			input:          &protocol.Code{Value: "exhau5te", RecordClick: true},
+			expectedCode:   codes.OK,
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:           "clicks exhausted, click not recorded",
+			input:          &protocol.Code{Value: "exhau5te"},
+			expectedCode:   codes.OK,
+			expectedStatus: http.StatusGone,
+		},
+		{
+			name:         "clicks left error, click not recorded",
+			input:        &protocol.Code{Value: "c0unterr"},
+			expectedCode: codes.Internal,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := client.Resolve(ctx, tc.input)
+			if status.Code(err) != tc.expectedCode {
+				t.Fatalf("expected code (%v), got (%v) with response: (%v)", tc.expectedCode, status.Code(err), res.String())
+			}
+
+			if res.GetStatus() != tc.expectedStatus {
+				t.Errorf("expected status (%d), got (%d)", tc.expectedStatus, res.GetStatus())
+			}
+
+			if res.GetLocation() != tc.expectedLocation {
+				t.Errorf("expected location (%s), got (%s)", tc.expectedLocation, res.GetLocation())
+			}
+		})
+	}
+}
+
+func TestWatchClicks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	stream, err := client.WatchClicks(ctx, &protocol.ClickFilter{UrlId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the call to subscribe to the clicks")
+	}
+
+	// a recorded redirect is published to the subscribers
+	if _, err = client.Resolve(ctx, &protocol.Code{Value: "84gfj4i9", RecordClick: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("expected a click event, got (%v)", err)
+	}
+
+	if e.UrlId != 1 || e.Code != "84gfj4i9" || e.Time == nil {
+		t.Errorf("expected the click of url (1) with code (84gfj4i9), got (%v)", e.String())
+	}
+
+	cancel()
+	if _, err = stream.Recv(); status.Code(err) != codes.Canceled {
+		t.Errorf("expected code (%v) after cancel, got (%v)", codes.Canceled, err)
+	}
+}
+
+func TestWatchClicksEnd(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	testCases := []struct {
+		name           string
+		input          *protocol.ClickFilter
+		expectedEvents int
+		expectedCode   codes.Code
+		expectedReason string
+	}{
+		{
+			name:           "slow consumer is dropped",
+			input:          &protocol.ClickFilter{Tag: "slow"},
+			expectedEvents: 1,
+			expectedCode:   codes.Unavailable,
+			expectedReason: "SLOW_CONSUMER",
+		},
+		{
+			name:         "feed closed",
+			input:        &protocol.ClickFilter{Tag: "closed"},
+			expectedCode: codes.OK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stream, err := client.WatchClicks(ctx, tc.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			events := 0
+			for {
+				if _, err = stream.Recv(); err != nil {
+					break
+				}
+
+				events++
+			}
+
+			if events != tc.expectedEvents {
+				t.Errorf("expected (%d) events, got (%d)", tc.expectedEvents, events)
+			}
+
+			if err == io.EOF {
+				err = nil
+			}
+
+			st := status.Convert(err)
+			if st.Code() != tc.expectedCode {
+				t.Fatalf("expected code (%v), got (%v)", tc.expectedCode, err)
+			}
+
+			if tc.expectedReason == "" {
+				return
+			}
+
+			for _, d := range st.Details() {
+				if info, ok := d.(*errdetails.ErrorInfo); ok && info.Reason == tc.expectedReason {
+					return
+				}
+			}
+
+			t.Errorf("expected reason (%s), got details (%v)", tc.expectedReason, st.Details())
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	until := timestamppb.New(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	testCases := []struct {
+		name          string
+		input         *protocol.Url
+		expectedError bool
+	}{
+		{
+			name:          "update service error",
+			input:         &protocol.Url{Id: 0, RedirectType: 301},
+			expectedError: true,
+		},
+		{
+			name:          "url not found",
+			input:         &protocol.Url{Id: 2, RedirectType: 301},
+			expectedError: true,
+		},
+		{
+			name:          "valid request",
+			input:         &protocol.Url{Id: 1, Url: "https://google.com", RedirectType: 301, PrefixMode: true},
+			expectedError: false,
+		},
+		{
+			name:          "valid request, activation window",
+			input:         &protocol.Url{Id: 1, Url: "https://google.com", RedirectType: 302, ActiveUntil: until, FallbackUrl: "https://google.com/ended"},
+			expectedError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.Update(ctx, tc.input)
+
+			if (err != nil) != tc.expectedError {
+				t.Errorf("expected error (%v), got (%v) with response: (%v)", tc.expectedError, err, resp.String())
+			}
+
+			if err == nil && tc.input.ActiveUntil != nil && !resp.ActiveUntil.AsTime().Equal(until.AsTime()) {
+				t.Errorf("expected active until (%v), got (%v)", until.AsTime(), resp.ActiveUntil.AsTime())
+			}
+
+			if err == nil && resp.FallbackUrl != tc.input.FallbackUrl {
+				t.Errorf("expected fallback url (%v), got (%v)", tc.input.FallbackUrl, resp.FallbackUrl)
+			}
+		})
+	}
+}
+
+func TestPatch(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	testCases := []struct {
+		name         string
+		input        *protocol.PatchUrlRequest
+		expectedCode codes.Code
+		expectedUrl  *protocol.Url
+	}{
+		{
+			name:         "missing url",
+			input:        &protocol.PatchUrlRequest{},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "url not found",
+			input:        &protocol.PatchUrlRequest{Url: &protocol.Url{Id: 2}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"max_clicks"}}},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name:         "unknown mask path",
+			input:        &protocol.PatchUrlRequest{Url: &protocol.Url{Id: 1}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"clicks"}}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "masked fields",
+			input:        &protocol.PatchUrlRequest{Url: &protocol.Url{Id: 1, Url: "https://google.ro", MaxClicks: 5}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"max_clicks"}}},
+			expectedCode: codes.OK,
+			expectedUrl:  &protocol.Url{Url: "https://google.com", MaxClicks: 5},
+		},
+		{
+			name:         "empty mask",
+			input:        &protocol.PatchUrlRequest{Url: &protocol.Url{Id: 1, Url: "https://google.ro"}},
+			expectedCode: codes.OK,
+			expectedUrl:  &protocol.Url{Url: "https://google.ro"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.Patch(ctx, tc.input)
+
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Fatalf("expected code (%v), got (%v) with response: (%v)", tc.expectedCode, code, resp.String())
+			}
+
+			if tc.expectedUrl != nil && (resp.Url != tc.expectedUrl.Url || resp.MaxClicks != tc.expectedUrl.MaxClicks) {
+				t.Errorf("expected url (%v) and max clicks (%d), got (%v)", tc.expectedUrl.Url, tc.expectedUrl.MaxClicks, resp.String())
+			}
+		})
+	}
+}
+
+// patchServiceMock serves a url with a clicks limit, an activation window, a fallback url and tags and records the
+// replaced url
+type patchServiceMock struct {
+	ServiceMock
+	replaced *entities.Url
+}
+
+func (s *patchServiceMock) GetById(ctx context.Context, id int64) (entities.Url, error) {
+	until := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	return entities.Url{
+		Id:           id,
+		Code:         "84gfj4i9",
+		Url:          "https://google.com",
+		RedirectType: entities.RedirectPermanent,
+		MaxClicks:    10,
+		ActiveUntil:  &until,
+		FallbackUrl:  "https://google.com/ended",
+		Tags:         []string{"newsletter"},
+	}, nil
+}
+
+func (s *patchServiceMock) Replace(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	s.replaced = u
+	return nil
+}
+
+func TestPatchClearsFields(t *testing.T) {
+	serviceMock := &patchServiceMock{}
+	us := NewUrlGrpcService(serviceMock, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+
+	// the masked fields that are not set in the url are cleared
+	req := &protocol.PatchUrlRequest{
+		Url:        &protocol.Url{Id: 1},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"max_clicks", "active_until", "fallback_url", "tags"}},
+	}
+	if _, err := us.Patch(context.Background(), req); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	u := serviceMock.replaced
+	if u == nil || u.MaxClicks != 0 || u.ActiveUntil != nil || u.FallbackUrl != "" || len(u.Tags) != 0 {
+		t.Fatalf("expected the masked fields to be cleared, got (%+v)", u)
+	}
+
+	if u.Url != "https://google.com" || u.RedirectType != entities.RedirectPermanent {
+		t.Errorf("expected the fields out of the mask to be kept, got (%+v)", u)
+	}
+}
+
+func TestGetRulesAndVariants(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	testCases := []struct {
+		name         string
+		input        *protocol.UrlId
+		expectedCode codes.Code
+	}{
+		{name: "get service error", input: &protocol.UrlId{Value: 0}, expectedCode: codes.Internal},
+		{name: "url not found", input: &protocol.UrlId{Value: 2}, expectedCode: codes.NotFound},
+		{name: "valid request", input: &protocol.UrlId{Value: 1}, expectedCode: codes.OK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rules, err := client.GetRules(ctx, tc.input)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Errorf("expected rules code (%v), got (%v) with response: (%v)", tc.expectedCode, code, rules.String())
+			}
+
+			variants, err := client.GetVariants(ctx, tc.input)
+			if code := status.Code(err); code != tc.expectedCode {
+				t.Errorf("expected variants code (%v), got (%v) with response: (%v)", tc.expectedCode, code, variants.String())
+			}
+
+			if err == nil && variants.Id != tc.input.Value {
+				t.Errorf("expected url id (%d), got (%d)", tc.input.Value, variants.Id)
+			}
+		})
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	from := timestamppb.New(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	testCases := []struct {
+		name          string
+		input         *protocol.UrlRules
+		expectedError bool
+	}{
+		{
+			name:          "set rules service error",
+			input:         &protocol.UrlRules{Id: 0},
+			expectedError: true,
+		},
+		{
+			name:          "url not found",
+			input:         &protocol.UrlRules{Id: 2},
+			expectedError: true,
+		},
+		{
+			name: "valid request",
+			input: &protocol.UrlRules{Id: 1, Rules: []*protocol.Rule{
+				{Url: "https://apps.apple.com/app", Platforms: []string{entities.PlatformIOS}},
+				{Url: "https://example.de", Countries: []string{"DE"}, From: from},
+			}},
+			expectedError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.SetRules(ctx, tc.input)
+
+			if (err != nil) != tc.expectedError {
+				t.Errorf("expected error (%v), got (%v) with response: (%v)", tc.expectedError, err, resp.String())
+			}
+
+			if err == nil && len(resp.Rules) != len(tc.input.Rules) {
+				t.Errorf("expected (%v) rules, got (%v)", len(tc.input.Rules), len(resp.Rules))
+			}
+
+			if err == nil && !resp.Rules[1].From.AsTime().Equal(from.AsTime()) {
+				t.Errorf("expected rule from (%v), got (%v)", from.AsTime(), resp.Rules[1].From.AsTime())
+			}
+		})
+	}
+}
+
+func TestSetVariants(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	testCases := []struct {
+		name          string
+		input         *protocol.UrlVariants
+		expectedError bool
+	}{
+		{
+			name:          "set variants service error",
+			input:         &protocol.UrlVariants{Id: 0},
+			expectedError: true,
+		},
+		{
+			name:          "url not found",
+			input:         &protocol.UrlVariants{Id: 2},
+			expectedError: true,
+		},
+		{
+			name:          "unknown variant",
+			input:         &protocol.UrlVariants{Id: 1, Variants: []*protocol.Variant{{Id: 101, Url: "https://example.com/a", Weight: 1}}},
+			expectedError: true,
+		},
+		{
+			name: "valid request",
+			input: &protocol.UrlVariants{Id: 1, Variants: []*protocol.Variant{
+				{Url: "https://example.com/a", Weight: 70},
+				{Url: "https://example.com/b", Weight: 30},
+			}},
+			expectedError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.SetVariants(ctx, tc.input)
+
+			if (err != nil) != tc.expectedError {
+				t.Errorf("expected error (%v), got (%v) with response: (%v)", tc.expectedError, err, resp.String())
+			}
+
+			if err == nil && len(resp.Variants) != len(tc.input.Variants) {
+				t.Errorf("expected (%v) variants, got (%v)", len(tc.input.Variants), len(resp.Variants))
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	testCases := []struct {
+		name          string
+		input         *protocol.UrlId
+		expectedError bool
+	}{
+		{
+			name:          "restore service error",
+			input:         &protocol.UrlId{Value: 0},
+			expectedError: true,
+		},
+		{
+			name:          "deleted url not found",
+			input:         &protocol.UrlId{Value: 2},
+			expectedError: true,
+		},
+		{
+			name:          "valid request",
+			input:         &protocol.UrlId{Value: 1},
+			expectedError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.Restore(ctx, tc.input)
+
+			if (err != nil) != tc.expectedError {
+				t.Errorf("expected error (%v), got (%v) with response: (%v)", tc.expectedError, err, resp.String())
+			}
+		})
+	}
+}
+
+func TestGetTrash(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	resp, err := client.GetTrash(ctx, &protocol.VoidResponse{})
+	if err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if len(resp.Urls) != 1 || resp.Urls[0].DeletedAt == nil {
+		t.Errorf("expected one deleted url, got (%v)", resp.Urls)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	w, err := client.AddWebhook(ctx, &protocol.Webhook{Url: "https://hooks.example.com", Events: []string{entities.WebhookUrlCreated}})
+	if err != nil || w.Id != 1 || w.Secret == "" {
+		t.Errorf("expected the webhook with its secret, got (%v) with error (%v)", w, err)
+	}
+
+	if _, err = client.AddWebhook(ctx, &protocol.Webhook{Url: "hooks.example.com"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected code (%s), got (%s)", codes.InvalidArgument, status.Code(err))
+	}
+
+	webhooks, err := client.GetWebhooks(ctx, &protocol.VoidResponse{})
+	if err != nil || len(webhooks.Webhooks) != 1 {
+		t.Errorf("expected one webhook, got (%v) with error (%v)", webhooks, err)
+	}
+
+	letters, err := client.GetDeadLetters(ctx, &protocol.DeadLetterFilter{WebhookId: 1})
+	if err != nil || len(letters.DeadLetters) != 1 || letters.DeadLetters[0].Event.Url.Code != "84gfj4i9" {
+		t.Errorf("expected one dead letter, got (%v) with error (%v)", letters, err)
+	}
+
+	testCases := []struct {
+		name         string
+		call         func() error
+		expectedCode codes.Code
+	}{
+		{
+			name: "delete webhook",
+			call: func() error {
+				_, err := client.DeleteWebhook(ctx, &protocol.WebhookId{Value: 1})
+				return err
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name: "delete missing webhook",
+			call: func() error {
+				_, err := client.DeleteWebhook(ctx, &protocol.WebhookId{Value: 2})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+		{
+			name: "replay",
+			call: func() error {
+				_, err := client.ReplayDeadLetter(ctx, &protocol.DeadLetterId{Value: 5})
+				return err
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name: "failed replay",
+			call: func() error {
+				_, err := client.ReplayDeadLetter(ctx, &protocol.DeadLetterId{Value: 6})
+				return err
+			},
+			expectedCode: codes.Unavailable,
+		},
+		{
+			name: "replay missing dead letter",
+			call: func() error {
+				_, err := client.ReplayDeadLetter(ctx, &protocol.DeadLetterId{Value: 7})
+				return err
+			},
+			expectedCode: codes.NotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := status.Code(tc.call()); code != tc.expectedCode {
+				t.Errorf("expected code (%s), got (%s)", tc.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithContextDialer(bufDialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = conn.Close()
+		if err != nil {
+			t.Errorf(err.Error())
+		}
+	}()
+
+	client := protocol.NewUrlServiceClient(conn)
+
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := from.Add(time.Hour)
+
+	testCases := []struct {
+		name          string
+		input         *protocol.AuditFilter
+		expectedError bool
+	}{
+		{
+			name:  "url filter",
+			input: &protocol.AuditFilter{UrlId: 1, Limit: 10},
+		},
+		{
+			name:  "time range",
+			input: &protocol.AuditFilter{From: timestamppb.New(from), Until: timestamppb.New(until)},
+		},
+		{
+			name:          "invalid time range",
+			input:         &protocol.AuditFilter{From: timestamppb.New(until), Until: timestamppb.New(from)},
+			expectedError: true,
+		},
+		{
+			name:          "service error",
+			input:         &protocol.AuditFilter{Actor: "invalidActor"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := client.GetAuditEvents(ctx, tc.input)
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+
+			if tc.expectedError {
+				return
+			}
+
+			if len(resp.Events) != 1 {
+				t.Fatalf("expected one event, got (%v)", resp.Events)
+			}
+
+			e := resp.Events[0]
+			if e.UrlId != tc.input.UrlId || e.Before.Url != "https://google.com" || e.After.Url != "https://google.ro" || !e.CreatedAt.AsTime().Equal(from) {
+				t.Errorf("unexpected event (%v)", e)
+			}
+		})
+	}
+}
+
+func TestRequestActor(t *testing.T) {
+	md := metadata.Pairs("x-api-key", "secret", "x-request-id", "req-1")
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}})
+
+	a := requestActor(ctx)
+
+	if a.Name != entities.NewActor("secret", "", "").Name {
+		t.Errorf("expected the hashed api key actor, got (%s)", a.Name)
+	}
+
+	if a.ClientIp != "192.0.2.1" {
+		t.Errorf("expected client ip (192.0.2.1), got (%s)", a.ClientIp)
+	}
+
+	if a.RequestId != "req-1" {
+		t.Errorf("expected request id (req-1), got (%s)", a.RequestId)
+	}
+
+	// without a peer the client ip is the last forwarded address, the one added by the gateway
+	gateway := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-forwarded-for", "203.0.113.7, 192.0.2.2"))
+	if ip := requestActor(gateway).ClientIp; ip != "192.0.2.2" {
+		t.Errorf("expected client ip (192.0.2.2), got (%s)", ip)
+	}
+
+	anonymous := requestActor(context.Background())
+	if anonymous.Name != entities.AnonymousActor || anonymous.RequestId == "" {
+		t.Errorf("expected an anonymous actor with a generated request id, got (%v)", anonymous)
+	}
+}
+
+func TestTimeoutInterceptor(t *testing.T) {
+	early, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	testCases := []struct {
+		name         string
+		ctx          context.Context
+		timeout      time.Duration
+		expectedLeft time.Duration
+	}{
+		{name: "server timeout", ctx: context.Background(), timeout: time.Second, expectedLeft: time.Second},
+		{name: "earlier client deadline", ctx: early, timeout: time.Second, expectedLeft: 100 * time.Millisecond},
+		{name: "client deadline only", ctx: early, timeout: 0, expectedLeft: 100 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				deadline, ok := ctx.Deadline()
+				if !ok {
+					return nil, fmt.Errorf("no deadline")
+				}
+
+				return time.Until(deadline), nil
+			}
+
+			res, err := TimeoutInterceptor(tc.timeout)(tc.ctx, nil, &grpc.UnaryServerInfo{}, handler)
+			if err != nil {
+				t.Fatalf("expected a deadline, got (%s)", err.Error())
+			}
+
+			if left := res.(time.Duration); left <= 0 || left > tc.expectedLeft {
+				t.Errorf("expected a deadline within (%s), got (%s)", tc.expectedLeft, left)
+			}
+		})
+	}
+}
+
+func TestAccessLogInterceptor(t *testing.T) {
+	testCases := []struct {
+		name           string
+		requestId      string
+		err            error
+		expectedLevel  string
+		expectedStatus string
+	}{
+		{name: "given request id", requestId: "req-1", expectedLevel: "INFO", expectedStatus: "OK"},
+		{name: "generated request id", expectedLevel: "INFO", expectedStatus: "OK"},
+		{name: "unknown error", requestId: "req-2", err: getError, expectedLevel: "ERROR", expectedStatus: "Unknown"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := logging.New(&buf, logging.FormatJSON, "info")
+			if err != nil {
+				t.Fatalf("unable to create logger: %s", err.Error())
+			}
+
+			ctx := context.Background()
+			if tc.requestId != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-request-id", tc.requestId))
+			}
+
+			var handlerRequestId string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				handlerRequestId = requestActor(ctx).RequestId
+				return nil, tc.err
+			}
+
+			_, err = AccessLogInterceptor(l)(ctx, &protocol.Url{Code: "84gfj4i9"}, &grpc.UnaryServerInfo{FullMethod: "/protocol.UrlService/Add"}, handler)
+			if err != tc.err {
+				t.Fatalf("expected error (%v), got (%v)", tc.err, err)
+			}
+
+			var record map[string]interface{}
+			if err = json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("unable to decode access log: %s", err.Error())
+			}
+
+			if handlerRequestId == "" || (tc.requestId != "" && handlerRequestId != tc.requestId) || record[logging.RequestIdKey] != handlerRequestId {
+				t.Errorf("expected request id (%s) in the call and the log, got (%s) and (%v)", tc.requestId, handlerRequestId, record[logging.RequestIdKey])
+			}
+
+			if record["level"] != tc.expectedLevel || record["status"] != tc.expectedStatus || record["code"] != "84gfj4i9" || record["method"] != "/protocol.UrlService/Add" {
+				t.Errorf("expected level (%s) status (%s) code (84gfj4i9), got (%v)", tc.expectedLevel, tc.expectedStatus, record)
+			}
+		})
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	invalid := service.ErrInvalidUrl.Wrap(fmt.Errorf("validation failed"))
+	invalid.Violations = []service.FieldViolation{{Field: "rules[0].url", Description: "failed on the required validation"}}
+
+	testCases := []struct {
+		name               string
+		input              error
+		expectedCode       codes.Code
+		expectedReason     string
+		expectedViolations int
+	}{
+		{name: "already exists", input: service.ErrCodeAlreadyExists, expectedCode: codes.AlreadyExists, expectedReason: "CODE_ALREADY_EXISTS"},
+		{name: "not found", input: service.ErrUrlNotFound, expectedCode: codes.NotFound, expectedReason: "URL_NOT_FOUND"},
+		{name: "invalid url", input: invalid, expectedCode: codes.InvalidArgument, expectedReason: "INVALID_URL", expectedViolations: 1},
+		{name: "storage unavailable", input: service.ErrStorageUnavailable.Wrap(fmt.Errorf("database is locked")), expectedCode: codes.Unavailable, expectedReason: "STORAGE_UNAVAILABLE"},
+		{name: "clicks exhausted", input: service.ErrClicksExhausted, expectedCode: codes.FailedPrecondition, expectedReason: "CLICKS_EXHAUSTED"},
+		{name: "deadline exceeded", input: fmt.Errorf("unable to fetch url: %w", context.DeadlineExceeded), expectedCode: codes.DeadlineExceeded},
+		{name: "internal", input: getError, expectedCode: codes.Internal},
+		{name: "status error", input: status.Error(codes.PermissionDenied, "denied"), expectedCode: codes.PermissionDenied},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := status.Convert(statusError(tc.input))
+			if st.Code() != tc.expectedCode {
+				t.Fatalf("expected code (%v), got (%v)", tc.expectedCode, st.Code())
+			}
+
+			var reason string
+			var violations int
+			for _, d := range st.Details() {
+				switch d := d.(type) {
+				case *errdetails.ErrorInfo:
+					reason = d.Reason
+					if d.Domain != service.ErrorDomain {
+						t.Errorf("expected domain (%s), got (%s)", service.ErrorDomain, d.Domain)
+					}
+				case *errdetails.BadRequest:
+					violations = len(d.FieldViolations)
+				}
+			}
+
+			if reason != tc.expectedReason {
+				t.Errorf("expected reason (%s), got (%s)", tc.expectedReason, reason)
+			}
+
+			if violations != tc.expectedViolations {
+				t.Errorf("expected (%d) field violations, got (%d)", tc.expectedViolations, violations)
+			}
+		})
+	}
+}
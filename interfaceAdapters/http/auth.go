@@ -0,0 +1,75 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"github.com/norby7/shortening-service/interfaceAdapters/certs"
+	"net/http"
+	"strings"
+)
+
+// ClientIdentity returns the identity of the verified client certificate of the request, or an empty string when the
+// client didn't send a certificate verified by the client CA
+func ClientIdentity(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return certs.Identity(r.TLS.VerifiedChains[0][0])
+}
+
+// clientIdentityKey is the request context key of the client identity verified by ClientAuth
+type clientIdentityKey struct{}
+
+// VerifiedClient returns the client identity verified by the ClientAuth middleware, or an empty string when the request
+// didn't go through it
+func VerifiedClient(ctx context.Context) string {
+	identity, _ := ctx.Value(clientIdentityKey{}).(string)
+	return identity
+}
+
+// ClientAuth returns a middleware that only serves the api requests, under /api and /counter/, of the clients with a
+// verified certificate like the grpc AuthInterceptor. The requests without one get the 401 status, and the 403 status
+// when the client identity isn't in allowed. Every client verified by the client CA is allowed when allowed is empty
+// The redirects, previews and documentation are served to every client, the identity of the api clients is added to the
+// request context for VerifiedClient
+func ClientAuth(allowed []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api" && !strings.HasPrefix(r.URL.Path, "/api/") && !strings.HasPrefix(r.URL.Path, "/counter/") {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			identity := ClientIdentity(r)
+			if identity == "" {
+				rw.Header().Set("Content-type", "application/json")
+				http.Error(rw, `{"message": "a client certificate is required"}`, http.StatusUnauthorized)
+				return
+			}
+
+			if !isAllowed(identity, allowed) {
+				rw.Header().Set("Content-type", "application/json")
+				http.Error(rw, fmt.Sprintf(`{"message": "client (%s) is not allowed"}`, identity), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, identity)))
+		})
+	}
+}
+
+// isAllowed reports whether the client identity is in allowed, every identity is allowed when allowed is empty
+func isAllowed(identity string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, a := range allowed {
+		if a == identity {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/norby7/shortening-service/interfaceAdapters/certs/certstest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAuth(t *testing.T) {
+	ca := certstest.NewCA(t, "test ca")
+
+	// state returns the tls state of a connection with the verified certificate of the client
+	state := func(name string) *tls.ConnectionState {
+		cert, _, _ := ca.Issue(t, name, false)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf("unable to parse the client certificate: %s", err.Error())
+		}
+
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca.Cert}}}
+	}
+
+	testCases := []struct {
+		name             string
+		path             string
+		tls              *tls.ConnectionState
+		allowed          []string
+		expectedStatus   int
+		expectedIdentity string
+	}{
+		{name: "api without tls", path: "/api/trash", expectedStatus: http.StatusUnauthorized},
+		{name: "api without certificate", path: "/api/1", tls: &tls.ConnectionState{}, expectedStatus: http.StatusUnauthorized},
+		{name: "counter without certificate", path: "/counter/1", tls: &tls.ConnectionState{}, expectedStatus: http.StatusUnauthorized},
+		{name: "click feed without certificate", path: EventsPath, tls: &tls.ConnectionState{}, expectedStatus: http.StatusUnauthorized},
+		{name: "root api without certificate", path: "/api", tls: &tls.ConnectionState{}, expectedStatus: http.StatusUnauthorized},
+		{name: "allowed client", path: "/api/1", tls: state("admin"), allowed: []string{"ops", "admin"}, expectedStatus: http.StatusOK, expectedIdentity: "admin"},
+		{name: "every client allowed", path: "/api/1", tls: state("ops"), expectedStatus: http.StatusOK, expectedIdentity: "ops"},
+		{name: "client not allowed", path: "/api/1", tls: state("ops"), allowed: []string{"admin"}, expectedStatus: http.StatusForbidden},
+		{name: "redirect without certificate", path: "/84gfj4i9", expectedStatus: http.StatusOK},
+		{name: "path starting like the api", path: "/apidocs12", expectedStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var identity string
+			next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				identity = VerifiedClient(r.Context())
+			})
+
+			req := httptest.NewRequest("GET", tc.path, nil)
+			req.TLS = tc.tls
+			rw := httptest.NewRecorder()
+
+			ClientAuth(tc.allowed)(next).ServeHTTP(rw, req)
+
+			if rw.Code != tc.expectedStatus {
+				t.Errorf("expected status (%d), got (%d) (%s)", tc.expectedStatus, rw.Code, rw.Body.String())
+			}
+
+			if identity != tc.expectedIdentity {
+				t.Errorf("expected verified client (%s), got (%s)", tc.expectedIdentity, identity)
+			}
+		})
+	}
+}
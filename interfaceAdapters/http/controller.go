@@ -1,258 +1,129 @@
-// Package http, classification of url shortening service API
-//
-// Documentation for url shortening service API
-//
-// Schemes: http
-// BasePath: /
-// Version: 1.0.0
-//
-// Consumes:
-// - application/json
-//
-// Produces:
-// - application/json
-// swagger:meta
+// Package http serves the short url redirects and previews, the REST api is served by the grpc gateway
 package http
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/norby7/shortening-service/entities"
+	"github.com/gorilla/mux"
 	"github.com/norby7/shortening-service/usecases/service"
-	"log"
+	"log/slog"
 	"net/http"
-	"path"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// Data structure representing a single url
-// swagger:response urlResponse
-type urlResponse struct {
-	// Location header that contains the short url
-	Location string
-	// A single url object
-	// in: body
-	Body entities.Url
-}
-
-// Generic error message response
-// swagger:response errorResponse
-type errorResponse struct {
-	Message string `json:"message"`
-}
-
-// Code already exists in the database error message response
-// swagger:response codeExistsErrorResponse
-type codeExistsErrorResponse struct {
-	Message string `json:"message"`
-}
-
-// swagger:response noContent
-type noContent struct{}
-
-// Redirections counter response
-// swagger:response counterResponse
-type counterResponse struct {
-	Counter int64 `json:"counter"`
-}
+// variantCookiePrefix is the name prefix of the cookie that keeps a visitor on the same variant of a url
+const variantCookiePrefix = "sv_"
 
-// swagger:model
-type addParam struct {
-	// short url code
-	//
-	// required: false
-	// min: 8
-	// max: 8
-	Code string `json:"code" validate:"required,min=8,max=8"`
-	// original url
-	//
-	// required: true
-	// min: 8
-	Url string `json:"url" validate:"required,min=8"`
-}
-
-// swagger:parameters Add
-type urlParam struct {
-	// Url object used for Add<br>
-	// Note: the "code" field is optional, the service will generate a random code if none given
-	// in: body
-	// required: true
-	Body addParam
-}
-
-// swagger:parameters Delete Get GetCounter
-type Id struct {
-	// Url object Id
-	// in: path
-	// required: true
-	Id int64
-}
-
-// swagger:parameters Redirect
-type Code struct {
-	// Url object Code
-	// in: path
-	// required: true
-	Code string
-}
+// variantCookieMaxAge is the number of seconds a visitor is kept on the same variant
+const variantCookieMaxAge = 30 * 24 * 60 * 60
 
 type Controller struct {
 	Service service.Interactor
-	Logger  *log.Logger
+	Logger  *slog.Logger
+	// Geo locates the client country for the redirect rules, country rules never match if it's nil
+	Geo service.GeoLocator
 }
 
-func NewController(s service.Interactor, l *log.Logger) *Controller {
+func NewController(s service.Interactor, l *slog.Logger) *Controller {
 	return &Controller{Service: s, Logger: l}
 }
 
-// swagger:route POST /api api Add
-// Creates a new url in the database and then returns it in the response
-// responses:
-// 201: urlResponse
-// 409: codeExistsErrorResponse
-// 422: errorResponse
-// 500: errorResponse
-
-// Add creates a new url in the database and returns it
-func (c *Controller) Add(rw http.ResponseWriter, r *http.Request) {
-	rw.Header().Set("Content-type", "application/json")
-	c.Logger.Println("Handle Add url")
-
-	var u entities.Url
-	err := u.FromJSON(r.Body)
-	if err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": "unable to parse url object %s"}`, err.Error()), http.StatusUnprocessableEntity)
-		return
-	}
-
-	if err = c.Service.Create(&u); err != nil {
-		code := http.StatusInternalServerError
-		msg := err
-		if err == service.ErrCodeAlreadyExists {
-			code = http.StatusConflict
-			msg = service.ErrCodeAlreadyExists
+// Timeout returns a middleware that cancels the request context after the given duration, the storage and cache calls
+// of the request stop at the deadline. A duration of 0 keeps the request context unchanged, the click feed stream
+// at EventsPath, the exports and the imports are never cancelled
+func Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
 		}
 
-		http.Error(rw, fmt.Sprintf(`{"message": "unable to add url %s"}`, msg.Error()), code)
-		return
-	}
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == EventsPath || r.URL.Path == ExportPath || r.URL.Path == ImportPath {
+				next.ServeHTTP(rw, r)
+				return
+			}
 
-	rw.Header().Set("Location", u.ShortUrl)
-	rw.WriteHeader(http.StatusCreated)
-	if err = u.ToJSON(rw); err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": "unable to encode url response object %s"}`, err.Error()), http.StatusUnprocessableEntity)
-		return
-	}
-}
-
-// swagger:route DELETE /api/{Id} api Delete
-// Deletes a url
-// responses:
-// 200: noContent
-// 400: errorResponse
-// 500: errorResponse
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
 
-// Delete removes a url from the database
-func (c *Controller) Delete(rw http.ResponseWriter, r *http.Request) {
-	rw.Header().Set("Content-type", "application/json")
-	c.Logger.Println("Handle Delete url")
-
-	id, err := strconv.Atoi(path.Base(r.URL.String()))
-	if err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": "invalid url id value: %s"}`, err.Error()), http.StatusBadRequest)
-		return
-	}
-
-	if err := c.Service.Delete(int64(id)); err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": "unable to delete url: %s"}`, err.Error()), http.StatusInternalServerError)
-		return
+			next.ServeHTTP(rw, r.WithContext(ctx))
+		})
 	}
 }
 
-// swagger:route GET /api/{Id} api Get
-// Returns a url based on the given ID or 404 if no short url exists with the given code
-// responses:
-// 200: urlResponse
-// 404: noContent
-// 422: errorResponse
-// 500: errorResponse
-
-// Get fetches a url from the database
-func (c *Controller) Get(rw http.ResponseWriter, r *http.Request) {
-	rw.Header().Set("Content-type", "application/json")
-	c.Logger.Println("Handle get url")
-
-	id, err := strconv.Atoi(path.Base(r.URL.String()))
-	if err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": "invalid url id value: %s"}`, err.Error()), http.StatusBadRequest)
-		return
+// errorStatus returns the http status code of an error, the service errors get the status code of their kind
+func errorStatus(err error) int {
+	switch service.KindOf(err) {
+	case service.KindInvalidArgument:
+		return http.StatusBadRequest
+	case service.KindNotFound:
+		return http.StatusNotFound
+	case service.KindAlreadyExists:
+		return http.StatusConflict
+	case service.KindFailedPrecondition:
+		return http.StatusPreconditionFailed
+	case service.KindUnavailable:
+		return http.StatusServiceUnavailable
 	}
 
-	url, err := c.Service.GetById(int64(id))
-	if err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": "unable to fetch url: %s"}`, err.Error()), http.StatusInternalServerError)
-		return
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
 	}
 
-	if url.Id == 0 {
-		rw.WriteHeader(http.StatusNotFound)
-		return
-	}
-
-	if err = url.ToJSON(rw); err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": "unable to encode url response object %s"}`, err.Error()), http.StatusUnprocessableEntity)
-		return
-	}
+	return http.StatusInternalServerError
 }
 
-// swagger:route GET /{Code} root Redirect
-// Redirects to a long url or returns 404 if no short url exists in the database with the given code
-// responses:
-// 302: noContent
-// 404: noContent
-// 500: errorResponse
-
 // RedirectShortUrl redirects the request to a long url if the given code exists in the database
+// The path that follows the code is only accepted for urls in prefix mode, the variant a visitor is assigned to
+// is kept in a cookie so returning visitors get the same variant
 func (c *Controller) RedirectShortUrl(rw http.ResponseWriter, r *http.Request) {
-	c.Logger.Println("Handle url redirect")
+	c.Logger.DebugContext(r.Context(), "handle url redirect")
 
-	code := path.Base(r.URL.String())
-	url, err := c.Service.GetUrlByCode(code)
+	code := mux.Vars(r)["code"]
+	res, err := service.NewResolver(c.Service, c.Geo, c.Logger).Resolve(r.Context(), code, resolveRequest(r, code))
 	if err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": "unable to fetch url: %s"}`, err.Error()), http.StatusInternalServerError)
+		http.Error(rw, fmt.Sprintf(`{"message": "unable to resolve url: %s"}`, err.Error()), errorStatus(err))
 		return
 	}
 
-	if url == "" {
-		rw.WriteHeader(http.StatusNotFound)
-		return
+	if res.CacheControl != "" {
+		rw.Header().Set("Cache-Control", res.CacheControl)
 	}
 
-	c.Service.IncrementCounter(code)
+	if res.NewVariant {
+		http.SetCookie(rw, &http.Cookie{
+			Name:     variantCookiePrefix + code,
+			Value:    strconv.FormatInt(res.VariantId, 10),
+			Path:     "/" + code,
+			MaxAge:   variantCookieMaxAge,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
 
-	http.Redirect(rw, r, url, http.StatusFound)
-}
+	if !res.IsRedirect() {
+		rw.WriteHeader(res.Status)
+		return
+	}
 
-// swagger:route GET /counter/{Id} counter GetCounter
-// Returns the redirections counter for a given url object Id
-// responses:
-// 200: counterResponse
-// 404: noContent
-// 500: errorResponse
+	http.Redirect(rw, r, res.Location, res.Status)
+}
 
-// GetCounter returns the redirections counter for a given url object Id
-func (c *Controller) GetCounter(rw http.ResponseWriter, r *http.Request) {
-	c.Logger.Println("Handle get counter")
+// Preview renders the destination, creation date and redirections counter of a short url
+func (c *Controller) Preview(rw http.ResponseWriter, r *http.Request) {
+	c.Logger.DebugContext(r.Context(), "handle url preview")
 
-	id, err := strconv.Atoi(path.Base(r.URL.String()))
-	if err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": "invalid url id value: %s"}`, err.Error()), http.StatusBadRequest)
-		return
+	asJSON := strings.Contains(r.Header.Get("Accept"), "application/json")
+	if asJSON {
+		rw.Header().Set("Content-type", "application/json")
 	}
 
-	url, err := c.Service.GetById(int64(id))
+	url, err := c.Service.GetByCode(r.Context(), mux.Vars(r)["code"])
 	if err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": ""unable to fetch url: %s"}`, err.Error()), http.StatusInternalServerError)
+		http.Error(rw, fmt.Sprintf(`{"message": "unable to fetch url: %s"}`, err.Error()), errorStatus(err))
 		return
 	}
 
@@ -261,9 +132,16 @@ func (c *Controller) GetCounter(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = json.NewEncoder(rw).Encode(counterResponse{Counter: url.Counter}); err != nil {
-		http.Error(rw, fmt.Sprintf(`{"message": ""unable to encode url response object %s"}`, err.Error()), http.StatusUnprocessableEntity)
+	if asJSON {
+		if err = url.ToJSON(rw); err != nil {
+			http.Error(rw, fmt.Sprintf(`{"message": "unable to encode url response object %s"}`, err.Error()), http.StatusUnprocessableEntity)
+		}
+
 		return
 	}
 
+	rw.Header().Set("Content-type", "text/html; charset=utf-8")
+	if err = previewTemplate.Execute(rw, newPreviewPage(url)); err != nil {
+		c.Logger.ErrorContext(r.Context(), "unable to render preview page", "error", err.Error())
+	}
 }
@@ -1,27 +1,44 @@
 package http
 
 import (
+	"context"
 	"fmt"
+	"github.com/gorilla/mux"
 	"github.com/norby7/shortening-service/entities"
 	"github.com/norby7/shortening-service/usecases/service"
+	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 var (
 	createError  = fmt.Errorf("unable to create the url")
 	deleteError  = fmt.Errorf("unable to delete the url")
+	updateError  = fmt.Errorf("unable to update the url")
 	getError     = fmt.Errorf("unable to fetch the url")
+	counterError = fmt.Errorf("unable to increment counter")
 )
 
 type ServiceMock struct{}
 
-func (s *ServiceMock) Create(u *entities.Url) error {
+type GeoLocatorMock struct{}
+
+func (g *GeoLocatorMock) Country(ip net.IP) (string, error) {
+	if ip.Equal(net.ParseIP("192.0.2.1")) {
+		return "DE", nil
+	}
+
+	return "", nil
+}
+
+func (s *ServiceMock) Create(ctx context.Context, u *entities.Url, actor entities.Actor) error {
 	if u.Url == "http://www.invalidUrl.com" || u.Url == "" {
 		return createError
 	}
@@ -33,27 +50,142 @@ func (s *ServiceMock) Create(u *entities.Url) error {
 	return nil
 }
 
-func (s *ServiceMock) Delete(id int64) error {
-	if id == 0 {
+func (s *ServiceMock) Delete(ctx context.Context, id int64, actor entities.Actor) error {
+	if id == 0 || actor.Name == "" || actor.RequestId == "" {
 		return deleteError
 	}
 
 	return nil
 }
 
-func (s *ServiceMock) GetUrlByCode(code string) (string, error) {
+func (s *ServiceMock) Restore(ctx context.Context, id int64, actor entities.Actor) (entities.Url, error) {
+	if id == 0 {
+		return entities.Url{}, updateError
+	}
+
+	if id != 1 {
+		return entities.Url{}, service.ErrUrlNotFound
+	}
+
+	return entities.Url{Id: 1, Code: "84gfj4i9", Url: "https://google.com"}, nil
+}
+
+func (s *ServiceMock) GetDeleted(ctx context.Context) ([]entities.Url, error) {
+	deletedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []entities.Url{{Id: 3, Code: "d3l3t3d0", Url: "https://google.com", DeletedAt: &deletedAt}}, nil
+}
+
+func (s *ServiceMock) Update(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	if u.Id == 0 {
+		return updateError
+	}
+
+	if u.Id != 1 {
+		return service.ErrUrlNotFound
+	}
+
+	return nil
+}
+
+func (s *ServiceMock) Replace(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	return s.Update(ctx, u, actor)
+}
+
+func (s *ServiceMock) SetRules(ctx context.Context, id int64, rules []entities.Rule, actor entities.Actor) (entities.Url, error) {
+	if id == 0 {
+		return entities.Url{}, updateError
+	}
+
+	if id != 1 {
+		return entities.Url{}, service.ErrUrlNotFound
+	}
+
+	return entities.Url{Id: 1, Code: "84gfj4i9", Url: "https://google.com", Rules: rules}, nil
+}
+
+func (s *ServiceMock) GetUrlByCode(ctx context.Context, code string) (entities.Url, error) {
 	if code == "invalidCode" {
-		return "", getError
+		return entities.Url{}, getError
+	}
+
+	if code == "p3rmanen" {
+		return entities.Url{Id: 2, Code: code, Url: "https://google.com", RedirectType: entities.RedirectPermanent}, nil
 	}
 
-	if code != "84gfj4i9"{
-		return "", nil
+	if code == "ru1es000" {
+		return entities.Url{Id: 4, Code: code, Url: "https://example.com", Rules: []entities.Rule{
+			{Url: "https://apps.apple.com/app", Platforms: []string{entities.PlatformIOS}},
+			{Url: "https://example.de", Countries: []string{"DE"}},
+		}}, nil
 	}
 
-	return "https://google.com", nil
+	if code == "ru1esp3r" {
+		return entities.Url{Id: 5, Code: code, Url: "https://example.com", RedirectType: entities.RedirectPermanent, Rules: []entities.Rule{
+			{Url: "https://example.de", Countries: []string{"DE"}},
+		}}, nil
+	}
+
+	if code == "ru1esp1t" {
+		return entities.Url{Id: 6, Code: code, Url: "https://example.com", Variants: []entities.Variant{
+			{Id: 41, Url: "https://example.com/a", Weight: 100},
+		}, Rules: []entities.Rule{
+			{Url: "https://example.com", Countries: []string{"DE"}},
+		}}, nil
+	}
+
+	if code == "sp1it000" {
+		return entities.Url{Id: 7, Code: code, Url: "https://example.com", RedirectType: entities.RedirectPermanent, Variants: []entities.Variant{
+			{Id: 21, Url: "https://example.com/a", Weight: 100},
+			{Id: 22, Url: "https://example.com/b", Weight: 0},
+			{Id: 23, Url: "https://example.com/c", Weight: 0},
+		}, Rules: []entities.Rule{
+			{Url: "https://apps.apple.com/app", Platforms: []string{entities.PlatformIOS}},
+		}}, nil
+	}
+
+	if code == "0net1me0" || code == "exhau5te" || code == "c0unterr" {
+		return entities.Url{Id: 9, Code: code, Url: "https://example.com/download", RedirectType: entities.RedirectPermanent, MaxClicks: 1}, nil
+	}
+
+	if code == "sp1it001" {
+		return entities.Url{Id: 8, Code: code, Url: "https://example.com", Variants: []entities.Variant{
+			{Id: 31, Url: "https://example.com/x", Weight: 50},
+			{Id: 32, Url: "https://example.com/y", Weight: 50},
+		}}, nil
+	}
+
+	if code == "sch3dul0" {
+		from := time.Now().Add(time.Hour)
+		return entities.Url{Id: 8, Code: code, Url: "https://example.com/launch", ActiveFrom: &from}, nil
+	}
+
+	if code == "exp1red0" || code == "fa11back" {
+		until := time.Now().Add(-time.Hour)
+		u := entities.Url{Id: 9, Code: code, Url: "https://example.com/sale", ActiveUntil: &until}
+		if code == "fa11back" {
+			u.FallbackUrl = "https://example.com/sale-ended"
+		}
+
+		return u, nil
+	}
+
+	if code == "w1nd0w00" {
+		until := time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC)
+		return entities.Url{Id: 10, Code: code, Url: "https://example.com/sale", RedirectType: entities.RedirectPermanent, ActiveUntil: &until}, nil
+	}
+
+	if code == "pr3f1x00" {
+		return entities.Url{Id: 3, Code: code, Url: "https://example.com/docs?lang=en", PrefixMode: true, ForwardQuery: true}, nil
+	}
+
+	if code != "84gfj4i9" {
+		return entities.Url{}, nil
+	}
+
+	return entities.Url{Id: 1, Code: code, Url: "https://google.com", RedirectType: entities.RedirectFound}, nil
 }
 
-func (s *ServiceMock) GetById(id int64) (entities.Url, error) {
+func (s *ServiceMock) GetById(ctx context.Context, id int64) (entities.Url, error) {
 	if id == 0 {
 		return entities.Url{}, getError
 	}
@@ -72,256 +204,535 @@ func (s *ServiceMock) GetById(id int64) (entities.Url, error) {
 	}, nil
 }
 
-func (s *ServiceMock) IncrementCounter(string) {
+func (s *ServiceMock) GetByCode(ctx context.Context, code string) (entities.Url, error) {
+	if code == "invalidCode" {
+		return entities.Url{}, getError
+	}
 
+	if code != "84gfj4i9" {
+		return entities.Url{}, nil
+	}
+
+	return entities.Url{
+		Id:       1,
+		Code:     "84gfj4i9",
+		Url:      "https://google.com",
+		ShortUrl: "http://localhost/84gfj4i9",
+		Domain:   "http://localhost",
+		Counter:  1,
+	}, nil
 }
 
-func TestAdd(t *testing.T) {
-	s := ServiceMock{}
-	l := log.New(os.Stdout, "urls-api", log.LstdFlags)
-	c := NewController(&s, l)
+func (s *ServiceMock) SetVariants(ctx context.Context, id int64, variants []entities.Variant, actor entities.Actor) (entities.Url, error) {
+	if id == 0 {
+		return entities.Url{}, updateError
+	}
 
-	testCases := []struct {
-		name       string
-		input      *strings.Reader
-		statusCode int
-	}{{
-		name:       "invalid json object",
-		input:      strings.NewReader(`"url":"Where does the sun set?}`),
-		statusCode: http.StatusUnprocessableEntity,
-	}, {
-		name:       "add error",
-		input:      strings.NewReader(`{"url":"http://www.invalidUrl.com"}`),
-		statusCode: http.StatusInternalServerError,
-	}, {
-		name:       "add error, code already exists",
-		input:      strings.NewReader(`{"url":"http://www.validUrl.com", "code":"d4jn8dsf"}`),
-		statusCode: http.StatusConflict,
-	}, {
-		name:       "valid request",
-		input:      strings.NewReader(`{"url":"http://www.validUrl.com"}`),
-		statusCode: http.StatusCreated,
-	}}
+	if id != 1 {
+		return entities.Url{}, service.ErrUrlNotFound
+	}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			req := httptest.NewRequest("POST", "/api", tc.input)
-			rec := httptest.NewRecorder()
+	for i := range variants {
+		if variants[i].Id > 100 {
+			return entities.Url{}, service.ErrVariantNotFound
+		}
+	}
 
-			c.Add(rec, req)
+	return entities.Url{Id: 1, Code: "84gfj4i9", Url: "https://google.com", Variants: variants}, nil
+}
 
-			result := rec.Result()
+func (s *ServiceMock) ConsumeClick(ctx context.Context, click entities.Click) error {
+	if click.Code == "exhau5te" {
+		return service.ErrClicksExhausted
+	}
 
-			if tc.statusCode != result.StatusCode {
-				resBody, _ := ioutil.ReadAll(result.Body)
-				t.Errorf("expected status code (%v), got (%v) with response: (%v)", tc.statusCode, result.StatusCode, string(resBody))
-			}
-		})
+	if click.Code == "c0unterr" {
+		return counterError
+	}
+
+	return nil
+}
+
+func (s *ServiceMock) RemainingClicks(ctx context.Context, code string) (int64, error) {
+	if code == "exhau5te" {
+		return 0, nil
+	}
+
+	if code == "c0unterr" {
+		return 0, counterError
+	}
+
+	return 1, nil
+}
+
+func (s *ServiceMock) GetAuditEvents(ctx context.Context, filter entities.AuditFilter) ([]entities.AuditEvent, error) {
+	if filter.Actor == "invalidActor" {
+		return nil, getError
+	}
+
+	if filter.From != nil && filter.Until != nil && !filter.Until.After(*filter.From) {
+		return nil, service.ErrInvalidAuditFilter
+	}
+
+	if filter.UrlId == 404 {
+		return nil, nil
+	}
+
+	return []entities.AuditEvent{{Id: 1, UrlId: filter.UrlId, Action: entities.AuditCreate, Actor: entities.AnonymousActor}}, nil
+}
+
+func (s *ServiceMock) IncrementCounter(context.Context, entities.Click) bool {
+	return true
+}
+
+// clickFeed is the feed of the clicks published by the ServiceMock, subscribed receives a value on every subscription to it
+var clickFeed = service.NewClickFeed(0)
+var subscribed = make(chan struct{}, 1)
+
+func (s *ServiceMock) PublishClick(ctx context.Context, e entities.ClickEvent) {
+	clickFeed.Publish(e)
+}
+
+func (s *ServiceMock) SubscribeClicks(ctx context.Context, filter entities.ClickFilter) *service.ClickSubscription {
+	switch filter.Tag {
+	case "slow":
+		// the subscriber keeps one event and is dropped on the next one
+		f := service.NewClickFeed(1)
+		sub := f.Subscribe(filter)
+		for i := 0; i < 2; i++ {
+			f.Publish(entities.ClickEvent{UrlId: 1, Code: "84gfj4i9", Tags: []string{"slow"}})
+		}
+
+		return sub
+	case "closed":
+		f := service.NewClickFeed(1)
+		defer f.Close()
+
+		return f.Subscribe(filter)
 	}
+
+	sub := clickFeed.Subscribe(filter)
+	subscribed <- struct{}{}
+
+	return sub
 }
 
-func TestDelete(t *testing.T) {
+func (s *ServiceMock) AddWebhook(ctx context.Context, w *entities.Webhook) error {
+	return nil
+}
+
+func (s *ServiceMock) GetWebhooks(ctx context.Context) ([]entities.Webhook, error) {
+	return nil, nil
+}
+
+func (s *ServiceMock) DeleteWebhook(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (s *ServiceMock) GetDeadLetters(ctx context.Context, webhookId int64) ([]entities.DeadLetter, error) {
+	return nil, nil
+}
+
+func (s *ServiceMock) ReplayDeadLetter(ctx context.Context, id int64) error {
+	return nil
+}
+
+// Export writes the format and the filter owner and deleted flag, the unavailable owner fails before writing and
+// the broken owner fails after the first url
+func (s *ServiceMock) Export(ctx context.Context, filter entities.ExportFilter, format string, w io.Writer) (int, error) {
+	switch filter.Owner {
+	case "unavailable":
+		return 0, service.ErrStorageUnavailable
+	case "broken":
+		fmt.Fprintln(w, "84gfj4i9")
+		return 1, getError
+	}
+
+	fmt.Fprintf(w, "%s %s %t\n", format, filter.Owner, filter.Deleted)
+
+	return 1, nil
+}
+
+// Import reports one url created by the actor, named by the code of its result, the body "conflict" fails with a conflict
+func (s *ServiceMock) Import(ctx context.Context, r io.Reader, o entities.ImportOptions, actor entities.Actor) (entities.ImportReport, error) {
+	report := entities.ImportReport{DryRun: o.DryRun}
+	if err := o.Validate(); err != nil {
+		return report, service.ErrInvalidImport.Wrap(err)
+	}
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return report, service.ErrInvalidImport.Wrap(err)
+	}
+
+	if string(body) == "conflict" {
+		report.Add(entities.ImportResult{Line: 1, Code: "84gfj4i9", Action: entities.ImportFailed, Error: "code already exists"})
+		return report, service.ErrImportConflict
+	}
+
+	report.Add(entities.ImportResult{Line: 1, Code: actor.Name, Action: entities.ImportCreated})
+
+	return report, nil
+}
+
+func TestRedirectShortUrl(t *testing.T) {
 	s := ServiceMock{}
-	l := log.New(os.Stdout, "urls-api", log.LstdFlags)
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	c := NewController(&s, l)
+	c.Geo = &GeoLocatorMock{}
 
 	testCases := []struct {
-		name       string
-		input      string
-		statusCode int
+		name         string
+		input        string
+		path         string
+		query        string
+		userAgent    string
+		clientIP     string
+		cookie       string
+		statusCode   int
+		cacheControl string
+		location     string
+		setCookie    string
 	}{
 		{
 			name:       "empty query",
 			input:      "",
-			statusCode: http.StatusBadRequest,
+			statusCode: http.StatusNotFound,
 		},
 		{
-			name:       "non integer query",
-			input:      "id",
-			statusCode: http.StatusBadRequest,
+			name:       "get error",
+			input:      "invalidCode",
+			statusCode: http.StatusInternalServerError,
 		},
 		{
-			name:       "delete error",
-			input:      "0",
-			statusCode: http.StatusInternalServerError,
+			name:         "valid request",
+			input:        "84gfj4i9",
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+			location:     "https://google.com",
 		},
 		{
-			name:       "valid request",
-			input:      "1",
-			statusCode: http.StatusOK,
+			name:         "valid request, query string not forwarded",
+			input:        "84gfj4i9",
+			query:        "?ref=newsletter",
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+			location:     "https://google.com",
 		},
 		{
-			name:       "negative id",
-			input:      "-1",
-			statusCode: http.StatusOK,
+			name:         "valid request, permanent redirect",
+			input:        "p3rmanen",
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "public, max-age=31536000",
+			location:     "https://google.com",
 		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			req := httptest.NewRequest("DELETE", "/api/"+tc.input, nil)
-			rec := httptest.NewRecorder()
-
-			c.Delete(rec, req)
-			result := rec.Result()
-
-			if result.StatusCode != tc.statusCode {
-				resBody, _ := ioutil.ReadAll(result.Body)
-				t.Errorf("expected status code (%v), got (%v) with response: (%v)", tc.statusCode, result.StatusCode, string(resBody))
-			}
-		})
-	}
-}
-
-func TestGet(t *testing.T) {
-	s := ServiceMock{}
-	l := log.New(os.Stdout, "urls-api", log.LstdFlags)
-	c := NewController(&s, l)
-
-	testCases := []struct {
-		name       string
-		input      string
-		statusCode int
-	}{
 		{
-			name:       "empty query",
-			input:      "",
-			statusCode: http.StatusBadRequest,
+			name:       "path for url not in prefix mode",
+			input:      "84gfj4i9",
+			path:       "extra",
+			statusCode: http.StatusNotFound,
 		},
 		{
-			name:       "non integer query",
-			input:      "id",
-			statusCode: http.StatusBadRequest,
+			name:         "prefix mode with path and query string",
+			input:        "pr3f1x00",
+			path:         "guide/intro",
+			query:        "?ref=newsletter",
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+			location:     "https://example.com/docs/guide/intro?lang=en&ref=newsletter",
 		},
 		{
-			name:       "get error",
-			input:      "0",
+			name:         "rules, platform rule matches",
+			input:        "ru1es000",
+			userAgent:    "Mozilla/5.0 (iPhone; CPU iPhone OS 15_4 like Mac OS X) AppleWebKit/605.1.15",
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+			location:     "https://apps.apple.com/app",
+		},
+		{
+			name:         "rules, country rule matches",
+			input:        "ru1es000",
+			userAgent:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64)",
+			clientIP:     "192.0.2.1",
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+			location:     "https://example.de",
+		},
+		{
+			name:         "rules, no rule matches",
+			input:        "ru1es000",
+			userAgent:    "Mozilla/5.0 (Windows NT 10.0; Win64; x64)",
+			clientIP:     "198.51.100.1",
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+			location:     "https://example.com",
+		},
+		{
+			name:         "rules, permanent redirect of a rule",
+			input:        "ru1esp3r",
+			clientIP:     "192.0.2.1",
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "no-store",
+			location:     "https://example.de",
+		},
+		{
+			name:         "rules, permanent redirect of a url with rules",
+			input:        "ru1esp3r",
+			clientIP:     "198.51.100.1",
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "no-store",
+			location:     "https://example.com",
+		},
+		{
+			name:         "variants, new visitor assigned by weight",
+			input:        "sp1it000",
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "no-store",
+			location:     "https://example.com/a",
+			setCookie:    "sv_sp1it000=21",
+		},
+		{
+			name:         "variants, returning visitor keeps the variant",
+			input:        "sp1it001",
+			cookie:       "32",
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+			location:     "https://example.com/y",
+		},
+		{
+			name:         "variants, disabled variant cookie reassigned",
+			input:        "sp1it000",
+			cookie:       "22",
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "no-store",
+			location:     "https://example.com/a",
+			setCookie:    "sv_sp1it000=21",
+		},
+		{
+			name:         "variants, removed variant cookie reassigned",
+			input:        "sp1it000",
+			cookie:       "99",
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "no-store",
+			location:     "https://example.com/a",
+			setCookie:    "sv_sp1it000=21",
+		},
+		{
+			name:         "variants, matching rule takes precedence",
+			input:        "sp1it000",
+			userAgent:    "Mozilla/5.0 (iPhone; CPU iPhone OS 15_4 like Mac OS X) AppleWebKit/605.1.15",
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "no-store",
+			location:     "https://apps.apple.com/app",
+		},
+		{
+			name:         "variants, rule of the original url takes precedence",
+			input:        "ru1esp1t",
+			clientIP:     "192.0.2.1",
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+			location:     "https://example.com",
+		},
+		{
+			name:         "click limited, click left",
+			input:        "0net1me0",
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "no-store",
+			location:     "https://example.com/download",
+		},
+		{
+			name:         "click limited, exhausted",
+			input:        "exhau5te",
+			statusCode:   http.StatusGone,
+			cacheControl: "no-store",
+		},
+		{
+			name:       "click limited, count error",
+			input:      "c0unterr",
 			statusCode: http.StatusInternalServerError,
 		},
 		{
-			name:       "valid request",
-			input:      "1",
-			statusCode: http.StatusOK,
+			name:         "activation window, not active yet",
+			input:        "sch3dul0",
+			statusCode:   http.StatusNotFound,
+			cacheControl: "no-store",
+		},
+		{
+			name:         "activation window, expired",
+			input:        "exp1red0",
+			statusCode:   http.StatusGone,
+			cacheControl: "no-store",
+		},
+		{
+			name:         "activation window, expired with fallback url",
+			input:        "fa11back",
+			statusCode:   http.StatusFound,
+			cacheControl: "no-store",
+			location:     "https://example.com/sale-ended",
+		},
+		{
+			name:         "activation window, active permanent redirect",
+			input:        "w1nd0w00",
+			statusCode:   http.StatusPermanentRedirect,
+			cacheControl: "public, max-age=31536000",
+			location:     "https://example.com/sale",
 		},
 		{
 			name:       "valid request, url not found",
-			input:      "-1",
+			input:      "84gfasdf",
 			statusCode: http.StatusNotFound,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			req := httptest.NewRequest("GET", "/api/"+tc.input, nil)
+			req := httptest.NewRequest("GET", "/"+tc.input+tc.query, nil)
+			if tc.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: variantCookiePrefix + tc.input, Value: tc.cookie})
+			}
+			req = mux.SetURLVars(req, map[string]string{"code": tc.input, "path": tc.path})
+			req.Header.Set("User-Agent", tc.userAgent)
+			if tc.clientIP != "" {
+				req.RemoteAddr = tc.clientIP + ":1234"
+			}
 			rec := httptest.NewRecorder()
 
-			c.Get(rec, req)
+			c.RedirectShortUrl(rec, req)
 			result := rec.Result()
 
 			if result.StatusCode != tc.statusCode {
 				resBody, _ := ioutil.ReadAll(result.Body)
 				t.Errorf("expected status code (%v), got (%v) with response: (%v)", tc.statusCode, result.StatusCode, string(resBody))
 			}
+
+			if result.Header.Get("Cache-Control") != tc.cacheControl {
+				t.Errorf("expected Cache-Control (%v), got (%v)", tc.cacheControl, result.Header.Get("Cache-Control"))
+			}
+
+			if result.Header.Get("Location") != tc.location {
+				t.Errorf("expected Location (%v), got (%v)", tc.location, result.Header.Get("Location"))
+			}
+
+			if setCookie := result.Header.Get("Set-Cookie"); !strings.HasPrefix(setCookie, tc.setCookie) || (tc.setCookie == "") != (setCookie == "") {
+				t.Errorf("expected Set-Cookie (%v), got (%v)", tc.setCookie, setCookie)
+			}
 		})
 	}
 }
 
-func TestRedirectShortUrl(t *testing.T) {
+func TestPreview(t *testing.T) {
 	s := ServiceMock{}
-	l := log.New(os.Stdout, "urls-api", log.LstdFlags)
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	c := NewController(&s, l)
 
 	testCases := []struct {
-		name       string
-		input      string
-		statusCode int
+		name        string
+		input       string
+		accept      string
+		statusCode  int
+		contentType string
 	}{
-		{
-			name:       "empty query",
-			input:      "",
-			statusCode: http.StatusNotFound,
-		},
 		{
 			name:       "get error",
 			input:      "invalidCode",
 			statusCode: http.StatusInternalServerError,
 		},
 		{
-			name:       "valid request",
-			input:      "84gfj4i9",
-			statusCode: http.StatusFound,
-		},
-		{
-			name:       "valid request, url not found",
+			name:       "url not found",
 			input:      "84gfasdf",
 			statusCode: http.StatusNotFound,
 		},
+		{
+			name:        "valid request, html page",
+			input:       "84gfj4i9",
+			accept:      "text/html",
+			statusCode:  http.StatusOK,
+			contentType: "text/html; charset=utf-8",
+		},
+		{
+			name:        "valid request, json",
+			input:       "84gfj4i9",
+			accept:      "application/json",
+			statusCode:  http.StatusOK,
+			contentType: "application/json",
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			req := httptest.NewRequest("GET", "/api/"+tc.input, nil)
+			req := httptest.NewRequest("GET", "/preview/"+tc.input, nil)
+			req.Header.Set("Accept", tc.accept)
+			req = mux.SetURLVars(req, map[string]string{"code": tc.input})
 			rec := httptest.NewRecorder()
 
-			c.RedirectShortUrl(rec, req)
+			c.Preview(rec, req)
 			result := rec.Result()
 
 			if result.StatusCode != tc.statusCode {
 				resBody, _ := ioutil.ReadAll(result.Body)
 				t.Errorf("expected status code (%v), got (%v) with response: (%v)", tc.statusCode, result.StatusCode, string(resBody))
 			}
+
+			if tc.contentType != "" && result.Header.Get("Content-type") != tc.contentType {
+				t.Errorf("expected content type (%v), got (%v)", tc.contentType, result.Header.Get("Content-type"))
+			}
 		})
 	}
 }
 
-func TestGetCounter(t *testing.T) {
-	s := ServiceMock{}
-	l := log.New(os.Stdout, "urls-api", log.LstdFlags)
-	c := NewController(&s, l)
+func TestTimeout(t *testing.T) {
+	testCases := []struct {
+		name         string
+		timeout      time.Duration
+		path         string
+		expectedLeft time.Duration
+	}{
+		{name: "request timeout", timeout: time.Second, path: "/84gfj4i9", expectedLeft: time.Second},
+		{name: "no timeout", timeout: 0, path: "/84gfj4i9"},
+		{name: "click feed stream", timeout: time.Second, path: EventsPath},
+		{name: "export", timeout: time.Second, path: ExportPath},
+		{name: "import", timeout: time.Second, path: ImportPath},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var deadline time.Time
+			var ok bool
+			h := Timeout(tc.timeout)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				deadline, ok = r.Context().Deadline()
+			}))
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tc.path, nil))
+
+			if ok != (tc.expectedLeft != 0) {
+				t.Fatalf("expected a deadline (%t), got (%t)", tc.expectedLeft != 0, ok)
+			}
+
+			if ok && time.Until(deadline) > tc.expectedLeft {
+				t.Errorf("expected a deadline within (%s), got (%s)", tc.expectedLeft, time.Until(deadline))
+			}
+		})
+	}
+}
 
+func TestErrorStatus(t *testing.T) {
 	testCases := []struct {
 		name       string
-		input      string
+		err        error
 		statusCode int
 	}{
-		{
-			name:       "empty query",
-			input:      "",
-			statusCode: http.StatusBadRequest,
-		},
-		{
-			name:       "non integer query",
-			input:      "id",
-			statusCode: http.StatusBadRequest,
-		},
-		{
-			name:       "get error",
-			input:      "0",
-			statusCode: http.StatusInternalServerError,
-		},
-		{
-			name:       "valid request",
-			input:      "1",
-			statusCode: http.StatusOK,
-		},
-		{
-			name:       "valid request, url not found",
-			input:      "-1",
-			statusCode: http.StatusNotFound,
-		},
+		{name: "invalid url", err: service.ErrInvalidUrl.Wrap(fmt.Errorf("invalid")), statusCode: http.StatusBadRequest},
+		{name: "not found", err: service.ErrUrlNotFound, statusCode: http.StatusNotFound},
+		{name: "already exists", err: service.ErrCodeAlreadyExists, statusCode: http.StatusConflict},
+		{name: "storage unavailable", err: service.ErrStorageUnavailable.Wrap(fmt.Errorf("database is locked")), statusCode: http.StatusServiceUnavailable},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, statusCode: http.StatusGatewayTimeout},
+		{name: "internal", err: getError, statusCode: http.StatusInternalServerError},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			req := httptest.NewRequest("GET", "/api/"+tc.input, nil)
-			rec := httptest.NewRecorder()
-
-			c.GetCounter(rec, req)
-			result := rec.Result()
-
-			if result.StatusCode != tc.statusCode {
-				resBody, _ := ioutil.ReadAll(result.Body)
-				t.Errorf("expected status code (%v), got (%v) with response: (%v)", tc.statusCode, result.StatusCode, string(resBody))
+			if status := errorStatus(tc.err); status != tc.statusCode {
+				t.Errorf("expected status code (%d), got (%d)", tc.statusCode, status)
 			}
 		})
 	}
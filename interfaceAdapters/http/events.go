@@ -0,0 +1,123 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/norby7/shortening-service/entities"
+	"github.com/norby7/shortening-service/usecases/service"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// EventsPath is the path of the click feed, it's a stream so the request timeout doesn't apply to it
+const EventsPath = "/api/events"
+
+// heartbeatInterval is the time between two comments sent on an idle click feed, proxies close silent connections
+const heartbeatInterval = 15 * time.Second
+
+// Events streams the clicks matching the urlId, owner and tag query parameters as server-sent events
+// Every click is a "click" event with the JSON click as data. A subscriber that doesn't keep up with the clicks is
+// dropped, it gets an "error" event with the SLOW_CONSUMER reason before the stream ends
+func (c *Controller) Events(rw http.ResponseWriter, r *http.Request) {
+	c.Logger.DebugContext(r.Context(), "handle click feed")
+
+	filter, err := clickFilter(r)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf(`{"message": "invalid click filter: %s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	// the stream is written for as long as the client reads it, past the server write timeout
+	rc := http.NewResponseController(rw)
+	if err = rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		c.Logger.WarnContext(r.Context(), "unable to clear the click feed write deadline", "error", err.Error())
+	}
+
+	sub := c.Service.SubscribeClicks(r.Context(), filter)
+	defer sub.Close()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	if err = rc.Flush(); err != nil {
+		c.Logger.ErrorContext(r.Context(), "unable to stream the click feed", "error", err.Error())
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(rw, ": heartbeat\n\n")
+		case e, ok := <-sub.Events():
+			if !ok {
+				if err = sub.Err(); err != nil {
+					writeErrorEvent(rw, err)
+					_ = rc.Flush()
+				}
+
+				return
+			}
+
+			err = writeEvent(rw, "click", e)
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			c.Logger.DebugContext(r.Context(), "click feed client gone", "error", err.Error())
+			return
+		}
+	}
+}
+
+// clickFilter returns the click filter of the urlId, owner and tag query parameters
+func clickFilter(r *http.Request) (entities.ClickFilter, error) {
+	q := r.URL.Query()
+	filter := entities.ClickFilter{Owner: q.Get("owner"), Tag: q.Get("tag")}
+
+	if id := q.Get("urlId"); id != "" {
+		var err error
+		if filter.UrlId, err = strconv.ParseInt(id, 10, 64); err != nil {
+			return filter, fmt.Errorf("invalid urlId (%s)", id)
+		}
+	}
+
+	return filter, nil
+}
+
+// writeEvent writes a server-sent event with the JSON value as data
+func writeEvent(rw http.ResponseWriter, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("unable to encode %s event: %s", name, err.Error())
+	}
+
+	_, err = fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", name, data)
+
+	return err
+}
+
+// writeErrorEvent writes the "error" event that ends a stream, with the reason and message of the service error
+func writeErrorEvent(rw http.ResponseWriter, err error) {
+	body := struct {
+		Reason  string `json:"reason,omitempty"`
+		Message string `json:"message"`
+	}{Message: err.Error()}
+
+	var e *service.Error
+	if errors.As(err, &e) {
+		body.Reason = e.Reason
+	}
+
+	_ = writeEvent(rw, "error", body)
+}
@@ -0,0 +1,109 @@
+package http
+
+import (
+	"context"
+	"github.com/norby7/shortening-service/entities"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	s := ServiceMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	c := NewController(&s, l)
+
+	testCases := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedBody   []string
+	}{
+		{
+			name:           "invalid url id",
+			query:          "?urlId=abc",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   []string{"invalid urlId (abc)"},
+		},
+		{
+			name:           "slow consumer is dropped",
+			query:          "?tag=slow",
+			expectedStatus: http.StatusOK,
+			expectedBody: []string{
+				"event: click\ndata: {\"urlId\":1,\"code\":\"84gfj4i9\",",
+				"event: error\ndata: {\"reason\":\"SLOW_CONSUMER\",",
+			},
+		},
+		{
+			name:           "feed closed",
+			query:          "?tag=closed",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			c.Events(rw, httptest.NewRequest("GET", EventsPath+tc.query, nil))
+
+			if rw.Code != tc.expectedStatus {
+				t.Fatalf("expected status (%d), got (%d)", tc.expectedStatus, rw.Code)
+			}
+
+			if tc.expectedStatus == http.StatusOK && rw.Header().Get("Content-Type") != "text/event-stream" {
+				t.Errorf("expected an event stream, got (%s)", rw.Header().Get("Content-Type"))
+			}
+
+			for _, b := range tc.expectedBody {
+				if !strings.Contains(rw.Body.String(), b) {
+					t.Errorf("expected body to contain (%s), got (%s)", b, rw.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	s := ServiceMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	c := NewController(&s, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rw := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		c.Events(rw, httptest.NewRequest("GET", EventsPath+"?urlId=1&owner=anonymous", nil).WithContext(ctx))
+		close(done)
+	}()
+
+	select {
+	case <-subscribed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to subscribe to the clicks")
+	}
+
+	u := entities.Url{Id: 1, Code: "84gfj4i9", Owner: entities.AnonymousActor}
+	other := entities.Url{Id: 2, Code: "a1b2c3d4", Owner: entities.AnonymousActor}
+	s.PublishClick(ctx, entities.NewClickEvent(&other, 0, time.Now()))
+	s.PublishClick(ctx, entities.NewClickEvent(&u, 10, time.Now()))
+
+	// the client disconnects once the click is published, the subscriber keeps the buffered events until then
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	body := rw.Body.String()
+	if !strings.Contains(body, `data: {"urlId":1,"code":"84gfj4i9","variantId":10,"owner":"anonymous",`) {
+		t.Errorf("expected the click of url (1), got (%s)", body)
+	}
+
+	if strings.Contains(body, "a1b2c3d4") {
+		t.Errorf("expected the click of url (2) to be filtered out, got (%s)", body)
+	}
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/norby7/shortening-service/entities"
+	"github.com/norby7/shortening-service/usecases/logging"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// statusRecorder keeps the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and writes it
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the implicit 200 status code of a response written without WriteHeader
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped ResponseWriter, http.ResponseController flushes the streamed responses through it
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// AccessLog returns a middleware that gives every request a request id, taken from the X-Request-ID header or generated,
+// sends it back in the X-Request-ID response header and adds it to the request context so the service, repository
+// and storage log lines of the request carry it. Once the request is served it logs its method, path, short url code,
+// status and latency, at the error level for the 5xx statuses
+func AccessLog(l *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			requestId := r.Header.Get("X-Request-ID")
+			if requestId == "" {
+				requestId = entities.NewRequestId()
+			}
+
+			rw.Header().Set("X-Request-ID", requestId)
+			ctx := logging.WithRequestId(r.Context(), requestId)
+
+			rec := &statusRecorder{ResponseWriter: rw}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			level := slog.LevelInfo
+			if rec.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			l.LogAttrs(ctx, level, "http request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("code", mux.Vars(r)["code"]),
+				slog.Int("status", rec.status),
+				slog.Duration("latency", time.Since(start)),
+			)
+		})
+	}
+}
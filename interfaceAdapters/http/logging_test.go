@@ -0,0 +1,71 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/norby7/shortening-service/usecases/logging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	testCases := []struct {
+		name           string
+		requestId      string
+		status         int
+		expectedLevel  string
+		expectedStatus float64
+	}{
+		{name: "given request id", requestId: "req-1", status: http.StatusFound, expectedLevel: "INFO", expectedStatus: 302},
+		{name: "generated request id", status: 0, expectedLevel: "INFO", expectedStatus: 200},
+		{name: "server error", requestId: "req-2", status: http.StatusInternalServerError, expectedLevel: "ERROR", expectedStatus: 500},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			l, err := logging.New(&buf, logging.FormatJSON, "info")
+			if err != nil {
+				t.Fatalf("unable to create logger: %s", err.Error())
+			}
+
+			var handlerRequestId string
+			r := mux.NewRouter()
+			r.Use(AccessLog(l))
+			r.HandleFunc("/{code:[a-zA-Z0-9]+}", func(rw http.ResponseWriter, r *http.Request) {
+				handlerRequestId = logging.RequestId(r.Context())
+				if tc.status != 0 {
+					rw.WriteHeader(tc.status)
+				}
+			})
+
+			req := httptest.NewRequest("GET", "/84gfj4i9", nil)
+			if tc.requestId != "" {
+				req.Header.Set("X-Request-ID", tc.requestId)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			requestId := rr.Header().Get("X-Request-ID")
+			if requestId == "" || (tc.requestId != "" && requestId != tc.requestId) || handlerRequestId != requestId {
+				t.Errorf("expected request id (%s) in the response and the handler context, got (%s) and (%s)", tc.requestId, requestId, handlerRequestId)
+			}
+
+			var record map[string]interface{}
+			if err = json.Unmarshal(buf.Bytes(), &record); err != nil {
+				t.Fatalf("unable to decode access log: %s", err.Error())
+			}
+
+			if record["level"] != tc.expectedLevel || record["status"] != tc.expectedStatus || record["code"] != "84gfj4i9" || record[logging.RequestIdKey] != requestId {
+				t.Errorf("expected level (%s) status (%v) code (84gfj4i9) request id (%s), got (%v)", tc.expectedLevel, tc.expectedStatus, requestId, record)
+			}
+
+			if _, ok := record["latency"]; !ok {
+				t.Errorf("expected the request latency, got (%v)", record)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package http
+
+import (
+	"github.com/norby7/shortening-service/entities"
+	"html/template"
+	"strings"
+)
+
+// previewTemplate is the html page rendered by the Preview handler
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="robots" content="noindex">
+  <title>Preview of {{.Link.ShortUrl}}</title>
+  <style>
+    body { font-family: sans-serif; max-width: 40em; margin: 3em auto; padding: 0 1em; color: #222; }
+    .destination { word-break: break-all; font-size: 1.2em; }
+    .warning { background: #fff4e5; border: 1px solid #f0ad4e; padding: 1em; margin: 1.5em 0; }
+    dt { font-weight: bold; margin-top: 0.5em; }
+  </style>
+</head>
+<body>
+  <h1>Where does this link go?</h1>
+  <p><code>{{.Link.ShortUrl}}</code> redirects to:</p>
+  <p class="destination"><a href="{{.Link.Url}}" rel="noopener noreferrer nofollow">{{.Link.Url}}</a></p>
+  <div class="warning">
+    <strong>Be careful:</strong> short links can hide malicious websites. Only continue if you recognise and trust the destination above.
+    {{- if .Insecure}}
+    The destination does not use a secure (https) connection.
+    {{- end}}
+  </div>
+  <dl>
+    <dt>Created</dt>
+    <dd>{{if .Link.CreatedAt.IsZero}}unknown{{else}}{{.Link.CreatedAt.Format "2 January 2006 15:04 MST"}}{{end}}</dd>
+    <dt>Clicks</dt>
+    <dd>{{.Link.Counter}}</dd>
+    {{- if .Link.IsClickLimited}}
+    <dt>Clicks left</dt>
+    <dd>{{.ClicksLeft}}</dd>
+    {{- end}}
+  </dl>
+</body>
+</html>
+`))
+
+// previewPage holds the data rendered by the preview template
+type previewPage struct {
+	Link       entities.Url
+	Insecure   bool
+	ClicksLeft int64
+}
+
+// newPreviewPage returns the preview template data for the given url
+func newPreviewPage(u entities.Url) previewPage {
+	p := previewPage{Link: u, Insecure: !strings.HasPrefix(u.Url, "https://")}
+	if u.IsClickLimited() && u.Counter < u.MaxClicks {
+		p.ClicksLeft = u.MaxClicks - u.Counter
+	}
+
+	return p
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	testCases := []struct {
+		name           string
+		traceparent    string
+		status         int
+		expectedTrace  string
+		expectedStatus codes.Code
+	}{
+		{name: "new trace", status: http.StatusFound, expectedStatus: codes.Unset},
+		{
+			name:           "propagated trace",
+			traceparent:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			status:         http.StatusFound,
+			expectedTrace:  "4bf92f3577b34da6a3ce929d0e0e4736",
+			expectedStatus: codes.Unset,
+		},
+		{name: "server error", status: http.StatusInternalServerError, expectedStatus: codes.Error},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter.Reset()
+
+			var handlerSpan trace.SpanContext
+			r := mux.NewRouter()
+			r.Use(Trace())
+			r.HandleFunc("/{code:[a-zA-Z0-9]+}", func(rw http.ResponseWriter, r *http.Request) {
+				handlerSpan = trace.SpanContextFromContext(r.Context())
+				rw.WriteHeader(tc.status)
+			})
+
+			req := httptest.NewRequest("GET", "/84gfj4i9", nil)
+			if tc.traceparent != "" {
+				req.Header.Set("traceparent", tc.traceparent)
+			}
+
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := exporter.GetSpans()
+			if len(spans) != 1 {
+				t.Fatalf("expected (1) span, got (%d)", len(spans))
+			}
+
+			span := spans[0]
+			if span.Name != "GET /{code:[a-zA-Z0-9]+}" || span.SpanKind != trace.SpanKindServer {
+				t.Errorf("expected server span (GET /{code:[a-zA-Z0-9]+}), got (%s) (%s)", span.SpanKind, span.Name)
+			}
+
+			if handlerSpan.SpanID() != span.SpanContext.SpanID() {
+				t.Errorf("expected the handler context to carry the request span")
+			}
+
+			if tc.expectedTrace != "" && span.SpanContext.TraceID().String() != tc.expectedTrace {
+				t.Errorf("expected trace id (%s), got (%s)", tc.expectedTrace, span.SpanContext.TraceID())
+			}
+
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, a := range span.Attributes {
+				attrs[a.Key] = a.Value
+			}
+
+			if attrs["code"].AsString() != "84gfj4i9" || attrs["http.response.status_code"].AsInt64() != int64(tc.status) {
+				t.Errorf("expected code (84gfj4i9) and status (%d), got (%v)", tc.status, span.Attributes)
+			}
+
+			if span.Status.Code != tc.expectedStatus {
+				t.Errorf("expected span status (%v), got (%v)", tc.expectedStatus, span.Status.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/norby7/shortening-service/entities"
+	"github.com/norby7/shortening-service/usecases/logging"
+	"github.com/norby7/shortening-service/usecases/service"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ExportPath is the path of the urls export, the export is streamed so the request timeout doesn't apply to it
+const ExportPath = "/api/export"
+
+// ImportPath is the path of the urls import, large imports take longer than the request and server timeouts
+const ImportPath = "/api/import"
+
+// MaxImportSize is the largest imported file, in bytes
+const MaxImportSize = 32 << 20
+
+// Export streams the urls matching the owner, tag, from, until and deleted query parameters as a file of the format
+// query parameter, csv or jsonl (the default)
+func (c *Controller) Export(rw http.ResponseWriter, r *http.Request) {
+	c.Logger.DebugContext(r.Context(), "handle urls export")
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = entities.FormatJSONL
+	}
+
+	filter, err := exportFilter(r)
+	if err != nil {
+		http.Error(rw, fmt.Sprintf(`{"message": "invalid export filter: %s"}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	switch format {
+	case entities.FormatCSV:
+		rw.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	case entities.FormatJSONL:
+		rw.Header().Set("Content-Type", "application/x-ndjson")
+	default:
+		http.Error(rw, fmt.Sprintf(`{"message": "invalid export format (%s)"}`, format), http.StatusBadRequest)
+		return
+	}
+
+	// the export is written for as long as the client reads it, past the server write timeout
+	rc := http.NewResponseController(rw)
+	if err = rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		c.Logger.WarnContext(r.Context(), "unable to clear the export write deadline", "error", err.Error())
+	}
+
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="urls.%s"`, format))
+	rw.Header().Set("Cache-Control", "no-store")
+
+	w := &countingWriter{w: rw}
+	n, err := c.Service.Export(r.Context(), filter, format, w)
+	if err != nil {
+		// the status can only be changed while nothing was written
+		if w.n == 0 {
+			rw.Header().Del("Content-Disposition")
+			http.Error(rw, fmt.Sprintf(`{"message": "unable to export urls: %s"}`, err.Error()), errorStatus(err))
+			return
+		}
+
+		c.Logger.ErrorContext(r.Context(), "unable to export urls", "exported", n, "error", err.Error())
+		// the client sees the truncated file as a broken download
+		panic(http.ErrAbortHandler)
+	}
+
+	c.Logger.DebugContext(r.Context(), "urls exported", "exported", n)
+}
+
+// Import adds the urls of the csv or jsonl request body, with the format, conflict (skip, overwrite or fail) and dryRun
+// query parameters, and responds with the report of every url. The report is sent with the 409 status if the fail
+// conflict policy stopped the import
+func (c *Controller) Import(rw http.ResponseWriter, r *http.Request) {
+	c.Logger.DebugContext(r.Context(), "handle urls import")
+	rw.Header().Set("Content-type", "application/json")
+
+	q := r.URL.Query()
+	opts := entities.ImportOptions{Format: q.Get("format"), Conflict: q.Get("conflict")}
+	if opts.Format == "" {
+		opts.Format = entities.FormatJSONL
+	}
+
+	if v := q.Get("dryRun"); v != "" {
+		var err error
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(rw, fmt.Sprintf(`{"message": "invalid dryRun (%s)"}`, v), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the bodies sent without a length are stopped by the reader once they reach the maximum size
+	if r.ContentLength > MaxImportSize {
+		http.Error(rw, fmt.Sprintf(`{"message": "the imported file is larger than %d bytes"}`, MaxImportSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ip := ""
+	if addr := clientIP(r); addr != nil {
+		ip = addr.String()
+	}
+
+	// the file is read and the report written for as long as the import takes, past the server read and write timeouts
+	rc := http.NewResponseController(rw)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		c.Logger.WarnContext(r.Context(), "unable to clear the import read deadline", "error", err.Error())
+	}
+
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		c.Logger.WarnContext(r.Context(), "unable to clear the import write deadline", "error", err.Error())
+	}
+
+	actor := entities.NewActor(r.Header.Get("X-API-Key"), ip, logging.RequestId(r.Context()))
+	body := http.MaxBytesReader(rw, r.Body, MaxImportSize)
+
+	report, err := c.Service.Import(r.Context(), body, opts, actor)
+	if err != nil && !errors.Is(err, service.ErrImportConflict) {
+		http.Error(rw, fmt.Sprintf(`{"message": "unable to import urls: %s"}`, err.Error()), errorStatus(err))
+		return
+	}
+
+	if err != nil {
+		rw.WriteHeader(errorStatus(err))
+	}
+
+	if err = json.NewEncoder(rw).Encode(report); err != nil {
+		c.Logger.ErrorContext(r.Context(), "unable to encode the import report", "error", err.Error())
+	}
+}
+
+// exportFilter returns the export filter of the owner, tag, from, until and deleted query parameters, the times are
+// RFC 3339 times
+func exportFilter(r *http.Request) (entities.ExportFilter, error) {
+	q := r.URL.Query()
+	filter := entities.ExportFilter{Owner: q.Get("owner"), Tag: q.Get("tag")}
+
+	var err error
+	if filter.From, err = timeParam(r, "from"); err != nil {
+		return filter, err
+	}
+
+	if filter.Until, err = timeParam(r, "until"); err != nil {
+		return filter, err
+	}
+
+	if v := q.Get("deleted"); v != "" {
+		if filter.Deleted, err = strconv.ParseBool(v); err != nil {
+			return filter, fmt.Errorf("invalid deleted (%s)", v)
+		}
+	}
+
+	return filter, nil
+}
+
+// timeParam returns the RFC 3339 time of the query parameter, nil if it's not set
+func timeParam(r *http.Request, name string) (*time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s (%s)", name, v)
+	}
+
+	return &t, nil
+}
+
+// countingWriter counts the bytes written to the wrapped writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes to the wrapped writer and counts the written bytes
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+
+	return n, err
+}
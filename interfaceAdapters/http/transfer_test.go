@@ -0,0 +1,231 @@
+package http
+
+import (
+	"encoding/json"
+	"github.com/norby7/shortening-service/entities"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	s := ServiceMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	c := NewController(&s, l)
+
+	testCases := []struct {
+		name                string
+		query               string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "default format",
+			query:               "?owner=anonymous",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "application/x-ndjson",
+			expectedBody:        "jsonl anonymous false\n",
+		},
+		{
+			name:                "csv with deleted urls",
+			query:               "?format=csv&deleted=true&from=2022-01-01T00:00:00Z&until=2023-01-01T00:00:00Z",
+			expectedStatus:      http.StatusOK,
+			expectedContentType: "text/csv; charset=utf-8",
+			expectedBody:        "csv  true\n",
+		},
+		{
+			name:           "unknown format",
+			query:          "?format=xml",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid export format (xml)",
+		},
+		{
+			name:           "invalid from",
+			query:          "?from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid from (yesterday)",
+		},
+		{
+			name:           "invalid deleted",
+			query:          "?deleted=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid deleted (maybe)",
+		},
+		{
+			name:           "storage unavailable",
+			query:          "?owner=unavailable",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "storage unavailable",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			c.Export(rw, httptest.NewRequest("GET", ExportPath+tc.query, nil))
+
+			if rw.Code != tc.expectedStatus {
+				t.Fatalf("expected status (%d), got (%d) (%s)", tc.expectedStatus, rw.Code, rw.Body.String())
+			}
+
+			if tc.expectedContentType != "" && rw.Header().Get("Content-Type") != tc.expectedContentType {
+				t.Errorf("expected content type (%s), got (%s)", tc.expectedContentType, rw.Header().Get("Content-Type"))
+			}
+
+			if tc.expectedStatus == http.StatusOK && !strings.HasPrefix(rw.Header().Get("Content-Disposition"), "attachment") {
+				t.Errorf("expected an attachment, got (%s)", rw.Header().Get("Content-Disposition"))
+			}
+
+			if !strings.Contains(rw.Body.String(), tc.expectedBody) {
+				t.Errorf("expected body to contain (%s), got (%s)", tc.expectedBody, rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestExportAborted(t *testing.T) {
+	s := ServiceMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	c := NewController(&s, l)
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("expected the handler to abort, got (%v)", r)
+		}
+	}()
+
+	c.Export(httptest.NewRecorder(), httptest.NewRequest("GET", ExportPath+"?owner=broken", nil))
+}
+
+func TestImport(t *testing.T) {
+	s := ServiceMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	c := NewController(&s, l)
+
+	testCases := []struct {
+		name           string
+		query          string
+		apiKey         string
+		body           string
+		contentLength  int64
+		expectedStatus int
+		expectedReport entities.ImportReport
+		expectedBody   string
+	}{
+		{
+			name:           "imported",
+			body:           `{"url":"https://google.com"}`,
+			expectedStatus: http.StatusOK,
+			expectedReport: entities.ImportReport{Total: 1, Created: 1,
+				Results: []entities.ImportResult{{Line: 1, Code: entities.AnonymousActor, Action: entities.ImportCreated}}},
+		},
+		{
+			name:           "dry run with api key",
+			query:          "?format=csv&conflict=skip&dryRun=true",
+			apiKey:         "secret",
+			body:           "url\nhttps://google.com\n",
+			expectedStatus: http.StatusOK,
+			expectedReport: entities.ImportReport{DryRun: true, Total: 1, Created: 1,
+				Results: []entities.ImportResult{{Line: 1, Code: entities.NewActor("secret", "", "").Name, Action: entities.ImportCreated}}},
+		},
+		{
+			name:           "conflict",
+			query:          "?conflict=fail",
+			body:           "conflict",
+			expectedStatus: http.StatusConflict,
+			expectedReport: entities.ImportReport{Total: 1, Failed: 1,
+				Results: []entities.ImportResult{{Line: 1, Code: "84gfj4i9", Action: entities.ImportFailed, Error: "code already exists"}}},
+		},
+		{
+			name:           "unknown conflict policy",
+			query:          "?conflict=merge",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "conflict must be skip, overwrite or fail",
+		},
+		{
+			name:           "invalid dry run",
+			query:          "?dryRun=maybe",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "invalid dryRun (maybe)",
+		},
+		{
+			name:           "too large",
+			contentLength:  MaxImportSize + 1,
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedBody:   "larger than",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", ImportPath+tc.query, strings.NewReader(tc.body))
+			req.Header.Set("X-API-Key", tc.apiKey)
+			if tc.contentLength != 0 {
+				req.ContentLength = tc.contentLength
+			}
+
+			rw := httptest.NewRecorder()
+			c.Import(rw, req)
+
+			if rw.Code != tc.expectedStatus {
+				t.Fatalf("expected status (%d), got (%d) (%s)", tc.expectedStatus, rw.Code, rw.Body.String())
+			}
+
+			if tc.expectedBody != "" {
+				if !strings.Contains(rw.Body.String(), tc.expectedBody) {
+					t.Errorf("expected body to contain (%s), got (%s)", tc.expectedBody, rw.Body.String())
+				}
+
+				return
+			}
+
+			var report entities.ImportReport
+			if err := json.NewDecoder(rw.Body).Decode(&report); err != nil {
+				t.Fatalf("unable to decode the report: %s", err.Error())
+			}
+
+			if report.Total != tc.expectedReport.Total || report.DryRun != tc.expectedReport.DryRun || len(report.Results) != 1 ||
+				report.Results[0] != tc.expectedReport.Results[0] {
+				t.Errorf("expected report (%+v), got (%+v)", tc.expectedReport, report)
+			}
+		})
+	}
+}
+
+func TestImportSlowBody(t *testing.T) {
+	s := ServiceMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	c := NewController(&s, l)
+
+	svr := httptest.NewUnstartedServer(http.HandlerFunc(c.Import))
+	svr.Config.ReadTimeout = 50 * time.Millisecond
+	svr.Config.WriteTimeout = 50 * time.Millisecond
+	svr.Start()
+	defer svr.Close()
+
+	// the body is sent in parts, the last one after the server timeouts
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte(`{"url":`))
+		time.Sleep(200 * time.Millisecond)
+		pw.Write([]byte(`"https://google.com"}`))
+		pw.Close()
+	}()
+
+	resp, err := http.Post(svr.URL+ImportPath, "application/x-ndjson", pr)
+	if err != nil {
+		t.Fatalf("unable to import: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	var report entities.ImportReport
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&report) != nil || report.Created != 1 {
+		t.Errorf("expected the report of the imported url, got (%d) (%+v)", resp.StatusCode, report)
+	}
+}
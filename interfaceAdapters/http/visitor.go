@@ -0,0 +1,44 @@
+package http
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/norby7/shortening-service/entities"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// resolveRequest returns the attributes of a short url request its destination is resolved with
+// The variant the visitor was assigned to before is read from the variant cookie of the url
+func resolveRequest(r *http.Request, code string) entities.ResolveRequest {
+	req := entities.ResolveRequest{
+		Path:        mux.Vars(r)["path"],
+		Query:       r.URL.Query(),
+		Visitor:     entities.NewVisitor(r.UserAgent(), r.Header.Get("Accept-Language")),
+		ClientIp:    clientIP(r),
+		RecordClick: true,
+	}
+
+	if cookie, err := r.Cookie(variantCookiePrefix + code); err == nil {
+		if id, err := strconv.ParseInt(cookie.Value, 10, 64); err == nil {
+			req.VariantId = id
+		}
+	}
+
+	return req
+}
+
+// clientIP returns the request client ip address, the first X-Forwarded-For address is used if the header is set
+func clientIP(r *http.Request) net.IP {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return net.ParseIP(strings.TrimSpace(strings.SplitN(fwd, ",", 2)[0]))
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{
+			name:       "remote address",
+			remoteAddr: "192.0.2.1:1234",
+			expected:   "192.0.2.1",
+		},
+		{
+			name:       "forwarded for header",
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  "198.51.100.7, 10.0.0.2",
+			expected:   "198.51.100.7",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+
+			if ip := clientIP(req); ip.String() != tc.expected {
+				t.Errorf("expected ip (%v), got (%v)", tc.expected, ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"io"
+	"strings"
+)
+
+// span exporters
+const (
+	// ExporterNone records the spans without exporting them, the trace ids are still propagated and logged
+	ExporterNone = "none"
+	// ExporterOTLP sends the spans to an OTLP/HTTP collector configured by the OTEL_EXPORTER_OTLP_* variables
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes the spans as JSON to the standard output
+	ExporterStdout = "stdout"
+)
+
+// NewExporter returns the span exporter with the given name, ExporterNone if empty
+// A nil exporter is returned for ExporterNone
+func NewExporter(ctx context.Context, name string, w io.Writer) (sdktrace.SpanExporter, error) {
+	switch strings.ToLower(name) {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterOTLP:
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create otlp exporter: %s", err.Error())
+		}
+
+		return exporter, nil
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create stdout exporter: %s", err.Error())
+		}
+
+		return exporter, nil
+	default:
+		return nil, fmt.Errorf("invalid traces exporter (%s), expected %s, %s or %s", name, ExporterNone, ExporterOTLP, ExporterStdout)
+	}
+}
+
+// NewTracerProvider returns a tracer provider that batches the spans of the given service to the exporter,
+// the spans are only recorded if the exporter is nil
+func NewTracerProvider(serviceName string, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	}
+
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+// Register sets the tracer provider used by the instrumented layers and the W3C trace context and baggage propagators
+func Register(tp *sdktrace.TracerProvider) {
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestNewExporter(t *testing.T) {
+	testCases := []struct {
+		name             string
+		exporter         string
+		expectedExporter bool
+		expectedError    bool
+	}{
+		{name: "default", exporter: ""},
+		{name: "none", exporter: "none"},
+		{name: "otlp", exporter: "OTLP", expectedExporter: true},
+		{name: "stdout", exporter: "stdout", expectedExporter: true},
+		{name: "invalid", exporter: "jaeger", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter, err := NewExporter(context.Background(), tc.exporter, &bytes.Buffer{})
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("expected error (%t), got (%v)", tc.expectedError, err)
+			}
+
+			if (exporter != nil) != tc.expectedExporter {
+				t.Errorf("expected exporter (%t), got (%v)", tc.expectedExporter, exporter)
+			}
+
+			if exporter != nil {
+				_ = exporter.Shutdown(context.Background())
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := NewTracerProvider("shortening-service-test", exporter)
+	Register(tp)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "test span")
+	span.End()
+
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("unable to flush spans: %s", err.Error())
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "test span" {
+		t.Fatalf("expected the (test span) span, got (%v)", spans)
+	}
+
+	if name, ok := spans[0].Resource.Set().Value("service.name"); !ok || name.AsString() != "shortening-service-test" {
+		t.Errorf("expected service name (shortening-service-test), got (%v)", name)
+	}
+
+	carrier := propagation.MapCarrier{}
+	ctx, span := otel.Tracer("test").Start(context.Background(), "propagated span")
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	span.End()
+
+	if carrier.Get("traceparent") == "" {
+		t.Errorf("expected the W3C traceparent to be injected, got (%v)", carrier)
+	}
+}
@@ -0,0 +1,457 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"expvar"
+	"fmt"
+	"github.com/go-openapi/runtime/middleware"
+	"github.com/gorilla/mux"
+	"github.com/norby7/shortening-service/config"
+	"github.com/norby7/shortening-service/interfaceAdapters/certs"
+	"github.com/norby7/shortening-service/interfaceAdapters/geoip"
+	grpc2 "github.com/norby7/shortening-service/interfaceAdapters/grpc"
+	"github.com/norby7/shortening-service/interfaceAdapters/grpc/protocol"
+	httpC "github.com/norby7/shortening-service/interfaceAdapters/http"
+	"github.com/norby7/shortening-service/interfaceAdapters/tracing"
+	"github.com/norby7/shortening-service/usecases/repository"
+	ucCache "github.com/norby7/shortening-service/usecases/repository/cache"
+	"github.com/norby7/shortening-service/usecases/repository/storage"
+	ucService "github.com/norby7/shortening-service/usecases/service"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShutdownTimeout is the time the servers wait for the running requests and the service waits for the queued
+// clicks to be saved on shutdown
+const ShutdownTimeout = 30 * time.Second
+
+// Listeners selects the servers started by Run
+type Listeners struct {
+	HTTP bool
+	GRPC bool
+}
+
+// App is the service with its storage, cache and tracer, shared by the http and grpc servers
+type App struct {
+	Config  config.Config
+	Logger  *slog.Logger
+	Service *ucService.Service
+	geo     *geoip.MaxMindLocator
+	storage *storage.SqliteStorage
+	tracer  *sdktrace.TracerProvider
+	// certs serves the tls certificates, nil when the servers serve in plaintext
+	certs     *certs.Reloader
+	stopCerts context.CancelFunc
+}
+
+// New loads the tls certificates, opens the storage and the cache of the configuration, registers the tracer and
+// starts the service
+func New(cfg config.Config, l *slog.Logger) (*App, error) {
+	a := &App{Config: cfg, Logger: l}
+
+	// the servers don't start in plaintext when the certificates can't be loaded
+	if cfg.TLS.Enabled() {
+		r, err := certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile, l)
+		if err != nil {
+			return nil, err
+		}
+
+		a.certs = r
+	}
+
+	// trace the requests with the otlp, stdout or none exporter
+	exporter, err := tracing.NewExporter(context.Background(), cfg.Tracing.Exporter, os.Stdout)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create traces exporter: %s", err.Error())
+	}
+
+	a.tracer = tracing.NewTracerProvider(cfg.Tracing.ServiceName, exporter)
+	tracing.Register(a.tracer)
+
+	// create sqlite database file if it doesn't exists
+	if err = storage.CreateDatabase(cfg.Database.Path); err != nil {
+		return nil, err
+	}
+
+	a.storage, err = storage.NewSqliteStorage(cfg.Database.Path, cfg.Database.Workers)
+	if err != nil {
+		return nil, err
+	}
+
+	a.storage.Logger = l
+
+	// checks if the schema exists and initialize it if it doesn't
+	if err = storage.ValidateSchema(a.storage.Handler); err != nil {
+		a.storage.Handler.Close()
+		return nil, err
+	}
+
+	redisCache, err := ucCache.NewRedisCache(cfg.Cache.Host, strconv.Itoa(cfg.Cache.Port), cfg.Cache.Password, cfg.Timeouts.Cache)
+	if err != nil {
+		l.Warn("unable to connect to redis cache", "error", err.Error())
+	}
+
+	urlRepo := repository.NewUrlRepository(a.storage, redisCache, l)
+	urlRepo.Timeouts = repository.Timeouts{Storage: cfg.Timeouts.Storage, Cache: cfg.Timeouts.Cache}
+
+	// count the clicks in redis if the redis counter mode is enabled and redis is available
+	counters := ucService.CounterOptions{
+		QueueSize:     cfg.Counters.QueueSize,
+		FlushInterval: cfg.Counters.FlushInterval,
+		BatchSize:     cfg.Counters.BatchSize,
+		Overflow:      cfg.Counters.Overflow,
+		Mode:          cfg.Counters.Mode,
+		FeedBuffer:    cfg.Counters.FeedBuffer,
+	}
+	if counters.Mode == ucService.CounterModeRedis {
+		if redisCache.Active {
+			urlRepo.Counters = ucCache.NewRedisCounters(redisCache.Client)
+		} else {
+			l.Warn("redis is not available, the clicks are counted in memory")
+			counters.Mode = ucService.CounterModeMemory
+		}
+	}
+
+	a.Service = ucService.NewService(urlRepo, counters, cfg.Domain)
+	a.Service.StartTrashPurger(cfg.TrashRetention)
+	a.Service.StartWebhooks(ucService.WebhookOptions{
+		Workers:      cfg.Webhooks.Workers,
+		QueueSize:    cfg.Webhooks.QueueSize,
+		MaxAttempts:  cfg.Webhooks.MaxAttempts,
+		RetryBackoff: cfg.Webhooks.RetryBackoff,
+		MaxBackoff:   cfg.Webhooks.MaxBackoff,
+		Timeout:      cfg.Webhooks.Timeout,
+	})
+
+	// load the geoip database used by the country redirect rules
+	if cfg.GeoIP.DBPath != "" {
+		a.geo, err = geoip.NewMaxMindLocator(cfg.GeoIP.DBPath)
+		if err != nil {
+			l.Warn("unable to load the geoip database", "error", err.Error())
+		}
+	}
+
+	// reload the certificates when their files change
+	if a.certs != nil {
+		var ctx context.Context
+		ctx, a.stopCerts = context.WithCancel(context.Background())
+		go a.certs.Watch(ctx, cfg.TLS.ReloadInterval)
+	}
+
+	return a, nil
+}
+
+// Close stops reloading the certificates, exports the batched spans and closes the geoip database and the storage
+// The service must be closed before, Run closes it when the servers stop
+func (a *App) Close(ctx context.Context) error {
+	if a.stopCerts != nil {
+		a.stopCerts()
+	}
+
+	if err := a.tracer.Shutdown(ctx); err != nil {
+		a.Logger.Error("unable to shutdown the tracer provider", "error", err.Error())
+	}
+
+	if a.geo != nil {
+		a.geo.Close()
+	}
+
+	return a.storage.Handler.Close()
+}
+
+// HTTPHandler returns the router of the http api with the tracing, access log and timeout middlewares
+// The REST api is served by the gateway of the UrlServiceServer, the redirects and previews by the controller
+// With mutual tls only the api requests of the allowed clients with a verified certificate are served, like the grpc calls
+func (a *App) HTTPHandler() (http.Handler, error) {
+	controller := httpC.NewController(a.Service, a.Logger)
+	if a.geo != nil {
+		controller.Geo = a.geo
+	}
+
+	gateway, err := grpc2.NewGateway(a.urlGrpcService())
+	if err != nil {
+		return nil, err
+	}
+
+	r := mux.NewRouter()
+	r.Use(httpC.Trace(), httpC.AccessLog(a.Logger), httpC.Timeout(a.Config.Timeouts.Request))
+	if a.Config.TLS.MutualTLS() {
+		r.Use(httpC.ClientAuth(a.Config.TLS.Clients()))
+	}
+	RegisterRoutes(r, *controller, gateway)
+
+	return r, nil
+}
+
+// GRPCServer returns a grpc server with the UrlServiceServer and the reflection service registered, created with opts
+// Every call is traced, logged and limited to the request timeout, or to the client deadline when it's earlier
+// The streams are logged and last until the client cancels them
+// With mutual tls only the calls of the allowed clients with a verified certificate are served
+func (a *App) GRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{grpc2.TraceInterceptor(), grpc2.AccessLogInterceptor(a.Logger), grpc2.TimeoutInterceptor(a.Config.Timeouts.Request)}
+	stream := []grpc.StreamServerInterceptor{grpc2.AccessLogStreamInterceptor(a.Logger)}
+	if a.Config.TLS.MutualTLS() {
+		unary = append(unary, grpc2.AuthInterceptor(a.Config.TLS.Clients()))
+		stream = append(stream, grpc2.AuthStreamInterceptor(a.Config.TLS.Clients()))
+	}
+
+	opts = append(opts, grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	s := grpc.NewServer(opts...)
+
+	//  register  grpcurl  The required  reflection  service
+	reflection.Register(s)
+	protocol.RegisterUrlServiceServer(s, a.urlGrpcService())
+
+	return s
+}
+
+// urlGrpcService returns the UrlServiceServer of the app, with the geoip database if it's loaded
+func (a *App) urlGrpcService() *grpc2.UrlGrpcService {
+	us := grpc2.NewUrlGrpcService(a.Service, a.Logger)
+	if a.geo != nil {
+		us.Geo = a.geo
+	}
+
+	return us
+}
+
+// RegisterRoutes registers the http server routes, the /api and /counter paths are served by the gateway
+// except for the click feed stream, the exports and the imports
+func RegisterRoutes(r *mux.Router, c httpC.Controller, gateway http.Handler) {
+	r.HandleFunc(httpC.EventsPath, c.Events).Methods("GET")
+	r.HandleFunc(httpC.ExportPath, c.Export).Methods("GET")
+	r.HandleFunc(httpC.ImportPath, c.Import).Methods("POST")
+	r.Handle("/api", gateway)
+	r.PathPrefix("/api/").Handler(gateway)
+	r.PathPrefix("/counter/").Handler(gateway).Methods("GET")
+
+	// create Redoc configuration
+	ops := middleware.RedocOpts{
+		SpecURL: "/swagger.yaml",
+	}
+
+	// add swagger documentation routes
+	sh := middleware.Redoc(ops, nil)
+	r.Handle("/docs", sh)
+	r.Handle("/swagger.yaml", http.FileServer(http.Dir("./")))
+
+	// expose the click counter metrics
+	r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+
+	r.HandleFunc("/preview/{code:[a-zA-Z0-9]+}", c.Preview).Methods("GET")
+	r.HandleFunc("/{code:[a-zA-Z0-9]+}+", c.Preview).Methods("GET")
+	r.HandleFunc("/{code:[a-zA-Z0-9]+}", c.RedirectShortUrl).Methods("GET")
+	r.HandleFunc("/{code:[a-zA-Z0-9]+}/{path:.*}", c.RedirectShortUrl).Methods("GET")
+}
+
+// Run starts the selected servers and serves until the context is done or a server fails
+// With the grpc multiplex setting both servers share the http port, the grpc calls are recognized by their
+// HTTP/2 application/grpc requests, cleartext HTTP/2 is accepted for them when the servers don't use tls
+// On shutdown both servers stop accepting requests and wait for the running ones, then the service is closed
+// so the queued clicks are saved
+func (a *App) Run(ctx context.Context, l Listeners) error {
+	if !l.HTTP && !l.GRPC {
+		return fmt.Errorf("no server selected, expected the http or the grpc server")
+	}
+
+	var httpLis, grpcLis net.Listener
+	var err error
+
+	if l.HTTP || (l.GRPC && a.Config.GRPC.Multiplex) {
+		if httpLis, err = net.Listen("tcp", net.JoinHostPort(a.Config.HTTP.Address, strconv.Itoa(a.Config.HTTP.Port))); err != nil {
+			return fmt.Errorf("unable to listen on the http port: %s", err.Error())
+		}
+	}
+
+	if l.GRPC && !a.Config.GRPC.Multiplex {
+		if grpcLis, err = net.Listen("tcp", net.JoinHostPort(a.Config.GRPC.Address, strconv.Itoa(a.Config.GRPC.Port))); err != nil {
+			if httpLis != nil {
+				httpLis.Close()
+			}
+
+			return fmt.Errorf("unable to listen on the grpc port: %s", err.Error())
+		}
+	}
+
+	return a.serve(ctx, httpLis, grpcLis, l)
+}
+
+// serve serves the http api, the grpc api, or both multiplexed, on httpLis and the grpc api on grpcLis
+// A nil listener isn't served. Both listeners are served over tls when the app has certificates
+func (a *App) serve(ctx context.Context, httpLis, grpcLis net.Listener, l Listeners) error {
+	var grpcServer *grpc.Server
+	if l.GRPC {
+		// with mutual tls the grpc server requires a verified client certificate
+		var opts []grpc.ServerOption
+		if a.certs != nil && grpcLis != nil {
+			clientAuth := tls.NoClientCert
+			if a.Config.TLS.MutualTLS() {
+				clientAuth = tls.RequireAndVerifyClientCert
+			}
+
+			opts = append(opts, grpc.Creds(credentials.NewTLS(a.certs.ServerConfig(clientAuth))))
+		}
+
+		grpcServer = a.GRPCServer(opts...)
+	}
+
+	var httpServer *http.Server
+	if httpLis != nil {
+		var h http.Handler = http.NotFoundHandler()
+		if l.HTTP {
+			var err error
+			if h, err = a.HTTPHandler(); err != nil {
+				return err
+			}
+		}
+
+		multiplexed := grpcServer != nil && grpcLis == nil
+		if multiplexed {
+			h = mixedHandler(grpcServer, h, a.Logger)
+			if a.certs == nil {
+				h = h2c.NewHandler(h, &http2.Server{})
+			}
+		}
+
+		// the responses can be written until one second after the request timeout so a timed out request still gets its error
+		httpServer = &http.Server{
+			Handler:      h,
+			IdleTimeout:  120 * time.Second,
+			ReadTimeout:  2 * time.Second,
+			WriteTimeout: a.Config.Timeouts.Request + time.Second,
+		}
+
+		// with mutual tls the http server verifies the certificates of the clients that send one, the api requests
+		// and grpc calls without one are rejected by the ClientAuth middleware and the grpc AuthInterceptor
+		if a.certs != nil {
+			clientAuth := tls.NoClientCert
+			if a.Config.TLS.MutualTLS() {
+				clientAuth = tls.VerifyClientCertIfGiven
+			}
+
+			httpServer.TLSConfig = a.certs.ServerConfig(clientAuth)
+		}
+	}
+
+	errs := make(chan error, 2)
+	if httpServer != nil {
+		go func() {
+			a.Logger.Info("starting http server", "address", httpLis.Addr().String(), "grpc", grpcServer != nil && grpcLis == nil, "tls", a.certs != nil)
+
+			var err error
+			if a.certs != nil {
+				err = httpServer.ServeTLS(httpLis, "", "")
+			} else {
+				err = httpServer.Serve(httpLis)
+			}
+
+			if err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("unable to serve http: %s", err.Error())
+			}
+		}()
+	}
+
+	if grpcServer != nil && grpcLis != nil {
+		go func() {
+			a.Logger.Info("starting grpc server", "address", grpcLis.Addr().String(), "tls", a.certs != nil, "mtls", a.Config.TLS.MutualTLS())
+			if err := grpcServer.Serve(grpcLis); err != nil {
+				errs <- fmt.Errorf("unable to serve grpc: %s", err.Error())
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		a.Logger.Info("graceful shutdown")
+	case err = <-errs:
+		a.Logger.Error("server failed, shutting down", "error", err.Error())
+	}
+
+	// end the click feed streams first, the servers wait for them
+	a.Service.CloseClickFeed()
+
+	tc, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	// stop both servers at the same time, the grpc calls are stopped if they don't finish before the timeout
+	var wg sync.WaitGroup
+	if httpServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := httpServer.Shutdown(tc); err != nil {
+				a.Logger.Error("error shuting down http server", "error", err.Error())
+			}
+		}()
+	}
+
+	// the multiplexed grpc calls are served by the http server, which waits for them, and can't be drained by
+	// GracefulStop, the grpc server is only stopped once the http server is shut down
+	if grpcServer != nil && grpcLis != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stopped := make(chan struct{})
+			go func() {
+				grpcServer.GracefulStop()
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+			case <-tc.Done():
+				grpcServer.Stop()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if grpcServer != nil && grpcLis == nil {
+		grpcServer.Stop()
+	}
+
+	if cerr := a.Service.Close(tc); cerr != nil {
+		a.Logger.Error("unable to close the service", "error", cerr.Error())
+	}
+
+	return err
+}
+
+// mixedHandler sends the grpc requests to the grpc server and the other ones to the http handler
+// The read and write deadlines of the http server are cleared for the grpc calls, the streams can stay open past them
+// and the calls have their own deadlines
+func mixedHandler(grpcServer http.Handler, h http.Handler, l *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			rc := http.NewResponseController(rw)
+			if err := rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				l.WarnContext(r.Context(), "unable to clear the grpc call read deadline", "error", err.Error())
+			}
+
+			if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				l.WarnContext(r.Context(), "unable to clear the grpc call write deadline", "error", err.Error())
+			}
+
+			grpcServer.ServeHTTP(rw, r)
+			return
+		}
+
+		h.ServeHTTP(rw, r)
+	})
+}
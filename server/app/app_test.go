@@ -0,0 +1,373 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/norby7/shortening-service/config"
+	"github.com/norby7/shortening-service/entities"
+	"github.com/norby7/shortening-service/interfaceAdapters/certs/certstest"
+	"github.com/norby7/shortening-service/interfaceAdapters/grpc/protocol"
+	ucService "github.com/norby7/shortening-service/usecases/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMixedHandler(t *testing.T) {
+	testCases := []struct {
+		name         string
+		protoMajor   int
+		contentType  string
+		expectedGrpc bool
+	}{
+		{name: "grpc call", protoMajor: 2, contentType: "application/grpc", expectedGrpc: true},
+		{name: "grpc proto call", protoMajor: 2, contentType: "application/grpc+proto", expectedGrpc: true},
+		{name: "http2 request", protoMajor: 2, contentType: "application/json"},
+		{name: "http1 request", protoMajor: 1, contentType: "application/grpc"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var grpcServed bool
+			grpcHandler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) { grpcServed = true })
+
+			req := httptest.NewRequest("POST", "/protocol.UrlService/GetTrash", nil)
+			req.ProtoMajor = tc.protoMajor
+			req.Header.Set("Content-Type", tc.contentType)
+
+			mixedHandler(grpcHandler, http.NotFoundHandler(), slog.Default()).ServeHTTP(httptest.NewRecorder(), req)
+
+			if grpcServed != tc.expectedGrpc {
+				t.Errorf("expected grpc (%t), got (%t)", tc.expectedGrpc, grpcServed)
+			}
+		})
+	}
+}
+
+func TestServe(t *testing.T) {
+	// the schema and migrations are read relative to the repository root
+	wd, _ := os.Getwd()
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("unable to change directory: %s", err.Error())
+	}
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "test ca")
+	_, certPEM, keyPEM := ca.Issue(t, "server", true)
+	admin, _, _ := ca.Issue(t, "admin", false)
+	ops, _, _ := ca.Issue(t, "ops", false)
+	tlsConfig := config.TLSConfig{
+		CertFile:       certstest.WriteFile(t, dir, "server.crt", certPEM),
+		KeyFile:        certstest.WriteFile(t, dir, "server.key", keyPEM),
+		ReloadInterval: time.Minute,
+	}
+	mtlsConfig := tlsConfig
+	mtlsConfig.ClientCAFile = certstest.WriteFile(t, dir, "ca.crt", ca.PEM)
+	mtlsConfig.AllowedClients = "admin"
+
+	testCases := []struct {
+		name      string
+		multiplex bool
+		tls       config.TLSConfig
+	}{
+		{name: "separate ports"},
+		{name: "multiplexed", multiplex: true},
+		{name: "tls separate ports", tls: tlsConfig},
+		{name: "tls multiplexed", multiplex: true, tls: tlsConfig},
+		{name: "mutual tls separate ports", tls: mtlsConfig},
+		{name: "mutual tls multiplexed", multiplex: true, tls: mtlsConfig},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Database.Path = filepath.Join(t.TempDir(), "urls.db")
+			cfg.Cache.Host = "127.0.0.1"
+			cfg.Cache.Port = 1
+			cfg.Timeouts.Cache = 100 * time.Millisecond
+			cfg.GRPC.Multiplex = tc.multiplex
+			cfg.TLS = tc.tls
+
+			a, err := New(cfg, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+			if err != nil {
+				t.Fatalf("unable to create the app: %s", err.Error())
+			}
+			defer a.Close(context.Background())
+
+			httpLis, _ := net.Listen("tcp", "127.0.0.1:0")
+			grpcAddr := httpLis.Addr().String()
+
+			var grpcLis net.Listener
+			if !tc.multiplex {
+				grpcLis, _ = net.Listen("tcp", "127.0.0.1:0")
+				grpcAddr = grpcLis.Addr().String()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- a.serve(ctx, httpLis, grpcLis, Listeners{HTTP: true, GRPC: true})
+			}()
+
+			// get requests the path of the http api with the given client certificates and returns the status
+			get := func(path string, clientCerts ...tls.Certificate) int {
+				scheme, httpClient := "http", http.DefaultClient
+				if tc.tls.Enabled() {
+					scheme = "https"
+					httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.Pool(), Certificates: clientCerts}}}
+				}
+
+				res, err := httpClient.Get(scheme + "://" + httpLis.Addr().String() + path)
+				if err != nil {
+					t.Fatalf("unexpected http error: %s", err.Error())
+				}
+				res.Body.Close()
+
+				return res.StatusCode
+			}
+
+			if status := get("/api/trash", admin); status != http.StatusOK {
+				t.Fatalf("expected http status (200), got (%d)", status)
+			}
+
+			// with mutual tls the REST api requires a client certificate like the grpc api, the redirects don't
+			if tc.tls.MutualTLS() {
+				if status := get("/api/trash"); status != http.StatusUnauthorized {
+					t.Errorf("expected http status (401) without a client certificate, got (%d)", status)
+				}
+
+				if status := get("/api/trash", ops); status != http.StatusForbidden {
+					t.Errorf("expected http status (403) for a client that isn't allowed, got (%d)", status)
+				}
+
+				if status := get("/n0tf0und"); status != http.StatusNotFound {
+					t.Errorf("expected http status (404) for a redirect without a client certificate, got (%d)", status)
+				}
+			}
+
+			// getTrash calls the grpc api with the given client certificates
+			getTrash := func(clientCerts ...tls.Certificate) error {
+				creds := insecure.NewCredentials()
+				if tc.tls.Enabled() {
+					creds = credentials.NewTLS(&tls.Config{ServerName: "localhost", RootCAs: ca.Pool(), Certificates: clientCerts})
+				}
+
+				conn, err := grpc.NewClient(grpcAddr, grpc.WithTransportCredentials(creds))
+				if err != nil {
+					t.Fatalf("unable to create grpc client: %s", err.Error())
+				}
+				defer conn.Close()
+
+				_, err = protocol.NewUrlServiceClient(conn).GetTrash(context.Background(), &protocol.VoidResponse{})
+
+				return err
+			}
+
+			if err = getTrash(admin); err != nil {
+				t.Fatalf("unexpected grpc error: %s", err.Error())
+			}
+
+			if tc.tls.MutualTLS() {
+				if err = getTrash(); err == nil {
+					t.Errorf("expected an error without a client certificate")
+				}
+
+				if err = getTrash(ops); err == nil {
+					t.Errorf("expected an error for a client that isn't allowed")
+				}
+			}
+
+			cancel()
+			select {
+			case err = <-done:
+				if err != nil {
+					t.Errorf("unexpected error: %s", err.Error())
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expected the servers to shut down")
+			}
+		})
+	}
+}
+
+func TestServeLongStream(t *testing.T) {
+	// the schema and migrations are read relative to the repository root
+	wd, _ := os.Getwd()
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("unable to change directory: %s", err.Error())
+	}
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	ca := certstest.NewCA(t, "test ca")
+	_, certPEM, keyPEM := ca.Issue(t, "server", true)
+
+	testCases := []struct {
+		name string
+		tls  config.TLSConfig
+	}{
+		{name: "multiplexed"},
+		{name: "tls multiplexed", tls: config.TLSConfig{
+			CertFile:       certstest.WriteFile(t, dir, "server.crt", certPEM),
+			KeyFile:        certstest.WriteFile(t, dir, "server.key", keyPEM),
+			ReloadInterval: time.Minute,
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Database.Path = filepath.Join(t.TempDir(), "urls.db")
+			cfg.Cache.Host = "127.0.0.1"
+			cfg.Cache.Port = 1
+			cfg.Timeouts.Cache = 100 * time.Millisecond
+			// the http server write timeout is one second after the request timeout
+			cfg.Timeouts.Request = 100 * time.Millisecond
+			cfg.GRPC.Multiplex = true
+			cfg.TLS = tc.tls
+
+			a, err := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			if err != nil {
+				t.Fatalf("unable to create the app: %s", err.Error())
+			}
+			defer a.Close(context.Background())
+
+			lis, _ := net.Listen("tcp", "127.0.0.1:0")
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- a.serve(ctx, lis, nil, Listeners{HTTP: true, GRPC: true})
+			}()
+			defer func() {
+				cancel()
+				<-done
+			}()
+
+			creds := insecure.NewCredentials()
+			scheme, transport := "http", &http.Transport{}
+			if tc.tls.Enabled() {
+				creds = credentials.NewTLS(&tls.Config{ServerName: "localhost", RootCAs: ca.Pool()})
+				scheme, transport = "https", &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.Pool()}}
+			}
+
+			conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(creds))
+			if err != nil {
+				t.Fatalf("unable to create grpc client: %s", err.Error())
+			}
+			defer conn.Close()
+
+			client := protocol.NewUrlServiceClient(conn)
+			u, err := client.Add(ctx, &protocol.Url{Url: "https://google.com"})
+			if err != nil {
+				t.Fatalf("unexpected grpc error: %s", err.Error())
+			}
+
+			stream, err := client.WatchClicks(ctx, &protocol.ClickFilter{UrlId: u.Id})
+			if err != nil {
+				t.Fatalf("unexpected grpc error: %s", err.Error())
+			}
+
+			// the stream must outlive the read and write timeouts of the http server
+			time.Sleep(2500 * time.Millisecond)
+
+			httpClient := &http.Client{Transport: transport, CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}}
+			res, err := httpClient.Get(scheme + "://" + lis.Addr().String() + "/" + u.Code)
+			if err != nil {
+				t.Fatalf("unexpected http error: %s", err.Error())
+			}
+			res.Body.Close()
+
+			e, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("expected the stream to stay open, got (%s)", err.Error())
+			}
+
+			if e.Code != u.Code {
+				t.Errorf("expected a click of (%s), got (%s)", u.Code, e.Code)
+			}
+		})
+	}
+}
+
+func TestRunWithoutServers(t *testing.T) {
+	a := &App{}
+	if err := a.Run(context.Background(), Listeners{}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestExportImportCommands(t *testing.T) {
+	// the schema and migrations are read relative to the repository root
+	wd, _ := os.Getwd()
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("unable to change directory: %s", err.Error())
+	}
+	defer os.Chdir(wd)
+
+	dir := t.TempDir()
+	t.Setenv("DB_PATH", filepath.Join(dir, "urls.db"))
+	t.Setenv("REDIS_HOSTNAME", "127.0.0.1")
+	t.Setenv("REDIS_PORT", "1")
+	t.Setenv("CACHE_TIMEOUT", "100ms")
+	t.Setenv("LOG_LEVEL", "error")
+
+	input := "url,code,counter,tags\nhttps://google.com,84gfj4i9,42,newsletter\nhttps://example.com,a1b2c3d4,0,\n"
+
+	var out bytes.Buffer
+	err := Import(flag.NewFlagSet("import", flag.ContinueOnError), []string{"-format", "csv", "-"}, strings.NewReader(input), &out)
+	if err != nil {
+		t.Fatalf("unable to import: %s", err.Error())
+	}
+
+	var report entities.ImportReport
+	if err = json.Unmarshal(out.Bytes(), &report); err != nil || report.Created != 2 {
+		t.Fatalf("expected 2 created urls, got (%s) (%v)", out.String(), err)
+	}
+
+	// the same urls conflict with the imported ones
+	out.Reset()
+	err = Import(flag.NewFlagSet("import", flag.ContinueOnError), []string{"-format", "csv", "-"}, strings.NewReader(input), &out)
+	if !errors.Is(err, ucService.ErrImportConflict) || !strings.Contains(out.String(), `"failed": 2`) {
+		t.Fatalf("expected a conflict error with the report, got (%v) (%s)", err, out.String())
+	}
+
+	file := filepath.Join(dir, "urls.jsonl")
+	if err = Export(flag.NewFlagSet("export", flag.ContinueOnError), []string{"-out", file}, io.Discard); err != nil {
+		t.Fatalf("unable to export: %s", err.Error())
+	}
+
+	b, _ := os.ReadFile(file)
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 || !strings.Contains(lines[0], `"counter":42`) {
+		t.Errorf("expected 2 exported urls, got (%s)", b)
+	}
+
+	out.Reset()
+	if err = Export(flag.NewFlagSet("export", flag.ContinueOnError), []string{"-format", "csv", "-tag", "newsletter"}, &out); err != nil {
+		t.Fatalf("unable to export: %s", err.Error())
+	}
+
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[1], "84gfj4i9,https://google.com,42,") {
+		t.Errorf("expected the csv header and the tagged url, got (%s)", out.String())
+	}
+
+	if err = Export(flag.NewFlagSet("export", flag.ContinueOnError), []string{"-from", "yesterday"}, &out); err == nil {
+		t.Errorf("expected an invalid -from error")
+	}
+}
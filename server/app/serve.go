@@ -0,0 +1,63 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"github.com/norby7/shortening-service/config"
+	"github.com/norby7/shortening-service/usecases/logging"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Serve is the command of the servers: it parses the -config and -print-config flags of args, loads the
+// configuration and prints it to w with -print-config, or else runs the selected servers until SIGINT or SIGTERM
+// serviceName is the default name of the service in the traces, the listeners may be set by flags defined on fs
+func Serve(fs *flag.FlagSet, args []string, serviceName string, l *Listeners, w io.Writer) error {
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "yaml or toml configuration file")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration, with the secrets redacted, and exit")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	def := config.Default()
+	def.Tracing.ServiceName = serviceName
+
+	cfg, err := config.Load(*configFile, def)
+	if err != nil {
+		return err
+	}
+
+	if *printConfig {
+		return cfg.Print(w)
+	}
+
+	// create the logger in the json or text format, logging the debug, info, warn or error records and above
+	logger, err := logging.New(w, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(logger)
+
+	a, err := New(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	runErr := a.Run(ctx, *l)
+
+	tc, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if err = a.Close(tc); err != nil {
+		logger.Error("unable to close the storage", "error", err.Error())
+	}
+
+	return runErr
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/norby7/shortening-service/config"
+	"github.com/norby7/shortening-service/entities"
+	"github.com/norby7/shortening-service/usecases/logging"
+	"github.com/norby7/shortening-service/usecases/repository"
+	ucCache "github.com/norby7/shortening-service/usecases/repository/cache"
+	"github.com/norby7/shortening-service/usecases/repository/storage"
+	ucService "github.com/norby7/shortening-service/usecases/service"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Export is the command of the exports: it parses the flags of args, opens the storage of the configuration and
+// writes the selected urls to the -out file, or to w if it's not set. The logs are written to stderr
+func Export(fs *flag.FlagSet, args []string, w io.Writer) error {
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "yaml or toml configuration file")
+	format := fs.String("format", entities.FormatJSONL, "format of the exported file, csv or jsonl")
+	out := fs.String("out", "", "exported file, stdout if it's not set")
+	owner := fs.String("owner", "", "only export the urls of this owner")
+	tag := fs.String("tag", "", "only export the urls with this tag")
+	from := fs.String("from", "", "only export the urls created at or after this RFC 3339 time")
+	until := fs.String("until", "", "only export the urls created before this RFC 3339 time")
+	deleted := fs.Bool("deleted", false, "export the urls in the trash too")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := entities.ExportFilter{Owner: *owner, Tag: *tag, Deleted: *deleted}
+
+	var err error
+	if filter.From, err = parseTimeFlag("from", *from); err != nil {
+		return err
+	}
+
+	if filter.Until, err = parseTimeFlag("until", *until); err != nil {
+		return err
+	}
+
+	s, closeService, err := OpenService(*configFile)
+	if err != nil {
+		return err
+	}
+	defer closeService()
+
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("unable to create the exported file: %s", err.Error())
+		}
+		defer f.Close()
+
+		w = f
+	}
+
+	n, err := s.Export(context.Background(), filter, *format, w)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("urls exported", "exported", n)
+
+	return nil
+}
+
+// Import is the command of the imports: it parses the flags of args, opens the storage of the configuration, imports
+// the file given as argument, or r if the argument is "-", and writes the JSON report to w
+// The imported urls without an owner belong to the cli actor, the logs are written to stderr
+func Import(fs *flag.FlagSet, args []string, r io.Reader, w io.Writer) error {
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "yaml or toml configuration file")
+	format := fs.String("format", entities.FormatJSONL, "format of the imported file, csv or jsonl")
+	conflict := fs.String("conflict", entities.ConflictFail, "policy of the urls whose code exists: skip, overwrite or fail")
+	dryRun := fs.Bool("dry-run", false, "report what would be imported without saving the urls")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected the imported file, or - for stdin, as the only argument")
+	}
+
+	if name := fs.Arg(0); name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return fmt.Errorf("unable to open the imported file: %s", err.Error())
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	s, closeService, err := OpenService(*configFile)
+	if err != nil {
+		return err
+	}
+	defer closeService()
+
+	actor := entities.Actor{Name: entities.CLIActor, RequestId: entities.NewRequestId()}
+	opts := entities.ImportOptions{Format: *format, Conflict: *conflict, DryRun: *dryRun}
+
+	report, err := s.Import(context.Background(), r, opts, actor)
+	if err != nil && !errors.Is(err, ucService.ErrImportConflict) {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if werr := enc.Encode(report); werr != nil {
+		return fmt.Errorf("unable to write the import report: %s", werr.Error())
+	}
+
+	return err
+}
+
+// OpenService loads the configuration and opens its storage and cache, the returned service has no servers, trash
+// purger or webhook deliveries. The cache is used so the overwritten urls aren't served from it anymore
+// The returned function closes the service and the storage
+func OpenService(configFile string) (*ucService.Service, func(), error) {
+	cfg, err := config.Load(configFile, config.Default())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slog.SetDefault(logger)
+
+	if err = storage.CreateDatabase(cfg.Database.Path); err != nil {
+		return nil, nil, err
+	}
+
+	st, err := storage.NewSqliteStorage(cfg.Database.Path, cfg.Database.Workers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st.Logger = logger
+
+	if err = storage.ValidateSchema(st.Handler); err != nil {
+		st.Handler.Close()
+		return nil, nil, err
+	}
+
+	redisCache, err := ucCache.NewRedisCache(cfg.Cache.Host, strconv.Itoa(cfg.Cache.Port), cfg.Cache.Password, cfg.Timeouts.Cache)
+	if err != nil {
+		logger.Warn("unable to connect to redis cache", "error", err.Error())
+	}
+
+	urlRepo := repository.NewUrlRepository(st, redisCache, logger)
+	urlRepo.Timeouts = repository.Timeouts{Storage: cfg.Timeouts.Storage, Cache: cfg.Timeouts.Cache}
+
+	s := ucService.NewService(urlRepo, ucService.CounterOptions{}, cfg.Domain)
+
+	return s, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+
+		if err := s.Close(ctx); err != nil {
+			logger.Error("unable to close the service", "error", err.Error())
+		}
+
+		if err := st.Handler.Close(); err != nil {
+			logger.Error("unable to close the storage", "error", err.Error())
+		}
+	}, nil
+}
+
+// parseTimeFlag returns the RFC 3339 time of a flag, nil if it's not set
+func parseTimeFlag(name, v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -%s (%s): %s", name, v, err.Error())
+	}
+
+	return &t, nil
+}
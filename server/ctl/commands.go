@@ -0,0 +1,473 @@
+package ctl
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/norby7/shortening-service/config"
+	"github.com/norby7/shortening-service/entities"
+	"github.com/norby7/shortening-service/server/app"
+	"github.com/norby7/shortening-service/usecases/repository/storage"
+	ucService "github.com/norby7/shortening-service/usecases/service"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// topUrls is the number of most clicked urls shown by the storage stats
+const topUrls = 10
+
+// errLimit stops listing the urls once the limit is reached
+var errLimit = errors.New("limit reached")
+
+// actor returns the actor of the changes made by the commands
+func actor() entities.Actor {
+	return entities.Actor{Name: entities.CLIActor, RequestId: entities.NewRequestId()}
+}
+
+// urlFlags are the flags of the editable url fields, shared by create and update
+type urlFlags struct {
+	url   entities.Url
+	from  string
+	until string
+	tags  string
+}
+
+// addUrlFlags adds the flags of the editable url fields to the flag set
+func addUrlFlags(fs *flag.FlagSet) *urlFlags {
+	f := &urlFlags{}
+	fs.StringVar(&f.url.Url, "url", "", "original url")
+	fs.IntVar(&f.url.RedirectType, "redirect", 0, "redirect status code, 301, 302, 307 or 308, 302 if it's not set")
+	fs.BoolVar(&f.url.ForwardQuery, "forward-query", false, "forward the query string of the short url to the original url")
+	fs.BoolVar(&f.url.PrefixMode, "prefix", false, "redirect /{code}/rest/of/path with the rest of the path appended")
+	fs.Int64Var(&f.url.MaxClicks, "max-clicks", 0, "number of redirects after which the url stops working, 0 for no limit")
+	fs.StringVar(&f.from, "active-from", "", "RFC 3339 time at which the url starts redirecting")
+	fs.StringVar(&f.until, "active-until", "", "RFC 3339 time at which the url stops redirecting")
+	fs.StringVar(&f.url.FallbackUrl, "fallback", "", "url used for redirects outside of the activation window")
+	fs.StringVar(&f.tags, "tags", "", "comma separated tags")
+
+	return f
+}
+
+// apply copies the fields of the flags that are set to the url
+func (f *urlFlags) apply(fs *flag.FlagSet, u *entities.Url) error {
+	from, err := parseTime("active-from", f.from)
+	if err != nil {
+		return err
+	}
+
+	until, err := parseTime("active-until", f.until)
+	if err != nil {
+		return err
+	}
+
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "url":
+			u.Url = f.url.Url
+		case "redirect":
+			u.RedirectType = f.url.RedirectType
+		case "forward-query":
+			u.ForwardQuery = f.url.ForwardQuery
+		case "prefix":
+			u.PrefixMode = f.url.PrefixMode
+		case "max-clicks":
+			u.MaxClicks = f.url.MaxClicks
+		case "active-from":
+			u.ActiveFrom = from
+		case "active-until":
+			u.ActiveUntil = until
+		case "fallback":
+			u.FallbackUrl = f.url.FallbackUrl
+		case "tags":
+			u.Tags = splitTags(f.tags)
+		}
+	})
+
+	return nil
+}
+
+// lookup returns the url of the code argument, or of the id if no argument is given
+func lookup(ctx context.Context, s *ucService.Service, fs *flag.FlagSet, id int64) (entities.Url, error) {
+	var u entities.Url
+	var err error
+
+	switch {
+	case fs.NArg() == 1 && id == 0:
+		u, err = s.GetByCode(ctx, fs.Arg(0))
+	case fs.NArg() == 0 && id > 0:
+		u, err = s.GetById(ctx, id)
+	default:
+		return entities.Url{}, fmt.Errorf("expected the code of the url as the only argument, or -id")
+	}
+
+	if err != nil {
+		return entities.Url{}, err
+	}
+
+	if u.Id == 0 {
+		return entities.Url{}, ucService.ErrUrlNotFound
+	}
+
+	return u, nil
+}
+
+// create adds a url with the given fields, its owner is the cli actor
+func create(fs *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	o := commonFlags(fs)
+	f := addUrlFlags(fs)
+	code := fs.String("code", "", "short code of 8 characters, generated if it's not set")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	if f.url.Url == "" {
+		return fmt.Errorf("-url is required")
+	}
+
+	u := entities.Url{Code: *code}
+	if err := f.apply(fs, &u); err != nil {
+		return err
+	}
+
+	s, closeService, err := app.OpenService(o.configFile)
+	if err != nil {
+		return err
+	}
+	defer closeService()
+
+	if err = s.Create(context.Background(), &u, actor()); err != nil {
+		return err
+	}
+
+	return writeUrl(stdout, o.output, u)
+}
+
+// get shows a url
+func get(fs *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	o := commonFlags(fs)
+	id := fs.Int64("id", 0, "id of the url, instead of its code")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	s, closeService, err := app.OpenService(o.configFile)
+	if err != nil {
+		return err
+	}
+	defer closeService()
+
+	u, err := lookup(context.Background(), s, fs, *id)
+	if err != nil {
+		return err
+	}
+
+	return writeUrl(stdout, o.output, u)
+}
+
+// list shows the urls matching the filter flags, in the order they were created
+func list(fs *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	o := commonFlags(fs)
+	owner := fs.String("owner", "", "only list the urls of this owner")
+	tag := fs.String("tag", "", "only list the urls with this tag")
+	deleted := fs.Bool("deleted", false, "list the urls in the trash too")
+	limit := fs.Int("limit", 100, "maximum number of listed urls, 0 for no limit")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	s, closeService, err := app.OpenService(o.configFile)
+	if err != nil {
+		return err
+	}
+	defer closeService()
+
+	var urls []entities.Url
+	filter := entities.ExportFilter{Owner: *owner, Tag: *tag, Deleted: *deleted}
+	err = s.Repo.ExportUrls(context.Background(), filter, func(u entities.Url) error {
+		if *limit > 0 && len(urls) == *limit {
+			return errLimit
+		}
+
+		urls = append(urls, u)
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimit) {
+		return fmt.Errorf("unable to list urls: %s", err.Error())
+	}
+
+	return writeUrls(stdout, o.output, urls)
+}
+
+// remove moves a url to the trash
+func remove(fs *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	o := commonFlags(fs)
+	id := fs.Int64("id", 0, "id of the url, instead of its code")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	s, closeService, err := app.OpenService(o.configFile)
+	if err != nil {
+		return err
+	}
+	defer closeService()
+
+	ctx := context.Background()
+	u, err := lookup(ctx, s, fs, *id)
+	if err != nil {
+		return err
+	}
+
+	if err = s.Delete(ctx, u.Id, actor()); err != nil {
+		return err
+	}
+
+	if o.output == OutputJSON {
+		return writeJSON(stdout, map[string]interface{}{"id": u.Id, "code": u.Code, "deleted": true})
+	}
+
+	_, err = fmt.Fprintf(stdout, "url %s (%d) moved to the trash\n", u.Code, u.Id)
+
+	return err
+}
+
+// update changes the fields of a url whose flags are set, the other fields are kept
+func update(fs *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	o := commonFlags(fs)
+	f := addUrlFlags(fs)
+	id := fs.Int64("id", 0, "id of the url, instead of its code")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	s, closeService, err := app.OpenService(o.configFile)
+	if err != nil {
+		return err
+	}
+	defer closeService()
+
+	ctx := context.Background()
+	u, err := lookup(ctx, s, fs, *id)
+	if err != nil {
+		return err
+	}
+
+	if err = f.apply(fs, &u); err != nil {
+		return err
+	}
+
+	if err = s.Replace(ctx, &u, actor()); err != nil {
+		return err
+	}
+
+	return writeUrl(stdout, o.output, u)
+}
+
+// rekey gives a url a new code, the previous code stops redirecting
+func rekey(fs *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	o := commonFlags(fs)
+	id := fs.Int64("id", 0, "id of the url, instead of its code")
+	code := fs.String("code", "", "new short code of 8 characters, generated if it's not set")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	s, closeService, err := app.OpenService(o.configFile)
+	if err != nil {
+		return err
+	}
+	defer closeService()
+
+	ctx := context.Background()
+	u, err := lookup(ctx, s, fs, *id)
+	if err != nil {
+		return err
+	}
+
+	u, err = s.Rekey(ctx, u.Id, *code, actor())
+	if err != nil {
+		return err
+	}
+
+	return writeUrl(stdout, o.output, u)
+}
+
+// urlStats are the clicks of a url and of its variants
+type urlStats struct {
+	Code      string             `json:"code"`
+	Url       string             `json:"url"`
+	Clicks    int64              `json:"clicks"`
+	MaxClicks int64              `json:"maxClicks"`
+	Variants  []entities.Variant `json:"variants,omitempty"`
+}
+
+// storageStats are the totals of the urls in the storage
+type storageStats struct {
+	Urls    int64      `json:"urls"`
+	Active  int64      `json:"active"`
+	Deleted int64      `json:"deleted"`
+	Clicks  int64      `json:"clicks"`
+	Top     []urlStats `json:"top"`
+}
+
+// stats shows the clicks of the url of the code argument or -id, or the totals of the storage and its most clicked
+// urls if neither is given
+func stats(fs *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	o := commonFlags(fs)
+	id := fs.Int64("id", 0, "id of the url, instead of its code")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	s, closeService, err := app.OpenService(o.configFile)
+	if err != nil {
+		return err
+	}
+	defer closeService()
+
+	ctx := context.Background()
+	if fs.NArg() == 0 && *id == 0 {
+		st, err := totals(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		return writeStorageStats(stdout, o.output, st)
+	}
+
+	u, err := lookup(ctx, s, fs, *id)
+	if err != nil {
+		return err
+	}
+
+	us := urlStats{Code: u.Code, Url: u.Url, Clicks: u.Counter, MaxClicks: u.MaxClicks, Variants: u.Variants}
+	if o.output == OutputJSON {
+		return writeJSON(stdout, us)
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tURL\tCLICKS\tMAX CLICKS")
+	fmt.Fprintf(tw, "%s\t%s\t%d\t%d\n", us.Code, us.Url, us.Clicks, us.MaxClicks)
+	if len(us.Variants) > 0 {
+		fmt.Fprintln(tw, "\nVARIANT\tURL\tWEIGHT\tCLICKS")
+		for _, v := range us.Variants {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%d\n", v.Id, v.Url, v.Weight, v.Counter)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// totals counts the urls and clicks of the storage, deleted urls included, and keeps the most clicked urls
+func totals(ctx context.Context, s *ucService.Service) (storageStats, error) {
+	var st storageStats
+	err := s.Repo.ExportUrls(ctx, entities.ExportFilter{Deleted: true}, func(u entities.Url) error {
+		st.Urls++
+		st.Clicks += u.Counter
+		if u.DeletedAt != nil {
+			st.Deleted++
+		} else {
+			st.Active++
+		}
+
+		st.Top = append(st.Top, urlStats{Code: u.Code, Url: u.Url, Clicks: u.Counter, MaxClicks: u.MaxClicks})
+		sort.SliceStable(st.Top, func(i, j int) bool { return st.Top[i].Clicks > st.Top[j].Clicks })
+		if len(st.Top) > topUrls {
+			st.Top = st.Top[:topUrls]
+		}
+
+		return nil
+	})
+	if err != nil {
+		return storageStats{}, fmt.Errorf("unable to count urls: %s", err.Error())
+	}
+
+	if st.Top == nil {
+		st.Top = []urlStats{}
+	}
+
+	return st, nil
+}
+
+// writeStorageStats writes the storage totals as a table or as a JSON object
+func writeStorageStats(w io.Writer, output string, st storageStats) error {
+	if output == OutputJSON {
+		return writeJSON(w, st)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "URLS\tACTIVE\tDELETED\tCLICKS")
+	fmt.Fprintf(tw, "%d\t%d\t%d\t%d\n", st.Urls, st.Active, st.Deleted, st.Clicks)
+	if len(st.Top) > 0 {
+		fmt.Fprintln(tw, "\nTOP CODE\tURL\tCLICKS\t")
+		for _, u := range st.Top {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t\n", u.Code, u.Url, u.Clicks)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// export writes the urls to a csv or jsonl file, see app.Export
+func export(fs *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	return app.Export(fs, args, stdout)
+}
+
+// importUrls adds the urls of a csv or jsonl file, see app.Import
+func importUrls(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error {
+	return app.Import(fs, args, stdin, stdout)
+}
+
+// migrate creates the database if it doesn't exist, applies the pending migrations and shows the schema versions
+// before and after them
+func migrate(fs *flag.FlagSet, args []string, _ io.Reader, stdout io.Writer) error {
+	o := commonFlags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(o.configFile, config.Default())
+	if err != nil {
+		return err
+	}
+
+	if err = storage.CreateDatabase(cfg.Database.Path); err != nil {
+		return err
+	}
+
+	st, err := storage.NewSqliteStorage(cfg.Database.Path, cfg.Database.Workers)
+	if err != nil {
+		return err
+	}
+	defer st.Handler.Close()
+
+	from, err := storage.SchemaVersion(st.Handler)
+	if err != nil {
+		return err
+	}
+
+	if err = storage.ValidateSchema(st.Handler); err != nil {
+		return err
+	}
+
+	to, err := storage.SchemaVersion(st.Handler)
+	if err != nil {
+		return err
+	}
+
+	if o.output == OutputJSON {
+		return writeJSON(stdout, map[string]int{"from": from, "to": to})
+	}
+
+	if from == to {
+		_, err = fmt.Fprintf(stdout, "schema up to date, version %d\n", to)
+		return err
+	}
+
+	_, err = fmt.Fprintf(stdout, "schema migrated from version %d to %d\n", from, to)
+
+	return err
+}
@@ -0,0 +1,191 @@
+package ctl
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/norby7/shortening-service/entities"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats of the commands
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+)
+
+// Usage describes the commands
+const Usage = `usage: shortenerctl <command> [flags]
+
+commands:
+  create   add a short url
+  get      show a url, by code or -id
+  list     list the urls, filtered by -owner, -tag and -deleted
+  delete   move a url to the trash, by code or -id
+  update   change the set fields of a url, by code or -id
+  rekey    give a url a new code, generated unless -code is set
+  stats    show the clicks of a url, or the totals of the storage without a code
+  export   write the urls to a csv or jsonl file
+  import   add the urls of a csv or jsonl file
+  migrate  create the database and apply the pending schema migrations
+
+every command reads the configuration of the servers, from the environment and the -config file, the commands other
+than export and import accept -output table or json, run "shortenerctl <command> -h" for the flags of a command
+`
+
+// ErrUsage is returned when no known command is given
+var ErrUsage = errors.New("unknown command")
+
+// command runs a command with its flag set and arguments
+type command func(fs *flag.FlagSet, args []string, stdin io.Reader, stdout io.Writer) error
+
+// commands are the commands by name
+var commands = map[string]command{
+	"create":  create,
+	"get":     get,
+	"list":    list,
+	"delete":  remove,
+	"update":  update,
+	"rekey":   rekey,
+	"stats":   stats,
+	"export":  export,
+	"import":  importUrls,
+	"migrate": migrate,
+}
+
+// Run runs the command named by the first argument with the rest of the arguments, the command output is written to
+// stdout and the logs to stderr. The urls are changed directly in the storage so no webhook event is sent for them
+func Run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return ErrUsage
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+
+	return cmd(fs, args[1:], stdin, stdout)
+}
+
+// options are the flags shared by the commands
+type options struct {
+	configFile string
+	output     string
+}
+
+// commonFlags adds the -config and -output flags to the flag set
+func commonFlags(fs *flag.FlagSet) *options {
+	o := &options{}
+	fs.StringVar(&o.configFile, "config", os.Getenv("CONFIG_FILE"), "yaml or toml configuration file")
+	fs.StringVar(&o.output, "output", OutputTable, "output format, table or json")
+
+	return o
+}
+
+// parse parses the arguments, the flags can come before or after the code argument, and checks the output format
+// The positional arguments are left in the flag set
+func (o *options) parse(fs *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		if fs.NArg() == 0 {
+			break
+		}
+
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	// the flags are all parsed, the flag set only keeps the positional arguments
+	if err := fs.Parse(append([]string{"--"}, positional...)); err != nil {
+		return err
+	}
+
+	if o.output != OutputTable && o.output != OutputJSON {
+		return fmt.Errorf("invalid -output (%s), expected table or json", o.output)
+	}
+
+	return nil
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("unable to write the output: %s", err.Error())
+	}
+
+	return nil
+}
+
+// writeUrls writes the urls as a table or as a JSON array
+func writeUrls(w io.Writer, output string, urls []entities.Url) error {
+	if output == OutputJSON {
+		if urls == nil {
+			urls = []entities.Url{}
+		}
+
+		return writeJSON(w, urls)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCODE\tURL\tCLICKS\tOWNER\tTAGS\tCREATED\tDELETED")
+	for _, u := range urls {
+		deleted := "-"
+		if u.DeletedAt != nil {
+			deleted = u.DeletedAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", u.Id, u.Code, u.Url, u.Counter, u.Owner,
+			strings.Join(u.Tags, ","), u.CreatedAt.Format(time.RFC3339), deleted)
+	}
+
+	return tw.Flush()
+}
+
+// writeUrl writes a url as a table or as a JSON object
+func writeUrl(w io.Writer, output string, u entities.Url) error {
+	if output == OutputJSON {
+		return writeJSON(w, u)
+	}
+
+	return writeUrls(w, output, []entities.Url{u})
+}
+
+// splitTags returns the tags of a comma separated list, nil for an empty list
+func splitTags(v string) []string {
+	var tags []string
+	for _, t := range strings.Split(v, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+
+	return tags
+}
+
+// parseTime returns the RFC 3339 time of a flag, nil if it's empty
+func parseTime(name, v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -%s (%s): %s", name, v, err.Error())
+	}
+
+	return &t, nil
+}
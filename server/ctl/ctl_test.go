@@ -0,0 +1,160 @@
+package ctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/norby7/shortening-service/entities"
+	ucService "github.com/norby7/shortening-service/usecases/service"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRunUsage(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "no command"},
+		{name: "unknown command", args: []string{"purge"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := Run(tc.args, nil, &bytes.Buffer{}); !errors.Is(err, ErrUsage) {
+				t.Errorf("expected a usage error, got (%v)", err)
+			}
+		})
+	}
+}
+
+func TestSplitTags(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{input: ""},
+		{input: "newsletter", expected: []string{"newsletter"}},
+		{input: " newsletter, launch ,,", expected: []string{"newsletter", "launch"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			if got := splitTags(tc.input); !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected (%v), got (%v)", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	// the schema and migrations are read relative to the repository root
+	wd, _ := os.Getwd()
+	if err := os.Chdir("../.."); err != nil {
+		t.Fatalf("unable to change directory: %s", err.Error())
+	}
+	defer os.Chdir(wd)
+
+	t.Setenv("DB_PATH", filepath.Join(t.TempDir(), "urls.db"))
+	t.Setenv("REDIS_HOSTNAME", "127.0.0.1")
+	t.Setenv("REDIS_PORT", "1")
+	t.Setenv("CACHE_TIMEOUT", "100ms")
+	t.Setenv("LOG_LEVEL", "error")
+
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := Run(args, strings.NewReader(""), &out)
+		return out.String(), err
+	}
+
+	runUrl := func(args ...string) entities.Url {
+		t.Helper()
+
+		out, err := run(append(args, "-output", "json")...)
+		if err != nil {
+			t.Fatalf("unable to run %s: %s", args[0], err.Error())
+		}
+
+		var u entities.Url
+		if err = json.Unmarshal([]byte(out), &u); err != nil {
+			t.Fatalf("unable to decode the url of %s (%s): %s", args[0], out, err.Error())
+		}
+
+		return u
+	}
+
+	out, err := run("migrate", "-output", "json")
+	if err != nil || !strings.Contains(out, `"to"`) {
+		t.Fatalf("unable to migrate: (%s) (%v)", out, err)
+	}
+
+	if out, err = run("migrate"); err != nil || !strings.HasPrefix(out, "schema up to date") {
+		t.Errorf("expected an up to date schema, got (%s) (%v)", out, err)
+	}
+
+	u := runUrl("create", "-url", "https://google.com", "-code", "84gfj4i9", "-tags", "newsletter,launch")
+	if u.Id == 0 || u.Owner != entities.CLIActor || len(u.Tags) != 2 {
+		t.Fatalf("expected a url of the cli with 2 tags, got (%+v)", u)
+	}
+
+	runUrl("create", "-url", "https://example.com", "-max-clicks", "5")
+
+	if _, err = run("create", "-code", "a1b2c3d4"); err == nil {
+		t.Errorf("expected a missing -url error")
+	}
+
+	if _, err = run("create", "-url", "https://google.com/other", "-code", "84gfj4i9"); !errors.Is(err, ucService.ErrCodeAlreadyExists) {
+		t.Errorf("expected a code exists error, got (%v)", err)
+	}
+
+	if got := runUrl("get", "84gfj4i9"); got.Id != u.Id {
+		t.Errorf("expected url (%d), got (%+v)", u.Id, got)
+	}
+
+	if _, err = run("get", "notfound"); !errors.Is(err, ucService.ErrUrlNotFound) {
+		t.Errorf("expected a not found error, got (%v)", err)
+	}
+
+	out, err = run("list", "-tag", "launch")
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); err != nil || len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") {
+		t.Errorf("expected the header and the tagged url, got (%s) (%v)", out, err)
+	}
+
+	out, err = run("list", "-limit", "1", "-output", "json")
+	var urls []entities.Url
+	if err != nil || json.Unmarshal([]byte(out), &urls) != nil || len(urls) != 1 {
+		t.Errorf("expected 1 listed url, got (%s) (%v)", out, err)
+	}
+
+	// only the set fields change
+	updated := runUrl("update", "84gfj4i9", "-redirect", "301", "-tags", "")
+	if updated.RedirectType != entities.RedirectMovedPermanently || updated.Url != u.Url || len(updated.Tags) != 0 {
+		t.Errorf("expected the redirect type and tags to change, got (%+v)", updated)
+	}
+
+	rekeyed := runUrl("rekey", "-id", "1", "-code", "n3wc0d3x")
+	if rekeyed.Code != "n3wc0d3x" || !strings.HasSuffix(rekeyed.ShortUrl, "/n3wc0d3x") {
+		t.Errorf("expected the new code, got (%+v)", rekeyed)
+	}
+
+	if _, err = run("get", "84gfj4i9"); !errors.Is(err, ucService.ErrUrlNotFound) {
+		t.Errorf("expected the previous code to be gone, got (%v)", err)
+	}
+
+	if out, err = run("delete", "n3wc0d3x"); err != nil || !strings.Contains(out, "moved to the trash") {
+		t.Errorf("unable to delete: (%s) (%v)", out, err)
+	}
+
+	out, err = run("stats", "-output", "json")
+	var st storageStats
+	if err != nil || json.Unmarshal([]byte(out), &st) != nil || st.Urls != 2 || st.Active != 1 || st.Deleted != 1 || len(st.Top) != 2 {
+		t.Errorf("expected 2 urls, 1 of them deleted, got (%s) (%v)", out, err)
+	}
+
+	if _, err = run("list", "-output", "yaml"); err == nil {
+		t.Errorf("expected an invalid output error")
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/norby7/shortening-service/server/app"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// usage describes the commands
+const usage = `usage: shortener <command> [flags]
+
+commands:
+  serve    serve the http and grpc apis over one shared service, run "shortener serve -h" for the flags
+  export   write the urls of the storage to a csv or jsonl file, run "shortener export -h" for the flags
+  import   add the urls of a csv or jsonl file to the storage, run "shortener import -h" for the flags
+`
+
+func main() {
+	// set the random seed
+	rand.Seed(time.Now().UnixNano())
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "serve":
+		l := app.Listeners{}
+		fs := flag.NewFlagSet("serve", flag.ExitOnError)
+		fs.BoolVar(&l.HTTP, "http", true, "serve the http api")
+		fs.BoolVar(&l.GRPC, "grpc", true, "serve the grpc api, on the http port when GRPC_MULTIPLEX is enabled")
+
+		err := app.Serve(fs, os.Args[2:], "shortening-service", &l, os.Stdout)
+		if err != nil {
+			log.Fatalln(err.Error())
+		}
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ExitOnError)
+		if err := app.Export(fs, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalln(err.Error())
+		}
+	case "import":
+		fs := flag.NewFlagSet("import", flag.ExitOnError)
+		if err := app.Import(fs, os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			log.Fatalln(err.Error())
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/norby7/shortening-service/server/ctl"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+)
+
+func main() {
+	// set the random seed
+	rand.Seed(time.Now().UnixNano())
+
+	err := ctl.Run(os.Args[1:], os.Stdin, os.Stdout)
+	switch {
+	case err == nil:
+	case errors.Is(err, ctl.ErrUsage):
+		fmt.Fprint(os.Stderr, ctl.Usage)
+		os.Exit(2)
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	default:
+		log.Fatalln(err.Error())
+	}
+}
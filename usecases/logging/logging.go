@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// log formats
+const (
+	// FormatJSON writes every record as a JSON object
+	FormatJSON = "json"
+	// FormatText writes every record as key=value pairs
+	FormatText = "text"
+)
+
+// RequestIdKey is the attribute key of the request id added to the records logged with a request context
+const RequestIdKey = "request_id"
+
+// TraceIdKey is the attribute key of the trace id added to the records logged with a traced context
+const TraceIdKey = "trace_id"
+
+// requestIdKey is the context key of the request id
+type requestIdKey struct{}
+
+// New returns a logger that writes the records in the given format, FormatJSON if empty,
+// at the given level or above, "debug", "info", "warn" or "error", "info" if empty
+// The records logged with a context that has a request id, or a span, carry it in the RequestIdKey, or TraceIdKey, attribute
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	case FormatText:
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format (%s), expected %s or %s", format, FormatJSON, FormatText)
+	}
+
+	return slog.New(contextHandler{h}), nil
+}
+
+// ParseLevel returns the slog level with the given name, slog.LevelInfo if the name is empty
+func ParseLevel(level string) (slog.Level, error) {
+	if level == "" {
+		return slog.LevelInfo, nil
+	}
+
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("invalid log level (%s): %s", level, err.Error())
+	}
+
+	return lvl, nil
+}
+
+// WithRequestId returns a copy of the context that carries the given request id
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestId returns the request id of the context, or an empty string if it has none
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request id and the trace id of the record context to the record
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the request id and the trace id of the context to the record and passes it to the wrapped handler
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestId(ctx); id != "" {
+		r.AddAttrs(slog.String(RequestIdKey, id))
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String(TraceIdKey, sc.TraceID().String()))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs returns a contextHandler that wraps the handler with the given attributes
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a contextHandler that wraps the handler with the given group
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		name          string
+		format        string
+		level         string
+		expectedError bool
+	}{
+		{name: "defaults"},
+		{name: "json debug", format: "json", level: "debug"},
+		{name: "text warn", format: "TEXT", level: "WARN"},
+		{name: "invalid format", format: "xml", expectedError: true},
+		{name: "invalid level", level: "verbose", expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(&bytes.Buffer{}, tc.format, tc.level)
+			if (err != nil) != tc.expectedError {
+				t.Errorf("expected error (%t), got (%v)", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestRequestIdAttribute(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, FormatJSON, "info")
+	if err != nil {
+		t.Fatalf("unable to create logger: %s", err.Error())
+	}
+
+	l.With("component", "test").InfoContext(WithRequestId(context.Background(), "req-1"), "with request id")
+	l.InfoContext(context.Background(), "without request id")
+	l.DebugContext(context.Background(), "below level")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected (2) records, got (%d): %s", len(lines), buf.String())
+	}
+
+	var record map[string]interface{}
+	if err = json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("unable to decode record: %s", err.Error())
+	}
+
+	if record[RequestIdKey] != "req-1" || record["component"] != "test" || record["level"] != "INFO" {
+		t.Errorf("expected request id (req-1) and component (test) at level INFO, got (%v)", record)
+	}
+
+	if strings.Contains(lines[1], RequestIdKey) {
+		t.Errorf("expected no request id, got (%s)", lines[1])
+	}
+}
+
+func TestTraceIdAttribute(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(&buf, FormatJSON, "info")
+	if err != nil {
+		t.Fatalf("unable to create logger: %s", err.Error())
+	}
+
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceId,
+		SpanID:  spanId,
+	}))
+
+	l.InfoContext(ctx, "with trace id")
+	l.InfoContext(context.Background(), "without trace id")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected (2) records, got (%d): %s", len(lines), buf.String())
+	}
+
+	var record map[string]interface{}
+	if err = json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("unable to decode record: %s", err.Error())
+	}
+
+	if record[TraceIdKey] != traceId.String() {
+		t.Errorf("expected trace id (%s), got (%v)", traceId, record)
+	}
+
+	if strings.Contains(lines[1], TraceIdKey) {
+		t.Errorf("expected no trace id, got (%s)", lines[1])
+	}
+}
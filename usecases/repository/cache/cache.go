@@ -1,6 +1,12 @@
 package cache
 
+import (
+	"context"
+	"time"
+)
+
 type Cache interface{
-	SetShortUrl(string, string) error
-	GetShortUrl(string) (string, error)
+	SetShortUrl(context.Context, string, string, time.Duration) error
+	GetShortUrl(context.Context, string) (string, error)
+	DeleteShortUrl(context.Context, string) error
 }
@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"context"
+	"github.com/norby7/shortening-service/entities"
+)
+
+// Counters counts clicks outside the storage until they are moved into it by batches
+type Counters interface {
+	// Increment adds a click to the counters
+	Increment(context.Context, entities.Click) error
+	// Claim moves the counted clicks into a batch that keeps the given id, or returns the batch that wasn't acknowledged yet
+	// It returns an empty id if there are no clicks to move
+	Claim(context.Context, string) (string, map[entities.Click]int64, error)
+	// Ack removes the batch with the given id once its clicks are saved into the storage
+	Ack(context.Context, string) error
+	// Pending returns the clicks of a code that are not acknowledged yet
+	Pending(context.Context, string) (PendingClicks, error)
+}
+
+// PendingClicks are the clicks of a code that are not acknowledged yet, by variant id, 0 for the url clicks without a variant
+type PendingClicks struct {
+	// Pending are the clicks that are not claimed by a batch yet
+	Pending map[int64]int64
+	// BatchId is the id of the claimed batch, empty if no batch is claimed
+	BatchId string
+	// Claimed are the clicks of the code in the claimed batch, the storage can save them before the batch is acknowledged
+	Claimed map[int64]int64
+}
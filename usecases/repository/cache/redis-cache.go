@@ -1,8 +1,10 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-redis/redis"
+	"time"
 )
 
 type RedisCache struct {
@@ -11,11 +13,15 @@ type RedisCache struct {
 }
 
 // NewRedisCache creates a new redis client and returns a new *RedisCache that contains the client
-func NewRedisCache(addr, port, pass string) (*RedisCache, error) {
+// The timeout bounds the dial, read and write of every command, 0 keeps the redis client defaults
+func NewRedisCache(addr, port, pass string, timeout time.Duration) (*RedisCache, error) {
 	client := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", addr, port),
-		Password: pass,
-		DB:       0,
+		Addr:         fmt.Sprintf("%s:%s", addr, port),
+		Password:     pass,
+		DB:           0,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
 	})
 
 	_, err := client.Ping().Result()
@@ -27,14 +33,20 @@ func NewRedisCache(addr, port, pass string) (*RedisCache, error) {
 }
 
 // SetShortUrl saves a short url code and url into the cache
-func (c *RedisCache) SetShortUrl(code, url string) error {
+// The entry expires after the given ttl, a ttl of 0 keeps it until it is deleted
+func (c *RedisCache) SetShortUrl(ctx context.Context, code, url string, ttl time.Duration) error {
 	// if cache is not active
 	if !c.Active {
 		return nil
 	}
 
-	err := c.Client.Set(code, url, 0).Err()
+	client, err := withContext(ctx, c.Client)
 	if err != nil {
+		return err
+	}
+
+	err = client.Set(code, url, ttl).Err()
+	if err != nil && ctx.Err() == nil {
 		// disable cache
 		c.Active = false
 	}
@@ -43,17 +55,54 @@ func (c *RedisCache) SetShortUrl(code, url string) error {
 }
 
 // GetShortUrl fetches the url with the given code from the cache
-func (c *RedisCache) GetShortUrl(code string) (string, error) {
+func (c *RedisCache) GetShortUrl(ctx context.Context, code string) (string, error) {
 	// if cache is not active
 	if !c.Active {
 		return "", nil
 	}
 
-	url, err := c.Client.Get(code).Result()
+	client, err := withContext(ctx, c.Client)
 	if err != nil {
+		return "", err
+	}
+
+	url, err := client.Get(code).Result()
+	// a missing or expired code is not a cache failure
+	if err != nil && err != redis.Nil && ctx.Err() == nil {
 		// disable cache
 		c.Active = false
 	}
 
 	return url, err
 }
+
+// DeleteShortUrl removes the short url code from the cache
+func (c *RedisCache) DeleteShortUrl(ctx context.Context, code string) error {
+	// if cache is not active
+	if !c.Active {
+		return nil
+	}
+
+	client, err := withContext(ctx, c.Client)
+	if err != nil {
+		return err
+	}
+
+	err = client.Del(code).Err()
+	if err != nil && ctx.Err() == nil {
+		// disable cache
+		c.Active = false
+	}
+
+	return err
+}
+
+// withContext returns the client bound to the context, or the context error if the context is already done
+// A command that fails because its context ended doesn't disable the cache, the failure belongs to the caller
+func withContext(ctx context.Context, client *redis.Client) (*redis.Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return client.WithContext(ctx), nil
+}
@@ -1,10 +1,12 @@
 package cache
 
 import (
+	"context"
 	"github.com/alicebob/miniredis"
 	"log"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestValidNewRedisCache(t *testing.T) {
@@ -15,14 +17,14 @@ func TestValidNewRedisCache(t *testing.T) {
 
 	srvAddr := strings.Split(mr.Addr(), ":")
 
-	_, err = NewRedisCache(srvAddr[0], srvAddr[1], "")
+	_, err = NewRedisCache(srvAddr[0], srvAddr[1], "", 0)
 	if err != nil {
 		t.Errorf("unable to connect to miniredis server: %s", err.Error())
 	}
 }
 
 func TestInvalidNewRedisCache(t *testing.T) {
-	_, err := NewRedisCache("localhost", "1", "")
+	_, err := NewRedisCache("localhost", "1", "", 0)
 	if err == nil {
 		t.Errorf("expected error connecting to redis server: %s", err.Error())
 	}
@@ -36,17 +38,17 @@ func TestSetGetShortUrl(t *testing.T) {
 
 	srvAddr := strings.Split(mr.Addr(), ":")
 
-	client, err := NewRedisCache(srvAddr[0], srvAddr[1], "")
+	client, err := NewRedisCache(srvAddr[0], srvAddr[1], "", 0)
 	if err != nil {
 		t.Errorf("unable to connect to miniredis server: %s", err.Error())
 	}
 
-	err = client.SetShortUrl("test", "www.test.com")
+	err = client.SetShortUrl(context.Background(), "test", "www.test.com", 0)
 	if err != nil {
 		t.Errorf("unable to set short url: %s", err.Error())
 	}
 
-	_, err = client.GetShortUrl("test")
+	_, err = client.GetShortUrl(context.Background(), "test")
 	if err != nil {
 		t.Errorf("unable to get short url: %s", err.Error())
 	}
@@ -60,29 +62,90 @@ func TestGetShortUrlError(t *testing.T) {
 
 	srvAddr := strings.Split(mr.Addr(), ":")
 
-	client, err := NewRedisCache(srvAddr[0], srvAddr[1], "")
+	client, err := NewRedisCache(srvAddr[0], srvAddr[1], "", 0)
 	if err != nil {
 		t.Errorf("unable to connect to miniredis server: %s", err.Error())
 	}
 
-	_, err = client.GetShortUrl("test1")
+	_, err = client.GetShortUrl(context.Background(), "test1")
 	if err == nil {
 		t.Errorf("expected error getting short url, got nil")
 	}
+
+	if !client.Active {
+		t.Errorf("expected cache to stay active after a missing code")
+	}
+}
+
+func TestSetShortUrlTTL(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	srvAddr := strings.Split(mr.Addr(), ":")
+
+	client, err := NewRedisCache(srvAddr[0], srvAddr[1], "", 0)
+	if err != nil {
+		t.Errorf("unable to connect to miniredis server: %s", err.Error())
+	}
+
+	err = client.SetShortUrl(context.Background(), "test", "www.test.com", time.Minute)
+	if err != nil {
+		t.Errorf("unable to set short url: %s", err.Error())
+	}
+
+	if ttl := mr.TTL("test"); ttl != time.Minute {
+		t.Errorf("expected ttl (%v), got (%v)", time.Minute, ttl)
+	}
+
+	mr.FastForward(time.Minute)
+
+	if mr.Exists("test") {
+		t.Errorf("expected short url to expire")
+	}
+}
+
+func TestDeleteShortUrl(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	srvAddr := strings.Split(mr.Addr(), ":")
+
+	client, err := NewRedisCache(srvAddr[0], srvAddr[1], "", 0)
+	if err != nil {
+		t.Errorf("unable to connect to miniredis server: %s", err.Error())
+	}
+
+	err = client.SetShortUrl(context.Background(), "test", "www.test.com", 0)
+	if err != nil {
+		t.Errorf("unable to set short url: %s", err.Error())
+	}
+
+	err = client.DeleteShortUrl(context.Background(), "test")
+	if err != nil {
+		t.Errorf("unable to delete short url: %s", err.Error())
+	}
+
+	if mr.Exists("test") {
+		t.Errorf("expected short url to be removed from the cache")
+	}
 }
 
 func TestCacheDisabled(t *testing.T) {
-	redisCache, err := NewRedisCache("", "", "")
+	redisCache, err := NewRedisCache("", "", "", 0)
 	if err == nil {
 		t.Error("expected error connecting to redis cache, got nil")
 	}
 
-	err = redisCache.SetShortUrl("code", "url")
+	err = redisCache.SetShortUrl(context.Background(), "code", "url", 0)
 	if err != nil {
 		t.Errorf("expected no error, got (%s)", err.Error())
 	}
 
-	url, err := redisCache.GetShortUrl("code")
+	url, err := redisCache.GetShortUrl(context.Background(), "code")
 	if url != "" {
 		t.Errorf("expected empty url, got (%s)", url)
 	}
@@ -90,4 +153,42 @@ func TestCacheDisabled(t *testing.T) {
 	if err != nil {
 		t.Errorf("expected no error, got (%s)", err.Error())
 	}
+
+	err = redisCache.DeleteShortUrl(context.Background(), "code")
+	if err != nil {
+		t.Errorf("expected no error, got (%s)", err.Error())
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	srvAddr := strings.Split(mr.Addr(), ":")
+
+	client, err := NewRedisCache(srvAddr[0], srvAddr[1], "", 0)
+	if err != nil {
+		t.Errorf("unable to connect to miniredis server: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err = client.SetShortUrl(ctx, "test", "www.test.com", 0); err != context.Canceled {
+		t.Errorf("expected error (%v), got (%v)", context.Canceled, err)
+	}
+
+	if _, err = client.GetShortUrl(ctx, "test"); err != context.Canceled {
+		t.Errorf("expected error (%v), got (%v)", context.Canceled, err)
+	}
+
+	if mr.Exists("test") {
+		t.Errorf("expected short url not to be saved with a canceled context")
+	}
+
+	if !client.Active {
+		t.Errorf("expected cache to stay active after a canceled context")
+	}
 }
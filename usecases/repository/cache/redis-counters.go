@@ -0,0 +1,233 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis"
+	"github.com/norby7/shortening-service/entities"
+	"strconv"
+)
+
+// redis keys of the counters, the codes only contain letters and digits so they can't collide with the fixed keys
+const (
+	// hash of the clicks of a code that are not claimed yet, by variant id
+	pendingCountersPrefix = "counters:pending:"
+	// hash of the claimed clicks of a code, by variant id
+	claimedCountersPrefix = "counters:claimed:"
+	// set of the codes that have pending clicks
+	dirtyCountersKey = "counters:dirty"
+	// set of the codes that have claimed clicks
+	claimedCountersKey = "counters:claimed"
+	// id of the claimed batch
+	counterBatchKey = "counters:batch"
+)
+
+// claimCounters moves the pending clicks into the claimed batch if no batch is claimed,
+// and returns the batch id followed by a {code, {variant id, clicks, ...}} list for every claimed code
+var claimCounters = redis.NewScript(`
+local id = redis.call('GET', KEYS[1])
+if not id then
+	local codes = redis.call('SMEMBERS', KEYS[2])
+	if #codes == 0 then
+		return {}
+	end
+
+	id = ARGV[1]
+	for _, code in ipairs(codes) do
+		local fields = redis.call('HGETALL', ARGV[2] .. code)
+		for i = 1, #fields, 2 do
+			redis.call('HINCRBY', ARGV[3] .. code, fields[i], fields[i + 1])
+		end
+
+		redis.call('DEL', ARGV[2] .. code)
+		redis.call('SADD', KEYS[3], code)
+	end
+
+	redis.call('DEL', KEYS[2])
+	redis.call('SET', KEYS[1], id)
+end
+
+local batch = {id}
+for _, code in ipairs(redis.call('SMEMBERS', KEYS[3])) do
+	table.insert(batch, {code, redis.call('HGETALL', ARGV[3] .. code)})
+end
+
+return batch
+`)
+
+// ackCounters removes the claimed batch if it has the given id
+var ackCounters = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+
+for _, code in ipairs(redis.call('SMEMBERS', KEYS[2])) do
+	redis.call('DEL', ARGV[2] .. code)
+end
+
+redis.call('DEL', KEYS[1], KEYS[2])
+return 1
+`)
+
+// RedisCounters counts the clicks with atomic redis increments
+// The clicks are moved into the storage by batches: a batch is claimed by a single script, so no click is counted
+// by two batches, and it is kept with the same id until it is acknowledged, so a batch that failed is retried
+type RedisCounters struct {
+	Client *redis.Client
+}
+
+// NewRedisCounters returns a new *RedisCounters that uses the given redis client
+func NewRedisCounters(c *redis.Client) *RedisCounters {
+	return &RedisCounters{Client: c}
+}
+
+// Increment adds a click to the pending clicks of its code and variant
+func (c *RedisCounters) Increment(ctx context.Context, click entities.Click) error {
+	client, err := withContext(ctx, c.Client)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(pendingCountersPrefix+click.Code, strconv.FormatInt(click.VariantId, 10), 1)
+		pipe.SAdd(dirtyCountersKey, click.Code)
+		return nil
+	})
+
+	return err
+}
+
+// Claim moves the pending clicks into a batch with the given id and returns it
+// If a batch was claimed but not acknowledged it is returned instead, with its own id and without the new clicks
+func (c *RedisCounters) Claim(ctx context.Context, id string) (string, map[entities.Click]int64, error) {
+	client, err := withContext(ctx, c.Client)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to claim the counters: %s", err.Error())
+	}
+
+	res, err := claimCounters.Run(client, []string{counterBatchKey, dirtyCountersKey, claimedCountersKey}, id, pendingCountersPrefix, claimedCountersPrefix).Result()
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to claim the counters: %s", err.Error())
+	}
+
+	batch, ok := res.([]interface{})
+	if !ok || len(batch) == 0 {
+		return "", nil, nil
+	}
+
+	batchId, ok := batch[0].(string)
+	if !ok {
+		return "", nil, fmt.Errorf("unable to claim the counters: invalid batch id %v", batch[0])
+	}
+
+	clicks := make(map[entities.Click]int64)
+	for _, item := range batch[1:] {
+		entry, ok := item.([]interface{})
+		if !ok || len(entry) != 2 {
+			return "", nil, fmt.Errorf("unable to claim the counters: invalid batch entry %v", item)
+		}
+
+		code, _ := entry[0].(string)
+		fields, _ := entry[1].([]interface{})
+		variants, err := parseCounterFields(fields)
+		if err != nil {
+			return "", nil, fmt.Errorf("unable to claim the counters of code (%s): %s", code, err.Error())
+		}
+
+		for variantId, n := range variants {
+			clicks[entities.Click{Code: code, VariantId: variantId}] += n
+		}
+	}
+
+	return batchId, clicks, nil
+}
+
+// Ack removes the claimed batch with the given id, the next Claim moves the pending clicks into a new batch
+func (c *RedisCounters) Ack(ctx context.Context, id string) error {
+	client, err := withContext(ctx, c.Client)
+	if err == nil {
+		err = ackCounters.Run(client, []string{counterBatchKey, claimedCountersKey}, id, claimedCountersPrefix).Err()
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to acknowledge the counters batch (%s): %s", id, err.Error())
+	}
+
+	return nil
+}
+
+// Pending returns the pending clicks of a code and its clicks in the claimed batch, read together
+func (c *RedisCounters) Pending(ctx context.Context, code string) (PendingClicks, error) {
+	client, err := withContext(ctx, c.Client)
+	if err != nil {
+		return PendingClicks{}, fmt.Errorf("unable to get the pending counters of code (%s): %s", code, err.Error())
+	}
+
+	var pending, claimed *redis.StringStringMapCmd
+	var batch *redis.StringCmd
+	_, err = client.TxPipelined(func(pipe redis.Pipeliner) error {
+		pending = pipe.HGetAll(pendingCountersPrefix + code)
+		claimed = pipe.HGetAll(claimedCountersPrefix + code)
+		batch = pipe.Get(counterBatchKey)
+		return nil
+	})
+
+	// no batch is claimed
+	if err != nil && err != redis.Nil {
+		return PendingClicks{}, fmt.Errorf("unable to get the pending counters of code (%s): %s", code, err.Error())
+	}
+
+	result := PendingClicks{BatchId: batch.Val()}
+	if result.Pending, err = parseCounterHash(pending.Val()); err != nil {
+		return PendingClicks{}, fmt.Errorf("invalid pending counters of code (%s): %s", code, err.Error())
+	}
+
+	if result.Claimed, err = parseCounterHash(claimed.Val()); err != nil {
+		return PendingClicks{}, fmt.Errorf("invalid claimed counters of code (%s): %s", code, err.Error())
+	}
+
+	return result, nil
+}
+
+// parseCounterHash converts a redis {variant id: clicks} hash into a map of clicks by variant id
+func parseCounterHash(fields map[string]string) (map[int64]int64, error) {
+	result := make(map[int64]int64, len(fields))
+	for field, value := range fields {
+		variantId, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid variant id (%s)", field)
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clicks (%s)", value)
+		}
+
+		result[variantId] += n
+	}
+
+	return result, nil
+}
+
+// parseCounterFields converts a redis {variant id, clicks, ...} hash reply into a map of clicks by variant id
+func parseCounterFields(fields []interface{}) (map[int64]int64, error) {
+	result := make(map[int64]int64)
+	for i := 0; i+1 < len(fields); i += 2 {
+		field, _ := fields[i].(string)
+		value, _ := fields[i+1].(string)
+
+		variantId, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid variant id (%v)", fields[i])
+		}
+
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid clicks (%v)", fields[i+1])
+		}
+
+		result[variantId] += n
+	}
+
+	return result, nil
+}
@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+	"github.com/norby7/shortening-service/entities"
+	"log"
+	"testing"
+)
+
+func newTestRedisCounters() (*miniredis.Miniredis, *RedisCounters) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	return mr, NewRedisCounters(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+}
+
+func TestIncrementPending(t *testing.T) {
+	mr, counters := newTestRedisCounters()
+	defer mr.Close()
+
+	clicks := []entities.Click{
+		{Code: "84gfj4i9"},
+		{Code: "84gfj4i9"},
+		{Code: "84gfj4i9", VariantId: 10},
+		{Code: "d3l3t3d0"},
+	}
+	for _, c := range clicks {
+		if err := counters.Increment(context.Background(), c); err != nil {
+			t.Fatalf("unable to increment counter: %s", err.Error())
+		}
+	}
+
+	pending, err := counters.Pending(context.Background(), "84gfj4i9")
+	if err != nil {
+		t.Fatalf("unable to get pending counters: %s", err.Error())
+	}
+
+	if pending.Pending[0] != 2 || pending.Pending[10] != 1 || pending.BatchId != "" || len(pending.Claimed) != 0 {
+		t.Errorf("expected (2) url clicks and (1) variant click, got (%+v)", pending)
+	}
+
+	pending, err = counters.Pending(context.Background(), "5f4r3e2w")
+	if err != nil {
+		t.Fatalf("unable to get pending counters: %s", err.Error())
+	}
+
+	if len(pending.Pending) != 0 {
+		t.Errorf("expected no pending clicks, got (%+v)", pending)
+	}
+}
+
+func TestClaimAck(t *testing.T) {
+	mr, counters := newTestRedisCounters()
+	defer mr.Close()
+
+	id, clicks, err := counters.Claim(context.Background(), "batch1")
+	if err != nil {
+		t.Fatalf("unable to claim counters: %s", err.Error())
+	}
+
+	if id != "" || len(clicks) != 0 {
+		t.Errorf("expected no batch without clicks, got (%s) (%v)", id, clicks)
+	}
+
+	counters.Increment(context.Background(), entities.Click{Code: "84gfj4i9"})
+	counters.Increment(context.Background(), entities.Click{Code: "84gfj4i9", VariantId: 10})
+
+	id, clicks, err = counters.Claim(context.Background(), "batch1")
+	if err != nil {
+		t.Fatalf("unable to claim counters: %s", err.Error())
+	}
+
+	if id != "batch1" || clicks[entities.Click{Code: "84gfj4i9"}] != 1 || clicks[entities.Click{Code: "84gfj4i9", VariantId: 10}] != 1 {
+		t.Errorf("expected batch (batch1) with the two clicks, got (%s) (%v)", id, clicks)
+	}
+
+	// new clicks are not added to the claimed batch but are pending, the claimed clicks are returned with their batch
+	counters.Increment(context.Background(), entities.Click{Code: "84gfj4i9"})
+
+	pending, _ := counters.Pending(context.Background(), "84gfj4i9")
+	if pending.Pending[0] != 1 || pending.BatchId != "batch1" || pending.Claimed[0] != 1 || pending.Claimed[10] != 1 {
+		t.Errorf("expected the new clicks to be pending and the claimed ones in (batch1), got (%+v)", pending)
+	}
+
+	// the batch that wasn't acknowledged is claimed again with its id
+	id, clicks, err = counters.Claim(context.Background(), "batch2")
+	if err != nil {
+		t.Fatalf("unable to claim counters: %s", err.Error())
+	}
+
+	if id != "batch1" || clicks[entities.Click{Code: "84gfj4i9"}] != 1 {
+		t.Errorf("expected batch (batch1) to be claimed again, got (%s) (%v)", id, clicks)
+	}
+
+	// a wrong id doesn't acknowledge the batch
+	if err = counters.Ack(context.Background(), "batch2"); err != nil {
+		t.Fatalf("unable to acknowledge counters: %s", err.Error())
+	}
+
+	if id, _, _ = counters.Claim(context.Background(), "batch3"); id != "batch1" {
+		t.Errorf("expected batch (batch1) to be kept, got (%s)", id)
+	}
+
+	if err = counters.Ack(context.Background(), "batch1"); err != nil {
+		t.Fatalf("unable to acknowledge counters: %s", err.Error())
+	}
+
+	id, clicks, err = counters.Claim(context.Background(), "batch4")
+	if err != nil {
+		t.Fatalf("unable to claim counters: %s", err.Error())
+	}
+
+	if id != "batch4" || len(clicks) != 1 || clicks[entities.Click{Code: "84gfj4i9"}] != 1 {
+		t.Errorf("expected batch (batch4) with the new click, got (%s) (%v)", id, clicks)
+	}
+
+	counters.Ack(context.Background(), "batch4")
+
+	pending, _ = counters.Pending(context.Background(), "84gfj4i9")
+	if len(pending.Pending) != 0 || pending.BatchId != "" || len(pending.Claimed) != 0 {
+		t.Errorf("expected no pending clicks after the acknowledge, got (%+v)", pending)
+	}
+}
+
+func TestCountersError(t *testing.T) {
+	mr, counters := newTestRedisCounters()
+	mr.Close()
+
+	if err := counters.Increment(context.Background(), entities.Click{Code: "84gfj4i9"}); err == nil {
+		t.Errorf("expected error incrementing counter, got nil")
+	}
+
+	if _, _, err := counters.Claim(context.Background(), "batch1"); err == nil {
+		t.Errorf("expected error claiming counters, got nil")
+	}
+
+	if err := counters.Ack(context.Background(), "batch1"); err == nil {
+		t.Errorf("expected error acknowledging counters, got nil")
+	}
+
+	if _, err := counters.Pending(context.Background(), "84gfj4i9"); err == nil {
+		t.Errorf("expected error getting pending counters, got nil")
+	}
+}
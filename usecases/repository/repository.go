@@ -1,9 +1,19 @@
 package repository
 
 import (
+	"context"
+	"github.com/norby7/shortening-service/entities"
 	"github.com/norby7/shortening-service/usecases/repository/storage"
 )
 
 type Repository interface{
 	storage.Storage
+	GetUrlByCode(context.Context, string) (entities.Url, error)
+	CountClick(context.Context, entities.Click) error
+	FlushCounters(context.Context) (map[entities.Click]int64, error)
+}
+
+// IsUnavailable checks if a repository error is a temporary failure of the storage, the call can be retried later
+func IsUnavailable(err error) bool {
+	return storage.IsUnavailable(err)
 }
@@ -1,23 +1,43 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"github.com/norby7/shortening-service/entities"
 	"io/ioutil"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type SqliteStorage struct {
 	Handler *sql.DB
+	// Logger receives the debug records of the saved changes, with the request id of their context
+	Logger *slog.Logger
 }
 
 var (
 	SqlOpen = sql.Open
 )
 
+// urlColumns is the list of columns selected for every Url query, in the order expected by scanUrl
+const urlColumns = `id, code, url, shortUrl, domain, counter, createdAt, redirectType, forwardQuery, prefixMode, rules, maxClicks, activeFrom, activeUntil, fallbackUrl, deletedAt, owner, tags`
+
+// counterBatchRetention is the time the ids of the added counters batches are kept to detect the batches sent again
+const counterBatchRetention = 24 * time.Hour
+
+// migrationsDir is the directory that contains the sql scripts applied on top of the base schema
+const migrationsDir = "./database/sqlite/migrations"
+
 // NewSqliteStorage connects to a sqlite database and returns a repository object that contains the database connection handler
 func NewSqliteStorage(p string, maxConns int) (*SqliteStorage, error) {
 	db, err := SqlOpen("sqlite3", p)
@@ -30,7 +50,7 @@ func NewSqliteStorage(p string, maxConns int) (*SqliteStorage, error) {
 		db.SetMaxOpenConns(maxConns)
 	}
 
-	return &SqliteStorage{Handler: db}, nil
+	return &SqliteStorage{Handler: db, Logger: slog.Default()}, nil
 }
 
 // CreateDatabase checks if the database file exists and creates one if it doesn't
@@ -58,6 +78,10 @@ func ValidateSchema(db *sql.DB) error {
 		}
 	}
 
+	if err = migrateSchema(db); err != nil {
+		return fmt.Errorf("unable to migrate database schema: %s", err.Error())
+	}
+
 	return nil
 }
 
@@ -107,81 +131,1292 @@ func initializeSchema(handler *sql.DB) error {
 	return nil
 }
 
-// Add inserts a new url into the database and returns an error in case something went wrong
-func (s *SqliteStorage) Add(url *entities.Url) error {
-	res, err := s.Handler.Exec(`INSERT INTO urls (code, url, counter, shortUrl, domain) VALUES (?, ?, ?, ?, ?)`, url.Code, url.Url, url.Counter, url.ShortUrl, url.Domain)
+// migrateSchema applies, in order, every migration script whose version is greater than the database user_version
+// Migration scripts are named <version>_<description>.sql, the database user_version is updated after each script
+func migrateSchema(handler *sql.DB) error {
+	var current int
+	if err := handler.QueryRow(`PRAGMA user_version`).Scan(&current); err != nil {
+		return fmt.Errorf("unable to read schema version: %s", err.Error())
+	}
+
+	files, err := filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
+	if err != nil {
+		return fmt.Errorf("unable to list migration scripts: %s", err.Error())
+	}
+
+	sort.Strings(files)
+
+	for _, f := range files {
+		version, err := strconv.Atoi(strings.SplitN(filepath.Base(f), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("invalid migration script name (%s): %s", f, err.Error())
+		}
+
+		if version <= current {
+			continue
+		}
+
+		c, err := ioutil.ReadFile(f)
+		if err != nil {
+			return fmt.Errorf("unable to open migration script (%s): %s", f, err.Error())
+		}
+
+		tx, err := handler.Begin()
+		if err != nil {
+			return fmt.Errorf("unable to start transaction: %s", err.Error())
+		}
+
+		if _, err = tx.Exec(string(c)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("unable to execute migration script (%s): %s", f, err.Error())
+		}
+
+		if _, err = tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("unable to update schema version: %s", err.Error())
+		}
+
+		if err = tx.Commit(); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("unable to commit transation: %s", err.Error())
+		}
+
+		current = version
+	}
+
+	return nil
+}
+
+// SchemaVersion returns the version of the last migration applied to the database, 0 before the first one
+func SchemaVersion(db *sql.DB) (int, error) {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("unable to read schema version: %s", err.Error())
+	}
+
+	return version, nil
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUrl reads the urlColumns of a single row into a Url object
+func scanUrl(row rowScanner, u *entities.Url) error {
+	var rules, tags string
+	var activeFrom, activeUntil, deletedAt sql.NullTime
+	if err := row.Scan(&u.Id, &u.Code, &u.Url, &u.ShortUrl, &u.Domain, &u.Counter, &u.CreatedAt, &u.RedirectType, &u.ForwardQuery, &u.PrefixMode, &rules, &u.MaxClicks,
+		&activeFrom, &activeUntil, &u.FallbackUrl, &deletedAt, &u.Owner, &tags); err != nil {
+		return err
+	}
+
+	u.ActiveFrom = nullTimeToTime(activeFrom)
+	u.ActiveUntil = nullTimeToTime(activeUntil)
+	u.DeletedAt = nullTimeToTime(deletedAt)
+
+	if err := decodeTags(tags, u); err != nil {
+		return err
+	}
+
+	return decodeRules(rules, u)
+}
+
+// nullTimeToTime returns the address of a valid nullable time, nil if the time is null
+func nullTimeToTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
+
+// timeToNullTime returns the nullable time stored for an optional time
+func timeToNullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// encodeRules returns the JSON stored in the rules column, an empty string if the url has no rules
+func encodeRules(rules []entities.Rule) (string, error) {
+	if len(rules) == 0 {
+		return "", nil
+	}
+
+	b, err := json.Marshal(rules)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode url rules: %s", err.Error())
+	}
+
+	return string(b), nil
+}
+
+// decodeRules sets the url rules from the JSON stored in the rules column
+func decodeRules(rules string, u *entities.Url) error {
+	if rules == "" {
+		u.Rules = nil
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(rules), &u.Rules); err != nil {
+		return fmt.Errorf("unable to decode url rules: %s", err.Error())
+	}
+
+	return nil
+}
+
+// encodeTags returns the JSON stored in the tags column, an empty string if the url has no tags
+func encodeTags(tags []string) (string, error) {
+	if len(tags) == 0 {
+		return "", nil
+	}
+
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode url tags: %s", err.Error())
+	}
+
+	return string(b), nil
+}
+
+// decodeTags sets the url tags from the JSON stored in the tags column
+func decodeTags(tags string, u *entities.Url) error {
+	if tags == "" {
+		u.Tags = nil
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(tags), &u.Tags); err != nil {
+		return fmt.Errorf("unable to decode url tags: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Add inserts a new url and its variants into the database and returns an error in case something went wrong
+// The creation is recorded in the audit events in the same transaction
+func (s *SqliteStorage) Add(ctx context.Context, url *entities.Url, actor entities.Actor) error {
+	rules, err := encodeRules(url.Rules)
+	if err != nil {
+		return err
+	}
+
+	tags, err := encodeTags(url.Tags)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	res, err := tx.ExecContext(ctx, `INSERT INTO urls (code, url, counter, shortUrl, domain, createdAt, redirectType, forwardQuery, prefixMode, rules, maxClicks, activeFrom, activeUntil, fallbackUrl, owner, tags) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		url.Code, url.Url, url.Counter, url.ShortUrl, url.Domain, url.CreatedAt, url.RedirectType, url.ForwardQuery, url.PrefixMode, rules, url.MaxClicks,
+		timeToNullTime(url.ActiveFrom), timeToNullTime(url.ActiveUntil), url.FallbackUrl, url.Owner, tags)
 	if err != nil {
+		_ = tx.Rollback()
 		return err
 	}
 
 	// get new url id
 	id, err := res.LastInsertId()
 	if err != nil {
+		_ = tx.Rollback()
 		return fmt.Errorf("unable to get last inserted id: %s", err.Error())
 	}
 
+	for i := range url.Variants {
+		if err = insertVariant(ctx, tx, id, &url.Variants[i]); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	after := *url
+	after.Id = id
+	if err = insertAuditEvent(ctx, tx, entities.AuditCreate, actor, nil, &after); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
 	// set the Url new Id
 	url.Id = id
 
+	s.Logger.DebugContext(ctx, "url added", "id", id, "code", url.Code)
+
 	return nil
 }
 
-// Delete removes a url from the database based on the given Id
-func (s *SqliteStorage) Delete(id int64) error {
-	if _, err := s.Handler.Exec(`DELETE FROM urls WHERE id = ?`, id); err != nil {
+// Delete moves a url to the trash by setting its deletion time, the url and its variants are kept until they are purged
+// The deletion is recorded in the audit events in the same transaction
+func (s *SqliteStorage) Delete(ctx context.Context, id int64, actor entities.Actor) error {
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	before, err := selectUrl(ctx, tx, `id = ? AND deletedAt IS NULL`, id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	// the url doesn't exist or is already deleted
+	if before.Id == 0 {
+		_ = tx.Rollback()
+		return nil
+	}
+
+	now := time.Now().UTC()
+	if _, err = tx.ExecContext(ctx, `UPDATE urls SET deletedAt = ? WHERE id = ?`, now, id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	after := before
+	after.DeletedAt = &now
+	if err = insertAuditEvent(ctx, tx, entities.AuditDelete, actor, &before, &after); err != nil {
+		_ = tx.Rollback()
 		return err
 	}
 
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
+	s.Logger.DebugContext(ctx, "url deleted", "id", id, "code", before.Code)
+
 	return nil
 }
 
-// GetUrlByCode returns a url from the database with the given code
-func (s *SqliteStorage) GetUrlByCode(code string) (string, error) {
-	var url string
-	if err := s.Handler.QueryRow(`SELECT url FROM urls WHERE code = ?`, code).Scan(&url); err != nil {
-		if err == sql.ErrNoRows {
-			return "", nil
-		}
+// Restore moves a url out of the trash, it returns false if no deleted url exists with the given id
+// The restoration is recorded in the audit events in the same transaction
+func (s *SqliteStorage) Restore(ctx context.Context, id int64, actor entities.Actor) (bool, error) {
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	before, err := selectUrl(ctx, tx, `id = ? AND deletedAt IS NOT NULL`, id)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	if before.Id == 0 {
+		_ = tx.Rollback()
+		return false, nil
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE urls SET deletedAt = NULL WHERE id = ?`, id); err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	after := before
+	after.DeletedAt = nil
+	if err = insertAuditEvent(ctx, tx, entities.AuditRestore, actor, &before, &after); err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
 
-		return "", err
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return false, fmt.Errorf("unable to commit transation: %s", err.Error())
 	}
 
-	return url, nil
+	s.Logger.DebugContext(ctx, "url restored", "id", id, "code", before.Code)
+
+	return true, nil
 }
 
-// GetById returns a url from the database with the given id
-func (s *SqliteStorage) GetById(id int64) (entities.Url, error) {
-	var u entities.Url
-	if err := s.Handler.QueryRow(`SELECT * FROM urls WHERE id = ?`, id).Scan(&u.Id, &u.Code, &u.Url, &u.ShortUrl, &u.Domain, &u.Counter); err != nil {
-		if err == sql.ErrNoRows {
-			return entities.Url{}, nil
+// GetDeleted returns the urls in the trash together with their variants, the most recently deleted first
+func (s *SqliteStorage) GetDeleted(ctx context.Context) ([]entities.Url, error) {
+	rows, err := s.Handler.QueryContext(ctx, `SELECT `+urlColumns+` FROM urls WHERE deletedAt IS NOT NULL ORDER BY deletedAt DESC, id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch deleted urls: %s", err.Error())
+	}
+
+	var urls []entities.Url
+	for rows.Next() {
+		var u entities.Url
+		if err = scanUrl(rows, &u); err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("unable to read deleted url: %s", err.Error())
 		}
 
-		return entities.Url{}, err
+		urls = append(urls, u)
 	}
 
-	return u, nil
+	if err = rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+
+	// the variants are loaded once the rows are closed so the connection is released
+	_ = rows.Close()
+	for i := range urls {
+		if err = loadVariants(ctx, s.Handler, &urls[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return urls, nil
 }
 
-// GetByUrl returns a url object from the database with the given url
-func (s *SqliteStorage) GetByUrl(url string) (entities.Url, error) {
-	var u entities.Url
-	if err := s.Handler.QueryRow(`SELECT * FROM urls WHERE url = ?`, url).Scan(&u.Id, &u.Code, &u.Url, &u.ShortUrl, &u.Domain, &u.Counter); err != nil {
-		if err == sql.ErrNoRows {
-			return entities.Url{}, nil
+// exportPageSize is the number of urls read by every query of ExportUrls
+const exportPageSize = 500
+
+// ExportUrls calls fn with every url selected by the filter, together with its variants, ordered by id
+// The urls are read by pages of exportPageSize so the connection isn't held while fn runs, an error of fn stops the export
+func (s *SqliteStorage) ExportUrls(ctx context.Context, filter entities.ExportFilter, fn func(entities.Url) error) error {
+	where := []string{`id > ?`}
+	args := []interface{}{int64(0)}
+
+	if filter.Owner != "" {
+		where = append(where, `owner = ?`)
+		args = append(args, filter.Owner)
+	}
+
+	if filter.From != nil {
+		where = append(where, `createdAt >= ?`)
+		args = append(args, filter.From.UTC())
+	}
+
+	if filter.Until != nil {
+		where = append(where, `createdAt < ?`)
+		args = append(args, filter.Until.UTC())
+	}
+
+	if !filter.Deleted {
+		where = append(where, `deletedAt IS NULL`)
+	}
+
+	query := `SELECT ` + urlColumns + ` FROM urls WHERE ` + strings.Join(where, ` AND `) + ` ORDER BY id LIMIT ?`
+	args = append(args, exportPageSize)
+
+	for {
+		rows, err := s.Handler.QueryContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("unable to fetch exported urls: %s", err.Error())
 		}
 
-		return entities.Url{}, err
+		var urls []entities.Url
+		for rows.Next() {
+			var u entities.Url
+			if err = scanUrl(rows, &u); err != nil {
+				_ = rows.Close()
+				return fmt.Errorf("unable to read exported url: %s", err.Error())
+			}
+
+			urls = append(urls, u)
+		}
+
+		if err = rows.Err(); err != nil {
+			_ = rows.Close()
+			return err
+		}
+
+		// the variants are loaded once the rows are closed so the connection is released
+		_ = rows.Close()
+		for i := range urls {
+			// the tags are stored as JSON so they are filtered here
+			if !filter.Matches(&urls[i]) {
+				continue
+			}
+
+			if err = loadVariants(ctx, s.Handler, &urls[i]); err != nil {
+				return err
+			}
+
+			if err = fn(urls[i]); err != nil {
+				return err
+			}
+		}
+
+		if len(urls) < exportPageSize {
+			return nil
+		}
+
+		// the next page starts after the last url of this one
+		args[0] = urls[len(urls)-1].Id
 	}
+}
 
-	return u, nil
+// PurgeDeleted permanently removes the urls, and their variants, that were deleted before the given time
+// It returns the number of removed urls
+func (s *SqliteStorage) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	before = before.UTC()
+	if _, err = tx.ExecContext(ctx, `DELETE FROM url_variants WHERE urlId IN (SELECT id FROM urls WHERE deletedAt IS NOT NULL AND deletedAt < ?)`, before); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM urls WHERE deletedAt IS NOT NULL AND deletedAt < ?`, before)
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("unable to get affected rows: %s", err.Error())
+	}
+
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
+	s.Logger.DebugContext(ctx, "deleted urls purged", "count", n, "before", before)
+
+	return n, nil
+}
+
+// CodeExists checks if a url, deleted or not, uses the given code
+// Deleted urls keep their code until they are purged so it can't be given to another url while they can be restored
+func (s *SqliteStorage) CodeExists(ctx context.Context, code string) (bool, error) {
+	var n int
+	if err := s.Handler.QueryRowContext(ctx, `SELECT COUNT(*) FROM urls WHERE code = ?`, code).Scan(&n); err != nil {
+		return false, err
+	}
+
+	return n != 0, nil
 }
 
-// IncrementCounter increments the counter for the given code
-func (s *SqliteStorage) IncrementCounter(code string) error {
-	if _, err := s.Handler.Exec(`UPDATE urls SET counter = counter + 1 WHERE code = ?`, code); err != nil {
+// Update saves the editable fields of a url into the database
+// The stored variants are synchronized with the url variants: variants without an id are inserted,
+// the url and weight of the existing ones are updated, keeping their counter, and the missing ones are removed
+// The change is recorded in the audit events in the same transaction
+func (s *SqliteStorage) Update(ctx context.Context, url *entities.Url, actor entities.Actor) error {
+	rules, err := encodeRules(url.Rules)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	tags, err := encodeTags(url.Tags)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	before, err := selectUrl(ctx, tx, `id = ? AND deletedAt IS NULL`, url.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if before.Id == 0 {
+		_ = tx.Rollback()
+		return fmt.Errorf("url (%d) doesn't exist", url.Id)
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE urls SET url = ?, redirectType = ?, forwardQuery = ?, prefixMode = ?, rules = ?, maxClicks = ?, activeFrom = ?, activeUntil = ?, fallbackUrl = ?, tags = ? WHERE id = ?`,
+		url.Url, url.RedirectType, url.ForwardQuery, url.PrefixMode, rules, url.MaxClicks,
+		timeToNullTime(url.ActiveFrom), timeToNullTime(url.ActiveUntil), url.FallbackUrl, tags, url.Id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err = syncVariants(ctx, tx, url); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err = insertAuditEvent(ctx, tx, entities.AuditUpdate, actor, &before, url); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
+	s.Logger.DebugContext(ctx, "url updated", "id", url.Id, "code", url.Code)
+
+	return nil
+}
+
+// Overwrite replaces every field of the url that has the same code, in the trash or not, with the given url
+// The counters, creation time and owner are replaced too and the variants are replaced by the url variants, the
+// replaced url keeps its id and leaves the trash, the change is recorded as an update in the same transaction
+func (s *SqliteStorage) Overwrite(ctx context.Context, url *entities.Url, actor entities.Actor) error {
+	rules, err := encodeRules(url.Rules)
+	if err != nil {
+		return err
+	}
+
+	tags, err := encodeTags(url.Tags)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	before, err := selectUrl(ctx, tx, `code = ?`, url.Code)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if before.Id == 0 {
+		_ = tx.Rollback()
+		return fmt.Errorf("url (%s) doesn't exist", url.Code)
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE urls SET url = ?, counter = ?, shortUrl = ?, domain = ?, createdAt = ?, redirectType = ?, forwardQuery = ?, prefixMode = ?, rules = ?, maxClicks = ?, activeFrom = ?, activeUntil = ?, fallbackUrl = ?, deletedAt = NULL, owner = ?, tags = ? WHERE id = ?`,
+		url.Url, url.Counter, url.ShortUrl, url.Domain, url.CreatedAt, url.RedirectType, url.ForwardQuery, url.PrefixMode, rules, url.MaxClicks,
+		timeToNullTime(url.ActiveFrom), timeToNullTime(url.ActiveUntil), url.FallbackUrl, url.Owner, tags, before.Id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM url_variants WHERE urlId = ?`, before.Id); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to remove url variants: %s", err.Error())
+	}
+
+	for i := range url.Variants {
+		if err = insertVariant(ctx, tx, before.Id, &url.Variants[i]); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	url.Id = before.Id
+	url.DeletedAt = nil
+	if err = insertAuditEvent(ctx, tx, entities.AuditUpdate, actor, &before, url); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
+	s.Logger.DebugContext(ctx, "url overwritten", "id", url.Id, "code", url.Code)
+
+	return nil
+}
+
+// Rekey changes the code and short url of the url with the url id, it returns an error if the url doesn't exist or
+// is in the trash. The change is recorded as an update in the same transaction
+func (s *SqliteStorage) Rekey(ctx context.Context, url *entities.Url, actor entities.Actor) error {
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	before, err := selectUrl(ctx, tx, `id = ? AND deletedAt IS NULL`, url.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if before.Id == 0 {
+		_ = tx.Rollback()
+		return fmt.Errorf("url (%d) doesn't exist", url.Id)
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE urls SET code = ?, shortUrl = ? WHERE id = ?`, url.Code, url.ShortUrl, url.Id); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	after := before
+	after.Code = url.Code
+	after.ShortUrl = url.ShortUrl
+	if err = insertAuditEvent(ctx, tx, entities.AuditUpdate, actor, &before, &after); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
+	s.Logger.DebugContext(ctx, "url rekeyed", "id", url.Id, "from", before.Code, "code", url.Code)
+
+	return nil
+}
+
+// syncVariants replaces the stored variants of a url with the url variants, inside the given transaction
+func syncVariants(ctx context.Context, tx *sql.Tx, url *entities.Url) error {
+	keep := []interface{}{url.Id}
+	for i := range url.Variants {
+		if url.Variants[i].Id != 0 {
+			keep = append(keep, url.Variants[i].Id)
+		}
+	}
+
+	query := `DELETE FROM url_variants WHERE urlId = ?`
+	if len(keep) > 1 {
+		query += ` AND id NOT IN (?` + strings.Repeat(`, ?`, len(keep)-2) + `)`
+	}
+
+	if _, err := tx.ExecContext(ctx, query, keep...); err != nil {
+		return fmt.Errorf("unable to remove url variants: %s", err.Error())
+	}
+
+	for i := range url.Variants {
+		v := &url.Variants[i]
+		if v.Id == 0 {
+			if err := insertVariant(ctx, tx, url.Id, v); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		res, err := tx.ExecContext(ctx, `UPDATE url_variants SET url = ?, weight = ? WHERE id = ? AND urlId = ?`, v.Url, v.Weight, v.Id, url.Id)
+		if err != nil {
+			return fmt.Errorf("unable to update url variant: %s", err.Error())
+		}
+
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return fmt.Errorf("url variant (%d) doesn't exist", v.Id)
+		}
+	}
+
+	return nil
+}
+
+// insertVariant inserts a new variant of the url with the given id and sets the variant new Id
+func insertVariant(ctx context.Context, tx *sql.Tx, urlId int64, v *entities.Variant) error {
+	res, err := tx.ExecContext(ctx, `INSERT INTO url_variants (urlId, url, weight, counter) VALUES (?, ?, ?, ?)`, urlId, v.Url, v.Weight, v.Counter)
+	if err != nil {
+		return fmt.Errorf("unable to insert url variant: %s", err.Error())
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("unable to get last inserted id: %s", err.Error())
+	}
+
+	v.Id = id
+
+	return nil
+}
+
+// querier is implemented by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// loadVariants sets the url variants from the database, ordered by id
+func loadVariants(ctx context.Context, q querier, u *entities.Url) error {
+	rows, err := q.QueryContext(ctx, `SELECT id, url, weight, counter FROM url_variants WHERE urlId = ? ORDER BY id`, u.Id)
+	if err != nil {
+		return fmt.Errorf("unable to fetch url variants: %s", err.Error())
+	}
+	defer rows.Close()
+
+	u.Variants = nil
+	for rows.Next() {
+		var v entities.Variant
+		if err = rows.Scan(&v.Id, &v.Url, &v.Weight, &v.Counter); err != nil {
+			return fmt.Errorf("unable to read url variant: %s", err.Error())
+		}
+
+		u.Variants = append(u.Variants, v)
+	}
+
+	return rows.Err()
+}
+
+// selectUrl returns the url selected by the where clause together with its variants, an empty url if none exists
+func selectUrl(ctx context.Context, q querier, where string, arg interface{}) (entities.Url, error) {
+	var u entities.Url
+	if err := scanUrl(q.QueryRowContext(ctx, `SELECT `+urlColumns+` FROM urls WHERE `+where, arg), &u); err != nil {
+		if err == sql.ErrNoRows {
+			return entities.Url{}, nil
+		}
+
+		return entities.Url{}, err
+	}
+
+	if err := loadVariants(ctx, q, &u); err != nil {
+		return entities.Url{}, err
+	}
+
+	return u, nil
+}
+
+// getUrl returns the url selected by the where clause together with its variants, an empty url if none exists
+// Deleted urls are never returned
+func (s *SqliteStorage) getUrl(ctx context.Context, where string, arg interface{}) (entities.Url, error) {
+	return selectUrl(ctx, s.Handler, `deletedAt IS NULL AND `+where, arg)
+}
+
+// GetById returns a url from the database with the given id
+func (s *SqliteStorage) GetById(ctx context.Context, id int64) (entities.Url, error) {
+	return s.getUrl(ctx, `id = ?`, id)
+}
+
+// GetByCode returns a url object from the database with the given code
+func (s *SqliteStorage) GetByCode(ctx context.Context, code string) (entities.Url, error) {
+	return s.getUrl(ctx, `code = ?`, code)
+}
+
+// GetByUrl returns a url object from the database with the given url
+func (s *SqliteStorage) GetByUrl(ctx context.Context, url string) (entities.Url, error) {
+	return s.getUrl(ctx, `url = ?`, url)
+}
+
+// IncrementCounters adds the coalesced clicks to the url and variant counters in a single transaction
+// The clicks map holds the number of clicks of each code and variant, the clicks of a code are added with a single update
+func (s *SqliteStorage) IncrementCounters(ctx context.Context, clicks map[entities.Click]int64) error {
+	if len(clicks) == 0 {
+		return nil
+	}
+
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	if err = addCounters(ctx, tx, clicks); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
+	return nil
+}
+
+// IncrementCountersBatch adds a batch of coalesced clicks to the counters only if the batch with the given id wasn't added before
+// The batch id is saved in the same transaction as the counters so a batch that is sent again is not counted twice
+func (s *SqliteStorage) IncrementCountersBatch(ctx context.Context, batchId string, clicks map[entities.Click]int64) error {
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	now := time.Now()
+	res, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO counter_batches (id, appliedAt) VALUES (?, ?)`, batchId, now)
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to save counters batch: %s", err.Error())
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to save counters batch: %s", err.Error())
+	}
+
+	// the batch was already added
+	if n == 0 {
+		_ = tx.Rollback()
+		s.Logger.InfoContext(ctx, "counters batch already saved, skipped", "batch_id", batchId)
+		return nil
+	}
+
+	if err = addCounters(ctx, tx, clicks); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM counter_batches WHERE appliedAt < ?`, now.Add(-counterBatchRetention)); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to remove old counters batches: %s", err.Error())
+	}
+
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
+	return nil
+}
+
+// addCounters adds the coalesced clicks to the url and variant counters
+func addCounters(ctx context.Context, tx *sql.Tx, clicks map[entities.Click]int64) error {
+	urls := make(map[string]int64)
+	var codes []string
+	var variants []entities.Click
+	for click, n := range clicks {
+		if _, ok := urls[click.Code]; !ok {
+			codes = append(codes, click.Code)
+		}
+
+		urls[click.Code] += n
+		if click.VariantId != 0 {
+			variants = append(variants, click)
+		}
+	}
+
+	// the rows are always updated in the same order
+	sort.Strings(codes)
+	sort.Slice(variants, func(i, j int) bool {
+		if variants[i].Code != variants[j].Code {
+			return variants[i].Code < variants[j].Code
+		}
+
+		return variants[i].VariantId < variants[j].VariantId
+	})
+
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx, `UPDATE urls SET counter = counter + ? WHERE code = ?`, urls[code], code); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range variants {
+		if _, err := tx.ExecContext(ctx, `UPDATE url_variants SET counter = counter + ? WHERE id = ? AND urlId = (SELECT id FROM urls WHERE code = ?)`, clicks[v], v.VariantId, v.Code); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CounterBatchSaved checks if the counters batch with the given id was added by IncrementCountersBatch
+func (s *SqliteStorage) CounterBatchSaved(ctx context.Context, batchId string) (bool, error) {
+	var n int
+	if err := s.Handler.QueryRowContext(ctx, `SELECT COUNT(*) FROM counter_batches WHERE id = ?`, batchId).Scan(&n); err != nil {
+		return false, fmt.Errorf("unable to check the counters batch: %s", err.Error())
+	}
+
+	return n > 0, nil
+}
+
+// ConsumeClick counts a redirect of a click limited url only if the url has clicks left
+// The check and the increment are done by a single conditional update so concurrent redirects can't exceed the limit
+// It returns the number of clicks left after the consumed one, or -1 if the url had no clicks left
+func (s *SqliteStorage) ConsumeClick(ctx context.Context, click entities.Click) (int64, error) {
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	var left int64
+	err = tx.QueryRowContext(ctx, `UPDATE urls SET counter = counter + 1 WHERE code = ? AND (maxClicks = 0 OR counter < maxClicks) AND deletedAt IS NULL RETURNING maxClicks - counter`, click.Code).Scan(&left)
+	if err != nil {
+		_ = tx.Rollback()
+		if err == sql.ErrNoRows {
+			return -1, nil
+		}
+
+		return 0, err
+	}
+
+	if click.VariantId != 0 {
+		if _, err = tx.ExecContext(ctx, `UPDATE url_variants SET counter = counter + 1 WHERE id = ? AND urlId = (SELECT id FROM urls WHERE code = ?)`, click.VariantId, click.Code); err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return 0, fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
+	return left, nil
+}
+
+// RemainingClicks returns the number of clicks a click limited url has left, 0 if it has none or doesn't exist
+func (s *SqliteStorage) RemainingClicks(ctx context.Context, code string) (int64, error) {
+	var left int64
+	err := s.Handler.QueryRowContext(ctx, `SELECT MAX(maxClicks - counter, 0) FROM urls WHERE code = ? AND deletedAt IS NULL`, code).Scan(&left)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, fmt.Errorf("unable to fetch url remaining clicks: %s", err.Error())
+	}
+
+	return left, nil
+}
+
+// GetCounters returns the counters of the urls with the given codes, the codes without a url are missing from the map
+func (s *SqliteStorage) GetCounters(ctx context.Context, codes []string) (map[string]int64, error) {
+	counters := make(map[string]int64, len(codes))
+	if len(codes) == 0 {
+		return counters, nil
+	}
+
+	args := make([]interface{}, len(codes))
+	for i := range codes {
+		args[i] = codes[i]
+	}
+
+	rows, err := s.Handler.QueryContext(ctx, `SELECT code, counter FROM urls WHERE code IN (?`+strings.Repeat(`, ?`, len(codes)-1)+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch url counters: %s", err.Error())
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var code string
+		var counter int64
+		if err = rows.Scan(&code, &counter); err != nil {
+			return nil, fmt.Errorf("unable to read url counter: %s", err.Error())
+		}
+
+		counters[code] = counter
+	}
+
+	return counters, rows.Err()
+}
+
+// insertAuditEvent records a change of a url, with the url snapshots before and after the change, inside the given transaction
+func insertAuditEvent(ctx context.Context, tx *sql.Tx, action string, actor entities.Actor, before, after *entities.Url) error {
+	b, err := encodeSnapshot(before)
+	if err != nil {
+		return err
+	}
+
+	a, err := encodeSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `INSERT INTO audit_events (urlId, action, actor, clientIp, requestId, before, after, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		after.Id, action, actor.Name, actor.ClientIp, actor.RequestId, b, a, time.Now().UTC()); err != nil {
+		return fmt.Errorf("unable to insert audit event: %s", err.Error())
+	}
+
+	return nil
+}
+
+// encodeSnapshot returns the JSON of a url snapshot stored in the audit events, an empty string if there is no snapshot
+func encodeSnapshot(u *entities.Url) (string, error) {
+	if u == nil {
+		return "", nil
+	}
+
+	b, err := json.Marshal(u)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode url snapshot: %s", err.Error())
+	}
+
+	return string(b), nil
+}
+
+// decodeSnapshot returns the url snapshot from the JSON stored in the audit events, nil if there is no snapshot
+func decodeSnapshot(s string) (*entities.Url, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var u entities.Url
+	if err := json.Unmarshal([]byte(s), &u); err != nil {
+		return nil, fmt.Errorf("unable to decode url snapshot: %s", err.Error())
+	}
+
+	return &u, nil
+}
+
+// GetAuditEvents returns the audit events that match the filter, the most recent first
+func (s *SqliteStorage) GetAuditEvents(ctx context.Context, filter entities.AuditFilter) ([]entities.AuditEvent, error) {
+	var where []string
+	var args []interface{}
+
+	if filter.UrlId != 0 {
+		where = append(where, `urlId = ?`)
+		args = append(args, filter.UrlId)
+	}
+
+	if filter.Actor != "" {
+		where = append(where, `actor = ?`)
+		args = append(args, filter.Actor)
+	}
+
+	if filter.From != nil {
+		where = append(where, `createdAt >= ?`)
+		args = append(args, filter.From.UTC())
+	}
+
+	if filter.Until != nil {
+		where = append(where, `createdAt < ?`)
+		args = append(args, filter.Until.UTC())
+	}
+
+	query := `SELECT id, urlId, action, actor, clientIp, requestId, before, after, createdAt FROM audit_events`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+
+	query += ` ORDER BY createdAt DESC, id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := s.Handler.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch audit events: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var events []entities.AuditEvent
+	for rows.Next() {
+		var e entities.AuditEvent
+		var before, after string
+		if err = rows.Scan(&e.Id, &e.UrlId, &e.Action, &e.Actor, &e.ClientIp, &e.RequestId, &before, &after, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("unable to read audit event: %s", err.Error())
+		}
+
+		if e.Before, err = decodeSnapshot(before); err != nil {
+			return nil, err
+		}
+
+		if e.After, err = decodeSnapshot(after); err != nil {
+			return nil, err
+		}
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// AddWebhook inserts a new webhook into the database and sets its id
+func (s *SqliteStorage) AddWebhook(ctx context.Context, w *entities.Webhook) error {
+	events, err := json.Marshal(w.Events)
+	if err != nil {
+		return fmt.Errorf("unable to encode webhook events: %s", err.Error())
+	}
+
+	res, err := s.Handler.ExecContext(ctx, `INSERT INTO webhooks (url, secret, events, createdAt) VALUES (?, ?, ?, ?)`, w.Url, w.Secret, string(events), w.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("unable to insert webhook: %s", err.Error())
+	}
+
+	if w.Id, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("unable to get last inserted id: %s", err.Error())
+	}
+
+	s.Logger.DebugContext(ctx, "webhook added", "id", w.Id, "url", w.Url)
+
+	return nil
+}
+
+// GetWebhooks returns every webhook with its secret, the oldest first
+func (s *SqliteStorage) GetWebhooks(ctx context.Context) ([]entities.Webhook, error) {
+	rows, err := s.Handler.QueryContext(ctx, `SELECT id, url, secret, events, createdAt FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch webhooks: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var webhooks []entities.Webhook
+	for rows.Next() {
+		var w entities.Webhook
+		var events string
+		if err = rows.Scan(&w.Id, &w.Url, &w.Secret, &events, &w.CreatedAt); err != nil {
+			return nil, fmt.Errorf("unable to read webhook: %s", err.Error())
+		}
+
+		if events != "" {
+			if err = json.Unmarshal([]byte(events), &w.Events); err != nil {
+				return nil, fmt.Errorf("unable to decode webhook events: %s", err.Error())
+			}
+		}
+
+		webhooks = append(webhooks, w)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook and its dead letters, it returns false if no webhook has the given id
+func (s *SqliteStorage) DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	tx, err := s.Handler.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("unable to start transaction: %s", err.Error())
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM webhook_dead_letters WHERE webhookId = ?`, id); err != nil {
+		_ = tx.Rollback()
+		return false, fmt.Errorf("unable to delete webhook dead letters: %s", err.Error())
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		_ = tx.Rollback()
+		return false, fmt.Errorf("unable to delete webhook: %s", err.Error())
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		_ = tx.Rollback()
+		return false, fmt.Errorf("unable to get affected rows: %s", err.Error())
+	}
+
+	if err = tx.Commit(); err != nil {
+		_ = tx.Rollback()
+		return false, fmt.Errorf("unable to commit transation: %s", err.Error())
+	}
+
+	return n != 0, nil
+}
+
+// AddDeadLetter inserts an undelivered webhook event into the database and sets its id
+func (s *SqliteStorage) AddDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	event, err := json.Marshal(d.Event)
+	if err != nil {
+		return fmt.Errorf("unable to encode webhook event: %s", err.Error())
+	}
+
+	res, err := s.Handler.ExecContext(ctx, `INSERT INTO webhook_dead_letters (webhookId, event, attempts, lastError, failedAt) VALUES (?, ?, ?, ?, ?)`,
+		d.WebhookId, string(event), d.Attempts, d.LastError, d.FailedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("unable to insert dead letter: %s", err.Error())
+	}
+
+	if d.Id, err = res.LastInsertId(); err != nil {
+		return fmt.Errorf("unable to get last inserted id: %s", err.Error())
+	}
+
+	s.Logger.DebugContext(ctx, "webhook dead letter added", "id", d.Id, "webhook_id", d.WebhookId, "event_id", d.Event.Id)
+
+	return nil
+}
+
+// UpdateDeadLetter saves the attempts, last error and failure time of a dead letter that failed again
+func (s *SqliteStorage) UpdateDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	if _, err := s.Handler.ExecContext(ctx, `UPDATE webhook_dead_letters SET attempts = ?, lastError = ?, failedAt = ? WHERE id = ?`,
+		d.Attempts, d.LastError, d.FailedAt.UTC(), d.Id); err != nil {
+		return fmt.Errorf("unable to update dead letter: %s", err.Error())
+	}
+
+	return nil
+}
+
+// deadLetterColumns is the list of columns selected for every dead letter query, in the order expected by scanDeadLetter
+const deadLetterColumns = `id, webhookId, event, attempts, lastError, failedAt`
+
+// scanDeadLetter reads the deadLetterColumns of a single row into a DeadLetter object
+func scanDeadLetter(row rowScanner, d *entities.DeadLetter) error {
+	var event string
+	if err := row.Scan(&d.Id, &d.WebhookId, &event, &d.Attempts, &d.LastError, &d.FailedAt); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal([]byte(event), &d.Event); err != nil {
+		return fmt.Errorf("unable to decode webhook event: %s", err.Error())
+	}
+
+	return nil
+}
+
+// GetDeadLetter returns the dead letter with the given id, an empty dead letter if none exists
+func (s *SqliteStorage) GetDeadLetter(ctx context.Context, id int64) (entities.DeadLetter, error) {
+	var d entities.DeadLetter
+	if err := scanDeadLetter(s.Handler.QueryRowContext(ctx, `SELECT `+deadLetterColumns+` FROM webhook_dead_letters WHERE id = ?`, id), &d); err != nil {
+		if err == sql.ErrNoRows {
+			return entities.DeadLetter{}, nil
+		}
+
+		return entities.DeadLetter{}, fmt.Errorf("unable to fetch dead letter: %s", err.Error())
+	}
+
+	return d, nil
+}
+
+// GetDeadLetters returns the dead letters of the webhook with the given id, or of every webhook if the id is 0,
+// the most recent failure first
+func (s *SqliteStorage) GetDeadLetters(ctx context.Context, webhookId int64) ([]entities.DeadLetter, error) {
+	query := `SELECT ` + deadLetterColumns + ` FROM webhook_dead_letters`
+	var args []interface{}
+	if webhookId != 0 {
+		query += ` WHERE webhookId = ?`
+		args = append(args, webhookId)
+	}
+
+	rows, err := s.Handler.QueryContext(ctx, query+` ORDER BY failedAt DESC, id DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch dead letters: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var letters []entities.DeadLetter
+	for rows.Next() {
+		var d entities.DeadLetter
+		if err = scanDeadLetter(rows, &d); err != nil {
+			return nil, fmt.Errorf("unable to read dead letter: %s", err.Error())
+		}
+
+		letters = append(letters, d)
+	}
+
+	return letters, rows.Err()
+}
+
+// DeleteDeadLetter removes a dead letter once its event is delivered
+func (s *SqliteStorage) DeleteDeadLetter(ctx context.Context, id int64) error {
+	if _, err := s.Handler.ExecContext(ctx, `DELETE FROM webhook_dead_letters WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("unable to delete dead letter: %s", err.Error())
+	}
+
+	return nil
+}
+
+// unavailableMessages are the messages of the temporary failures, they are checked when the error lost its type
+var unavailableMessages = []string{
+	sqlite3.ErrBusy.Error(),
+	sqlite3.ErrLocked.Error(),
+	context.DeadlineExceeded.Error(),
+	driver.ErrBadConn.Error(),
+	sql.ErrConnDone.Error(),
+}
+
+// IsUnavailable checks if err is a temporary failure of the database: busy or locked, a timed out call or a lost connection
+// The call can be retried later
+func IsUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var se sqlite3.Error
+	if errors.As(err, &se) {
+		return se.Code == sqlite3.ErrBusy || se.Code == sqlite3.ErrLocked
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return true
+	}
+
+	for _, m := range unavailableMessages {
+		if strings.Contains(err.Error(), m) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -1,11 +1,15 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mattn/go-sqlite3"
 	"github.com/norby7/shortening-service/entities"
 	"testing"
+	"time"
 )
 
 var (
@@ -32,6 +36,29 @@ var MockErrOpener = func(d string, p string) (*sql.DB, error) {
 	return nil, nil
 }
 
+var testActor = entities.Actor{Name: "apikey:1a2b3c", ClientIp: "192.0.2.1", RequestId: "8f1e2d3c"}
+
+// urlRowColumns are the columns returned by the urlColumns queries
+var urlRowColumns = []string{"id", "code", "url", "shortUrl", "domain", "counter", "createdAt", "redirectType", "forwardQuery", "prefixMode", "rules", "maxClicks", "activeFrom", "activeUntil", "fallbackUrl", "deletedAt", "owner", "tags"}
+
+// expectSnapshot adds the expected queries that read the snapshot of the url with the given id, an id of 0 returns no url
+func expectSnapshot(id int64) {
+	rows := sqlmock.NewRows(urlRowColumns)
+	if id == 0 {
+		dbMock.ExpectQuery(`SELECT .* FROM urls WHERE id = \?`).WillReturnRows(rows)
+		return
+	}
+
+	rows.AddRow(id, "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "0", time.Now(), "302", "0", "0", "", "0", nil, nil, "", nil, "anonymous", "")
+	dbMock.ExpectQuery(`SELECT .* FROM urls WHERE id = \?`).WithArgs(id).WillReturnRows(rows)
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "weight", "counter"}))
+}
+
+// expectAuditEvent adds the expected insert of an audit event of the url with the given id
+func expectAuditEvent(id int64, action string) {
+	dbMock.ExpectExec(`INSERT INTO audit_events`).WithArgs(id, action, testActor.Name, testActor.ClientIp, testActor.RequestId, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
 func TestNewRepository(t *testing.T) {
 	SqlOpen = MockErrOpener
 	testCases := []struct {
@@ -75,12 +102,54 @@ func TestValidAdd(t *testing.T) {
 		Counter:  1,
 	}
 
-	dbMock.ExpectExec(`INSERT INTO urls`).WithArgs(u.Code, u.Url, u.Counter, u.ShortUrl, u.Domain).WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`INSERT INTO urls`).WithArgs(u.Code, u.Url, u.Counter, u.ShortUrl, u.Domain, u.CreatedAt, u.RedirectType, u.ForwardQuery, u.PrefixMode, "", u.MaxClicks, sqlmock.AnyArg(), sqlmock.AnyArg(), u.FallbackUrl, u.Owner, "").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectAuditEvent(1, entities.AuditCreate)
+	dbMock.ExpectCommit()
+
+	err = repo.Add(context.Background(), &u, testActor)
+	if err != nil {
+		t.Fatalf("unable to execute add call: %s", err.Error())
+	}
+}
+
+func TestValidAddWithVariants(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	u := entities.Url{
+		Code:     "84gfj4i9",
+		Url:      "https://google.com",
+		ShortUrl: "http://localhost/84gfj4i9",
+		Domain:   "http://localhost",
+		Variants: []entities.Variant{
+			{Url: "https://google.com/a", Weight: 70},
+			{Url: "https://google.com/b", Weight: 30},
+		},
+	}
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`INSERT INTO urls`).WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`INSERT INTO url_variants`).WithArgs(1, "https://google.com/a", 70, 0).WillReturnResult(sqlmock.NewResult(10, 1))
+	dbMock.ExpectExec(`INSERT INTO url_variants`).WithArgs(1, "https://google.com/b", 30, 0).WillReturnResult(sqlmock.NewResult(11, 1))
+	expectAuditEvent(1, entities.AuditCreate)
+	dbMock.ExpectCommit()
 
-	err = repo.Add(&u)
+	err = repo.Add(context.Background(), &u, testActor)
 	if err != nil {
 		t.Fatalf("unable to execute add call: %s", err.Error())
 	}
+
+	if u.Id != 1 || u.Variants[0].Id != 10 || u.Variants[1].Id != 11 {
+		t.Errorf("expected url and variant ids to be set, got (%v)", u)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
 }
 
 func TestInsertErrorAdd(t *testing.T){
@@ -101,9 +170,11 @@ func TestInsertErrorAdd(t *testing.T){
 
 	insertErr := fmt.Errorf("error executing insert query")
 
-	dbMock.ExpectExec(`INSERT INTO urls`).WithArgs(u.Code, u.Url, u.Counter, u.ShortUrl, u.Domain).WillReturnError(insertErr)
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`INSERT INTO urls`).WithArgs(u.Code, u.Url, u.Counter, u.ShortUrl, u.Domain, u.CreatedAt, u.RedirectType, u.ForwardQuery, u.PrefixMode, "", u.MaxClicks, sqlmock.AnyArg(), sqlmock.AnyArg(), u.FallbackUrl, u.Owner, "").WillReturnError(insertErr)
+	dbMock.ExpectRollback()
 
-	err = repo.Add(&u)
+	err = repo.Add(context.Background(), &u, testActor)
 	if err == nil {
 		t.Errorf("expected error (%v), got error nil", insertErr)
 	}
@@ -116,12 +187,42 @@ func TestValidDelete(t *testing.T) {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	dbMock.ExpectExec(`DELETE FROM urls`).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectBegin()
+	expectSnapshot(1)
+	dbMock.ExpectExec(`UPDATE urls SET deletedAt = \? WHERE id = \?`).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditEvent(1, entities.AuditDelete)
+	dbMock.ExpectCommit()
+
+	err = repo.Delete(context.Background(), 1, testActor)
+	if err != nil {
+		t.Fatalf("unable to execute delete call: %s", err.Error())
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestNotFoundDelete(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	// nothing is changed or audited for a missing or already deleted url
+	dbMock.ExpectBegin()
+	expectSnapshot(0)
+	dbMock.ExpectRollback()
 
-	err = repo.Delete(1)
+	err = repo.Delete(context.Background(), 1, testActor)
 	if err != nil {
 		t.Fatalf("unable to execute delete call: %s", err.Error())
 	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
 }
 
 func TestErrorDelete(t *testing.T) {
@@ -132,188 +233,1394 @@ func TestErrorDelete(t *testing.T) {
 	}
 
 	deleteErr := fmt.Errorf("erorr executing delete query")
-	dbMock.ExpectExec(`DELETE FROM urls`).WithArgs(1).WillReturnError(deleteErr)
+	dbMock.ExpectBegin()
+	expectSnapshot(1)
+	dbMock.ExpectExec(`UPDATE urls SET deletedAt`).WithArgs(sqlmock.AnyArg(), 1).WillReturnError(deleteErr)
+	dbMock.ExpectRollback()
 
-	err = repo.Delete(1)
+	err = repo.Delete(context.Background(), 1, testActor)
 	if err == nil {
 		t.Errorf("expected error (%v), got error nil", deleteErr)
 	}
 }
 
-func TestValidGetUrlByCode(t *testing.T){
+func TestAuditErrorDelete(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	rows := sqlmock.NewRows([]string{"url"})
-	rows.AddRow("84gfj4i9")
+	// the deletion is rolled back when its audit event can't be written
+	auditErr := fmt.Errorf("error executing audit insert query")
+	dbMock.ExpectBegin()
+	expectSnapshot(1)
+	dbMock.ExpectExec(`UPDATE urls SET deletedAt`).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectExec(`INSERT INTO audit_events`).WillReturnError(auditErr)
+	dbMock.ExpectRollback()
 
-	dbMock.ExpectQuery(`SELECT`).WillReturnRows(rows)
+	err = repo.Delete(context.Background(), 1, testActor)
+	if err == nil {
+		t.Errorf("expected error (%v), got error nil", auditErr)
+	}
 
-	_, err = repo.GetUrlByCode("84gfj4i9")
-	if err != nil{
-		t.Fatalf("unable to execute get by code call: %s", err.Error())
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
 	}
 }
 
-func TestNoRowsGetUrlByCode(t *testing.T){
+func TestRestore(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	dbMock.ExpectQuery(`SELECT`).WillReturnError(sql.ErrNoRows)
+	restoreErr := fmt.Errorf("error executing restore query")
 
-	_, err = repo.GetUrlByCode("84gfj4i9")
-	if err != nil{
-		t.Fatalf("expected no error, got: %s", err.Error())
+	testCases := []struct {
+		name     string
+		id       int64
+		err      error
+		restored bool
+		isError  bool
+	}{
+		{
+			name:     "deleted url",
+			id:       1,
+			restored: true,
+		},
+		{
+			name:     "no deleted url",
+			id:       0,
+			restored: false,
+		},
+		{
+			name:    "query error",
+			id:      1,
+			err:     restoreErr,
+			isError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dbMock.ExpectBegin()
+			expectSnapshot(tc.id)
+			if tc.id != 0 {
+				exp := dbMock.ExpectExec(`UPDATE urls SET deletedAt = NULL WHERE id = \?`).WithArgs(tc.id)
+				if tc.err != nil {
+					exp.WillReturnError(tc.err)
+				} else {
+					exp.WillReturnResult(sqlmock.NewResult(0, 1))
+					expectAuditEvent(tc.id, entities.AuditRestore)
+				}
+			}
+
+			if tc.restored {
+				dbMock.ExpectCommit()
+			} else {
+				dbMock.ExpectRollback()
+			}
+
+			restored, err := repo.Restore(context.Background(), tc.id, testActor)
+
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+
+			if restored != tc.restored {
+				t.Errorf("expected restored (%v), got (%v)", tc.restored, restored)
+			}
+
+			if err = dbMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled expectations: %s", err.Error())
+			}
+		})
 	}
 }
 
-func TestErrorGetUrlByCode(t *testing.T){
+func TestGetDeleted(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	queryErr := fmt.Errorf("error fetching data")
+	deletedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"id", "code", "url", "shortUrl", "domain", "counter", "createdAt", "redirectType", "forwardQuery", "prefixMode", "rules", "maxClicks", "activeFrom", "activeUntil", "fallbackUrl", "deletedAt", "owner", "tags"})
+	rows.AddRow("2", "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "3", time.Now(), "302", "0", "0", "", "0", nil, nil, "", deletedAt, "anonymous", "")
+	rows.AddRow("1", "a1b2c3d4", "https://example.com", "http://localhost/a1b2c3d4", "http://localhost", "0", time.Now(), "302", "0", "0", "", "0", nil, nil, "", deletedAt, "anonymous", "")
+
+	dbMock.ExpectQuery(`SELECT .* FROM urls WHERE deletedAt IS NOT NULL ORDER BY deletedAt DESC`).WillReturnRows(rows)
+
+	variants := sqlmock.NewRows([]string{"id", "url", "weight", "counter"})
+	variants.AddRow("10", "https://google.com/a", "70", "3")
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(2).WillReturnRows(variants)
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "weight", "counter"}))
+
+	urls, err := repo.GetDeleted(context.Background())
+	if err != nil {
+		t.Fatalf("unable to execute get deleted call: %s", err.Error())
+	}
+
+	if len(urls) != 2 || urls[0].Id != 2 || len(urls[0].Variants) != 1 {
+		t.Fatalf("expected urls (2, 1) with variants, got (%v)", urls)
+	}
+
+	if urls[0].DeletedAt == nil || !urls[0].DeletedAt.Equal(deletedAt) {
+		t.Errorf("expected deleted at (%v), got (%v)", deletedAt, urls[0].DeletedAt)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestErrorGetDeleted(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	queryErr := fmt.Errorf("error executing select query")
 	dbMock.ExpectQuery(`SELECT`).WillReturnError(queryErr)
 
-	_, err = repo.GetUrlByCode("84gfj4i9")
-	if err == nil{
+	_, err = repo.GetDeleted(context.Background())
+	if err == nil {
 		t.Errorf("expected error (%v), got error nil", queryErr)
 	}
 }
 
-func TestValidGetById(t *testing.T){
+func TestValidPurgeDeleted(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	rows := sqlmock.NewRows([]string{"id", "code", "url", "shortUrl", "domain", "counter"})
-	rows.AddRow("1", "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "0")
+	before := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`DELETE FROM url_variants WHERE urlId IN \(SELECT id FROM urls WHERE deletedAt IS NOT NULL AND deletedAt < \?\)`).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 4))
+	dbMock.ExpectExec(`DELETE FROM urls WHERE deletedAt IS NOT NULL AND deletedAt < \?`).WithArgs(before).WillReturnResult(sqlmock.NewResult(0, 2))
+	dbMock.ExpectCommit()
 
-	dbMock.ExpectQuery(`SELECT`).WillReturnRows(rows)
+	n, err := repo.PurgeDeleted(context.Background(), before)
+	if err != nil {
+		t.Fatalf("unable to execute purge call: %s", err.Error())
+	}
 
-	_, err = repo.GetById(1)
-	if err != nil{
-		t.Fatalf("unable to execute get by code call: %s", err.Error())
+	if n != 2 {
+		t.Errorf("expected (2) purged urls, got (%d)", n)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
 	}
 }
 
-func TestNoRowsGetById(t *testing.T){
+func TestErrorPurgeDeleted(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	dbMock.ExpectQuery(`SELECT`).WillReturnError(sql.ErrNoRows)
+	deleteErr := fmt.Errorf("error executing delete query")
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`DELETE FROM url_variants`).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec(`DELETE FROM urls`).WillReturnError(deleteErr)
+	dbMock.ExpectRollback()
 
-	_, err = repo.GetById(0)
-	if err != nil{
-		t.Fatalf("expected no error, got: %s", err.Error())
+	_, err = repo.PurgeDeleted(context.Background(), time.Now())
+	if err == nil {
+		t.Errorf("expected error (%v), got error nil", deleteErr)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
 	}
 }
 
-func TestErrorGetById(t *testing.T){
+func TestCodeExists(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	queryErr := fmt.Errorf("error fetching data")
-	dbMock.ExpectQuery(`SELECT`).WillReturnError(queryErr)
+	// the count doesn't filter the deleted urls, their codes stay reserved until they are purged
+	dbMock.ExpectQuery(`SELECT COUNT\(\*\) FROM urls WHERE code = \?$`).WithArgs("84gfj4i9").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
 
-	_, err = repo.GetById(1)
-	if err == nil{
+	exists, err := repo.CodeExists(context.Background(), "84gfj4i9")
+	if err != nil {
+		t.Fatalf("unable to execute code exists call: %s", err.Error())
+	}
+
+	if !exists {
+		t.Errorf("expected code to exist")
+	}
+
+	queryErr := fmt.Errorf("error executing count query")
+	dbMock.ExpectQuery(`SELECT COUNT`).WithArgs("a1b2c3d4").WillReturnError(queryErr)
+
+	if _, err = repo.CodeExists(context.Background(), "a1b2c3d4"); err == nil {
 		t.Errorf("expected error (%v), got error nil", queryErr)
 	}
 }
 
-func TestValidGetByUrl(t *testing.T){
+func TestValidUpdate(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	rows := sqlmock.NewRows([]string{"id", "code", "url", "shortUrl", "domain", "counter"})
-	rows.AddRow("1", "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "0")
+	u := entities.Url{
+		Id:           1,
+		Url:          "https://google.com",
+		RedirectType: entities.RedirectPermanent,
+		PrefixMode:   true,
+		Tags:         []string{"newsletter"},
+	}
 
-	dbMock.ExpectQuery(`SELECT`).WillReturnRows(rows)
+	dbMock.ExpectBegin()
+	expectSnapshot(1)
+	dbMock.ExpectExec(`UPDATE urls`).WithArgs(u.Url, u.RedirectType, u.ForwardQuery, u.PrefixMode, sqlmock.AnyArg(), u.MaxClicks, sqlmock.AnyArg(), sqlmock.AnyArg(), u.FallbackUrl, `["newsletter"]`, u.Id).WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`DELETE FROM url_variants`).WithArgs(u.Id).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectAuditEvent(1, entities.AuditUpdate)
+	dbMock.ExpectCommit()
 
-	_, err = repo.GetByUrl("https://google.com")
-	if err != nil{
-		t.Fatalf("unable to execute get by code call: %s", err.Error())
+	err = repo.Update(context.Background(), &u, testActor)
+	if err != nil {
+		t.Fatalf("unable to execute update call: %s", err.Error())
 	}
 }
 
-func TestNoRowsGetByUrl(t *testing.T){
+func TestValidUpdateVariants(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	dbMock.ExpectQuery(`SELECT`).WillReturnError(sql.ErrNoRows)
+	u := entities.Url{
+		Id:  1,
+		Url: "https://google.com",
+		Variants: []entities.Variant{
+			{Id: 10, Url: "https://google.com/a", Weight: 50},
+			{Url: "https://google.com/c", Weight: 50},
+		},
+	}
 
-	_, err = repo.GetByUrl("https://google1.com")
-	if err != nil{
-		t.Fatalf("expected no error, got: %s", err.Error())
+	dbMock.ExpectBegin()
+	expectSnapshot(1)
+	dbMock.ExpectExec(`UPDATE urls`).WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`DELETE FROM url_variants WHERE urlId = \? AND id NOT IN \(\?\)`).WithArgs(1, 10).WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectExec(`UPDATE url_variants`).WithArgs("https://google.com/a", 50, 10, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectExec(`INSERT INTO url_variants`).WithArgs(1, "https://google.com/c", 50, 0).WillReturnResult(sqlmock.NewResult(12, 1))
+	expectAuditEvent(1, entities.AuditUpdate)
+	dbMock.ExpectCommit()
+
+	err = repo.Update(context.Background(), &u, testActor)
+	if err != nil {
+		t.Fatalf("unable to execute update call: %s", err.Error())
+	}
+
+	if u.Variants[1].Id != 12 {
+		t.Errorf("expected new variant id (12), got (%d)", u.Variants[1].Id)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
 	}
 }
 
-func TestErrorGetByUrl(t *testing.T){
+func TestUnknownVariantUpdate(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	queryErr := fmt.Errorf("error fetching data")
-	dbMock.ExpectQuery(`SELECT`).WillReturnError(queryErr)
+	u := entities.Url{
+		Id:       1,
+		Url:      "https://google.com",
+		Variants: []entities.Variant{{Id: 99, Url: "https://google.com/a", Weight: 1}},
+	}
 
-	_, err = repo.GetByUrl("https://google.com")
-	if err == nil{
+	dbMock.ExpectBegin()
+	expectSnapshot(1)
+	dbMock.ExpectExec(`UPDATE urls`).WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`DELETE FROM url_variants`).WithArgs(1, 99).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec(`UPDATE url_variants`).WithArgs("https://google.com/a", 1, 99, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectRollback()
+
+	err = repo.Update(context.Background(), &u, testActor)
+	if err == nil {
+		t.Errorf("expected unknown variant error, got nil")
+	}
+}
+
+func TestErrorUpdate(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	u := entities.Url{Id: 1, Url: "https://google.com"}
+
+	updateErr := fmt.Errorf("error executing update query")
+	dbMock.ExpectBegin()
+	expectSnapshot(1)
+	dbMock.ExpectExec(`UPDATE urls`).WithArgs(u.Url, u.RedirectType, u.ForwardQuery, u.PrefixMode, sqlmock.AnyArg(), u.MaxClicks, sqlmock.AnyArg(), sqlmock.AnyArg(), u.FallbackUrl, sqlmock.AnyArg(), u.Id).WillReturnError(updateErr)
+	dbMock.ExpectRollback()
+
+	err = repo.Update(context.Background(), &u, testActor)
+	if err == nil {
+		t.Errorf("expected error (%v), got error nil", updateErr)
+	}
+}
+
+func TestNotFoundUpdate(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectBegin()
+	expectSnapshot(0)
+	dbMock.ExpectRollback()
+
+	err = repo.Update(context.Background(), &entities.Url{Id: 1, Url: "https://google.com"}, testActor)
+	if err == nil {
+		t.Errorf("expected url not found error, got nil")
+	}
+}
+
+func TestExportUrls(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(urlRowColumns)
+	rows.AddRow(1, "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "3", from, "302", "0", "0", "", "0", nil, nil, "", nil, "anonymous", `["newsletter"]`)
+	rows.AddRow(2, "a1b2c3d4", "https://example.com", "http://localhost/a1b2c3d4", "http://localhost", "0", from, "302", "0", "0", "", "0", nil, nil, "", nil, "anonymous", "")
+
+	dbMock.ExpectQuery(`SELECT .* FROM urls WHERE id > \? AND owner = \? AND createdAt >= \? AND deletedAt IS NULL ORDER BY id LIMIT \?`).
+		WithArgs(0, "anonymous", from, exportPageSize).WillReturnRows(rows)
+
+	variants := sqlmock.NewRows([]string{"id", "url", "weight", "counter"})
+	variants.AddRow("10", "https://google.com/a", "70", "3")
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(1).WillReturnRows(variants)
+
+	var urls []entities.Url
+	err = repo.ExportUrls(context.Background(), entities.ExportFilter{Owner: "anonymous", Tag: "newsletter", From: &from}, func(u entities.Url) error {
+		urls = append(urls, u)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to execute export urls call: %s", err.Error())
+	}
+
+	if len(urls) != 1 || urls[0].Id != 1 || len(urls[0].Variants) != 1 {
+		t.Fatalf("expected the url (1) with its variant, got (%v)", urls)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestPagesExportUrls(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	page := func(first, n int) *sqlmock.Rows {
+		rows := sqlmock.NewRows(urlRowColumns)
+		for id := first; id < first+n; id++ {
+			rows.AddRow(id, fmt.Sprintf("%08d", id), "https://google.com", "", "http://localhost", "0", time.Now(), "302", "0", "0", "", "0", nil, nil, "", nil, "anonymous", "")
+		}
+
+		return rows
+	}
+
+	dbMock.ExpectQuery(`SELECT .* FROM urls WHERE id > \? AND deletedAt IS NULL ORDER BY id LIMIT \?`).WithArgs(0, exportPageSize).WillReturnRows(page(1, exportPageSize))
+	for id := 1; id <= exportPageSize; id++ {
+		dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "weight", "counter"}))
+	}
+
+	dbMock.ExpectQuery(`SELECT .* FROM urls WHERE id > \?`).WithArgs(exportPageSize, exportPageSize).WillReturnRows(page(exportPageSize+1, 1))
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(exportPageSize + 1).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "weight", "counter"}))
+
+	n := 0
+	err = repo.ExportUrls(context.Background(), entities.ExportFilter{}, func(u entities.Url) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to execute export urls call: %s", err.Error())
+	}
+
+	if n != exportPageSize+1 {
+		t.Errorf("expected (%d) urls, got (%d)", exportPageSize+1, n)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestErrorExportUrls(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	queryErr := fmt.Errorf("error executing select query")
+	dbMock.ExpectQuery(`SELECT .* FROM urls WHERE id > \? ORDER BY id`).WillReturnError(queryErr)
+
+	err = repo.ExportUrls(context.Background(), entities.ExportFilter{Deleted: true}, func(u entities.Url) error { return nil })
+	if err == nil {
 		t.Errorf("expected error (%v), got error nil", queryErr)
 	}
+
+	rows := sqlmock.NewRows(urlRowColumns)
+	rows.AddRow(1, "84gfj4i9", "https://google.com", "", "http://localhost", "0", time.Now(), "302", "0", "0", "", "0", nil, nil, "", nil, "anonymous", "")
+	rows.AddRow(2, "a1b2c3d4", "https://example.com", "", "http://localhost", "0", time.Now(), "302", "0", "0", "", "0", nil, nil, "", nil, "anonymous", "")
+	dbMock.ExpectQuery(`SELECT .* FROM urls`).WillReturnRows(rows)
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "weight", "counter"}))
+
+	// an error of the callback stops the export
+	writeErr := fmt.Errorf("unable to write the url")
+	calls := 0
+	err = repo.ExportUrls(context.Background(), entities.ExportFilter{}, func(u entities.Url) error {
+		calls++
+		return writeErr
+	})
+	if err != writeErr || calls != 1 {
+		t.Errorf("expected error (%v) after one url, got (%v) after (%d)", writeErr, err, calls)
+	}
 }
 
-func TestValidIncrementCounter(t *testing.T){
+func TestOverwrite(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	dbMock.ExpectExec(`UPDATE urls`).WithArgs("www.test.com").WillReturnResult(sqlmock.NewResult(1, 1))
+	createdAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	u := entities.Url{
+		Code:         "84gfj4i9",
+		Url:          "https://google.ro",
+		ShortUrl:     "http://localhost/84gfj4i9",
+		Domain:       "http://localhost",
+		Counter:      42,
+		CreatedAt:    createdAt,
+		RedirectType: entities.RedirectFound,
+		Owner:        "apikey:1a2b3c",
+		Variants:     []entities.Variant{{Url: "https://google.ro/a", Weight: 100, Counter: 40}},
+	}
 
-	err = repo.IncrementCounter("www.test.com")
-	if err != nil{
-		t.Errorf("expected no error, got: %s", err.Error())
+	dbMock.ExpectBegin()
+	rows := sqlmock.NewRows(urlRowColumns)
+	rows.AddRow(7, "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "3", time.Now(), "302", "0", "0", "", "0", nil, nil, "", time.Now(), "anonymous", "")
+	dbMock.ExpectQuery(`SELECT .* FROM urls WHERE code = \?`).WithArgs(u.Code).WillReturnRows(rows)
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"id", "url", "weight", "counter"}))
+	dbMock.ExpectExec(`UPDATE urls SET .* deletedAt = NULL`).
+		WithArgs(u.Url, u.Counter, u.ShortUrl, u.Domain, createdAt, u.RedirectType, false, false, sqlmock.AnyArg(), int64(0), sqlmock.AnyArg(), sqlmock.AnyArg(), "", u.Owner, sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectExec(`DELETE FROM url_variants WHERE urlId = \?`).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	dbMock.ExpectExec(`INSERT INTO url_variants`).WithArgs(7, "https://google.ro/a", 100, 40).WillReturnResult(sqlmock.NewResult(12, 1))
+	expectAuditEvent(7, entities.AuditUpdate)
+	dbMock.ExpectCommit()
+
+	if err = repo.Overwrite(context.Background(), &u, testActor); err != nil {
+		t.Fatalf("unable to execute overwrite call: %s", err.Error())
+	}
+
+	if u.Id != 7 || u.Variants[0].Id != 12 {
+		t.Errorf("expected url (7) with variant (12), got (%d) with (%d)", u.Id, u.Variants[0].Id)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
 	}
 }
 
-func TestErrorIncrementCounter(t *testing.T){
+func TestNotFoundOverwrite(t *testing.T) {
 	SqlOpen = MockOpener
 	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
 	if err != nil {
 		t.Fatalf("unable to create mock repository: %s", err.Error())
 	}
 
-	updateErr := fmt.Errorf("error executing update query")
-	dbMock.ExpectExec(`UPDATE urls`).WithArgs("www.test.com").WillReturnError(updateErr)
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`SELECT .* FROM urls WHERE code = \?`).WithArgs("84gfj4i9").WillReturnRows(sqlmock.NewRows(urlRowColumns))
+	dbMock.ExpectRollback()
 
-	err = repo.IncrementCounter("www.test.com")
-	if err == nil{
-		t.Errorf("expected error (%v), got error nil", updateErr)
+	if err = repo.Overwrite(context.Background(), &entities.Url{Code: "84gfj4i9"}, testActor); err == nil {
+		t.Errorf("expected url not found error, got nil")
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestRekey(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	u := entities.Url{Id: 1, Code: "n3wc0d3x", ShortUrl: "http://localhost/n3wc0d3x"}
+
+	dbMock.ExpectBegin()
+	expectSnapshot(1)
+	dbMock.ExpectExec(`UPDATE urls SET code = \?, shortUrl = \? WHERE id = \?`).WithArgs(u.Code, u.ShortUrl, u.Id).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditEvent(1, entities.AuditUpdate)
+	dbMock.ExpectCommit()
+
+	if err = repo.Rekey(context.Background(), &u, testActor); err != nil {
+		t.Fatalf("unable to execute rekey call: %s", err.Error())
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
 	}
-}
\ No newline at end of file
+}
+
+func TestNotFoundRekey(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectBegin()
+	expectSnapshot(0)
+	dbMock.ExpectRollback()
+
+	if err = repo.Rekey(context.Background(), &entities.Url{Id: 2, Code: "n3wc0d3x"}, testActor); err == nil {
+		t.Errorf("expected url not found error, got nil")
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectQuery(`PRAGMA user_version`).WillReturnRows(sqlmock.NewRows([]string{"user_version"}).AddRow(12))
+
+	version, err := SchemaVersion(repo.Handler)
+	if err != nil || version != 12 {
+		t.Errorf("expected version (12), got (%d) (%v)", version, err)
+	}
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := from.Add(24 * time.Hour)
+
+	rows := sqlmock.NewRows([]string{"id", "urlId", "action", "actor", "clientIp", "requestId", "before", "after", "createdAt"})
+	rows.AddRow(2, 1, entities.AuditUpdate, testActor.Name, testActor.ClientIp, testActor.RequestId, `{"id":1,"url":"https://google.com"}`, `{"id":1,"url":"https://google.ro"}`, from.Add(time.Hour))
+	rows.AddRow(1, 1, entities.AuditCreate, testActor.Name, testActor.ClientIp, testActor.RequestId, "", `{"id":1,"url":"https://google.com"}`, from)
+
+	dbMock.ExpectQuery(`SELECT id, urlId, action, actor, clientIp, requestId, before, after, createdAt FROM audit_events WHERE urlId = \? AND actor = \? AND createdAt >= \? AND createdAt < \? ORDER BY createdAt DESC, id DESC LIMIT \?`).
+		WithArgs(1, testActor.Name, from, until, 10).WillReturnRows(rows)
+
+	events, err := repo.GetAuditEvents(context.Background(), entities.AuditFilter{UrlId: 1, Actor: testActor.Name, From: &from, Until: &until, Limit: 10})
+	if err != nil {
+		t.Fatalf("unable to execute get audit events call: %s", err.Error())
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected (2) events, got (%d)", len(events))
+	}
+
+	if events[0].Before == nil || events[0].Before.Url != "https://google.com" || events[0].After.Url != "https://google.ro" {
+		t.Errorf("expected update snapshots, got before (%v) after (%v)", events[0].Before, events[0].After)
+	}
+
+	if events[1].Before != nil {
+		t.Errorf("expected no snapshot before the create event, got (%v)", events[1].Before)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestErrorGetAuditEvents(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	queryErr := fmt.Errorf("error executing select query")
+	dbMock.ExpectQuery(`SELECT .* FROM audit_events ORDER BY`).WillReturnError(queryErr)
+
+	_, err = repo.GetAuditEvents(context.Background(), entities.AuditFilter{})
+	if err == nil {
+		t.Errorf("expected error (%v), got error nil", queryErr)
+	}
+}
+
+func TestGetCounters(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	rows := sqlmock.NewRows([]string{"code", "counter"})
+	rows.AddRow("84gfj4i9", 100)
+	dbMock.ExpectQuery(`SELECT code, counter FROM urls WHERE code IN \(\?, \?\)`).WithArgs("84gfj4i9", "a1b2c3d4").WillReturnRows(rows)
+
+	counters, err := repo.GetCounters(context.Background(), []string{"84gfj4i9", "a1b2c3d4"})
+	if err != nil {
+		t.Fatalf("unable to execute get counters call: %s", err.Error())
+	}
+
+	if len(counters) != 1 || counters["84gfj4i9"] != 100 {
+		t.Errorf("expected the counter (100) of (84gfj4i9) only, got (%v)", counters)
+	}
+
+	if counters, err = repo.GetCounters(context.Background(), nil); err != nil || len(counters) != 0 {
+		t.Errorf("expected no counters without codes, got (%v) (%v)", counters, err)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestAddWebhook(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := entities.Webhook{Url: "https://hooks.example.com", Secret: "s3cr3t", Events: []string{entities.WebhookUrlCreated}, CreatedAt: now}
+	dbMock.ExpectExec(`INSERT INTO webhooks \(url, secret, events, createdAt\) VALUES`).
+		WithArgs(w.Url, w.Secret, `["url.created"]`, now).WillReturnResult(sqlmock.NewResult(3, 1))
+
+	if err = repo.AddWebhook(context.Background(), &w); err != nil {
+		t.Fatalf("unable to execute add webhook call: %s", err.Error())
+	}
+
+	if w.Id != 3 {
+		t.Errorf("expected id (3), got (%d)", w.Id)
+	}
+
+	insertErr := fmt.Errorf("error executing insert query")
+	dbMock.ExpectExec(`INSERT INTO webhooks`).WillReturnError(insertErr)
+	if err = repo.AddWebhook(context.Background(), &w); err == nil {
+		t.Errorf("expected error (%v), got error nil", insertErr)
+	}
+}
+
+func TestGetWebhooks(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "url", "secret", "events", "createdAt"})
+	rows.AddRow(1, "https://hooks.example.com", "s3cr3t", `["url.created","url.milestone"]`, time.Now())
+	dbMock.ExpectQuery(`SELECT id, url, secret, events, createdAt FROM webhooks ORDER BY id`).WillReturnRows(rows)
+
+	webhooks, err := repo.GetWebhooks(context.Background())
+	if err != nil {
+		t.Fatalf("unable to execute get webhooks call: %s", err.Error())
+	}
+
+	if len(webhooks) != 1 || webhooks[0].Secret != "s3cr3t" || !webhooks[0].Subscribes(entities.WebhookUrlMilestone) {
+		t.Errorf("expected the webhook with its secret and events, got (%v)", webhooks)
+	}
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	testCases := []struct {
+		name     string
+		affected int64
+		expected bool
+	}{
+		{name: "deleted", affected: 1, expected: true},
+		{name: "not found", affected: 0, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			SqlOpen = MockOpener
+			repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+			if err != nil {
+				t.Fatalf("unable to create mock repository: %s", err.Error())
+			}
+
+			dbMock.ExpectBegin()
+			dbMock.ExpectExec(`DELETE FROM webhook_dead_letters WHERE webhookId = \?`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+			dbMock.ExpectExec(`DELETE FROM webhooks WHERE id = \?`).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, tc.affected))
+			dbMock.ExpectCommit()
+
+			found, err := repo.DeleteWebhook(context.Background(), 1)
+			if err != nil {
+				t.Fatalf("unable to execute delete webhook call: %s", err.Error())
+			}
+
+			if found != tc.expected {
+				t.Errorf("expected found (%v), got (%v)", tc.expected, found)
+			}
+
+			if err = dbMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled expectations: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := entities.DeadLetter{
+		WebhookId: 1,
+		Event:     entities.WebhookEvent{Id: "e1", Type: entities.WebhookUrlCreated, Url: entities.Url{Id: 1, Code: "84gfj4i9"}, Time: now},
+		Attempts:  5,
+		LastError: "unexpected status (500)",
+		FailedAt:  now,
+	}
+
+	dbMock.ExpectExec(`INSERT INTO webhook_dead_letters \(webhookId, event, attempts, lastError, failedAt\) VALUES`).
+		WithArgs(1, sqlmock.AnyArg(), 5, d.LastError, now).WillReturnResult(sqlmock.NewResult(7, 1))
+	if err = repo.AddDeadLetter(context.Background(), &d); err != nil || d.Id != 7 {
+		t.Fatalf("expected the dead letter (7) to be added, got (%d) (%v)", d.Id, err)
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "webhookId", "event", "attempts", "lastError", "failedAt"})
+	rows.AddRow(7, 1, `{"id":"e1","type":"url.created","url":{"id":1,"code":"84gfj4i9"},"time":"2022-01-01T00:00:00Z"}`, 5, d.LastError, now)
+	dbMock.ExpectQuery(`SELECT id, webhookId, event, attempts, lastError, failedAt FROM webhook_dead_letters WHERE webhookId = \? ORDER BY failedAt DESC, id DESC`).WithArgs(1).WillReturnRows(rows)
+
+	letters, err := repo.GetDeadLetters(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("unable to execute get dead letters call: %s", err.Error())
+	}
+
+	if len(letters) != 1 || letters[0].Event.Id != "e1" || letters[0].Event.Url.Code != "84gfj4i9" || letters[0].Attempts != 5 {
+		t.Errorf("expected the dead letter of event (e1), got (%v)", letters)
+	}
+
+	dbMock.ExpectQuery(`SELECT .* FROM webhook_dead_letters WHERE id = \?`).WithArgs(8).WillReturnError(sql.ErrNoRows)
+	if missing, err := repo.GetDeadLetter(context.Background(), 8); err != nil || missing.Id != 0 {
+		t.Errorf("expected no dead letter, got (%v) (%v)", missing, err)
+	}
+
+	d.Attempts = 6
+	dbMock.ExpectExec(`UPDATE webhook_dead_letters SET attempts = \?, lastError = \?, failedAt = \? WHERE id = \?`).
+		WithArgs(6, d.LastError, now, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = repo.UpdateDeadLetter(context.Background(), &d); err != nil {
+		t.Errorf("unable to execute update dead letter call: %s", err.Error())
+	}
+
+	dbMock.ExpectExec(`DELETE FROM webhook_dead_letters WHERE id = \?`).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err = repo.DeleteDeadLetter(context.Background(), 7); err != nil {
+		t.Errorf("unable to execute delete dead letter call: %s", err.Error())
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestValidGetById(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "code", "url", "shortUrl", "domain", "counter", "createdAt", "redirectType", "forwardQuery", "prefixMode", "rules", "maxClicks", "activeFrom", "activeUntil", "fallbackUrl", "deletedAt", "owner", "tags"})
+	rows.AddRow("1", "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "0", time.Now(), "302", "0", "1", `[{"url":"https://apps.apple.com/app","platforms":["ios"]}]`, "0", nil, time.Now().Add(time.Hour), "https://google.com/fallback", nil, "anonymous", "")
+
+	dbMock.ExpectQuery(`SELECT`).WillReturnRows(rows)
+
+	variants := sqlmock.NewRows([]string{"id", "url", "weight", "counter"})
+	variants.AddRow("10", "https://google.com/a", "70", "3")
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(1).WillReturnRows(variants)
+
+	u, err := repo.GetById(context.Background(), 1)
+	if err != nil{
+		t.Fatalf("unable to execute get by code call: %s", err.Error())
+	}
+
+	if u.ActiveFrom != nil || u.ActiveUntil == nil || u.FallbackUrl != "https://google.com/fallback" {
+		t.Errorf("expected activation window until (%v) with fallback url, got from (%v) until (%v) fallback (%s)", time.Now().Add(time.Hour), u.ActiveFrom, u.ActiveUntil, u.FallbackUrl)
+	}
+}
+
+func TestNoRowsGetById(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectQuery(`SELECT`).WillReturnError(sql.ErrNoRows)
+
+	_, err = repo.GetById(context.Background(), 0)
+	if err != nil{
+		t.Fatalf("expected no error, got: %s", err.Error())
+	}
+}
+
+func TestTimeoutGetById(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	rows := sqlmock.NewRows([]string{"id"})
+	dbMock.ExpectQuery(`SELECT`).WillDelayFor(time.Second).WillReturnRows(rows)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = repo.GetById(ctx, 1)
+	if err == nil{
+		t.Errorf("expected error (%v), got error nil", context.DeadlineExceeded)
+	}
+}
+
+func TestErrorGetById(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	queryErr := fmt.Errorf("error fetching data")
+	dbMock.ExpectQuery(`SELECT`).WillReturnError(queryErr)
+
+	_, err = repo.GetById(context.Background(), 1)
+	if err == nil{
+		t.Errorf("expected error (%v), got error nil", queryErr)
+	}
+}
+
+func TestValidGetByCode(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "code", "url", "shortUrl", "domain", "counter", "createdAt", "redirectType", "forwardQuery", "prefixMode", "rules", "maxClicks", "activeFrom", "activeUntil", "fallbackUrl", "deletedAt", "owner", "tags"})
+	rows.AddRow("1", "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "0", time.Now(), "302", "0", "1", `[{"url":"https://apps.apple.com/app","platforms":["ios"]}]`, "0", nil, nil, "", nil, "anonymous", `["newsletter"]`)
+
+	dbMock.ExpectQuery(`SELECT .* FROM urls WHERE deletedAt IS NULL AND code = \?`).WithArgs("84gfj4i9").WillReturnRows(rows)
+
+	variants := sqlmock.NewRows([]string{"id", "url", "weight", "counter"})
+	variants.AddRow("10", "https://google.com/a", "70", "3")
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(1).WillReturnRows(variants)
+
+	u, err := repo.GetByCode(context.Background(), "84gfj4i9")
+	if err != nil{
+		t.Fatalf("unable to execute get by code call: %s", err.Error())
+	}
+
+	if u.Id != 1 {
+		t.Errorf("expected url id (1), got (%d)", u.Id)
+	}
+
+	if len(u.Rules) != 1 || u.Rules[0].Platforms[0] != entities.PlatformIOS {
+		t.Errorf("expected decoded rules, got (%v)", u.Rules)
+	}
+
+	if len(u.Variants) != 1 || u.Variants[0].Counter != 3 {
+		t.Errorf("expected loaded variants, got (%v)", u.Variants)
+	}
+
+	if u.Owner != entities.AnonymousActor || !u.HasTag("newsletter") {
+		t.Errorf("expected owner (anonymous) and tag (newsletter), got (%s) and (%v)", u.Owner, u.Tags)
+	}
+}
+
+func TestInvalidRulesGetByCode(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "code", "url", "shortUrl", "domain", "counter", "createdAt", "redirectType", "forwardQuery", "prefixMode", "rules", "maxClicks", "activeFrom", "activeUntil", "fallbackUrl", "deletedAt", "owner", "tags"})
+	rows.AddRow("1", "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "0", time.Now(), "302", "0", "1", `{"url"`, "0", nil, nil, "", nil, "anonymous", "")
+
+	dbMock.ExpectQuery(`SELECT`).WithArgs("84gfj4i9").WillReturnRows(rows)
+
+	_, err = repo.GetByCode(context.Background(), "84gfj4i9")
+	if err == nil{
+		t.Errorf("expected rules decode error, got nil")
+	}
+}
+
+func TestErrorVariantsGetByCode(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "code", "url", "shortUrl", "domain", "counter", "createdAt", "redirectType", "forwardQuery", "prefixMode", "rules", "maxClicks", "activeFrom", "activeUntil", "fallbackUrl", "deletedAt", "owner", "tags"})
+	rows.AddRow("1", "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "0", time.Now(), "302", "0", "0", "", "0", nil, nil, "", nil, "anonymous", "")
+
+	queryErr := fmt.Errorf("error fetching variants")
+	dbMock.ExpectQuery(`SELECT`).WithArgs("84gfj4i9").WillReturnRows(rows)
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(1).WillReturnError(queryErr)
+
+	_, err = repo.GetByCode(context.Background(), "84gfj4i9")
+	if err == nil{
+		t.Errorf("expected error (%v), got error nil", queryErr)
+	}
+}
+
+func TestNoRowsGetByCode(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectQuery(`SELECT`).WillReturnError(sql.ErrNoRows)
+
+	u, err := repo.GetByCode(context.Background(), "84gfj4i9")
+	if err != nil{
+		t.Fatalf("expected no error, got: %s", err.Error())
+	}
+
+	if u.Id != 0 {
+		t.Errorf("expected empty url, got (%v)", u)
+	}
+}
+
+func TestErrorGetByCode(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	queryErr := fmt.Errorf("error fetching data")
+	dbMock.ExpectQuery(`SELECT`).WillReturnError(queryErr)
+
+	_, err = repo.GetByCode(context.Background(), "84gfj4i9")
+	if err == nil{
+		t.Errorf("expected error (%v), got error nil", queryErr)
+	}
+}
+
+func TestValidGetByUrl(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	rows := sqlmock.NewRows([]string{"id", "code", "url", "shortUrl", "domain", "counter", "createdAt", "redirectType", "forwardQuery", "prefixMode", "rules", "maxClicks", "activeFrom", "activeUntil", "fallbackUrl", "deletedAt", "owner", "tags"})
+	rows.AddRow("1", "84gfj4i9", "https://google.com", "http://localhost/84gfj4i9", "http://localhost", "0", time.Now(), "302", "0", "1", `[{"url":"https://apps.apple.com/app","platforms":["ios"]}]`, "0", nil, nil, "", nil, "anonymous", "")
+
+	dbMock.ExpectQuery(`SELECT`).WillReturnRows(rows)
+
+	variants := sqlmock.NewRows([]string{"id", "url", "weight", "counter"})
+	variants.AddRow("10", "https://google.com/a", "70", "3")
+	dbMock.ExpectQuery(`SELECT id, url, weight, counter FROM url_variants`).WithArgs(1).WillReturnRows(variants)
+
+	_, err = repo.GetByUrl(context.Background(), "https://google.com")
+	if err != nil{
+		t.Fatalf("unable to execute get by code call: %s", err.Error())
+	}
+}
+
+func TestNoRowsGetByUrl(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectQuery(`SELECT`).WillReturnError(sql.ErrNoRows)
+
+	_, err = repo.GetByUrl(context.Background(), "https://google1.com")
+	if err != nil{
+		t.Fatalf("expected no error, got: %s", err.Error())
+	}
+}
+
+func TestErrorGetByUrl(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	queryErr := fmt.Errorf("error fetching data")
+	dbMock.ExpectQuery(`SELECT`).WillReturnError(queryErr)
+
+	_, err = repo.GetByUrl(context.Background(), "https://google.com")
+	if err == nil{
+		t.Errorf("expected error (%v), got error nil", queryErr)
+	}
+}
+
+func TestValidIncrementCounters(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE urls`).WithArgs(5, "84gfj4i9").WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`UPDATE urls`).WithArgs(2, "www.test.com").WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`UPDATE url_variants`).WithArgs(3, 10, "84gfj4i9").WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`UPDATE url_variants`).WithArgs(1, 11, "84gfj4i9").WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectCommit()
+
+	err = repo.IncrementCounters(context.Background(), map[entities.Click]int64{
+		{Code: "www.test.com"}:            2,
+		{Code: "84gfj4i9", VariantId: 11}: 1,
+		{Code: "84gfj4i9", VariantId: 10}: 3,
+		{Code: "84gfj4i9"}:                1,
+	})
+	if err != nil{
+		t.Errorf("expected no error, got: %s", err.Error())
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestEmptyIncrementCounters(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	if err = repo.IncrementCounters(context.Background(), map[entities.Click]int64{}); err != nil{
+		t.Errorf("expected no error, got: %s", err.Error())
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestErrorIncrementCounters(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	updateErr := fmt.Errorf("error executing update query")
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`UPDATE urls`).WithArgs(1, "www.test.com").WillReturnError(updateErr)
+	dbMock.ExpectRollback()
+
+	err = repo.IncrementCounters(context.Background(), map[entities.Click]int64{{Code: "www.test.com"}: 1})
+	if err == nil{
+		t.Errorf("expected error (%v), got error nil", updateErr)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestValidIncrementCountersBatch(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`INSERT OR IGNORE INTO counter_batches`).WithArgs("batch1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`UPDATE urls`).WithArgs(2, "84gfj4i9").WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`UPDATE url_variants`).WithArgs(1, 10, "84gfj4i9").WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`DELETE FROM counter_batches`).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectCommit()
+
+	err = repo.IncrementCountersBatch(context.Background(), "batch1", map[entities.Click]int64{
+		{Code: "84gfj4i9"}:                1,
+		{Code: "84gfj4i9", VariantId: 10}: 1,
+	})
+	if err != nil{
+		t.Errorf("expected no error, got: %s", err.Error())
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestDuplicateIncrementCountersBatch(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`INSERT OR IGNORE INTO counter_batches`).WithArgs("batch1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectRollback()
+
+	err = repo.IncrementCountersBatch(context.Background(), "batch1", map[entities.Click]int64{{Code: "84gfj4i9"}: 1})
+	if err != nil{
+		t.Errorf("expected no error, got: %s", err.Error())
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestErrorIncrementCountersBatch(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	updateErr := fmt.Errorf("error executing update query")
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(`INSERT OR IGNORE INTO counter_batches`).WithArgs("batch1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectExec(`UPDATE urls`).WithArgs(1, "84gfj4i9").WillReturnError(updateErr)
+	dbMock.ExpectRollback()
+
+	err = repo.IncrementCountersBatch(context.Background(), "batch1", map[entities.Click]int64{{Code: "84gfj4i9"}: 1})
+	if err == nil{
+		t.Errorf("expected error (%v), got error nil", updateErr)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestCounterBatchSaved(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	testCases := []struct {
+		name     string
+		count    string
+		err      error
+		expected bool
+		isError  bool
+	}{
+		{name: "saved", count: "1", expected: true},
+		{name: "not saved", count: "0"},
+		{name: "query error", err: fmt.Errorf("error executing select query"), isError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query := dbMock.ExpectQuery(`SELECT COUNT\(\*\) FROM counter_batches WHERE id = \?`).WithArgs("batch1")
+			if tc.err != nil {
+				query.WillReturnError(tc.err)
+			} else {
+				query.WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tc.count))
+			}
+
+			saved, err := repo.CounterBatchSaved(context.Background(), "batch1")
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+
+			if saved != tc.expected {
+				t.Errorf("expected saved (%v), got (%v)", tc.expected, saved)
+			}
+
+			if err = dbMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled expectations: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestValidConsumeClick(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`UPDATE urls SET counter = counter \+ 1 WHERE code = \? AND \(maxClicks = 0 OR counter < maxClicks\)`).WithArgs("84gfj4i9").WillReturnRows(sqlmock.NewRows([]string{"left"}).AddRow("2"))
+	dbMock.ExpectExec(`UPDATE url_variants`).WithArgs(10, "84gfj4i9").WillReturnResult(sqlmock.NewResult(1, 1))
+	dbMock.ExpectCommit()
+
+	left, err := repo.ConsumeClick(context.Background(), entities.Click{Code: "84gfj4i9", VariantId: 10})
+	if err != nil{
+		t.Fatalf("expected no error, got: %s", err.Error())
+	}
+
+	if left != 2 {
+		t.Errorf("expected (2) clicks left, got (%d)", left)
+	}
+
+	if err = dbMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %s", err.Error())
+	}
+}
+
+func TestExhaustedConsumeClick(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`UPDATE urls`).WithArgs("84gfj4i9").WillReturnError(sql.ErrNoRows)
+	dbMock.ExpectRollback()
+
+	left, err := repo.ConsumeClick(context.Background(), entities.Click{Code: "84gfj4i9"})
+	if err != nil{
+		t.Fatalf("expected no error, got: %s", err.Error())
+	}
+
+	if left != -1 {
+		t.Errorf("expected (-1) clicks left, got (%d)", left)
+	}
+}
+
+func TestErrorConsumeClick(t *testing.T){
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	updateErr := fmt.Errorf("error executing update query")
+	dbMock.ExpectBegin()
+	dbMock.ExpectQuery(`UPDATE urls`).WithArgs("84gfj4i9").WillReturnError(updateErr)
+	dbMock.ExpectRollback()
+
+	_, err = repo.ConsumeClick(context.Background(), entities.Click{Code: "84gfj4i9"})
+	if err == nil{
+		t.Errorf("expected error (%v), got error nil", updateErr)
+	}
+}
+
+func TestRemainingClicks(t *testing.T) {
+	SqlOpen = MockOpener
+	repo, err := NewSqliteStorage("./database/sqlite/test.db", 0)
+	if err != nil {
+		t.Fatalf("unable to create mock repository: %s", err.Error())
+	}
+
+	queryErr := fmt.Errorf("error executing select query")
+
+	testCases := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		err     error
+		left    int64
+		isError bool
+	}{
+		{name: "clicks left", rows: sqlmock.NewRows([]string{"left"}).AddRow("3"), left: 3},
+		{name: "exhausted", rows: sqlmock.NewRows([]string{"left"}).AddRow("0"), left: 0},
+		{name: "url not found", err: sql.ErrNoRows, left: 0},
+		{name: "query error", err: queryErr, isError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query := dbMock.ExpectQuery(`SELECT MAX\(maxClicks - counter, 0\) FROM urls WHERE code = \? AND deletedAt IS NULL`).WithArgs("84gfj4i9")
+			if tc.err != nil {
+				query.WillReturnError(tc.err)
+			} else {
+				query.WillReturnRows(tc.rows)
+			}
+
+			left, err := repo.RemainingClicks(context.Background(), "84gfj4i9")
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+
+			if left != tc.left {
+				t.Errorf("expected (%d) clicks left, got (%d)", tc.left, left)
+			}
+
+			if err = dbMock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled expectations: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestIsUnavailable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "no error", err: nil, expected: false},
+		{name: "busy database", err: sqlite3.Error{Code: sqlite3.ErrBusy}, expected: true},
+		{name: "locked table", err: sqlite3.Error{Code: sqlite3.ErrLocked}, expected: true},
+		{name: "constraint", err: sqlite3.Error{Code: sqlite3.ErrConstraint}, expected: false},
+		{name: "storage timeout", err: context.DeadlineExceeded, expected: true},
+		{name: "lost connection", err: driver.ErrBadConn, expected: true},
+		{name: "described busy database", err: fmt.Errorf("unable to start transaction: %s", sqlite3.ErrBusy.Error()), expected: true},
+		{name: "other error", err: sql.ErrNoRows, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if IsUnavailable(tc.err) != tc.expected {
+				t.Errorf("expected (%v), got (%v)", tc.expected, !tc.expected)
+			}
+		})
+	}
+}
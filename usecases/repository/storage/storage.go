@@ -1,13 +1,39 @@
 package storage
 
-import "github.com/norby7/shortening-service/entities"
+import (
+	"context"
+	"github.com/norby7/shortening-service/entities"
+	"time"
+)
 
 type Storage interface{
-	Add(*entities.Url) error
-	Delete(int64) error
-	GetUrlByCode(string) (string, error)
-	GetById(int64) (entities.Url, error)
-	GetByUrl(string) (entities.Url, error)
-	IncrementCounter(string) error
+	Add(context.Context, *entities.Url, entities.Actor) error
+	Delete(context.Context, int64, entities.Actor) error
+	Update(context.Context, *entities.Url, entities.Actor) error
+	GetById(context.Context, int64) (entities.Url, error)
+	GetByCode(context.Context, string) (entities.Url, error)
+	GetByUrl(context.Context, string) (entities.Url, error)
+	IncrementCounters(context.Context, map[entities.Click]int64) error
+	IncrementCountersBatch(context.Context, string, map[entities.Click]int64) error
+	CounterBatchSaved(context.Context, string) (bool, error)
+	ConsumeClick(context.Context, entities.Click) (int64, error)
+	RemainingClicks(context.Context, string) (int64, error)
+	Restore(context.Context, int64, entities.Actor) (bool, error)
+	GetDeleted(context.Context) ([]entities.Url, error)
+	PurgeDeleted(context.Context, time.Time) (int64, error)
+	CodeExists(context.Context, string) (bool, error)
+	GetAuditEvents(context.Context, entities.AuditFilter) ([]entities.AuditEvent, error)
+	GetCounters(context.Context, []string) (map[string]int64, error)
+	AddWebhook(context.Context, *entities.Webhook) error
+	GetWebhooks(context.Context) ([]entities.Webhook, error)
+	DeleteWebhook(context.Context, int64) (bool, error)
+	AddDeadLetter(context.Context, *entities.DeadLetter) error
+	UpdateDeadLetter(context.Context, *entities.DeadLetter) error
+	GetDeadLetter(context.Context, int64) (entities.DeadLetter, error)
+	GetDeadLetters(context.Context, int64) ([]entities.DeadLetter, error)
+	DeleteDeadLetter(context.Context, int64) error
+	ExportUrls(context.Context, entities.ExportFilter, func(entities.Url) error) error
+	Overwrite(context.Context, *entities.Url, entities.Actor) error
+	Rekey(context.Context, *entities.Url, entities.Actor) error
 }
 
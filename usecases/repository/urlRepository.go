@@ -1,80 +1,597 @@
 package repository
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"github.com/go-redis/redis"
 	"github.com/norby7/shortening-service/entities"
 	"github.com/norby7/shortening-service/usecases/repository/cache"
 	"github.com/norby7/shortening-service/usecases/repository/storage"
-	"log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"time"
 )
 
+// DefaultStorageTimeout is the maximum duration of a storage call when the timeouts have no storage timeout
+const DefaultStorageTimeout = 5 * time.Second
+
+// DefaultCacheTimeout is the maximum duration of a cache or redis counters call when the timeouts have no cache timeout
+const DefaultCacheTimeout = 500 * time.Millisecond
+
+// Timeouts limit the duration of every storage and cache call, a shorter deadline of the caller context still applies
+type Timeouts struct {
+	Storage time.Duration
+	Cache   time.Duration
+}
+
 type UrlRepository struct {
 	storage storage.Storage
 	cache   cache.Cache
-	Logger  *log.Logger
+	Logger  *slog.Logger
+	// Counters counts the clicks in redis until FlushCounters moves them into the storage, CountClick fails if it's nil
+	Counters cache.Counters
+	// Timeouts of the storage and cache calls, the zero values use the defaults
+	Timeouts Timeouts
 }
 
+// tracer creates the spans of the Url lookups and of the storage and cache calls
+var tracer = otel.Tracer("github.com/norby7/shortening-service/usecases/repository")
+
+// ErrNoCounters is returned by CountClick when the repository has no redis counters
+var ErrNoCounters = fmt.Errorf("redis counters are not configured")
+
 // NewUrlRepository returns a new UrlRepository object address
-func NewUrlRepository(s storage.Storage, c cache.Cache, l *log.Logger) *UrlRepository {
+func NewUrlRepository(s storage.Storage, c cache.Cache, l *slog.Logger) *UrlRepository {
 	return &UrlRepository{
 		storage: s,
 		cache:   c,
 		Logger:  l,
+		Timeouts: Timeouts{
+			Storage: DefaultStorageTimeout,
+			Cache:   DefaultCacheTimeout,
+		},
+	}
+}
+
+// storageContext returns the context of a storage call, bounded by the storage timeout and traced by a
+// "storage.{op}" child span, the returned function cancels the context and ends the span
+func (r *UrlRepository) storageContext(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout := r.Timeouts.Storage
+	if timeout <= 0 {
+		timeout = DefaultStorageTimeout
+	}
+
+	return callContext(ctx, "storage."+op, timeout, semconv.DBSystemSqlite)
+}
+
+// cacheContext returns the context of a cache or redis counters call, bounded by the cache timeout and traced by a
+// "cache.{op}" child span, the returned function cancels the context and ends the span
+func (r *UrlRepository) cacheContext(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout := r.Timeouts.Cache
+	if timeout <= 0 {
+		timeout = DefaultCacheTimeout
+	}
+
+	return callContext(ctx, "cache."+op, timeout, semconv.DBSystemRedis)
+}
+
+// callContext starts a client span with the given name and attributes and bounds its context by the timeout
+func callContext(ctx context.Context, name string, timeout time.Duration, attrs ...attribute.KeyValue) (context.Context, context.CancelFunc) {
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return ctx, func() {
+		cancel()
+		span.End()
 	}
 }
 
+// recordError marks the span of the context as failed with the given error
+func recordError(ctx context.Context, err error) {
+	span := trace.SpanFromContext(ctx)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
 // Add calls the storage Add function to insert a new Url into the database
-func (r *UrlRepository) Add(u *entities.Url) error {
-	return r.storage.Add(u)
+func (r *UrlRepository) Add(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	ctx, cancel := r.storageContext(ctx, "Add")
+	defer cancel()
+
+	return r.storage.Add(ctx, u, actor)
+}
+
+// Delete calls the storage Delete function to move a Url to the trash
+// The Url code is also removed from the cache so it stops redirecting
+func (r *UrlRepository) Delete(ctx context.Context, id int64, actor entities.Actor) error {
+	ctx, cancel := r.storageContext(ctx, "Delete")
+	defer cancel()
+
+	u, err := r.storage.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err = r.storage.Delete(ctx, id, actor); err != nil {
+		return err
+	}
+
+	r.evict(ctx, u.Code)
+
+	return nil
+}
+
+// Restore calls the storage Restore function to move a Url out of the trash
+func (r *UrlRepository) Restore(ctx context.Context, id int64, actor entities.Actor) (bool, error) {
+	ctx, cancel := r.storageContext(ctx, "Restore")
+	defer cancel()
+
+	return r.storage.Restore(ctx, id, actor)
+}
+
+// GetDeleted calls the storage GetDeleted function to fetch the Urls in the trash
+func (r *UrlRepository) GetDeleted(ctx context.Context) ([]entities.Url, error) {
+	ctx, cancel := r.storageContext(ctx, "GetDeleted")
+	defer cancel()
+
+	return r.storage.GetDeleted(ctx)
+}
+
+// PurgeDeleted calls the storage PurgeDeleted function to permanently remove the Urls deleted before the given time
+func (r *UrlRepository) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	ctx, cancel := r.storageContext(ctx, "PurgeDeleted")
+	defer cancel()
+
+	return r.storage.PurgeDeleted(ctx, before)
+}
+
+// CodeExists calls the storage CodeExists function to check if a Url, deleted or not, uses the given code
+// The cache is not used because it only contains the Urls that are not deleted
+func (r *UrlRepository) CodeExists(ctx context.Context, code string) (bool, error) {
+	ctx, cancel := r.storageContext(ctx, "CodeExists")
+	defer cancel()
+
+	return r.storage.CodeExists(ctx, code)
+}
+
+// GetAuditEvents calls the storage GetAuditEvents function to fetch the recorded changes of the Urls
+func (r *UrlRepository) GetAuditEvents(ctx context.Context, filter entities.AuditFilter) ([]entities.AuditEvent, error) {
+	ctx, cancel := r.storageContext(ctx, "GetAuditEvents")
+	defer cancel()
+
+	return r.storage.GetAuditEvents(ctx, filter)
+}
+
+// GetCounters calls the storage GetCounters function to fetch the saved counters of the Urls with the given codes
+func (r *UrlRepository) GetCounters(ctx context.Context, codes []string) (map[string]int64, error) {
+	ctx, cancel := r.storageContext(ctx, "GetCounters")
+	defer cancel()
+
+	return r.storage.GetCounters(ctx, codes)
 }
 
-// Delete calls the storage Delete function to remove a Url from the database
-func (r *UrlRepository) Delete(id int64) error {
-	return r.storage.Delete(id)
+// AddWebhook calls the storage AddWebhook function to insert a new webhook into the database
+func (r *UrlRepository) AddWebhook(ctx context.Context, w *entities.Webhook) error {
+	ctx, cancel := r.storageContext(ctx, "AddWebhook")
+	defer cancel()
+
+	return r.storage.AddWebhook(ctx, w)
 }
 
-// GetByUrlCode returns a long url either from the cache if it exists or from the storage if it doesn't
-// It adds the code to the cache if it doesn't already exists
-func (r *UrlRepository) GetUrlByCode(code string) (string, error) {
+// GetWebhooks calls the storage GetWebhooks function to fetch every webhook
+func (r *UrlRepository) GetWebhooks(ctx context.Context) ([]entities.Webhook, error) {
+	ctx, cancel := r.storageContext(ctx, "GetWebhooks")
+	defer cancel()
+
+	return r.storage.GetWebhooks(ctx)
+}
+
+// DeleteWebhook calls the storage DeleteWebhook function to remove a webhook and its dead letters
+func (r *UrlRepository) DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	ctx, cancel := r.storageContext(ctx, "DeleteWebhook")
+	defer cancel()
+
+	return r.storage.DeleteWebhook(ctx, id)
+}
+
+// AddDeadLetter calls the storage AddDeadLetter function to save an undelivered webhook event
+func (r *UrlRepository) AddDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	ctx, cancel := r.storageContext(ctx, "AddDeadLetter")
+	defer cancel()
+
+	return r.storage.AddDeadLetter(ctx, d)
+}
+
+// UpdateDeadLetter calls the storage UpdateDeadLetter function to save a failed replay of a dead letter
+func (r *UrlRepository) UpdateDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	ctx, cancel := r.storageContext(ctx, "UpdateDeadLetter")
+	defer cancel()
+
+	return r.storage.UpdateDeadLetter(ctx, d)
+}
+
+// GetDeadLetter calls the storage GetDeadLetter function to fetch an undelivered webhook event
+func (r *UrlRepository) GetDeadLetter(ctx context.Context, id int64) (entities.DeadLetter, error) {
+	ctx, cancel := r.storageContext(ctx, "GetDeadLetter")
+	defer cancel()
+
+	return r.storage.GetDeadLetter(ctx, id)
+}
+
+// GetDeadLetters calls the storage GetDeadLetters function to fetch the undelivered events of a webhook
+func (r *UrlRepository) GetDeadLetters(ctx context.Context, webhookId int64) ([]entities.DeadLetter, error) {
+	ctx, cancel := r.storageContext(ctx, "GetDeadLetters")
+	defer cancel()
+
+	return r.storage.GetDeadLetters(ctx, webhookId)
+}
+
+// DeleteDeadLetter calls the storage DeleteDeadLetter function to remove a replayed dead letter
+func (r *UrlRepository) DeleteDeadLetter(ctx context.Context, id int64) error {
+	ctx, cancel := r.storageContext(ctx, "DeleteDeadLetter")
+	defer cancel()
+
+	return r.storage.DeleteDeadLetter(ctx, id)
+}
+
+// Update calls the storage Update function to save the Url changes into the database
+// The Url code is removed from the cache so the next redirect uses the new values
+func (r *UrlRepository) Update(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	ctx, cancel := r.storageContext(ctx, "Update")
+	defer cancel()
+
+	if err := r.storage.Update(ctx, u, actor); err != nil {
+		return err
+	}
+
+	r.evict(ctx, u.Code)
+
+	return nil
+}
+
+// ExportUrls calls the storage ExportUrls function to pass the urls selected by the filter to fn
+// The export can be long so it only uses the timeout of the given context
+func (r *UrlRepository) ExportUrls(ctx context.Context, filter entities.ExportFilter, fn func(entities.Url) error) error {
+	ctx, span := tracer.Start(ctx, "storage.ExportUrls", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.DBSystemSqlite))
+	defer span.End()
+
+	return r.storage.ExportUrls(ctx, filter, fn)
+}
+
+// Overwrite calls the storage Overwrite function to replace the url with the same code and removes it from the cache
+func (r *UrlRepository) Overwrite(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	ctx, cancel := r.storageContext(ctx, "Overwrite")
+	defer cancel()
+
+	if err := r.storage.Overwrite(ctx, u, actor); err != nil {
+		return err
+	}
+
+	r.evict(ctx, u.Code)
+
+	return nil
+}
+
+// Rekey calls the storage Rekey function to change the code of a url and removes the previous code from the cache
+func (r *UrlRepository) Rekey(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	ctx, cancel := r.storageContext(ctx, "Rekey")
+	defer cancel()
+
+	before, err := r.storage.GetById(ctx, u.Id)
+	if err != nil {
+		return err
+	}
+
+	if err = r.storage.Rekey(ctx, u, actor); err != nil {
+		return err
+	}
+
+	r.evict(ctx, before.Code)
+
+	return nil
+}
+
+// GetUrlByCode returns the Url used for redirects either from the cache if it exists or from the storage if it doesn't
+// It adds the Url to the cache, encoded as JSON, if it doesn't already exists
+// The counters of the returned Url and of its variants are always 0 because they change on every redirect, use GetByCode to get them
+// The lookup is traced by a span with the code and cache hit attributes
+func (r *UrlRepository) GetUrlByCode(ctx context.Context, code string) (entities.Url, error) {
+	ctx, span := tracer.Start(ctx, "UrlRepository.GetUrlByCode", trace.WithAttributes(attribute.String("code", code)))
+	defer span.End()
+
 	// search code in cache
-	u, err := r.cache.GetShortUrl(code)
-	if err != nil && err != redis.Nil{
-		r.Logger.Println("unable to get short url from cache: " + err.Error())
+	cacheCtx, cancel := r.cacheContext(ctx, "GetShortUrl")
+	v, err := r.cache.GetShortUrl(cacheCtx, code)
+	if err != nil && err != redis.Nil {
+		recordError(cacheCtx, err)
 	}
+	cancel()
 
-	// if the code doesn't exist in cache
-	if u == "" {
-		// get url from storage
-		url, err := r.storage.GetUrlByCode(code)
-		if err != nil {
-			return "", err
+	if err != nil && err != redis.Nil {
+		r.Logger.WarnContext(ctx, "unable to get short url from cache", "code", code, "error", err.Error())
+	}
+
+	if v != "" {
+		var u entities.Url
+		if err = json.Unmarshal([]byte(v), &u); err == nil {
+			span.SetAttributes(attribute.Bool("cache.hit", true))
+			return u, nil
 		}
 
-		// if url exists, add it to the cache
-		if url != "" {
-			err = r.cache.SetShortUrl(code, url)
+		r.Logger.WarnContext(ctx, "unable to decode short url from cache", "code", code, "error", err.Error())
+	}
+
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+
+	// get url from storage
+	storageCtx, cancel := r.storageContext(ctx, "GetByCode")
+	u, err := r.storage.GetByCode(storageCtx, code)
+	if err != nil {
+		recordError(storageCtx, err)
+	}
+	cancel()
+
+	if err != nil {
+		recordError(ctx, err)
+		return entities.Url{}, err
+	}
+
+	// exhausted urls are not cached, their redirects fail until the limit is raised
+	exhausted := u.IsClickLimited() && u.Counter >= u.MaxClicks
+
+	u.Counter = 0
+	for i := range u.Variants {
+		u.Variants[i].Counter = 0
+	}
+
+	// if url exists, add it to the cache until its activation window changes
+	if u.Id != 0 && !exhausted {
+		b, err := json.Marshal(u)
+		if err == nil {
+			cacheCtx, cancel := r.cacheContext(ctx, "SetShortUrl")
+			err = r.cache.SetShortUrl(cacheCtx, code, string(b), u.UntilWindowChange(time.Now()))
 			if err != nil {
-				r.Logger.Println("unable to add short url to cache:" + err.Error())
+				recordError(cacheCtx, err)
 			}
+			cancel()
 		}
 
-		return url, nil
+		if err != nil {
+			r.Logger.WarnContext(ctx, "unable to add short url to cache", "code", code, "error", err.Error())
+		}
 	}
 
 	return u, nil
 }
 
 // GetById calls the storage GetById function to fetch a Url from the database by its Id
-func (r *UrlRepository) GetById(id int64) (entities.Url, error) {
-	return r.storage.GetById(id)
+// The counters include the clicks that are not moved from redis into the storage yet
+func (r *UrlRepository) GetById(ctx context.Context, id int64) (entities.Url, error) {
+	storageCtx, cancel := r.storageContext(ctx, "GetById")
+	u, err := r.storage.GetById(storageCtx, id)
+	cancel()
+
+	if err != nil {
+		return entities.Url{}, err
+	}
+
+	return r.withPendingClicks(ctx, u), nil
+}
+
+// GetByCode calls the storage GetByCode function to fetch a Url from the database by its Code
+// The result is not cached because it contains the up-to-date redirections counter,
+// including the clicks that are not moved from redis into the storage yet
+func (r *UrlRepository) GetByCode(ctx context.Context, code string) (entities.Url, error) {
+	storageCtx, cancel := r.storageContext(ctx, "GetByCode")
+	u, err := r.storage.GetByCode(storageCtx, code)
+	cancel()
+
+	if err != nil {
+		return entities.Url{}, err
+	}
+
+	return r.withPendingClicks(ctx, u), nil
 }
 
 // GetByUrl calls the storage GetByUrl function to fetch a Url from the database by its Url
-func (r *UrlRepository) GetByUrl(url string) (entities.Url, error) {
-	return r.storage.GetByUrl(url)
+func (r *UrlRepository) GetByUrl(ctx context.Context, url string) (entities.Url, error) {
+	ctx, cancel := r.storageContext(ctx, "GetByUrl")
+	defer cancel()
+
+	return r.storage.GetByUrl(ctx, url)
+}
+
+// IncrementCounters calls the storage IncrementCounters function to add a batch of coalesced clicks to the counters
+func (r *UrlRepository) IncrementCounters(ctx context.Context, clicks map[entities.Click]int64) error {
+	ctx, cancel := r.storageContext(ctx, "IncrementCounters")
+	defer cancel()
+
+	return r.storage.IncrementCounters(ctx, clicks)
+}
+
+// IncrementCountersBatch calls the storage IncrementCountersBatch function to add a batch of clicks to the counters only once
+func (r *UrlRepository) IncrementCountersBatch(ctx context.Context, batchId string, clicks map[entities.Click]int64) error {
+	ctx, cancel := r.storageContext(ctx, "IncrementCountersBatch")
+	defer cancel()
+
+	return r.storage.IncrementCountersBatch(ctx, batchId, clicks)
+}
+
+// CountClick adds a click to the redis counters, FlushCounters moves it into the storage
+func (r *UrlRepository) CountClick(ctx context.Context, click entities.Click) error {
+	if r.Counters == nil {
+		return ErrNoCounters
+	}
+
+	ctx, cancel := r.cacheContext(ctx, "Increment")
+	defer cancel()
+
+	return r.Counters.Increment(ctx, click)
+}
+
+// FlushCounters moves the clicks counted in redis into the storage and returns the moved clicks
+// The clicks are claimed as a batch with a new id, or the id of the batch that failed before, and the batch is removed
+// from redis only after the storage saved it. The storage ignores the batch ids it already saved so no click is counted twice
+// The clicks of the batches moved before an error are returned with it
+func (r *UrlRepository) FlushCounters(ctx context.Context) (map[entities.Click]int64, error) {
+	flushed := make(map[entities.Click]int64)
+	if r.Counters == nil {
+		return flushed, nil
+	}
+
+	for {
+		id, err := newBatchId()
+		if err != nil {
+			return flushed, err
+		}
+
+		batchId, clicks, err := r.claimCounters(ctx, id)
+		if err != nil || batchId == "" {
+			return flushed, err
+		}
+
+		if err = r.IncrementCountersBatch(ctx, batchId, clicks); err != nil {
+			return flushed, err
+		}
+
+		for click, n := range clicks {
+			flushed[click] += n
+		}
+
+		if err = r.ackCounters(ctx, batchId); err != nil {
+			return flushed, err
+		}
+
+		// the clicks counted after a retried batch was claimed are moved by a new batch
+		if batchId == id {
+			return flushed, nil
+		}
+	}
+}
+
+// claimCounters calls the redis counters Claim function bounded by the cache timeout
+func (r *UrlRepository) claimCounters(ctx context.Context, id string) (string, map[entities.Click]int64, error) {
+	ctx, cancel := r.cacheContext(ctx, "Claim")
+	defer cancel()
+
+	return r.Counters.Claim(ctx, id)
+}
+
+// ackCounters calls the redis counters Ack function bounded by the cache timeout
+func (r *UrlRepository) ackCounters(ctx context.Context, id string) error {
+	ctx, cancel := r.cacheContext(ctx, "Ack")
+	defer cancel()
+
+	return r.Counters.Ack(ctx, id)
+}
+
+// CounterBatchSaved calls the storage CounterBatchSaved function to check if a counters batch was saved
+func (r *UrlRepository) CounterBatchSaved(ctx context.Context, batchId string) (bool, error) {
+	ctx, cancel := r.storageContext(ctx, "CounterBatchSaved")
+	defer cancel()
+
+	return r.storage.CounterBatchSaved(ctx, batchId)
 }
 
-// IncrementCounter calls the storage IncrementCounter
-func (r *UrlRepository) IncrementCounter(code string) error {
-	return r.storage.IncrementCounter(code)
+// withPendingClicks adds the clicks of the Url that are still in redis to its counters
+// The claimed clicks are only added while their batch isn't saved, a saved batch is already in the stored counters
+// until it's acknowledged. Errors are only logged and the stored counters are returned
+func (r *UrlRepository) withPendingClicks(ctx context.Context, u entities.Url) entities.Url {
+	if r.Counters == nil || u.Id == 0 {
+		return u
+	}
+
+	cacheCtx, cancel := r.cacheContext(ctx, "Pending")
+	pending, err := r.Counters.Pending(cacheCtx, u.Code)
+	cancel()
+
+	if err != nil {
+		r.Logger.WarnContext(ctx, "unable to get pending clicks", "code", u.Code, "error", err.Error())
+		return u
+	}
+
+	clicks := []map[int64]int64{pending.Pending}
+	if pending.BatchId != "" && len(pending.Claimed) > 0 {
+		saved, err := r.CounterBatchSaved(ctx, pending.BatchId)
+		if err != nil {
+			r.Logger.WarnContext(ctx, "unable to check the counters batch", "batch_id", pending.BatchId, "error", err.Error())
+			return u
+		}
+
+		if !saved {
+			clicks = append(clicks, pending.Claimed)
+		}
+	}
+
+	for _, variants := range clicks {
+		for variantId, n := range variants {
+			u.Counter += n
+			for i := range u.Variants {
+				if u.Variants[i].Id == variantId {
+					u.Variants[i].Counter += n
+				}
+			}
+		}
+	}
+
+	return u
+}
+
+// newBatchId returns a random counters batch id
+func newBatchId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate counters batch id: %s", err.Error())
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// ConsumeClick calls the storage ConsumeClick function to count a redirect of a click limited Url
+// The Url code is removed from the cache once the Url has no clicks left
+func (r *UrlRepository) ConsumeClick(ctx context.Context, click entities.Click) (int64, error) {
+	ctx, cancel := r.storageContext(ctx, "ConsumeClick")
+	defer cancel()
+
+	left, err := r.storage.ConsumeClick(ctx, click)
+	if err != nil {
+		return 0, err
+	}
+
+	if left <= 0 {
+		r.evict(ctx, click.Code)
+	}
+
+	return left, nil
+}
+
+// RemainingClicks calls the storage RemainingClicks function, the cached Urls don't have their counter
+func (r *UrlRepository) RemainingClicks(ctx context.Context, code string) (int64, error) {
+	ctx, cancel := r.storageContext(ctx, "RemainingClicks")
+	defer cancel()
+
+	return r.storage.RemainingClicks(ctx, code)
+}
+
+// evict removes a code from the cache, errors are only logged because the storage is the source of truth
+// The caller context is only used for the logs and the span, its cancellation is ignored because the storage change
+// is already saved and the cached Url must not outlive it
+func (r *UrlRepository) evict(ctx context.Context, code string) {
+	if code == "" {
+		return
+	}
+
+	cacheCtx, cancel := r.cacheContext(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), "DeleteShortUrl")
+	defer cancel()
+
+	if err := r.cache.DeleteShortUrl(cacheCtx, code); err != nil {
+		r.Logger.WarnContext(ctx, "unable to remove short url from cache", "code", code, "error", err.Error())
+	}
 }
@@ -1,16 +1,25 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"github.com/norby7/shortening-service/entities"
-	"log"
+	"github.com/norby7/shortening-service/usecases/repository/cache"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"log/slog"
 	"os"
 	"testing"
+	"time"
 )
 
 var (
 	addError     = fmt.Errorf("unable to add the url")
 	deleteError  = fmt.Errorf("unable to delete the url")
+	updateError  = fmt.Errorf("unable to update the url")
 	getError     = fmt.Errorf("unable to fetch the url")
 	counterError = fmt.Errorf("unable to increment counter")
 	getUrlError  = fmt.Errorf("unable to get url from cache")
@@ -18,9 +27,90 @@ var (
 )
 
 type StorageMock struct{}
-type CacheMock struct{}
 
-func (r *StorageMock) Add(u *entities.Url) error {
+// CountersMock claims a batch with the clicks and the id of its batch field, or the given id if it's empty
+// The next clicks replace the clicks once the batch is acknowledged
+type CountersMock struct {
+	clicks  map[entities.Click]int64
+	next    map[entities.Click]int64
+	batch   string
+	claimed []string
+	acked   []string
+	err     error
+}
+
+func (c *CountersMock) Increment(ctx context.Context, click entities.Click) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	if c.clicks == nil {
+		c.clicks = make(map[entities.Click]int64)
+	}
+
+	c.clicks[click]++
+
+	return nil
+}
+
+func (c *CountersMock) Claim(ctx context.Context, id string) (string, map[entities.Click]int64, error) {
+	if c.err != nil {
+		return "", nil, c.err
+	}
+
+	if len(c.clicks) == 0 {
+		return "", nil, nil
+	}
+
+	if c.batch != "" {
+		id = c.batch
+	}
+
+	c.claimed = append(c.claimed, id)
+
+	return id, c.clicks, nil
+}
+
+func (c *CountersMock) Ack(ctx context.Context, id string) error {
+	c.acked = append(c.acked, id)
+	c.batch = ""
+	c.clicks = c.next
+	c.next = nil
+
+	return nil
+}
+
+// Pending returns the clicks as claimed by the batch field if it's set, and the next clicks as pending
+func (c *CountersMock) Pending(ctx context.Context, code string) (cache.PendingClicks, error) {
+	if c.err != nil {
+		return cache.PendingClicks{}, c.err
+	}
+
+	byVariant := func(clicks map[entities.Click]int64) map[int64]int64 {
+		result := make(map[int64]int64)
+		for click, n := range clicks {
+			if click.Code == code {
+				result[click.VariantId] += n
+			}
+		}
+
+		return result
+	}
+
+	if c.batch == "" {
+		return cache.PendingClicks{Pending: byVariant(c.clicks)}, nil
+	}
+
+	return cache.PendingClicks{Pending: byVariant(c.next), BatchId: c.batch, Claimed: byVariant(c.clicks)}, nil
+}
+
+type CacheMock struct {
+	set     []string
+	ttls    []time.Duration
+	deleted []string
+}
+
+func (r *StorageMock) Add(ctx context.Context, u *entities.Url, actor entities.Actor) error {
 	if u.Url == "http://www.invalidUrl.com" {
 		return addError
 	}
@@ -28,7 +118,7 @@ func (r *StorageMock) Add(u *entities.Url) error {
 	return nil
 }
 
-func (r *StorageMock) Delete(id int64) error {
+func (r *StorageMock) Delete(ctx context.Context, id int64, actor entities.Actor) error {
 	if id == 0 {
 		return deleteError
 	}
@@ -36,19 +126,15 @@ func (r *StorageMock) Delete(id int64) error {
 	return nil
 }
 
-func (r *StorageMock) GetUrlByCode(code string) (string, error) {
-	if code == "invalidCode" {
-		return "", getError
+func (r *StorageMock) Update(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	if u.Id == 0 {
+		return updateError
 	}
 
-	if code != "84gfj4i9" && code != "invalidSetCode" {
-		return "", nil
-	}
-
-	return "https://google.com", nil
+	return nil
 }
 
-func (r *StorageMock) GetById(id int64) (entities.Url, error) {
+func (r *StorageMock) GetById(ctx context.Context, id int64) (entities.Url, error) {
 	if id == 0 {
 		return entities.Url{}, getError
 	}
@@ -67,7 +153,36 @@ func (r *StorageMock) GetById(id int64) (entities.Url, error) {
 	}, nil
 }
 
-func (r *StorageMock) GetByUrl(url string) (entities.Url, error) {
+func (r *StorageMock) GetByCode(ctx context.Context, code string) (entities.Url, error) {
+	if code == "invalidCode" {
+		return entities.Url{}, getError
+	}
+
+	if code == "exhausted" {
+		return entities.Url{Id: 2, Code: code, Url: "https://google.com", Counter: 1, MaxClicks: 1}, nil
+	}
+
+	if code == "scheduled" {
+		until := time.Now().Add(time.Hour)
+		return entities.Url{Id: 3, Code: code, Url: "https://google.com", ActiveUntil: &until}, nil
+	}
+
+	if code != "84gfj4i9" && code != "invalidSetCode" {
+		return entities.Url{}, nil
+	}
+
+	return entities.Url{
+		Id:       1,
+		Code:     "84gfj4i9",
+		Url:      "https://google.com",
+		ShortUrl: "http://localhost/84gfj4i9",
+		Domain:   "http://localhost",
+		Counter:  1,
+		Variants: []entities.Variant{{Id: 10, Url: "https://google.com/a", Weight: 1, Counter: 1}},
+	}, nil
+}
+
+func (r *StorageMock) GetByUrl(ctx context.Context, url string) (entities.Url, error) {
 	if url == "http://www.invalidUrl.com" {
 		return entities.Url{}, getError
 	}
@@ -86,36 +201,180 @@ func (r *StorageMock) GetByUrl(url string) (entities.Url, error) {
 	}, nil
 }
 
-func (r *StorageMock) IncrementCounter(url string) error {
-	if url == "" {
+func (r *StorageMock) IncrementCounters(ctx context.Context, clicks map[entities.Click]int64) error {
+	if _, ok := clicks[entities.Click{}]; ok {
 		return counterError
 	}
 
 	return nil
 }
 
-func (c *CacheMock) SetShortUrl(code, url string) error {
+func (r *StorageMock) IncrementCountersBatch(ctx context.Context, batchId string, clicks map[entities.Click]int64) error {
+	if batchId == "failingBatch" {
+		return counterError
+	}
+
+	return nil
+}
+
+func (r *StorageMock) CounterBatchSaved(ctx context.Context, batchId string) (bool, error) {
+	switch batchId {
+	case "failingBatch":
+		return false, counterError
+	case "savedBatch":
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (r *StorageMock) ConsumeClick(ctx context.Context, click entities.Click) (int64, error) {
+	switch click.Code {
+	case "":
+		return 0, counterError
+	case "lastClick":
+		return 0, nil
+	case "exhausted":
+		return -1, nil
+	}
+
+	return 2, nil
+}
+
+func (r *StorageMock) RemainingClicks(ctx context.Context, code string) (int64, error) {
+	switch code {
+	case "":
+		return 0, counterError
+	case "exhausted":
+		return 0, nil
+	}
+
+	return 2, nil
+}
+
+func (r *StorageMock) Restore(ctx context.Context, id int64, actor entities.Actor) (bool, error) {
+	if id == 0 {
+		return false, updateError
+	}
+
+	return id == 1, nil
+}
+
+func (r *StorageMock) GetDeleted(ctx context.Context) ([]entities.Url, error) {
+	return []entities.Url{{Id: 1, Code: "84gfj4i9", Url: "https://google.com"}}, nil
+}
+
+func (r *StorageMock) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	return 1, nil
+}
+
+func (r *StorageMock) CodeExists(ctx context.Context, code string) (bool, error) {
+	if code == "invalidCode" {
+		return false, getError
+	}
+
+	return code == "84gfj4i9", nil
+}
+
+func (r *StorageMock) GetAuditEvents(ctx context.Context, filter entities.AuditFilter) ([]entities.AuditEvent, error) {
+	return nil, nil
+}
+
+func (r *StorageMock) GetCounters(ctx context.Context, codes []string) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+func (r *StorageMock) AddWebhook(ctx context.Context, w *entities.Webhook) error {
+	return nil
+}
+
+func (r *StorageMock) GetWebhooks(ctx context.Context) ([]entities.Webhook, error) {
+	return nil, nil
+}
+
+func (r *StorageMock) DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	return true, nil
+}
+
+func (r *StorageMock) AddDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	return nil
+}
+
+func (r *StorageMock) UpdateDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	return nil
+}
+
+func (r *StorageMock) GetDeadLetter(ctx context.Context, id int64) (entities.DeadLetter, error) {
+	return entities.DeadLetter{}, nil
+}
+
+func (r *StorageMock) GetDeadLetters(ctx context.Context, webhookId int64) ([]entities.DeadLetter, error) {
+	return nil, nil
+}
+
+func (r *StorageMock) DeleteDeadLetter(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (r *StorageMock) ExportUrls(ctx context.Context, filter entities.ExportFilter, fn func(entities.Url) error) error {
+	return nil
+}
+
+func (r *StorageMock) Rekey(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	if u.Id == 0 {
+		return updateError
+	}
+
+	return nil
+}
+
+func (r *StorageMock) Overwrite(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	if u.Url == "" {
+		return updateError
+	}
+
+	return nil
+}
+
+func (c *CacheMock) SetShortUrl(ctx context.Context, code, url string, ttl time.Duration) error {
 	if code == "invalidSetCode" {
 		return setUrlError
 	}
 
+	c.set = append(c.set, code)
+	c.ttls = append(c.ttls, ttl)
+
 	return nil
 }
 
-func (c *CacheMock) GetShortUrl(code string) (string, error) {
+func (c *CacheMock) GetShortUrl(ctx context.Context, code string) (string, error) {
 	if code == "invalidCode" {
 		return "", getUrlError
 	}
 
 	if code == "cacheUrl" {
-		return "cacheUrl", nil
+		return `{"id":1,"code":"cacheUrl","url":"https://google.com"}`, nil
+	}
+
+	if code == "invalidCacheValue" {
+		return "https://google.com", nil
 	}
 
 	return "", nil
 }
 
+func (c *CacheMock) DeleteShortUrl(ctx context.Context, code string) error {
+	if code == "invalidDeleteCode" {
+		return deleteError
+	}
+
+	c.deleted = append(c.deleted, code)
+
+	return nil
+}
+
 func TestGetUrlByCode(t *testing.T) {
-	l := log.New(os.Stdout, "urls-api-test", log.LstdFlags)
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
 	st := &StorageMock{}
 	ch := &CacheMock{}
 	repo := NewUrlRepository(st, ch, l)
@@ -155,11 +414,298 @@ func TestGetUrlByCode(t *testing.T) {
 			input:   "invalidSetCode",
 			isError: false,
 		},
+		{
+			name:    "invalid cache value",
+			input:   "invalidCacheValue",
+			isError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := repo.GetUrlByCode(context.Background(), tc.input)
+
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+		})
+	}
+}
+
+func TestGetUrlByCodeCounters(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st := &StorageMock{}
+	ch := &CacheMock{}
+	repo := NewUrlRepository(st, ch, l)
+
+	u, err := repo.GetUrlByCode(context.Background(), "84gfj4i9")
+	if err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if u.Counter != 0 {
+		t.Errorf("expected url counter (0), got (%d)", u.Counter)
+	}
+
+	if len(u.Variants) != 1 || u.Variants[0].Counter != 0 {
+		t.Errorf("expected variants with counter (0), got (%v)", u.Variants)
+	}
+}
+
+func TestGetUrlByCodeExhausted(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st := &StorageMock{}
+	ch := &CacheMock{}
+	repo := NewUrlRepository(st, ch, l)
+
+	u, err := repo.GetUrlByCode(context.Background(), "exhausted")
+	if err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if u.Id != 2 {
+		t.Errorf("expected url (2), got (%d)", u.Id)
+	}
+
+	if len(ch.set) != 0 {
+		t.Errorf("expected exhausted url not to be cached, got (%v)", ch.set)
+	}
+}
+
+func TestGetUrlByCodeActivationWindow(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st := &StorageMock{}
+	ch := &CacheMock{}
+	repo := NewUrlRepository(st, ch, l)
+
+	_, err := repo.GetUrlByCode(context.Background(), "scheduled")
+	if err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if len(ch.ttls) != 1 {
+		t.Fatalf("expected url to be cached once, got (%v)", ch.set)
+	}
+
+	if ch.ttls[0] <= 0 || ch.ttls[0] > time.Hour {
+		t.Errorf("expected ttl until the window end, got (%v)", ch.ttls[0])
+	}
+}
+
+func TestConsumeClick(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st := &StorageMock{}
+
+	testCases := []struct {
+		name    string
+		input   string
+		left    int64
+		evicted bool
+		isError bool
+	}{
+		{
+			name:    "storage error",
+			input:   "",
+			isError: true,
+		},
+		{
+			name:  "clicks left",
+			input: "84gfj4i9",
+			left:  2,
+		},
+		{
+			name:    "last click",
+			input:   "lastClick",
+			left:    0,
+			evicted: true,
+		},
+		{
+			name:    "exhausted",
+			input:   "exhausted",
+			left:    -1,
+			evicted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := &CacheMock{}
+			repo := NewUrlRepository(st, ch, l)
+
+			left, err := repo.ConsumeClick(context.Background(), entities.Click{Code: tc.input})
+
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+
+			if left != tc.left {
+				t.Errorf("expected (%d) clicks left, got (%d)", tc.left, left)
+			}
+
+			if (len(ch.deleted) > 0) != tc.evicted {
+				t.Errorf("expected evicted (%v), got deleted codes (%v)", tc.evicted, ch.deleted)
+			}
+		})
+	}
+}
+
+func TestRemainingClicks(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	repo := NewUrlRepository(&StorageMock{}, &CacheMock{}, l)
+
+	testCases := []struct {
+		name    string
+		input   string
+		left    int64
+		isError bool
+	}{
+		{name: "storage error", input: "", isError: true},
+		{name: "clicks left", input: "84gfj4i9", left: 2},
+		{name: "exhausted", input: "exhausted", left: 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			left, err := repo.RemainingClicks(context.Background(), tc.input)
+
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+
+			if left != tc.left {
+				t.Errorf("expected (%d) clicks left, got (%d)", tc.left, left)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st := &StorageMock{}
+	ch := &CacheMock{}
+	repo := NewUrlRepository(st, ch, l)
+
+	testCases := []struct {
+		name    string
+		input   *entities.Url
+		isError bool
+	}{
+		{
+			name:    "update error",
+			input:   &entities.Url{Id: 0, Code: "84gfj4i9"},
+			isError: true,
+		},
+		{
+			name:    "valid update",
+			input:   &entities.Url{Id: 1, Code: "84gfj4i9"},
+			isError: false,
+		},
+		{
+			name:    "cache delete error",
+			input:   &entities.Url{Id: 1, Code: "invalidDeleteCode"},
+			isError: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := repo.Update(context.Background(), tc.input, entities.Actor{Name: entities.AnonymousActor})
+
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+		})
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	testCases := []struct {
+		name    string
+		input   *entities.Url
+		isError bool
+		evicted bool
+	}{
+		{
+			name:    "overwrite error",
+			input:   &entities.Url{Code: "84gfj4i9"},
+			isError: true,
+		},
+		{
+			name:    "valid overwrite",
+			input:   &entities.Url{Code: "84gfj4i9", Url: "https://google.com"},
+			evicted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := &CacheMock{}
+			repo := NewUrlRepository(&StorageMock{}, ch, l)
+
+			err := repo.Overwrite(context.Background(), tc.input, entities.Actor{Name: entities.AnonymousActor})
+			if (err != nil) != tc.isError {
+				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
+			}
+
+			if (len(ch.deleted) > 0) != tc.evicted {
+				t.Errorf("expected evicted (%v), got deleted codes (%v)", tc.evicted, ch.deleted)
+			}
+		})
+	}
+}
+
+func TestRekey(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	ch := &CacheMock{}
+	repo := NewUrlRepository(&StorageMock{}, ch, l)
+
+	if err := repo.Rekey(context.Background(), &entities.Url{Id: 0, Code: "n3wc0d3x"}, entities.Actor{}); err == nil {
+		t.Errorf("expected an error for url (0)")
+	}
+
+	if err := repo.Rekey(context.Background(), &entities.Url{Id: 1, Code: "n3wc0d3x"}, entities.Actor{}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// the previous code of the url is evicted
+	if len(ch.deleted) != 1 || ch.deleted[0] != "84gfj4i9" {
+		t.Errorf("expected the previous code to be evicted, got (%v)", ch.deleted)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	st := &StorageMock{}
+	ch := &CacheMock{}
+	repo := NewUrlRepository(st, ch, l)
+
+	testCases := []struct {
+		name    string
+		input   int64
+		isError bool
+	}{
+		{
+			name:    "get error",
+			input:   0,
+			isError: true,
+		},
+		{
+			name:    "valid delete",
+			input:   1,
+			isError: false,
+		},
+		{
+			name:    "url not found",
+			input:   2,
+			isError: false,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := repo.GetUrlByCode(tc.input)
+			err := repo.Delete(context.Background(), tc.input, entities.Actor{Name: entities.AnonymousActor})
 
 			if (err != nil) != tc.isError {
 				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
@@ -167,3 +713,318 @@ func TestGetUrlByCode(t *testing.T) {
 		})
 	}
 }
+
+func TestCountClick(t *testing.T) {
+	st := &StorageMock{}
+	ch := &CacheMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	repo := NewUrlRepository(st, ch, l)
+
+	if err := repo.CountClick(context.Background(), entities.Click{Code: "84gfj4i9"}); err != ErrNoCounters {
+		t.Errorf("expected error (%v), got (%v)", ErrNoCounters, err)
+	}
+
+	counters := &CountersMock{}
+	repo.Counters = counters
+
+	if err := repo.CountClick(context.Background(), entities.Click{Code: "84gfj4i9"}); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if counters.clicks[entities.Click{Code: "84gfj4i9"}] != 1 {
+		t.Errorf("expected the click to be counted, got (%v)", counters.clicks)
+	}
+}
+
+func TestPendingClicks(t *testing.T) {
+	st := &StorageMock{}
+	ch := &CacheMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	repo := NewUrlRepository(st, ch, l)
+	repo.Counters = &CountersMock{clicks: map[entities.Click]int64{
+		{Code: "84gfj4i9"}:                2,
+		{Code: "84gfj4i9", VariantId: 10}: 3,
+		{Code: "d3l3t3d0"}:                4,
+	}}
+
+	u, err := repo.GetByCode(context.Background(), "84gfj4i9")
+	if err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if u.Counter != 6 || u.Variants[0].Counter != 4 {
+		t.Errorf("expected counters (6) and (4), got (%d) and (%d)", u.Counter, u.Variants[0].Counter)
+	}
+
+	u, err = repo.GetById(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if u.Counter != 6 {
+		t.Errorf("expected counter (6), got (%d)", u.Counter)
+	}
+
+	// the claimed clicks are only added while their batch isn't saved
+	next := map[entities.Click]int64{{Code: "84gfj4i9"}: 1}
+	claimed := map[entities.Click]int64{{Code: "84gfj4i9"}: 2}
+	testCases := []struct {
+		name     string
+		batch    string
+		expected int64
+	}{
+		{name: "batch not saved", batch: "batch1", expected: 4},
+		{name: "batch saved", batch: "savedBatch", expected: 2},
+		{name: "batch check error", batch: "failingBatch", expected: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo.Counters = &CountersMock{clicks: claimed, next: next, batch: tc.batch}
+
+			u, err := repo.GetByCode(context.Background(), "84gfj4i9")
+			if err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if u.Counter != tc.expected {
+				t.Errorf("expected counter (%d), got (%d)", tc.expected, u.Counter)
+			}
+		})
+	}
+
+	// the stored counters are returned when redis fails
+	repo.Counters = &CountersMock{err: counterError}
+
+	u, err = repo.GetByCode(context.Background(), "84gfj4i9")
+	if err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if u.Counter != 1 {
+		t.Errorf("expected counter (1), got (%d)", u.Counter)
+	}
+}
+
+func TestFlushCounters(t *testing.T) {
+	st := &StorageMock{}
+	ch := &CacheMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	testCases := []struct {
+		name          string
+		counters      *CountersMock
+		expectedError bool
+		acked         int
+		flushed       int64
+	}{
+		{
+			name:     "no clicks",
+			counters: &CountersMock{},
+		},
+		{
+			name:     "new batch",
+			counters: &CountersMock{clicks: map[entities.Click]int64{{Code: "84gfj4i9"}: 1}},
+			acked:    1,
+			flushed:  1,
+		},
+		{
+			name:     "retried batch",
+			counters: &CountersMock{clicks: map[entities.Click]int64{{Code: "84gfj4i9"}: 1}, batch: "batch1"},
+			acked:    1,
+			flushed:  1,
+		},
+		{
+			name: "retried batch and new clicks",
+			counters: &CountersMock{
+				clicks: map[entities.Click]int64{{Code: "84gfj4i9"}: 1},
+				next:   map[entities.Click]int64{{Code: "84gfj4i9"}: 2},
+				batch:  "batch1",
+			},
+			acked:   2,
+			flushed: 3,
+		},
+		{
+			name:          "storage error",
+			counters:      &CountersMock{clicks: map[entities.Click]int64{{Code: "84gfj4i9"}: 1}, batch: "failingBatch"},
+			expectedError: true,
+		},
+		{
+			name:          "redis error",
+			counters:      &CountersMock{err: counterError},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := NewUrlRepository(st, ch, l)
+			repo.Counters = tc.counters
+
+			flushed, err := repo.FlushCounters(context.Background())
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+
+			if flushed[entities.Click{Code: "84gfj4i9"}] != tc.flushed {
+				t.Errorf("expected (%d) flushed clicks, got (%v)", tc.flushed, flushed)
+			}
+
+			if len(tc.counters.acked) != tc.acked {
+				t.Fatalf("expected (%d) acknowledged batches, got (%v)", tc.acked, tc.counters.acked)
+			}
+
+			for i := range tc.counters.acked {
+				if tc.counters.acked[i] != tc.counters.claimed[i] {
+					t.Errorf("expected the claimed batch (%s) to be acknowledged, got (%s)", tc.counters.claimed[i], tc.counters.acked[i])
+				}
+			}
+		})
+	}
+
+	// without redis counters there is nothing to flush
+	if _, err := NewUrlRepository(st, ch, l).FlushCounters(context.Background()); err != nil {
+		t.Errorf("expected no error, got (%v)", err)
+	}
+}
+
+// DeadlineStorageMock records the time left before the deadline of the GetByUrl context
+type DeadlineStorageMock struct {
+	StorageMock
+	left time.Duration
+}
+
+func (r *DeadlineStorageMock) GetByUrl(ctx context.Context, url string) (entities.Url, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return entities.Url{}, nil
+	}
+
+	r.left = time.Until(deadline)
+
+	return entities.Url{}, ctx.Err()
+}
+
+func TestStorageTimeout(t *testing.T) {
+	ch := &CacheMock{}
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	expiredCtx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	testCases := []struct {
+		name          string
+		ctx           context.Context
+		timeouts      Timeouts
+		expectedLeft  time.Duration
+		expectedError error
+	}{
+		{
+			name:         "default timeout",
+			ctx:          context.Background(),
+			expectedLeft: DefaultStorageTimeout,
+		},
+		{
+			name:         "configured timeout",
+			ctx:          context.Background(),
+			timeouts:     Timeouts{Storage: time.Second},
+			expectedLeft: time.Second,
+		},
+		{
+			name:          "earlier caller deadline",
+			ctx:           expiredCtx,
+			timeouts:      Timeouts{Storage: time.Second},
+			expectedError: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := &DeadlineStorageMock{}
+			repo := NewUrlRepository(st, ch, l)
+			if tc.timeouts.Storage != 0 {
+				repo.Timeouts = tc.timeouts
+			}
+
+			_, err := repo.GetByUrl(tc.ctx, "https://google.com")
+			if err != tc.expectedError {
+				t.Fatalf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+
+			if tc.expectedLeft != 0 && (st.left <= 0 || st.left > tc.expectedLeft) {
+				t.Errorf("expected a deadline within (%s), got (%s)", tc.expectedLeft, st.left)
+			}
+		})
+	}
+}
+
+func TestGetUrlByCodeSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	l := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	repo := NewUrlRepository(&StorageMock{}, &CacheMock{}, l)
+
+	testCases := []struct {
+		name          string
+		input         string
+		expectedSpans []string
+		expectedHit   bool
+		expectedError bool
+	}{
+		{
+			name:          "cache hit",
+			input:         "cacheUrl",
+			expectedSpans: []string{"cache.GetShortUrl", "UrlRepository.GetUrlByCode"},
+			expectedHit:   true,
+		},
+		{
+			name:          "cache miss",
+			input:         "84gfj4i9",
+			expectedSpans: []string{"cache.GetShortUrl", "storage.GetByCode", "cache.SetShortUrl", "UrlRepository.GetUrlByCode"},
+		},
+		{
+			name:          "storage error",
+			input:         "invalidCode",
+			expectedSpans: []string{"cache.GetShortUrl", "storage.GetByCode", "UrlRepository.GetUrlByCode"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter.Reset()
+
+			_, _ = repo.GetUrlByCode(context.Background(), tc.input)
+
+			spans := exporter.GetSpans()
+			if len(spans) != len(tc.expectedSpans) {
+				t.Fatalf("expected spans (%v), got (%d) spans", tc.expectedSpans, len(spans))
+			}
+
+			parent := spans[len(spans)-1]
+			for i, span := range spans {
+				if span.Name != tc.expectedSpans[i] {
+					t.Errorf("expected span (%s), got (%s)", tc.expectedSpans[i], span.Name)
+				}
+
+				if i < len(spans)-1 && span.Parent.SpanID() != parent.SpanContext.SpanID() {
+					t.Errorf("expected span (%s) to be a child of (%s)", span.Name, parent.Name)
+				}
+			}
+
+			attrs := make(map[attribute.Key]attribute.Value)
+			for _, a := range parent.Attributes {
+				attrs[a.Key] = a.Value
+			}
+
+			if attrs["code"].AsString() != tc.input || attrs["cache.hit"].AsBool() != tc.expectedHit {
+				t.Errorf("expected code (%s) and cache hit (%t), got (%v)", tc.input, tc.expectedHit, parent.Attributes)
+			}
+
+			if (parent.Status.Code == codes.Error) != tc.expectedError {
+				t.Errorf("expected error status (%t), got (%v)", tc.expectedError, parent.Status)
+			}
+		})
+	}
+}
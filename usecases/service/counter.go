@@ -0,0 +1,363 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"github.com/norby7/shortening-service/entities"
+	"github.com/norby7/shortening-service/usecases/repository"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// policies used when the clicks queue is full
+const (
+	// OverflowDrop drops the click and counts it in the dropped metric, redirects never wait for the counters
+	OverflowDrop = "drop"
+	// OverflowBlock waits until the queue has room, no click is lost but redirects slow down with the database
+	OverflowBlock = "block"
+)
+
+// modes used to count the clicks
+const (
+	// CounterModeMemory coalesces the clicks in memory and saves them into the storage on every flush
+	CounterModeMemory = "memory"
+	// CounterModeRedis counts the clicks with atomic redis increments that are moved into the storage on every flush,
+	// the clicks are coalesced in memory while redis is unavailable
+	CounterModeRedis = "redis"
+)
+
+// DefaultCounterQueueSize is the number of clicks waiting to be coalesced when the options have no queue size
+const DefaultCounterQueueSize = 10000
+
+// DefaultCounterFlushInterval is the time between two counter flushes when the options have no interval
+const DefaultCounterFlushInterval = time.Second
+
+// DefaultCounterBatchSize is the number of coalesced counters that triggers a flush before the interval ends
+const DefaultCounterBatchSize = 500
+
+// counterMetrics are the click counter metrics published by expvar: enqueued and dropped clicks, flushed clicks,
+// flushes, failed flushes, clicks not saved yet, clicks counted in redis and clicks queued because redis failed
+var counterMetrics = expvar.NewMap("counters")
+
+// CounterOptions configure how clicks are queued and saved, the zero values use the defaults
+type CounterOptions struct {
+	// size of the clicks queue
+	QueueSize int
+	// time between two flushes of the coalesced clicks
+	FlushInterval time.Duration
+	// number of coalesced counters that triggers an early flush
+	BatchSize int
+	// OverflowDrop or OverflowBlock, OverflowDrop if empty
+	Overflow string
+	// CounterModeMemory or CounterModeRedis, CounterModeMemory if empty
+	Mode string
+	// number of click events buffered for every click feed subscriber
+	FeedBuffer int
+}
+
+// counterPipeline queues clicks without blocking the redirects, coalesces them per code and variant
+// and saves them with a single batch of updates on every flush
+type counterPipeline struct {
+	repo    repository.Repository
+	options CounterOptions
+	clicks  chan entities.Click
+	// mu guards closed, enqueue holds it for reading so clicks are never sent on the closed channel
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+	// flush requests of flushNow, answered with the error of the flush
+	flushes chan chan error
+	// error of the final flush, set before done is closed
+	err error
+	// closed to stop the redis flusher, which closes flusherDone after its final flush
+	stopFlusher chan struct{}
+	flusherDone chan struct{}
+	// error of the final redis flush, set before flusherDone is closed
+	flusherErr error
+	// called with the clicks saved by every flush, from the flushing goroutine, if it's not nil
+	onFlush func(map[entities.Click]int64)
+}
+
+// newCounterPipeline returns a counterPipeline with its coalescing goroutine started, onFlush is called with the
+// clicks saved by every flush
+func newCounterPipeline(r repository.Repository, o CounterOptions, onFlush func(map[entities.Click]int64)) *counterPipeline {
+	if o.QueueSize <= 0 {
+		o.QueueSize = DefaultCounterQueueSize
+	}
+
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = DefaultCounterFlushInterval
+	}
+
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultCounterBatchSize
+	}
+
+	if o.Overflow != OverflowBlock {
+		o.Overflow = OverflowDrop
+	}
+
+	if o.Mode != CounterModeRedis {
+		o.Mode = CounterModeMemory
+	}
+
+	p := &counterPipeline{
+		repo:    r,
+		options: o,
+		clicks:  make(chan entities.Click, o.QueueSize),
+		done:    make(chan struct{}),
+		flushes: make(chan chan error),
+		onFlush: onFlush,
+	}
+
+	go p.run()
+
+	if o.Mode == CounterModeRedis {
+		p.stopFlusher = make(chan struct{})
+		p.flusherDone = make(chan struct{})
+		go p.runFlusher()
+	}
+
+	return p
+}
+
+// count adds a click to the redis counters in CounterModeRedis, and queues it if redis is not used or fails
+func (p *counterPipeline) count(ctx context.Context, click entities.Click) bool {
+	if p.options.Mode == CounterModeRedis {
+		err := p.repo.CountClick(ctx, click)
+		if err == nil {
+			counterMetrics.Add("redis", 1)
+			return true
+		}
+
+		counterMetrics.Add("redisErrors", 1)
+		slog.WarnContext(ctx, "unable to count click in redis, the click is queued", "code", click.Code, "error", err.Error())
+	}
+
+	return p.enqueue(click)
+}
+
+// runFlusher moves the clicks counted in redis into the storage on every interval tick and once more when it's stopped
+func (p *counterPipeline) runFlusher() {
+	defer close(p.flusherDone)
+
+	ticker := time.NewTicker(p.options.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.flushRedis()
+		case <-p.stopFlusher:
+			p.flusherErr = p.flushRedis()
+			return
+		}
+	}
+}
+
+// flushRedis moves the clicks counted in redis into the storage, a failed batch is retried on the next flush
+// The flush doesn't depend on a request so it only uses the repository timeouts
+// The clicks moved before an error are passed to onFlush too
+func (p *counterPipeline) flushRedis() error {
+	flushed, err := p.repo.FlushCounters(context.Background())
+	p.flushed(flushed)
+
+	if err != nil {
+		counterMetrics.Add("flushErrors", 1)
+		slog.Error("unable to flush the redis counters", "error", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// enqueue adds a click to the queue and returns false if it was dropped
+// Clicks are dropped when the queue is full and the overflow policy is OverflowDrop, or after the pipeline is closed
+func (p *counterPipeline) enqueue(click entities.Click) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		counterMetrics.Add("dropped", 1)
+		return false
+	}
+
+	if p.options.Overflow == OverflowBlock {
+		p.clicks <- click
+	} else {
+		select {
+		case p.clicks <- click:
+		default:
+			counterMetrics.Add("dropped", 1)
+			return false
+		}
+	}
+
+	counterMetrics.Add("enqueued", 1)
+	counterMetrics.Add("pending", 1)
+
+	return true
+}
+
+// run coalesces the queued clicks and flushes them on every interval tick, when the batch is full and when the queue is closed
+func (p *counterPipeline) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.options.FlushInterval)
+	defer ticker.Stop()
+
+	pending := make(map[entities.Click]int64)
+	var failed bool
+
+	for {
+		select {
+		case click, ok := <-p.clicks:
+			if !ok {
+				p.err = p.flush(pending)
+				return
+			}
+
+			pending[click]++
+
+			// after a failed flush the clicks are retried on the next tick instead of on every new click
+			if len(pending) >= p.options.BatchSize && !failed {
+				failed = p.flush(pending) != nil
+			}
+		case <-ticker.C:
+			failed = p.flush(pending) != nil
+		case res := <-p.flushes:
+			p.drain(pending)
+			err := p.flush(pending)
+			failed = err != nil
+			res <- err
+		}
+	}
+}
+
+// drain adds the queued clicks to pending without waiting for new ones
+func (p *counterPipeline) drain(pending map[entities.Click]int64) {
+	for {
+		select {
+		case click, ok := <-p.clicks:
+			if !ok {
+				return
+			}
+
+			pending[click]++
+		default:
+			return
+		}
+	}
+}
+
+// flushNow saves the queued clicks and moves the clicks counted in redis into the storage, it returns when they are
+// saved or the context is done. The clicks enqueued while it runs may not be saved
+func (p *counterPipeline) flushNow(ctx context.Context) error {
+	res := make(chan error, 1)
+
+	select {
+	case p.flushes <- res:
+	case <-p.done:
+		return fmt.Errorf("unable to flush the counters: the counters are closed")
+	case <-ctx.Done():
+		return fmt.Errorf("unable to flush the counters: %s", ctx.Err().Error())
+	}
+
+	select {
+	case err := <-res:
+		if err != nil {
+			return fmt.Errorf("unable to flush the counters: %s", err.Error())
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("unable to flush the counters: %s", ctx.Err().Error())
+	}
+
+	if p.options.Mode != CounterModeRedis {
+		return nil
+	}
+
+	flushed, err := p.repo.FlushCounters(ctx)
+	p.flushed(flushed)
+	if err != nil {
+		return fmt.Errorf("unable to flush the redis counters: %s", err.Error())
+	}
+
+	return nil
+}
+
+// flush saves the coalesced clicks and removes them from pending
+// The clicks are kept in pending if they can't be saved so they are retried on the next flush
+// The flush doesn't depend on a request so it only uses the repository timeouts
+func (p *counterPipeline) flush(pending map[entities.Click]int64) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := p.repo.IncrementCounters(context.Background(), pending); err != nil {
+		counterMetrics.Add("flushErrors", 1)
+		slog.Error("unable to flush the counters", "counters", len(pending), "error", err.Error())
+		return err
+	}
+
+	var n int64
+	flushed := make(map[entities.Click]int64, len(pending))
+	for click, clicks := range pending {
+		n += clicks
+		flushed[click] = clicks
+		delete(pending, click)
+	}
+
+	p.flushed(flushed)
+
+	counterMetrics.Add("flushes", 1)
+	counterMetrics.Add("flushed", n)
+	counterMetrics.Add("pending", -n)
+
+	return nil
+}
+
+// flushed passes the saved clicks to onFlush
+func (p *counterPipeline) flushed(clicks map[entities.Click]int64) {
+	if p.onFlush != nil && len(clicks) > 0 {
+		p.onFlush(clicks)
+	}
+}
+
+// close stops accepting clicks and waits until the queued clicks, and the clicks counted in redis, are saved or the context is done
+func (p *counterPipeline) close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.clicks)
+		if p.stopFlusher != nil {
+			close(p.stopFlusher)
+		}
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		if p.err != nil {
+			return fmt.Errorf("unable to flush the counters: %s", p.err.Error())
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("unable to flush the counters: %s", ctx.Err().Error())
+	}
+
+	if p.flusherDone == nil {
+		return nil
+	}
+
+	select {
+	case <-p.flusherDone:
+		if p.flusherErr != nil {
+			return fmt.Errorf("unable to flush the redis counters: %s", p.flusherErr.Error())
+		}
+
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("unable to flush the redis counters: %s", ctx.Err().Error())
+	}
+}
@@ -0,0 +1,451 @@
+package service
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"github.com/norby7/shortening-service/entities"
+	"sync"
+	"testing"
+	"time"
+)
+
+var flushError = fmt.Errorf("unable to flush the counters")
+
+// CounterRepositoryMock records the flushed counters, the flushes fail while failing is set
+// and wait for the release channel when it's set
+// The clicks counted in redis are kept in redis until FlushCounters, CountClick fails while redisDown is set
+type CounterRepositoryMock struct {
+	RepositoryMock
+	mu        sync.Mutex
+	flushes   []map[entities.Click]int64
+	failing   bool
+	release   chan struct{}
+	redis     map[entities.Click]int64
+	redisDown bool
+}
+
+func (r *CounterRepositoryMock) CountClick(ctx context.Context, click entities.Click) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.redisDown {
+		return flushError
+	}
+
+	if r.redis == nil {
+		r.redis = make(map[entities.Click]int64)
+	}
+
+	r.redis[click]++
+
+	return nil
+}
+
+func (r *CounterRepositoryMock) FlushCounters(ctx context.Context) (map[entities.Click]int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failing {
+		return nil, flushError
+	}
+
+	flushed := r.redis
+	if len(r.redis) > 0 {
+		r.flushes = append(r.flushes, r.redis)
+		r.redis = nil
+	}
+
+	return flushed, nil
+}
+
+func (r *CounterRepositoryMock) IncrementCounters(ctx context.Context, clicks map[entities.Click]int64) error {
+	if r.release != nil {
+		<-r.release
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failing {
+		return flushError
+	}
+
+	flushed := make(map[entities.Click]int64)
+	for click, n := range clicks {
+		flushed[click] = n
+	}
+
+	r.flushes = append(r.flushes, flushed)
+
+	return nil
+}
+
+func (r *CounterRepositoryMock) setFailing(failing bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failing = failing
+}
+
+// totals returns the flushed clicks of every code and variant and the number of flushes
+func (r *CounterRepositoryMock) totals() (map[entities.Click]int64, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	totals := make(map[entities.Click]int64)
+	for _, f := range r.flushes {
+		for click, n := range f {
+			totals[click] += n
+		}
+	}
+
+	return totals, len(r.flushes)
+}
+
+// counterMetric returns the current value of a counter metric
+func counterMetric(name string) int64 {
+	if v, ok := counterMetrics.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+
+	return 0
+}
+
+func TestCounterPipelineCoalesce(t *testing.T) {
+	r := &CounterRepositoryMock{}
+	p := newCounterPipeline(r, CounterOptions{FlushInterval: time.Hour}, nil)
+
+	clicks := []entities.Click{
+		{Code: "84gfj4i9"},
+		{Code: "84gfj4i9"},
+		{Code: "84gfj4i9", VariantId: 10},
+		{Code: "d3l3t3d0"},
+	}
+	for _, c := range clicks {
+		if !p.enqueue(c) {
+			t.Fatalf("expected click (%v) to be queued", c)
+		}
+	}
+
+	if err := p.close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	totals, flushes := r.totals()
+	if flushes != 1 {
+		t.Errorf("expected the clicks to be saved by a single flush, got (%d)", flushes)
+	}
+
+	expected := map[entities.Click]int64{
+		{Code: "84gfj4i9"}:                2,
+		{Code: "84gfj4i9", VariantId: 10}: 1,
+		{Code: "d3l3t3d0"}:                1,
+	}
+	for click, n := range expected {
+		if totals[click] != n {
+			t.Errorf("expected (%d) clicks for (%v), got (%d)", n, click, totals[click])
+		}
+	}
+
+	if p.enqueue(clicks[0]) {
+		t.Errorf("expected the clicks to be dropped after close")
+	}
+}
+
+func TestCounterPipelineInterval(t *testing.T) {
+	r := &CounterRepositoryMock{}
+	p := newCounterPipeline(r, CounterOptions{FlushInterval: 10 * time.Millisecond}, nil)
+	defer p.close(context.Background())
+
+	p.enqueue(entities.Click{Code: "84gfj4i9"})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if totals, _ := r.totals(); totals[entities.Click{Code: "84gfj4i9"}] == 1 {
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Errorf("expected the click to be flushed on the interval")
+}
+
+func TestCounterPipelineBatch(t *testing.T) {
+	r := &CounterRepositoryMock{}
+	p := newCounterPipeline(r, CounterOptions{FlushInterval: time.Hour, BatchSize: 2}, nil)
+
+	p.enqueue(entities.Click{Code: "84gfj4i9"})
+	p.enqueue(entities.Click{Code: "d3l3t3d0"})
+	p.enqueue(entities.Click{Code: "5f4r3e2w"})
+
+	if err := p.close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if _, flushes := r.totals(); flushes != 2 {
+		t.Errorf("expected a batch flush and a final flush, got (%d) flushes", flushes)
+	}
+}
+
+func TestCounterPipelineOverflow(t *testing.T) {
+	release := make(chan struct{})
+	r := &CounterRepositoryMock{release: release}
+	p := newCounterPipeline(r, CounterOptions{QueueSize: 1, FlushInterval: time.Hour, BatchSize: 1}, nil)
+
+	before := counterMetric("dropped")
+
+	// the first click is flushed and blocks the pipeline, the second one fills the queue
+	var queued int
+	for i := 0; i < 5; i++ {
+		if p.enqueue(entities.Click{Code: "84gfj4i9"}) {
+			queued++
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if queued != 2 {
+		t.Errorf("expected (2) queued clicks, got (%d)", queued)
+	}
+
+	if dropped := counterMetric("dropped") - before; dropped != 3 {
+		t.Errorf("expected (3) dropped clicks, got (%d)", dropped)
+	}
+
+	close(release)
+	if err := p.close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if totals, _ := r.totals(); totals[entities.Click{Code: "84gfj4i9"}] != 2 {
+		t.Errorf("expected the queued clicks to be saved, got (%v)", totals)
+	}
+}
+
+func TestCounterPipelineBlock(t *testing.T) {
+	release := make(chan struct{})
+	r := &CounterRepositoryMock{release: release}
+	p := newCounterPipeline(r, CounterOptions{QueueSize: 1, FlushInterval: time.Hour, BatchSize: 1, Overflow: OverflowBlock}, nil)
+
+	p.enqueue(entities.Click{Code: "84gfj4i9"})
+	time.Sleep(10 * time.Millisecond)
+	p.enqueue(entities.Click{Code: "84gfj4i9"})
+
+	queued := make(chan bool)
+	go func() {
+		queued <- p.enqueue(entities.Click{Code: "84gfj4i9"})
+	}()
+
+	select {
+	case <-queued:
+		t.Fatalf("expected the click to wait for room in the queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if !<-queued {
+		t.Errorf("expected the click to be queued")
+	}
+
+	if err := p.close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if totals, _ := r.totals(); totals[entities.Click{Code: "84gfj4i9"}] != 3 {
+		t.Errorf("expected no click to be lost, got (%v)", totals)
+	}
+}
+
+func TestCounterPipelineRetry(t *testing.T) {
+	r := &CounterRepositoryMock{failing: true}
+	p := newCounterPipeline(r, CounterOptions{FlushInterval: 10 * time.Millisecond}, nil)
+
+	p.enqueue(entities.Click{Code: "84gfj4i9"})
+	time.Sleep(30 * time.Millisecond)
+	r.setFailing(false)
+
+	if err := p.close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if totals, _ := r.totals(); totals[entities.Click{Code: "84gfj4i9"}] != 1 {
+		t.Errorf("expected the failed clicks to be saved by a later flush, got (%v)", totals)
+	}
+}
+
+func TestCounterPipelineCloseError(t *testing.T) {
+	r := &CounterRepositoryMock{failing: true}
+	p := newCounterPipeline(r, CounterOptions{FlushInterval: time.Hour}, nil)
+
+	p.enqueue(entities.Click{Code: "84gfj4i9"})
+
+	if err := p.close(context.Background()); err == nil {
+		t.Errorf("expected an error when the last flush fails")
+	}
+}
+
+func TestCounterPipelineCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	r := &CounterRepositoryMock{release: release}
+	p := newCounterPipeline(r, CounterOptions{FlushInterval: time.Hour}, nil)
+
+	p.enqueue(entities.Click{Code: "84gfj4i9"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := p.close(ctx); err == nil {
+		t.Errorf("expected an error when the context is done before the flush")
+	}
+}
+
+func TestCounterPipelineOnFlush(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode string
+	}{
+		{name: "memory", mode: CounterModeMemory},
+		{name: "redis", mode: CounterModeRedis},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &CounterRepositoryMock{}
+
+			var mu sync.Mutex
+			flushed := make(map[entities.Click]int64)
+			p := newCounterPipeline(r, CounterOptions{FlushInterval: time.Hour, Mode: tc.mode}, func(clicks map[entities.Click]int64) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				for click, n := range clicks {
+					flushed[click] += n
+				}
+			})
+
+			p.count(context.Background(), entities.Click{Code: "84gfj4i9"})
+			p.count(context.Background(), entities.Click{Code: "84gfj4i9"})
+
+			if err := p.close(context.Background()); err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if flushed[entities.Click{Code: "84gfj4i9"}] != 2 {
+				t.Errorf("expected the flushed clicks to be passed to onFlush, got (%v)", flushed)
+			}
+		})
+	}
+}
+
+func TestCounterPipelineRedis(t *testing.T) {
+	r := &CounterRepositoryMock{}
+	p := newCounterPipeline(r, CounterOptions{FlushInterval: time.Hour, Mode: CounterModeRedis}, nil)
+
+	p.count(context.Background(), entities.Click{Code: "84gfj4i9"})
+	p.count(context.Background(), entities.Click{Code: "84gfj4i9", VariantId: 10})
+
+	r.mu.Lock()
+	redisClicks := len(r.redis)
+	r.mu.Unlock()
+
+	if redisClicks != 2 {
+		t.Errorf("expected the clicks to be counted in redis, got (%d)", redisClicks)
+	}
+
+	// the clicks are queued while redis is down
+	r.mu.Lock()
+	r.redisDown = true
+	r.mu.Unlock()
+
+	p.count(context.Background(), entities.Click{Code: "84gfj4i9"})
+
+	if err := p.close(context.Background()); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	totals, _ := r.totals()
+	if totals[entities.Click{Code: "84gfj4i9"}] != 2 || totals[entities.Click{Code: "84gfj4i9", VariantId: 10}] != 1 {
+		t.Errorf("expected the redis and queued clicks to be saved on close, got (%v)", totals)
+	}
+}
+
+func TestCounterPipelineRedisInterval(t *testing.T) {
+	r := &CounterRepositoryMock{}
+	p := newCounterPipeline(r, CounterOptions{FlushInterval: 10 * time.Millisecond, Mode: CounterModeRedis}, nil)
+	defer p.close(context.Background())
+
+	p.count(context.Background(), entities.Click{Code: "84gfj4i9"})
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if totals, _ := r.totals(); totals[entities.Click{Code: "84gfj4i9"}] == 1 {
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Errorf("expected the redis clicks to be flushed on the interval")
+}
+
+func TestCounterPipelineRedisCloseError(t *testing.T) {
+	r := &CounterRepositoryMock{}
+	p := newCounterPipeline(r, CounterOptions{FlushInterval: time.Hour, Mode: CounterModeRedis}, nil)
+
+	p.count(context.Background(), entities.Click{Code: "84gfj4i9"})
+	r.setFailing(true)
+
+	if err := p.close(context.Background()); err == nil {
+		t.Errorf("expected an error when the last redis flush fails")
+	}
+}
+
+func TestCounterPipelineFlushNow(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode string
+	}{
+		{name: "memory", mode: CounterModeMemory},
+		{name: "redis", mode: CounterModeRedis},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &CounterRepositoryMock{}
+			p := newCounterPipeline(r, CounterOptions{FlushInterval: time.Hour, Mode: tc.mode}, nil)
+
+			p.count(context.Background(), entities.Click{Code: "84gfj4i9"})
+			p.enqueue(entities.Click{Code: "84gfj4i9"})
+
+			if err := p.flushNow(context.Background()); err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if totals, _ := r.totals(); totals[entities.Click{Code: "84gfj4i9"}] != 2 {
+				t.Errorf("expected the clicks to be saved before flushNow returns, got (%v)", totals)
+			}
+
+			p.enqueue(entities.Click{Code: "84gfj4i9"})
+			r.setFailing(true)
+			if err := p.flushNow(context.Background()); err == nil {
+				t.Errorf("expected an error when the flush fails")
+			}
+
+			r.setFailing(false)
+			if err := p.close(context.Background()); err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if err := p.flushNow(context.Background()); err == nil {
+				t.Errorf("expected an error after close")
+			}
+		})
+	}
+}
@@ -1,6 +1,169 @@
 package service
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator"
+	"github.com/norby7/shortening-service/usecases/repository"
+	"strings"
+)
 
-var ErrCodeAlreadyExists = fmt.Errorf("code already exists in the database")
-var ErrCheckCode = fmt.Errorf("unable to check if the code already exists in the database")
+// ErrorDomain is the domain of the service errors reasons, it is sent with the reason to the grpc clients
+const ErrorDomain = "shortening-service"
+
+// Kind is the category of a service error, the transports translate it into their status codes
+type Kind int
+
+const (
+	// KindInternal is an unexpected failure, the errors that are not service errors have this kind
+	KindInternal Kind = iota
+	// KindInvalidArgument is a request that can't be served as it is
+	KindInvalidArgument
+	// KindNotFound is a request for a url that doesn't exist
+	KindNotFound
+	// KindAlreadyExists is a request that conflicts with an existing url
+	KindAlreadyExists
+	// KindFailedPrecondition is a request for a url in a state that doesn't allow it
+	KindFailedPrecondition
+	// KindUnavailable is a temporary failure, the request can be retried later
+	KindUnavailable
+)
+
+// String returns the name of the kind
+func (k Kind) String() string {
+	switch k {
+	case KindInvalidArgument:
+		return "invalid argument"
+	case KindNotFound:
+		return "not found"
+	case KindAlreadyExists:
+		return "already exists"
+	case KindFailedPrecondition:
+		return "failed precondition"
+	case KindUnavailable:
+		return "unavailable"
+	}
+
+	return "internal"
+}
+
+// FieldViolation describes an invalid field of a request, Field is the json path of the field
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Error is a service error, the transports translate its kind into a status code and send its reason to the clients
+// The sentinel errors below are compared with errors.Is, the errors returned with a cause or with field violations
+// match the sentinel of their reason
+type Error struct {
+	Kind Kind
+	// Reason is a stable UPPER_SNAKE_CASE identifier of the error
+	Reason     string
+	Message    string
+	Violations []FieldViolation
+	// Err is the cause of the error, it's not sent to the clients
+	Err error
+}
+
+// Error returns the error message followed by its cause
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+
+	return e.Message
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is checks if target is a service error with the same reason
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Reason == e.Reason
+}
+
+// Wrap returns a copy of the error caused by err
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.Err = err
+
+	return &c
+}
+
+var ErrCodeAlreadyExists = &Error{Kind: KindAlreadyExists, Reason: "CODE_ALREADY_EXISTS", Message: "code already exists in the database"}
+var ErrCheckCode = &Error{Kind: KindInternal, Reason: "CODE_CHECK_FAILED", Message: "unable to check if the code already exists in the database"}
+var ErrUrlNotFound = &Error{Kind: KindNotFound, Reason: "URL_NOT_FOUND", Message: "url not found in the database"}
+var ErrVariantNotFound = &Error{Kind: KindInvalidArgument, Reason: "VARIANT_NOT_FOUND", Message: "variant not found for the url"}
+var ErrClicksExhausted = &Error{Kind: KindFailedPrecondition, Reason: "CLICKS_EXHAUSTED", Message: "url has no clicks left"}
+var ErrInvalidAuditFilter = &Error{Kind: KindInvalidArgument, Reason: "INVALID_AUDIT_FILTER", Message: "audit filter until must be after from"}
+var ErrInvalidAuditLimit = &Error{Kind: KindInvalidArgument, Reason: "INVALID_AUDIT_FILTER", Message: "audit filter limit must be a positive number"}
+var ErrInvalidUrl = &Error{Kind: KindInvalidArgument, Reason: "INVALID_URL", Message: "invalid url"}
+var ErrStorageUnavailable = &Error{Kind: KindUnavailable, Reason: "STORAGE_UNAVAILABLE", Message: "storage unavailable"}
+var ErrSlowConsumer = &Error{Kind: KindUnavailable, Reason: "SLOW_CONSUMER", Message: "click feed subscriber dropped, it didn't keep up with the events"}
+var ErrInvalidWebhook = &Error{Kind: KindInvalidArgument, Reason: "INVALID_WEBHOOK", Message: "invalid webhook"}
+var ErrWebhookNotFound = &Error{Kind: KindNotFound, Reason: "WEBHOOK_NOT_FOUND", Message: "webhook not found in the database"}
+var ErrDeadLetterNotFound = &Error{Kind: KindNotFound, Reason: "DEAD_LETTER_NOT_FOUND", Message: "dead letter not found in the database"}
+var ErrWebhooksDisabled = &Error{Kind: KindFailedPrecondition, Reason: "WEBHOOKS_DISABLED", Message: "webhook deliveries are not started"}
+var ErrWebhookDeliveryFailed = &Error{Kind: KindUnavailable, Reason: "WEBHOOK_DELIVERY_FAILED", Message: "unable to deliver the event to the webhook"}
+var ErrInvalidExport = &Error{Kind: KindInvalidArgument, Reason: "INVALID_EXPORT", Message: "invalid export options"}
+var ErrInvalidImport = &Error{Kind: KindInvalidArgument, Reason: "INVALID_IMPORT", Message: "invalid import"}
+var ErrImportConflict = &Error{Kind: KindAlreadyExists, Reason: "IMPORT_CONFLICT", Message: "imported codes already exist in the database, nothing was imported"}
+
+// KindOf returns the kind of a service error, KindInternal for the other errors
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	return KindInternal
+}
+
+// invalidUrl returns ErrInvalidUrl caused by the validation error of a url, with a violation for every invalid field
+func invalidUrl(err error) error {
+	e := ErrInvalidUrl.Wrap(err)
+
+	var ve validator.ValidationErrors
+	if errors.As(err, &ve) {
+		for _, fe := range ve {
+			e.Violations = append(e.Violations, FieldViolation{
+				Field:       fieldPath(fe.Namespace()),
+				Description: fmt.Sprintf("failed on the %s validation", fe.Tag()),
+			})
+		}
+	}
+
+	return e
+}
+
+// fieldPath converts the namespace of a validated field, e.g. Url.Rules[0].Url, into its json path, rules[0].url
+func fieldPath(namespace string) string {
+	parts := strings.Split(namespace, ".")
+	if len(parts) > 1 {
+		parts = parts[1:]
+	}
+
+	for i := range parts {
+		parts[i] = strings.ToLower(parts[i][:1]) + parts[i][1:]
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// repositoryError returns ErrStorageUnavailable caused by err if the repository failure is temporary
+// The other errors are prefixed by msg, or returned unchanged if msg is empty
+func repositoryError(msg string, err error) error {
+	if repository.IsUnavailable(err) {
+		return ErrStorageUnavailable.Wrap(err)
+	}
+
+	if msg == "" {
+		return err
+	}
+
+	return fmt.Errorf("%s: %s", msg, err.Error())
+}
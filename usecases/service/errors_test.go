@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/norby7/shortening-service/entities"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		target   error
+		expected bool
+	}{
+		{name: "sentinel", err: ErrUrlNotFound, target: ErrUrlNotFound, expected: true},
+		{name: "wrapped sentinel", err: ErrStorageUnavailable.Wrap(fmt.Errorf("database is locked")), target: ErrStorageUnavailable, expected: true},
+		{name: "other reason", err: ErrUrlNotFound, target: ErrVariantNotFound, expected: false},
+		{name: "cause", err: ErrStorageUnavailable.Wrap(context.DeadlineExceeded), target: context.DeadlineExceeded, expected: true},
+		{name: "not a service error", err: fmt.Errorf("unable to fetch url"), target: ErrUrlNotFound, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if errors.Is(tc.err, tc.target) != tc.expected {
+				t.Errorf("expected errors.Is (%v), got (%v)", tc.expected, !tc.expected)
+			}
+		})
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected Kind
+	}{
+		{name: "already exists", err: ErrCodeAlreadyExists, expected: KindAlreadyExists},
+		{name: "wrapped unavailable", err: ErrStorageUnavailable.Wrap(fmt.Errorf("database is locked")), expected: KindUnavailable},
+		{name: "clicks exhausted", err: ErrClicksExhausted, expected: KindFailedPrecondition},
+		{name: "other error", err: fmt.Errorf("unable to fetch url"), expected: KindInternal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if kind := KindOf(tc.err); kind != tc.expected {
+				t.Errorf("expected kind (%s), got (%s)", tc.expected, kind)
+			}
+		})
+	}
+}
+
+func TestInvalidUrl(t *testing.T) {
+	u := entities.Url{Code: "abc", Url: "https://google.com", Domain: "http://localhost", Rules: []entities.Rule{{}}}
+
+	err := invalidUrl(u.Validate())
+	if !errors.Is(err, ErrInvalidUrl) || KindOf(err) != KindInvalidArgument {
+		t.Fatalf("expected an invalid url error, got (%v)", err)
+	}
+
+	var e *Error
+	errors.As(err, &e)
+
+	fields := map[string]bool{}
+	for _, v := range e.Violations {
+		fields[v.Field] = true
+	}
+
+	for _, f := range []string{"code", "rules[0].url"} {
+		if !fields[f] {
+			t.Errorf("expected a violation of (%s), got (%v)", f, e.Violations)
+		}
+	}
+}
+
+func TestRepositoryError(t *testing.T) {
+	testCases := []struct {
+		name            string
+		msg             string
+		err             error
+		expectedKind    Kind
+		expectedMessage string
+	}{
+		{name: "locked database", msg: "unable to fetch url", err: fmt.Errorf("unable to start transaction: database is locked"), expectedKind: KindUnavailable, expectedMessage: "storage unavailable: unable to start transaction: database is locked"},
+		{name: "storage timeout", err: context.DeadlineExceeded, expectedKind: KindUnavailable, expectedMessage: "storage unavailable: context deadline exceeded"},
+		{name: "other error", msg: "unable to fetch url", err: fmt.Errorf("no such table"), expectedKind: KindInternal, expectedMessage: "unable to fetch url: no such table"},
+		{name: "unchanged error", err: ErrUrlNotFound, expectedKind: KindNotFound, expectedMessage: ErrUrlNotFound.Error()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := repositoryError(tc.msg, tc.err)
+
+			if KindOf(err) != tc.expectedKind {
+				t.Errorf("expected kind (%s), got (%s)", tc.expectedKind, KindOf(err))
+			}
+
+			if err.Error() != tc.expectedMessage {
+				t.Errorf("expected message (%s), got (%s)", tc.expectedMessage, err.Error())
+			}
+		})
+	}
+}
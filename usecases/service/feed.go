@@ -0,0 +1,136 @@
+package service
+
+import (
+	"expvar"
+	"github.com/norby7/shortening-service/entities"
+	"sync"
+)
+
+// DefaultClickFeedBuffer is the number of click events buffered for every subscriber when the options have no buffer
+const DefaultClickFeedBuffer = 100
+
+// feedMetrics are the click feed metrics published by expvar: published events, events delivered to the subscribers,
+// subscribers dropped because they were too slow and current subscribers
+var feedMetrics = expvar.NewMap("clickFeed")
+
+// ClickFeed publishes the counted clicks to the subscribers whose filter selects them
+// Publishing never waits for the subscribers, every subscriber has its own buffer and a subscriber whose buffer is
+// full is dropped, its subscription ends with ErrSlowConsumer so it knows it missed events
+type ClickFeed struct {
+	buffer int
+	// mu guards subscribers and closed, Publish holds it for reading so the events are never sent on a closed channel
+	mu          sync.RWMutex
+	subscribers map[*ClickSubscription]struct{}
+	closed      bool
+}
+
+// ClickSubscription receives the click events selected by its filter until it's closed or dropped
+type ClickSubscription struct {
+	filter entities.ClickFilter
+	events chan entities.ClickEvent
+	feed   *ClickFeed
+	// the reason the subscription ended, set before events is closed
+	err error
+}
+
+// NewClickFeed returns a ClickFeed that buffers up to buffer events for every subscriber, DefaultClickFeedBuffer if it's not positive
+func NewClickFeed(buffer int) *ClickFeed {
+	if buffer <= 0 {
+		buffer = DefaultClickFeedBuffer
+	}
+
+	return &ClickFeed{buffer: buffer, subscribers: make(map[*ClickSubscription]struct{})}
+}
+
+// Subscribe returns a subscription to the events selected by the filter, it must be closed when it's no longer read
+// The subscriptions created after the feed is closed are already ended
+func (f *ClickFeed) Subscribe(filter entities.ClickFilter) *ClickSubscription {
+	s := &ClickSubscription{filter: filter, events: make(chan entities.ClickEvent, f.buffer), feed: f}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		close(s.events)
+		return s
+	}
+
+	f.subscribers[s] = struct{}{}
+	feedMetrics.Add("subscribers", 1)
+
+	return s
+}
+
+// Publish sends the event to the subscribers whose filter selects it and drops the subscribers whose buffer is full
+func (f *ClickFeed) Publish(e entities.ClickEvent) {
+	var slow []*ClickSubscription
+
+	f.mu.RLock()
+	for s := range f.subscribers {
+		if !s.filter.Matches(&e) {
+			continue
+		}
+
+		select {
+		case s.events <- e:
+			feedMetrics.Add("delivered", 1)
+		default:
+			slow = append(slow, s)
+		}
+	}
+	f.mu.RUnlock()
+
+	feedMetrics.Add("published", 1)
+
+	for _, s := range slow {
+		if f.remove(s, ErrSlowConsumer) {
+			feedMetrics.Add("dropped", 1)
+		}
+	}
+}
+
+// Close ends every subscription, the subscribers receive their buffered events and then see their channel closed
+func (f *ClickFeed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for s := range f.subscribers {
+		delete(f.subscribers, s)
+		close(s.events)
+		feedMetrics.Add("subscribers", -1)
+	}
+}
+
+// remove ends the subscription with the given reason and returns false if it had already ended
+func (f *ClickFeed) remove(s *ClickSubscription, reason error) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.subscribers[s]; !ok {
+		return false
+	}
+
+	delete(f.subscribers, s)
+	s.err = reason
+	close(s.events)
+	feedMetrics.Add("subscribers", -1)
+
+	return true
+}
+
+// Events returns the channel of the subscription events, it's closed when the subscription ends
+func (s *ClickSubscription) Events() <-chan entities.ClickEvent {
+	return s.events
+}
+
+// Err returns ErrSlowConsumer if the subscription was dropped, nil if it was closed or the feed was closed
+// It must only be called after the events channel is closed
+func (s *ClickSubscription) Err() error {
+	return s.err
+}
+
+// Close ends the subscription, it can be called more than once
+func (s *ClickSubscription) Close() {
+	s.feed.remove(s, nil)
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"errors"
+	"github.com/norby7/shortening-service/entities"
+	"testing"
+	"time"
+)
+
+// drain returns the events left in the subscription once it's ended
+func drain(s *ClickSubscription) []entities.ClickEvent {
+	var events []entities.ClickEvent
+	for e := range s.Events() {
+		events = append(events, e)
+	}
+
+	return events
+}
+
+func TestClickFeedPublish(t *testing.T) {
+	f := NewClickFeed(10)
+
+	all := f.Subscribe(entities.ClickFilter{})
+	byUrl := f.Subscribe(entities.ClickFilter{UrlId: 1})
+	byTag := f.Subscribe(entities.ClickFilter{Tag: "newsletter"})
+
+	first := entities.Url{Id: 1, Code: "84gfj4i9", Owner: entities.AnonymousActor}
+	second := entities.Url{Id: 2, Code: "a1b2c3d4", Owner: entities.AnonymousActor, Tags: []string{"newsletter"}}
+
+	f.Publish(entities.NewClickEvent(&first, 0, time.Now()))
+	f.Publish(entities.NewClickEvent(&second, 0, time.Now()))
+	f.Publish(entities.NewClickEvent(&first, 0, time.Now()))
+	f.Close()
+
+	testCases := []struct {
+		name          string
+		subscription  *ClickSubscription
+		expectedCodes []string
+	}{
+		{
+			name:          "every event",
+			subscription:  all,
+			expectedCodes: []string{"84gfj4i9", "a1b2c3d4", "84gfj4i9"},
+		},
+		{
+			name:          "url id",
+			subscription:  byUrl,
+			expectedCodes: []string{"84gfj4i9", "84gfj4i9"},
+		},
+		{
+			name:          "tag",
+			subscription:  byTag,
+			expectedCodes: []string{"a1b2c3d4"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			events := drain(tc.subscription)
+			if len(events) != len(tc.expectedCodes) {
+				t.Fatalf("expected (%d) events, got (%v)", len(tc.expectedCodes), events)
+			}
+
+			for i := range events {
+				if events[i].Code != tc.expectedCodes[i] {
+					t.Errorf("expected event (%d) code (%s), got (%s)", i, tc.expectedCodes[i], events[i].Code)
+				}
+			}
+
+			if tc.subscription.Err() != nil {
+				t.Errorf("expected no error when the feed is closed, got (%v)", tc.subscription.Err())
+			}
+		})
+	}
+}
+
+func TestClickFeedSlowConsumer(t *testing.T) {
+	f := NewClickFeed(2)
+	defer f.Close()
+
+	slow := f.Subscribe(entities.ClickFilter{})
+	fast := f.Subscribe(entities.ClickFilter{})
+
+	u := entities.Url{Id: 1, Code: "84gfj4i9"}
+	for i := 0; i < 3; i++ {
+		f.Publish(entities.NewClickEvent(&u, 0, time.Now()))
+
+		// the fast subscriber keeps up with the events
+		<-fast.Events()
+	}
+
+	// the slow subscriber keeps the buffered events and is dropped on the first event it has no room for
+	if events := drain(slow); len(events) != 2 {
+		t.Errorf("expected (2) buffered events, got (%v)", events)
+	}
+
+	if !errors.Is(slow.Err(), ErrSlowConsumer) {
+		t.Errorf("expected error (%v), got (%v)", ErrSlowConsumer, slow.Err())
+	}
+
+	f.Publish(entities.NewClickEvent(&u, 0, time.Now()))
+	select {
+	case <-fast.Events():
+	default:
+		t.Errorf("expected the fast subscriber to keep receiving events")
+	}
+}
+
+func TestClickSubscriptionClose(t *testing.T) {
+	f := NewClickFeed(0)
+
+	s := f.Subscribe(entities.ClickFilter{})
+	s.Close()
+	s.Close()
+
+	u := entities.Url{Id: 1, Code: "84gfj4i9"}
+	f.Publish(entities.NewClickEvent(&u, 0, time.Now()))
+
+	if events := drain(s); len(events) != 0 {
+		t.Errorf("expected no events after close, got (%v)", events)
+	}
+
+	if s.Err() != nil {
+		t.Errorf("expected no error, got (%v)", s.Err())
+	}
+
+	// the subscriptions of a closed feed are already ended
+	f.Close()
+	if events := drain(f.Subscribe(entities.ClickFilter{})); len(events) != 0 {
+		t.Errorf("expected no events from a closed feed, got (%v)", events)
+	}
+}
@@ -1,11 +1,34 @@
 package service
 
-import "github.com/norby7/shortening-service/entities"
+import (
+	"context"
+	"github.com/norby7/shortening-service/entities"
+	"io"
+)
 
 type Interactor interface {
-	Create(*entities.Url) error
-	Delete(int64) error
-	GetUrlByCode(string) (string, error)
-	GetById(int64) (entities.Url, error)
-	IncrementCounter(string)
+	Create(context.Context, *entities.Url, entities.Actor) error
+	Delete(context.Context, int64, entities.Actor) error
+	Restore(context.Context, int64, entities.Actor) (entities.Url, error)
+	GetDeleted(context.Context) ([]entities.Url, error)
+	GetAuditEvents(context.Context, entities.AuditFilter) ([]entities.AuditEvent, error)
+	Update(context.Context, *entities.Url, entities.Actor) error
+	Replace(context.Context, *entities.Url, entities.Actor) error
+	SetRules(context.Context, int64, []entities.Rule, entities.Actor) (entities.Url, error)
+	SetVariants(context.Context, int64, []entities.Variant, entities.Actor) (entities.Url, error)
+	GetUrlByCode(context.Context, string) (entities.Url, error)
+	GetById(context.Context, int64) (entities.Url, error)
+	GetByCode(context.Context, string) (entities.Url, error)
+	IncrementCounter(context.Context, entities.Click) bool
+	ConsumeClick(context.Context, entities.Click) error
+	RemainingClicks(context.Context, string) (int64, error)
+	PublishClick(context.Context, entities.ClickEvent)
+	SubscribeClicks(context.Context, entities.ClickFilter) *ClickSubscription
+	AddWebhook(context.Context, *entities.Webhook) error
+	GetWebhooks(context.Context) ([]entities.Webhook, error)
+	DeleteWebhook(context.Context, int64) error
+	GetDeadLetters(context.Context, int64) ([]entities.DeadLetter, error)
+	ReplayDeadLetter(context.Context, int64) error
+	Export(context.Context, entities.ExportFilter, string, io.Writer) (int, error)
+	Import(context.Context, io.Reader, entities.ImportOptions, entities.Actor) (entities.ImportReport, error)
 }
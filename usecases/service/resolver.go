@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/norby7/shortening-service/entities"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// permanentRedirectCacheControl is the Cache-Control header value sent for permanent redirects
+const permanentRedirectCacheControl = "public, max-age=31536000"
+
+// permanentRedirectMaxAge is the max-age of permanentRedirectCacheControl
+const permanentRedirectMaxAge = 365 * 24 * time.Hour
+
+// noStore is the Cache-Control header value of the responses that must reach the service every time
+const noStore = "no-store"
+
+// GeoLocator returns the ISO 3166-1 alpha-2 country code of an ip address
+// An empty country code is returned if the ip address location is unknown
+type GeoLocator interface {
+	Country(net.IP) (string, error)
+}
+
+// Resolver resolves the short url requests of the http redirects and of the grpc Resolve calls
+type Resolver struct {
+	Service Interactor
+	// Geo locates the client country for the redirect rules, country rules never match if it's nil
+	Geo    GeoLocator
+	Logger *slog.Logger
+}
+
+// NewResolver returns a new Resolver object address
+func NewResolver(s Interactor, geo GeoLocator, l *slog.Logger) *Resolver {
+	return &Resolver{Service: s, Geo: geo, Logger: l}
+}
+
+// Resolve returns the response to a request for the short url with the given code
+// The status code of the response is the url redirect type, or 404 if the url doesn't exist, doesn't accept the path
+// or is not active yet, and 410 if it expired or has no clicks left. Outside of the activation window the urls with
+// a fallback url redirect to it with 302. The rules are matched against the visitor and the traffic that no rule
+// claimed is split across the variants. The click is counted if the request records it and the url is active,
+// synchronously for the click limited urls so concurrent requests can't exceed the limit, and published to the click feed
+// unless it was dropped. The clicks left of the click limited urls are checked when the click isn't recorded too
+func (r *Resolver) Resolve(ctx context.Context, code string, req entities.ResolveRequest) (entities.Resolution, error) {
+	u, err := r.Service.GetUrlByCode(ctx, code)
+	if err != nil {
+		return entities.Resolution{}, err
+	}
+
+	if u.Id == 0 || (req.Path != "" && !u.PrefixMode) {
+		return entities.Resolution{Status: http.StatusNotFound}, nil
+	}
+
+	res := entities.Resolution{UrlId: u.Id}
+
+	// outside of the activation window the url is not counted, visitors go to the fallback url if there is one
+	now := time.Now()
+	if !u.IsActive(now) {
+		res.CacheControl = noStore
+
+		switch {
+		case u.FallbackUrl != "":
+			res.Status = http.StatusFound
+			res.Location = u.FallbackUrl
+		case u.IsExpired(now):
+			res.Status = http.StatusGone
+		default:
+			res.Status = http.StatusNotFound
+		}
+
+		return res, nil
+	}
+
+	click := entities.Click{Code: code}
+	matched := false
+	if len(u.Rules) > 0 {
+		visitor := req.Visitor
+		visitor.Time = now
+		if u.HasCountryRules() {
+			visitor.Country = r.country(ctx, req.ClientIp)
+		}
+
+		u.Url, matched = u.MatchRules(visitor)
+	}
+
+	// the traffic that no rule claimed is split across the variants
+	if !matched && len(u.Variants) > 0 {
+		if v, assigned := pickVariant(&u, req.VariantId); v != nil {
+			u.Url = v.Url
+			click.VariantId = v.Id
+			res.VariantId = v.Id
+			res.NewVariant = assigned
+		}
+	}
+
+	dest, err := u.Destination(req.Path, req.Query)
+	if err != nil {
+		return entities.Resolution{}, fmt.Errorf("invalid url destination: %s", err.Error())
+	}
+
+	if req.RecordClick {
+		counted := true
+		// click limited urls are counted synchronously so concurrent redirects can't exceed the limit
+		if u.IsClickLimited() {
+			if err = r.Service.ConsumeClick(ctx, click); err != nil {
+				if errors.Is(err, ErrClicksExhausted) {
+					return entities.Resolution{UrlId: u.Id, Status: http.StatusGone, CacheControl: noStore}, nil
+				}
+
+				return entities.Resolution{}, err
+			}
+		} else {
+			counted = r.Service.IncrementCounter(ctx, click)
+		}
+
+		// the dropped clicks are not published
+		if counted {
+			r.Service.PublishClick(ctx, entities.NewClickEvent(&u, click.VariantId, now))
+		}
+	} else if u.IsClickLimited() {
+		// the counter of the url isn't cached, the clicks left are read from the storage
+		left, err := r.Service.RemainingClicks(ctx, code)
+		if err != nil {
+			return entities.Resolution{}, err
+		}
+
+		if left <= 0 {
+			return entities.Resolution{UrlId: u.Id, Status: http.StatusGone, CacheControl: noStore}, nil
+		}
+	}
+
+	// temporary redirects must reach the server every time so the counter keeps working,
+	// split and click limited redirects are never cached so every click is counted, and the redirects of urls with
+	// rules aren't either since their destination depends on the visitor and the time
+	if u.IsPermanentRedirect() && click.VariantId == 0 && !u.IsClickLimited() && len(u.Rules) == 0 {
+		res.CacheControl = permanentCacheControl(&u, now)
+	} else {
+		res.CacheControl = noStore
+	}
+
+	res.Status = u.RedirectStatus()
+	res.Location = dest
+
+	return res, nil
+}
+
+// country returns the country of the client ip, empty if it's unknown or no GeoLocator is available
+func (r *Resolver) country(ctx context.Context, ip net.IP) string {
+	if r.Geo == nil || ip == nil {
+		return ""
+	}
+
+	country, err := r.Geo.Country(ip)
+	if err != nil && r.Logger != nil {
+		r.Logger.WarnContext(ctx, "unable to locate client ip", "error", err.Error())
+	}
+
+	return country
+}
+
+// pickVariant returns the variant of the url the visitor is assigned to, nil if no variant has weight
+// Returning visitors keep their variant while it exists and has weight, the others are assigned a variant by weight
+// and assigned is set so the assignment can be stored
+func pickVariant(u *entities.Url, previous int64) (v *entities.Variant, assigned bool) {
+	if previous != 0 {
+		if v = u.VariantById(previous); v != nil && v.Weight > 0 {
+			return v, false
+		}
+	}
+
+	total := u.TotalWeight()
+	if total <= 0 {
+		return nil, false
+	}
+
+	v = u.PickVariant(rand.Intn(total))
+
+	return v, v != nil
+}
+
+// permanentCacheControl returns the Cache-Control header value of a permanent redirect,
+// clients can't cache it past the end of the url activation window
+func permanentCacheControl(u *entities.Url, now time.Time) string {
+	if u.ActiveUntil == nil || u.ActiveUntil.Sub(now) >= permanentRedirectMaxAge {
+		return permanentRedirectCacheControl
+	}
+
+	return fmt.Sprintf("public, max-age=%d", int64(u.ActiveUntil.Sub(now).Seconds()))
+}
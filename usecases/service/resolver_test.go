@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"github.com/norby7/shortening-service/entities"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// resolverServiceMock serves the urls of the resolver tests and records the counted and published clicks
+type resolverServiceMock struct {
+	Interactor
+	counted   []entities.Click
+	published []entities.ClickEvent
+}
+
+func (s *resolverServiceMock) GetUrlByCode(ctx context.Context, code string) (entities.Url, error) {
+	switch code {
+	case "invalidCode":
+		return entities.Url{}, getError
+	case "84gfj4i9":
+		return entities.Url{Id: 1, Code: code, Url: "https://google.com", RedirectType: entities.RedirectPermanent}, nil
+	case "pr3f1x00":
+		return entities.Url{Id: 2, Code: code, Url: "https://example.com/docs", PrefixMode: true, ForwardQuery: true}, nil
+	case "ru1es000":
+		return entities.Url{Id: 3, Code: code, Url: "https://example.com", Rules: []entities.Rule{
+			{Url: "https://example.de", Countries: []string{"DE"}},
+		}}, nil
+	case "sp1it000":
+		return entities.Url{Id: 4, Code: code, Url: "https://example.com", Variants: []entities.Variant{
+			{Id: 21, Url: "https://example.com/a", Weight: 100},
+			{Id: 22, Url: "https://example.com/b", Weight: 0},
+			{Id: 23, Url: "https://example.com/c", Weight: 50},
+		}}, nil
+	case "ru1esp1t":
+		return entities.Url{Id: 7, Code: code, Url: "https://example.com", Rules: []entities.Rule{
+			{Url: "https://example.com", Countries: []string{"DE"}},
+		}, Variants: []entities.Variant{{Id: 31, Url: "https://example.com/a", Weight: 100}}}, nil
+	case "ru1esp3r":
+		return entities.Url{Id: 8, Code: code, Url: "https://example.com", RedirectType: entities.RedirectPermanent, Rules: []entities.Rule{
+			{Url: "https://example.de", Countries: []string{"DE"}},
+		}}, nil
+	case "exhau5te":
+		return entities.Url{Id: 5, Code: code, Url: "https://example.com", MaxClicks: 1}, nil
+	case "l1m1ted0":
+		return entities.Url{Id: 9, Code: code, Url: "https://example.com", MaxClicks: 5}, nil
+	case "dr0pped0":
+		return entities.Url{Id: 10, Code: code, Url: "https://example.com"}, nil
+	case "exp1red0":
+		until := time.Now().Add(-time.Hour)
+		return entities.Url{Id: 6, Code: code, Url: "https://example.com", ActiveUntil: &until}, nil
+	}
+
+	return entities.Url{}, nil
+}
+
+func (s *resolverServiceMock) ConsumeClick(ctx context.Context, click entities.Click) error {
+	return ErrClicksExhausted
+}
+
+func (s *resolverServiceMock) RemainingClicks(ctx context.Context, code string) (int64, error) {
+	if code == "exhau5te" {
+		return 0, nil
+	}
+
+	return 4, nil
+}
+
+func (s *resolverServiceMock) IncrementCounter(ctx context.Context, click entities.Click) bool {
+	// the clicks of dr0pped0 are dropped by the counter
+	if click.Code == "dr0pped0" {
+		return false
+	}
+
+	s.counted = append(s.counted, click)
+
+	return true
+}
+
+func (s *resolverServiceMock) PublishClick(ctx context.Context, e entities.ClickEvent) {
+	s.published = append(s.published, e)
+}
+
+type geoLocatorMock struct{}
+
+func (g *geoLocatorMock) Country(ip net.IP) (string, error) {
+	if ip.Equal(net.ParseIP("192.0.2.1")) {
+		return "DE", nil
+	}
+
+	return "", nil
+}
+
+func TestResolve(t *testing.T) {
+	testCases := []struct {
+		name            string
+		code            string
+		request         entities.ResolveRequest
+		expected        entities.Resolution
+		expectedError   bool
+		expectedCounted int
+	}{
+		{name: "get error", code: "invalidCode", expectedError: true},
+		{name: "url not found", code: "84gfasdf", expected: entities.Resolution{Status: http.StatusNotFound}},
+		{
+			name:            "permanent redirect",
+			code:            "84gfj4i9",
+			request:         entities.ResolveRequest{RecordClick: true},
+			expected:        entities.Resolution{UrlId: 1, Status: http.StatusPermanentRedirect, Location: "https://google.com", CacheControl: permanentRedirectCacheControl},
+			expectedCounted: 1,
+		},
+		{
+			name:     "click not recorded",
+			code:     "84gfj4i9",
+			expected: entities.Resolution{UrlId: 1, Status: http.StatusPermanentRedirect, Location: "https://google.com", CacheControl: permanentRedirectCacheControl},
+		},
+		{
+			name:     "path without prefix mode",
+			code:     "84gfj4i9",
+			request:  entities.ResolveRequest{Path: "guide"},
+			expected: entities.Resolution{Status: http.StatusNotFound},
+		},
+		{
+			name:     "prefix mode with query",
+			code:     "pr3f1x00",
+			request:  entities.ResolveRequest{Path: "guide", Query: url.Values{"ref": {"proxy"}}},
+			expected: entities.Resolution{UrlId: 2, Status: http.StatusFound, Location: "https://example.com/docs/guide?ref=proxy", CacheControl: noStore},
+		},
+		{
+			name:     "country rule",
+			code:     "ru1es000",
+			request:  entities.ResolveRequest{ClientIp: net.ParseIP("192.0.2.1")},
+			expected: entities.Resolution{UrlId: 3, Status: http.StatusFound, Location: "https://example.de", CacheControl: noStore},
+		},
+		{
+			name:     "rule of the original url overrides the variants",
+			code:     "ru1esp1t",
+			request:  entities.ResolveRequest{ClientIp: net.ParseIP("192.0.2.1")},
+			expected: entities.Resolution{UrlId: 7, Status: http.StatusFound, Location: "https://example.com", CacheControl: noStore},
+		},
+		{
+			name:     "permanent redirect of a rule",
+			code:     "ru1esp3r",
+			request:  entities.ResolveRequest{ClientIp: net.ParseIP("192.0.2.1")},
+			expected: entities.Resolution{UrlId: 8, Status: http.StatusPermanentRedirect, Location: "https://example.de", CacheControl: noStore},
+		},
+		{
+			name:     "permanent redirect of a url with rules",
+			code:     "ru1esp3r",
+			expected: entities.Resolution{UrlId: 8, Status: http.StatusPermanentRedirect, Location: "https://example.com", CacheControl: noStore},
+		},
+		{
+			name:     "returning visitor keeps the variant",
+			code:     "sp1it000",
+			request:  entities.ResolveRequest{VariantId: 23},
+			expected: entities.Resolution{UrlId: 4, Status: http.StatusFound, Location: "https://example.com/c", CacheControl: noStore, VariantId: 23},
+		},
+		{
+			name:     "variant without weight is reassigned",
+			code:     "sp1it000",
+			request:  entities.ResolveRequest{VariantId: 22},
+			expected: entities.Resolution{UrlId: 4, Status: http.StatusFound, Location: "https://example.com/a", CacheControl: noStore, VariantId: 21, NewVariant: true},
+		},
+		{
+			name:     "clicks exhausted",
+			code:     "exhau5te",
+			request:  entities.ResolveRequest{RecordClick: true},
+			expected: entities.Resolution{UrlId: 5, Status: http.StatusGone, CacheControl: noStore},
+		},
+		{
+			name:     "dropped click",
+			code:     "dr0pped0",
+			request:  entities.ResolveRequest{RecordClick: true},
+			expected: entities.Resolution{UrlId: 10, Status: http.StatusFound, Location: "https://example.com", CacheControl: noStore},
+		},
+		{
+			name:     "clicks exhausted, click not recorded",
+			code:     "exhau5te",
+			expected: entities.Resolution{UrlId: 5, Status: http.StatusGone, CacheControl: noStore},
+		},
+		{
+			name:     "clicks left, click not recorded",
+			code:     "l1m1ted0",
+			expected: entities.Resolution{UrlId: 9, Status: http.StatusFound, Location: "https://example.com", CacheControl: noStore},
+		},
+		{
+			name:     "expired",
+			code:     "exp1red0",
+			request:  entities.ResolveRequest{RecordClick: true},
+			expected: entities.Resolution{UrlId: 6, Status: http.StatusGone, CacheControl: noStore},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &resolverServiceMock{}
+			r := NewResolver(s, &geoLocatorMock{}, nil)
+
+			res, err := r.Resolve(context.Background(), tc.code, tc.request)
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+
+			// the variant assigned to a new visitor is random, only the weighted variant can be picked here
+			if res != tc.expected && !(tc.expected.NewVariant && res.VariantId == 23 && res.NewVariant) {
+				t.Errorf("expected resolution (%+v), got (%+v)", tc.expected, res)
+			}
+
+			if len(s.counted) != tc.expectedCounted {
+				t.Errorf("expected (%d) counted clicks, got (%v)", tc.expectedCounted, s.counted)
+			}
+
+			// every counted click is published, with the url it redirected
+			if len(s.published) != tc.expectedCounted {
+				t.Errorf("expected (%d) published clicks, got (%v)", tc.expectedCounted, s.published)
+			}
+
+			for _, e := range s.published {
+				if e.UrlId != res.UrlId || e.Code != tc.code {
+					t.Errorf("expected a click event of url (%d) with code (%s), got (%+v)", res.UrlId, tc.code, e)
+				}
+			}
+		})
+	}
+}
+
+func TestPermanentCacheControl(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	soon := now.Add(90 * time.Minute)
+	later := now.Add(2 * permanentRedirectMaxAge)
+
+	testCases := []struct {
+		name     string
+		input    entities.Url
+		expected string
+	}{
+		{
+			name:     "no activation window",
+			input:    entities.Url{},
+			expected: permanentRedirectCacheControl,
+		},
+		{
+			name:     "window ends before the max age",
+			input:    entities.Url{ActiveUntil: &soon},
+			expected: "public, max-age=5400",
+		},
+		{
+			name:     "window ends after the max age",
+			input:    entities.Url{ActiveUntil: &later},
+			expected: permanentRedirectCacheControl,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := permanentCacheControl(&tc.input, now); got != tc.expected {
+				t.Errorf("expected Cache-Control (%v), got (%v)", tc.expected, got)
+			}
+		})
+	}
+}
@@ -1,71 +1,128 @@
 package service
 
 import (
-	"fmt"
+	"context"
 	"github.com/norby7/shortening-service/entities"
 	"github.com/norby7/shortening-service/usecases/repository"
-	"log"
+	"log/slog"
 	"math/rand"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Service struct {
-	Repo        repository.Repository
-	CounterJobs chan string
-	Domain string
+	Repo     repository.Repository
+	Domain   string
+	counters *counterPipeline
+	feed     *ClickFeed
+	// counting is held for reading while a click is counted and for writing while rekeys changes
+	counting sync.RWMutex
+	// rekeys are the rekeys in progress by previous code, their clicks wait until the code is changed
+	rekeys map[string]*rekey
+	// webhooks is set by StartWebhooks, no event is sent before
+	webhooks atomic.Pointer[WebhookDispatcher]
 }
 
 var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
 
+// DefaultTrashRetention is the time deleted urls are kept in the trash before they are permanently removed
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// DefaultRequestTimeout is the maximum duration of an http request or grpc call when no request timeout is configured
+const DefaultRequestTimeout = 10 * time.Second
+
+// DefaultAuditLimit is the number of audit events returned when the filter has no limit
+const DefaultAuditLimit = 100
+
+// MaxAuditLimit is the maximum number of audit events returned by a search
+const MaxAuditLimit = 1000
+
+// trashPurgeInterval is the time between two runs of the trash purge job
+const trashPurgeInterval = time.Hour
+
 // NewService returns a new Service object address
-func NewService(r repository.Repository, workers int, domain string) *Service {
-	counterJobs := make(chan string, 100)
-	for i := 0; i < workers; i++ {
-		go counterWorker(r, counterJobs)
-	}
+// The clicks are counted in the background as configured by the counter options, Close saves the queued clicks
+func NewService(r repository.Repository, counter CounterOptions, domain string) *Service {
+	s := &Service{Repo: r, Domain: domain, feed: NewClickFeed(counter.FeedBuffer)}
+	s.counters = newCounterPipeline(r, counter, s.clicksFlushed)
 
-	return &Service{Repo: r, CounterJobs: counterJobs, Domain: domain}
+	return s
 }
 
-// counterWorker fetches short url codes from a channel and calls the repository IncrementCounter function with the code
-func counterWorker(repo repository.Repository, jobs <-chan string) {
-	for {
-		select {
-		case job, ok := <-jobs:
-			if !ok {
-				return
-			}
+// trashWorker permanently removes, on every interval tick, the urls that were deleted more than retention ago
+func trashWorker(repo repository.Repository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-			err := repo.IncrementCounter(job)
-			if err != nil {
-				log.Printf("unable to increment code (%s) counter: %s\n", job, err.Error())
-			}
+	for now := range ticker.C {
+		n, err := purgeTrash(repo, retention, now)
+		if err != nil {
+			slog.Error("unable to purge deleted urls", "error", err.Error())
+			continue
+		}
+
+		if n > 0 {
+			slog.Info("purged deleted urls", "count", n)
 		}
 	}
 }
 
+// purgeTrash permanently removes the urls that were deleted more than retention before now and returns their number
+func purgeTrash(repo repository.Repository, retention time.Duration, now time.Time) (int64, error) {
+	return repo.PurgeDeleted(context.Background(), now.Add(-retention))
+}
+
+// StartTrashPurger starts the background job that permanently removes the urls deleted more than retention ago
+// The codes of the deleted urls are reserved until they are removed
+func (s *Service) StartTrashPurger(retention time.Duration) {
+	go trashWorker(s.Repo, retention, trashPurgeInterval)
+}
+
 // Create validates the Url object, generates a new code if none is given and inserts it into the repository
-func (s *Service) Create(u *entities.Url) error {
-	// check if the url has no scheme
-	if !strings.HasPrefix(u.Url, "http://") && !strings.HasPrefix(u.Url, "https://") {
-		u.Url = "http://" + u.Url
+// The actor that sends the request becomes the owner of the Url. An existing Url with the same destination is returned
+// instead only if it has the same owner and options and isn't click limited
+func (s *Service) Create(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	u.Url = withScheme(u.Url)
+	for i := range u.Rules {
+		u.Rules[i].Url = withScheme(u.Rules[i].Url)
 	}
 
-	// check if the url exists, return the shortUrl if it does
-	dbUrl, err := s.Repo.GetByUrl(u.Url)
-	if err != nil{
-		return fmt.Errorf("unable to check if url already exist in the database: %s", err.Error())
+	for i := range u.Variants {
+		u.Variants[i].Id = 0
+		u.Variants[i].Counter = 0
+		u.Variants[i].Url = withScheme(u.Variants[i].Url)
 	}
 
-	// if the url is found, return it
-	if dbUrl.Id != 0{
-		*u = dbUrl
-		return nil
+	if u.FallbackUrl != "" {
+		u.FallbackUrl = withScheme(u.FallbackUrl)
+	}
+
+	if u.BurnAfterReading {
+		u.MaxClicks = 1
+		u.BurnAfterReading = false
+	}
+
+	// click limited urls are never shared, every request gets its own code
+	if !u.IsClickLimited() {
+		// check if the url exists, return the shortUrl if it does
+		dbUrl, err := s.Repo.GetByUrl(ctx, u.Url)
+		if err != nil{
+			return repositoryError("unable to check if url already exist in the database", err)
+		}
+
+		// if an unlimited url with the same options and owner is found, return it
+		if dbUrl.Id != 0 && !dbUrl.IsClickLimited() && dbUrl.Owner == actor.Name && sameOptions(dbUrl, *u) {
+			*u = dbUrl
+			return nil
+		}
 	}
 
 	// if no code was sent by the user, generate a new unique code
 	if u.Code == "" {
-		code, err := s.generateNewUniqueCode()
+		code, err := s.generateNewUniqueCode(ctx)
 		if err != nil {
 			return err
 		}
@@ -73,9 +130,9 @@ func (s *Service) Create(u *entities.Url) error {
 		u.Code = code
 	} else {
 		// check if the code already exists
-		exists, err := s.codeExists(u.Code)
+		exists, err := s.codeExists(ctx, u.Code)
 		if err != nil {
-			return fmt.Errorf("%s: %s", ErrCheckCode.Error(), err.Error())
+			return err
 		}
 
 		if exists {
@@ -85,33 +142,719 @@ func (s *Service) Create(u *entities.Url) error {
 
 	u.ShortUrl = s.Domain + "/" + u.Code
 	u.Domain = s.Domain
+	u.CreatedAt = time.Now().UTC()
+	u.Owner = actor.Name
+
+	if u.RedirectType == 0 {
+		u.RedirectType = entities.RedirectFound
+	}
 
 	// validate the Url object
 	if err := u.Validate(); err != nil {
+		return invalidUrl(err)
+	}
+
+	if err := s.Repo.Add(ctx, u, actor); err != nil {
+		return repositoryError("", err)
+	}
+
+	s.notify(ctx, entities.WebhookUrlCreated, *u, 0)
+
+	return nil
+}
+
+// Delete moves a Url to the trash, it stops redirecting and can be restored until it is purged
+func (s *Service) Delete(ctx context.Context, id int64, actor entities.Actor) error {
+	// the url is fetched before it's deleted for the event sent to the webhooks
+	deleted := entities.Url{Id: id}
+	if d := s.webhooks.Load(); d != nil && d.Subscribed(ctx, entities.WebhookUrlDeleted) {
+		if u, err := s.Repo.GetById(ctx, id); err == nil && u.Id != 0 {
+			deleted = u
+		}
+	}
+
+	if err := s.Repo.Delete(ctx, id, actor); err != nil {
+		return repositoryError("", err)
+	}
+
+	s.notify(ctx, entities.WebhookUrlDeleted, deleted, 0)
+
+	return nil
+}
+
+// Restore moves a deleted Url out of the trash and returns it
+// It returns ErrUrlNotFound if no deleted Url exists with the given id
+func (s *Service) Restore(ctx context.Context, id int64, actor entities.Actor) (entities.Url, error) {
+	restored, err := s.Repo.Restore(ctx, id, actor)
+	if err != nil {
+		return entities.Url{}, repositoryError("unable to restore url", err)
+	}
+
+	if !restored {
+		return entities.Url{}, ErrUrlNotFound
+	}
+
+	return s.GetById(ctx, id)
+}
+
+// GetDeleted returns the Urls in the trash
+func (s *Service) GetDeleted(ctx context.Context) ([]entities.Url, error) {
+	urls, err := s.Repo.GetDeleted(ctx)
+	if err != nil {
+		return nil, repositoryError("", err)
+	}
+
+	return urls, nil
+}
+
+// Update changes the editable fields of an existing Url that are set in the given Url, the fields that are not set
+// keep their current value. The options are only turned on and the clicks limit, activation window, fallback url and
+// tags can't be removed, Replace changes every editable field
+// The Url object is replaced with the updated one
+func (s *Service) Update(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	dbUrl, err := s.Repo.GetById(ctx, u.Id)
+	if err != nil {
+		return repositoryError("unable to fetch url", err)
+	}
+
+	if dbUrl.Id == 0 {
+		return ErrUrlNotFound
+	}
+
+	if u.Url != "" {
+		dbUrl.Url = u.Url
+	}
+
+	if u.RedirectType != 0 {
+		dbUrl.RedirectType = u.RedirectType
+	}
+
+	if u.ForwardQuery {
+		dbUrl.ForwardQuery = true
+	}
+
+	if u.PrefixMode {
+		dbUrl.PrefixMode = true
+	}
+
+	if u.MaxClicks != 0 {
+		dbUrl.MaxClicks = u.MaxClicks
+	}
+
+	if u.ActiveFrom != nil {
+		dbUrl.ActiveFrom = u.ActiveFrom
+	}
+
+	if u.ActiveUntil != nil {
+		dbUrl.ActiveUntil = u.ActiveUntil
+	}
+
+	if u.FallbackUrl != "" {
+		dbUrl.FallbackUrl = u.FallbackUrl
+	}
+
+	if u.Tags != nil {
+		dbUrl.Tags = u.Tags
+	}
+
+	if err = s.save(ctx, &dbUrl, actor); err != nil {
 		return err
 	}
 
-	return s.Repo.Add(u)
+	*u = dbUrl
+
+	return nil
+}
+
+// Replace replaces the editable fields of an existing Url with the values of the given Url
+// The editable fields are the original url, the redirect type, the query forwarding and prefix mode options, the clicks limit
+// the activation window with its fallback url and the tags, the owner never changes
+// The redirect rules and variants are kept, they are changed with SetRules and SetVariants
+// The Url object is replaced with the updated one
+func (s *Service) Replace(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	dbUrl, err := s.Repo.GetById(ctx, u.Id)
+	if err != nil {
+		return repositoryError("unable to fetch url", err)
+	}
+
+	if dbUrl.Id == 0 {
+		return ErrUrlNotFound
+	}
+
+	dbUrl.Url = u.Url
+	dbUrl.RedirectType = u.RedirectType
+	dbUrl.ForwardQuery = u.ForwardQuery
+	dbUrl.PrefixMode = u.PrefixMode
+	dbUrl.MaxClicks = u.MaxClicks
+	dbUrl.ActiveFrom = u.ActiveFrom
+	dbUrl.ActiveUntil = u.ActiveUntil
+	dbUrl.FallbackUrl = u.FallbackUrl
+	dbUrl.Tags = u.Tags
+
+	if err = s.save(ctx, &dbUrl, actor); err != nil {
+		return err
+	}
+
+	*u = dbUrl
+
+	return nil
+}
+
+// save completes, validates and saves the editable fields of an updated Url
+func (s *Service) save(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	if u.Url != "" {
+		u.Url = withScheme(u.Url)
+	}
+
+	if u.FallbackUrl != "" {
+		u.FallbackUrl = withScheme(u.FallbackUrl)
+	}
+
+	if u.RedirectType == 0 {
+		u.RedirectType = entities.RedirectFound
+	}
+
+	// validate the Url object
+	if err := u.Validate(); err != nil {
+		return invalidUrl(err)
+	}
+
+	if err := s.Repo.Update(ctx, u, actor); err != nil {
+		return repositoryError("", err)
+	}
+
+	return nil
+}
+
+// rekey is a code change in progress, code is the new code once done is closed, empty if the code didn't change
+type rekey struct {
+	done chan struct{}
+	code string
+}
+
+// Rekey gives a new code to the url with the given id, the code is generated if it's empty, and returns the url
+// The clicks counted under the previous code are saved before it changes, its new clicks wait until the code is changed
+// and are counted under the new code. The previous code then stops redirecting right away and can be used by a new url
+func (s *Service) Rekey(ctx context.Context, id int64, code string, actor entities.Actor) (entities.Url, error) {
+	u, err := s.Repo.GetById(ctx, id)
+	if err != nil {
+		return entities.Url{}, repositoryError("unable to fetch url", err)
+	}
+
+	if u.Id == 0 {
+		return entities.Url{}, ErrUrlNotFound
+	}
+
+	if code == "" {
+		if code, err = s.generateNewUniqueCode(ctx); err != nil {
+			return entities.Url{}, err
+		}
+	} else {
+		exists, err := s.codeExists(ctx, code)
+		if err != nil {
+			return entities.Url{}, err
+		}
+
+		if exists {
+			return entities.Url{}, ErrCodeAlreadyExists
+		}
+	}
+
+	previous := u.Code
+	u.Code = code
+	u.ShortUrl = u.Domain + "/" + code
+	if err = u.Validate(); err != nil {
+		return entities.Url{}, invalidUrl(err)
+	}
+
+	// the clicks are counted by code, they are saved while the previous code still belongs to the url
+	r := &rekey{done: make(chan struct{})}
+
+	s.counting.Lock()
+	if s.rekeys == nil {
+		s.rekeys = make(map[string]*rekey)
+	}
+	s.rekeys[previous] = r
+	s.counting.Unlock()
+
+	defer func() {
+		s.counting.Lock()
+		delete(s.rekeys, previous)
+		s.counting.Unlock()
+		close(r.done)
+	}()
+
+	if err = s.counters.flushNow(ctx); err != nil {
+		return entities.Url{}, repositoryError("unable to save the clicks of the previous code", err)
+	}
+
+	if err = s.Repo.Rekey(ctx, &u, actor); err != nil {
+		return entities.Url{}, repositoryError("", err)
+	}
+
+	r.code = u.Code
+
+	return u, nil
+}
+
+// SetRules replaces the conditional redirect rules of an existing Url and returns the updated Url
+func (s *Service) SetRules(ctx context.Context, id int64, rules []entities.Rule, actor entities.Actor) (entities.Url, error) {
+	dbUrl, err := s.Repo.GetById(ctx, id)
+	if err != nil {
+		return entities.Url{}, repositoryError("unable to fetch url", err)
+	}
+
+	if dbUrl.Id == 0 {
+		return entities.Url{}, ErrUrlNotFound
+	}
+
+	for i := range rules {
+		rules[i].Url = withScheme(rules[i].Url)
+	}
+
+	dbUrl.Rules = rules
+
+	// validate the Url object
+	if err = dbUrl.Validate(); err != nil {
+		return entities.Url{}, invalidUrl(err)
+	}
+
+	if err = s.Repo.Update(ctx, &dbUrl, actor); err != nil {
+		return entities.Url{}, repositoryError("", err)
+	}
+
+	return dbUrl, nil
+}
+
+// SetVariants replaces the weighted destinations of an existing Url and returns the updated Url
+// Variants with an id keep their counter and get the new url and weight, variants without an id are added
+// and the variants that are not in the list are removed
+func (s *Service) SetVariants(ctx context.Context, id int64, variants []entities.Variant, actor entities.Actor) (entities.Url, error) {
+	dbUrl, err := s.Repo.GetById(ctx, id)
+	if err != nil {
+		return entities.Url{}, repositoryError("unable to fetch url", err)
+	}
+
+	if dbUrl.Id == 0 {
+		return entities.Url{}, ErrUrlNotFound
+	}
+
+	for i := range variants {
+		variants[i].Url = withScheme(variants[i].Url)
+		variants[i].Counter = 0
+
+		if variants[i].Id == 0 {
+			continue
+		}
+
+		existing := dbUrl.VariantById(variants[i].Id)
+		if existing == nil {
+			return entities.Url{}, ErrVariantNotFound
+		}
+
+		variants[i].Counter = existing.Counter
+	}
+
+	dbUrl.Variants = variants
+
+	// validate the Url object
+	if err = dbUrl.Validate(); err != nil {
+		return entities.Url{}, invalidUrl(err)
+	}
+
+	if err = s.Repo.Update(ctx, &dbUrl, actor); err != nil {
+		return entities.Url{}, repositoryError("", err)
+	}
+
+	return dbUrl, nil
 }
 
-// Delete removes a Url from the repository
-func (s *Service) Delete(id int64) error {
-	return s.Repo.Delete(id)
+// GetAuditEvents returns the recorded changes of the Urls that match the filter, the most recent first
+// The number of returned events is DefaultAuditLimit if the filter has no limit and at most MaxAuditLimit
+func (s *Service) GetAuditEvents(ctx context.Context, filter entities.AuditFilter) ([]entities.AuditEvent, error) {
+	if filter.From != nil && filter.Until != nil && !filter.Until.After(*filter.From) {
+		return nil, ErrInvalidAuditFilter
+	}
+
+	if filter.Limit < 0 {
+		return nil, ErrInvalidAuditLimit
+	}
+
+	if filter.Limit == 0 {
+		filter.Limit = DefaultAuditLimit
+	}
+
+	if filter.Limit > MaxAuditLimit {
+		filter.Limit = MaxAuditLimit
+	}
+
+	events, err := s.Repo.GetAuditEvents(ctx, filter)
+	if err != nil {
+		return nil, repositoryError("", err)
+	}
+
+	return events, nil
 }
 
-// GetUrlByCode fetches a Url from the repository by its code
-func (s *Service) GetUrlByCode(code string) (string, error) {
-	return s.Repo.GetUrlByCode(code)
+// GetUrlByCode fetches the Url used for redirects from the repository by its code
+func (s *Service) GetUrlByCode(ctx context.Context, code string) (entities.Url, error) {
+	u, err := s.Repo.GetUrlByCode(ctx, code)
+	if err != nil {
+		return entities.Url{}, repositoryError("", err)
+	}
+
+	return u, nil
 }
 
 // GetById fetches a Url from the repository by its id
-func (s *Service) GetById(id int64) (entities.Url, error) {
-	return s.Repo.GetById(id)
+func (s *Service) GetById(ctx context.Context, id int64) (entities.Url, error) {
+	u, err := s.Repo.GetById(ctx, id)
+	if err != nil {
+		return entities.Url{}, repositoryError("", err)
+	}
+
+	return u, nil
+}
+
+// GetByCode fetches a Url from the repository by its code
+func (s *Service) GetByCode(ctx context.Context, code string) (entities.Url, error) {
+	u, err := s.Repo.GetByCode(ctx, code)
+	if err != nil {
+		return entities.Url{}, repositoryError("", err)
+	}
+
+	return u, nil
+}
+
+// IncrementCounter counts a click in redis or queues it, depending on the counter mode, it doesn't wait for the database
+// The clicks of a code that is being changed by Rekey wait until it's done and are counted under the new code
+// It returns false if the click was dropped
+func (s *Service) IncrementCounter(ctx context.Context, click entities.Click) bool {
+	s.counting.RLock()
+	r, ok := s.rekeys[click.Code]
+	if !ok {
+		defer s.counting.RUnlock()
+		return s.counters.count(ctx, click)
+	}
+	s.counting.RUnlock()
+
+	<-r.done
+	if r.code != "" {
+		click.Code = r.code
+	}
+
+	return s.counters.count(ctx, click)
+}
+
+// PublishClick sends a counted click to the click feed subscribers, it doesn't wait for them
+func (s *Service) PublishClick(ctx context.Context, e entities.ClickEvent) {
+	s.feed.Publish(e)
 }
 
-// IncrementCounter adds a new code into the CounterJobs channel
-func (s *Service) IncrementCounter(code string) {
-	s.CounterJobs <- code
+// SubscribeClicks returns a subscription to the counted clicks selected by the filter
+func (s *Service) SubscribeClicks(ctx context.Context, filter entities.ClickFilter) *ClickSubscription {
+	return s.feed.Subscribe(filter)
+}
+
+// CloseClickFeed ends the click feed subscriptions, the servers close it before they stop so the streams don't hold them
+func (s *Service) CloseClickFeed() {
+	s.feed.Close()
+}
+
+// Close stops counting clicks and saves the queued ones, it returns an error if they can't be saved before the context is done
+// The click feed is closed too, and the webhook deliveries are stopped once the last milestone events are queued
+func (s *Service) Close(ctx context.Context) error {
+	s.feed.Close()
+
+	err := s.counters.close(ctx)
+
+	if d := s.webhooks.Load(); d != nil {
+		if werr := d.Close(ctx); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	return err
+}
+
+// ConsumeClick synchronously counts a redirect of a click limited Url
+// It returns ErrClicksExhausted if the Url has no clicks left
+func (s *Service) ConsumeClick(ctx context.Context, click entities.Click) error {
+	left, err := s.Repo.ConsumeClick(ctx, click)
+	if err != nil {
+		return repositoryError("", err)
+	}
+
+	if left < 0 {
+		return ErrClicksExhausted
+	}
+
+	// the last click of the url was used
+	if left == 0 {
+		if d := s.webhooks.Load(); d != nil && d.Subscribed(ctx, entities.WebhookUrlExpired) {
+			expired := entities.Url{Code: click.Code}
+			if u, err := s.Repo.GetByCode(ctx, click.Code); err == nil && u.Id != 0 {
+				expired = u
+			}
+
+			s.notify(ctx, entities.WebhookUrlExpired, expired, 0)
+		}
+	}
+
+	return nil
+}
+
+// RemainingClicks returns the number of clicks a click limited Url has left from the repository
+func (s *Service) RemainingClicks(ctx context.Context, code string) (int64, error) {
+	left, err := s.Repo.RemainingClicks(ctx, code)
+	if err != nil {
+		return 0, repositoryError("", err)
+	}
+
+	return left, nil
+}
+
+// StartWebhooks starts delivering the url events to the webhooks, configured by the webhook options
+// Close stops the deliveries
+func (s *Service) StartWebhooks(o WebhookOptions) {
+	s.webhooks.Store(NewWebhookDispatcher(s.Repo, o))
+}
+
+// AddWebhook validates the webhook and saves it, a secret is generated if none is given
+// The Webhook object is replaced with the saved one, it's the only time its secret is returned
+func (s *Service) AddWebhook(ctx context.Context, w *entities.Webhook) error {
+	if err := w.Validate(); err != nil {
+		return ErrInvalidWebhook.Wrap(err)
+	}
+
+	if w.Secret == "" {
+		w.Secret = entities.NewWebhookSecret()
+	}
+
+	w.Id = 0
+	w.CreatedAt = time.Now().UTC()
+
+	if err := s.Repo.AddWebhook(ctx, w); err != nil {
+		return repositoryError("unable to add webhook", err)
+	}
+
+	if d := s.webhooks.Load(); d != nil {
+		d.Invalidate()
+	}
+
+	return nil
+}
+
+// GetWebhooks returns the webhooks without their secrets
+func (s *Service) GetWebhooks(ctx context.Context) ([]entities.Webhook, error) {
+	webhooks, err := s.Repo.GetWebhooks(ctx)
+	if err != nil {
+		return nil, repositoryError("", err)
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook and its dead letters
+// It returns ErrWebhookNotFound if no webhook exists with the given id
+func (s *Service) DeleteWebhook(ctx context.Context, id int64) error {
+	deleted, err := s.Repo.DeleteWebhook(ctx, id)
+	if err != nil {
+		return repositoryError("unable to delete webhook", err)
+	}
+
+	if !deleted {
+		return ErrWebhookNotFound
+	}
+
+	if d := s.webhooks.Load(); d != nil {
+		d.Invalidate()
+	}
+
+	return nil
+}
+
+// GetDeadLetters returns the events that couldn't be delivered to the webhook with the given id, or to every webhook
+// if the id is 0, the most recent failures first
+func (s *Service) GetDeadLetters(ctx context.Context, webhookId int64) ([]entities.DeadLetter, error) {
+	letters, err := s.Repo.GetDeadLetters(ctx, webhookId)
+	if err != nil {
+		return nil, repositoryError("", err)
+	}
+
+	return letters, nil
+}
+
+// ReplayDeadLetter delivers a dead letter again, once, and removes it if the delivery succeeds
+// A failed delivery is counted in the dead letter and returns ErrWebhookDeliveryFailed
+// It returns ErrDeadLetterNotFound if no dead letter exists with the given id, or if its webhook was deleted
+func (s *Service) ReplayDeadLetter(ctx context.Context, id int64) error {
+	d := s.webhooks.Load()
+	if d == nil {
+		return ErrWebhooksDisabled
+	}
+
+	letter, err := s.Repo.GetDeadLetter(ctx, id)
+	if err != nil {
+		return repositoryError("unable to fetch dead letter", err)
+	}
+
+	if letter.Id == 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	webhooks, err := s.Repo.GetWebhooks(ctx)
+	if err != nil {
+		return repositoryError("unable to fetch webhooks", err)
+	}
+
+	var webhook *entities.Webhook
+	for i := range webhooks {
+		if webhooks[i].Id == letter.WebhookId {
+			webhook = &webhooks[i]
+		}
+	}
+
+	if webhook == nil {
+		return ErrDeadLetterNotFound
+	}
+
+	if sendErr := d.Send(ctx, *webhook, letter.Event); sendErr != nil {
+		letter.Attempts++
+		letter.LastError = sendErr.Error()
+		letter.FailedAt = time.Now().UTC()
+
+		if err = s.Repo.UpdateDeadLetter(ctx, &letter); err != nil {
+			return repositoryError("unable to update dead letter", err)
+		}
+
+		return ErrWebhookDeliveryFailed.Wrap(sendErr)
+	}
+
+	webhookMetrics.Add("replayed", 1)
+
+	if err = s.Repo.DeleteDeadLetter(ctx, id); err != nil {
+		return repositoryError("unable to delete dead letter", err)
+	}
+
+	return nil
+}
+
+// notify sends an event of the given type for the url to the subscribed webhooks, once the webhooks are started
+func (s *Service) notify(ctx context.Context, eventType string, u entities.Url, milestone int64) {
+	d := s.webhooks.Load()
+	if d == nil {
+		return
+	}
+
+	e := entities.NewWebhookEvent(eventType, u, time.Now())
+	e.Milestone = milestone
+
+	d.Dispatch(ctx, e)
+}
+
+// clicksFlushed sends the milestone events of the urls whose counter reached one of the ClickMilestones with the
+// flushed clicks, it's called by the counter workers after every flush
+// The flush doesn't depend on a request so it only uses the repository timeouts
+func (s *Service) clicksFlushed(clicks map[entities.Click]int64) {
+	d := s.webhooks.Load()
+	ctx := context.Background()
+	if d == nil || !d.Subscribed(ctx, entities.WebhookUrlMilestone) {
+		return
+	}
+
+	// the variant clicks count in the url counter too
+	added := make(map[string]int64)
+	for click, n := range clicks {
+		added[click.Code] += n
+	}
+
+	codes := make([]string, 0, len(added))
+	for code := range added {
+		codes = append(codes, code)
+	}
+
+	counters, err := s.Repo.GetCounters(ctx, codes)
+	if err != nil {
+		slog.Error("unable to fetch the counters of the flushed clicks", "error", err.Error())
+		return
+	}
+
+	for code, after := range counters {
+		milestone := entities.CrossedMilestone(after-added[code], after)
+		if milestone == 0 {
+			continue
+		}
+
+		u, err := s.Repo.GetByCode(ctx, code)
+		if err != nil || u.Id == 0 {
+			u = entities.Url{Code: code, Counter: after}
+		}
+
+		s.notify(ctx, entities.WebhookUrlMilestone, u, milestone)
+	}
+}
+
+// withScheme adds the http scheme to a url that has no scheme
+func withScheme(url string) string {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return "http://" + url
+	}
+
+	return url
+}
+
+// sameOptions checks if an existing Url redirects like a new Url would with the options it was created with
+func sameOptions(dbUrl, u entities.Url) bool {
+	redirectType := u.RedirectType
+	if redirectType == 0 {
+		redirectType = entities.RedirectFound
+	}
+
+	if dbUrl.RedirectType != redirectType || dbUrl.ForwardQuery != u.ForwardQuery || dbUrl.PrefixMode != u.PrefixMode ||
+		dbUrl.FallbackUrl != u.FallbackUrl || !sameTime(dbUrl.ActiveFrom, u.ActiveFrom) ||
+		!sameTime(dbUrl.ActiveUntil, u.ActiveUntil) {
+		return false
+	}
+
+	if len(dbUrl.Rules) != len(u.Rules) || len(dbUrl.Variants) != len(u.Variants) || len(dbUrl.Tags) != len(u.Tags) {
+		return false
+	}
+
+	for i := range u.Rules {
+		a, b := dbUrl.Rules[i], u.Rules[i]
+		if a.Url != b.Url || !slices.Equal(a.Platforms, b.Platforms) || !slices.Equal(a.Languages, b.Languages) ||
+			!slices.Equal(a.Countries, b.Countries) || !sameTime(a.From, b.From) || !sameTime(a.Until, b.Until) {
+			return false
+		}
+	}
+
+	for i := range u.Variants {
+		if dbUrl.Variants[i].Url != u.Variants[i].Url || dbUrl.Variants[i].Weight != u.Variants[i].Weight {
+			return false
+		}
+	}
+
+	for _, t := range u.Tags {
+		if !slices.Contains(dbUrl.Tags, t) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameTime checks if two optional times are both missing or the same instant
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return a.Equal(*b)
 }
 
 // randCode returns a random string with n length
@@ -125,26 +868,31 @@ func randCode(n int) string {
 }
 
 // codeExists checks if the code is already stored into the database
-func (s *Service) codeExists(code string) (bool, error) {
+// The codes of deleted urls are taken until the urls are purged so a restored url keeps its code
+func (s *Service) codeExists(ctx context.Context, code string) (bool, error) {
 	// check if code already exists
-	url, err := s.Repo.GetUrlByCode(code)
+	exists, err := s.Repo.CodeExists(ctx, code)
 	if err != nil {
-		return false, fmt.Errorf("%s: %s", ErrCheckCode.Error(), err.Error())
+		if repository.IsUnavailable(err) {
+			return false, ErrStorageUnavailable.Wrap(err)
+		}
+
+		return false, ErrCheckCode.Wrap(err)
 	}
 
-	return url != "", nil
+	return exists, nil
 }
 
 // generateNewUniqueCode creates a new code
 // if the generated code is not unique, it will regenerate it
-func (s *Service) generateNewUniqueCode() (string, error) {
+func (s *Service) generateNewUniqueCode(ctx context.Context) (string, error) {
 	code := randCode(8)
 	// while the code already exists
 	for {
 		// check if code already exists
-		exists, err := s.codeExists(code)
+		exists, err := s.codeExists(ctx, code)
 		if err != nil {
-			return "", fmt.Errorf("%s: %s", ErrCheckCode.Error(), err.Error())
+			return "", err
 		}
 
 		if !exists {
@@ -1,22 +1,29 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/norby7/shortening-service/entities"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
 	addError     = fmt.Errorf("unable to add the url")
 	deleteError  = fmt.Errorf("unable to delete the url")
+	updateError  = fmt.Errorf("unable to update the url")
 	getError     = fmt.Errorf("unable to fetch the url")
 	counterError = fmt.Errorf("unable to increment counter")
 )
 
+var testActor = entities.Actor{Name: entities.AnonymousActor, ClientIp: "192.0.2.1", RequestId: "8f1e2d3c"}
+
 type RepositoryMock struct{}
 
-func (r *RepositoryMock) Add(u *entities.Url) error {
+func (r *RepositoryMock) Add(ctx context.Context, u *entities.Url, actor entities.Actor) error {
 	if u.Url == "http://www.invalidUrl.com" {
 		return addError
 	}
@@ -24,7 +31,7 @@ func (r *RepositoryMock) Add(u *entities.Url) error {
 	return nil
 }
 
-func (r *RepositoryMock) Delete(id int64) error {
+func (r *RepositoryMock) Delete(ctx context.Context, id int64, actor entities.Actor) error {
 	if id == 0 {
 		return deleteError
 	}
@@ -32,19 +39,27 @@ func (r *RepositoryMock) Delete(id int64) error {
 	return nil
 }
 
-func (r *RepositoryMock) GetUrlByCode(code string) (string, error) {
+func (r *RepositoryMock) Update(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	if u.Url == "http://www.invalidUrl.com" {
+		return updateError
+	}
+
+	return nil
+}
+
+func (r *RepositoryMock) GetUrlByCode(ctx context.Context, code string) (entities.Url, error) {
 	if code == "invalidCode" {
-		return "", getError
+		return entities.Url{}, getError
 	}
 
-	if code != "84gfj4i9"{
-		return "", nil
+	if code != "84gfj4i9" {
+		return entities.Url{}, nil
 	}
 
-	return "https://google.com", nil
+	return entities.Url{Id: 1, Code: "84gfj4i9", Url: "https://google.com"}, nil
 }
 
-func (r *RepositoryMock) GetById(id int64) (entities.Url, error) {
+func (r *RepositoryMock) GetById(ctx context.Context, id int64) (entities.Url, error) {
 	if id == 0 {
 		return entities.Url{}, getError
 	}
@@ -60,15 +75,16 @@ func (r *RepositoryMock) GetById(id int64) (entities.Url, error) {
 		ShortUrl: "http://localhost/84gfj4i9",
 		Domain:   "http://localhost",
 		Counter:  1,
+		Variants: []entities.Variant{{Id: 10, Url: "https://google.com/a", Weight: 100, Counter: 5}},
 	}, nil
 }
 
-func (r *RepositoryMock) GetByUrl(url string) (entities.Url, error) {
-	if url == "http://www.invalidUrl.com" {
+func (r *RepositoryMock) GetByCode(ctx context.Context, code string) (entities.Url, error) {
+	if code == "invalidCode" {
 		return entities.Url{}, getError
 	}
 
-	if url != "http://www.existingUrl.com" {
+	if code != "84gfj4i9" {
 		return entities.Url{}, nil
 	}
 
@@ -82,8 +98,160 @@ func (r *RepositoryMock) GetByUrl(url string) (entities.Url, error) {
 	}, nil
 }
 
-func (r *RepositoryMock) IncrementCounter(url string) error {
-	if url == "" {
+func (r *RepositoryMock) GetByUrl(ctx context.Context, url string) (entities.Url, error) {
+	if url == "http://www.invalidUrl.com" {
+		return entities.Url{}, getError
+	}
+
+	if url != "http://www.existingUrl.com" {
+		return entities.Url{}, nil
+	}
+
+	return entities.Url{
+		Id:           1,
+		Code:         "84gfj4i9",
+		Url:          "https://google.com",
+		ShortUrl:     "http://localhost/84gfj4i9",
+		Domain:       "http://localhost",
+		Counter:      1,
+		RedirectType: entities.RedirectFound,
+		Owner:        entities.AnonymousActor,
+	}, nil
+}
+
+func (r *RepositoryMock) RemainingClicks(ctx context.Context, code string) (int64, error) {
+	if code == "" {
+		return 0, counterError
+	}
+
+	if code == "exhausted" {
+		return 0, nil
+	}
+
+	return 1, nil
+}
+
+func (r *RepositoryMock) ConsumeClick(ctx context.Context, click entities.Click) (int64, error) {
+	if click.Code == "" {
+		return 0, counterError
+	}
+
+	if click.Code == "exhausted" {
+		return -1, nil
+	}
+
+	return 0, nil
+}
+
+func (r *RepositoryMock) Restore(ctx context.Context, id int64, actor entities.Actor) (bool, error) {
+	if id == 0 {
+		return false, updateError
+	}
+
+	return id == 1, nil
+}
+
+func (r *RepositoryMock) GetDeleted(ctx context.Context) ([]entities.Url, error) {
+	return []entities.Url{{Id: 3, Code: "d3l3t3d0", Url: "https://google.com"}}, nil
+}
+
+func (r *RepositoryMock) PurgeDeleted(ctx context.Context, before time.Time) (int64, error) {
+	// the url in the trash was deleted on 2022-01-15
+	if before.After(time.Date(2022, 1, 15, 0, 0, 0, 0, time.UTC)) {
+		return 1, nil
+	}
+
+	return 0, nil
+}
+
+func (r *RepositoryMock) CodeExists(ctx context.Context, code string) (bool, error) {
+	if code == "invalidCode" {
+		return false, getError
+	}
+
+	// d3l3t3d0 is the code of a url in the trash
+	return code == "84gfj4i9" || code == "d3l3t3d0", nil
+}
+
+func (r *RepositoryMock) GetAuditEvents(ctx context.Context, filter entities.AuditFilter) ([]entities.AuditEvent, error) {
+	if filter.Actor == "invalidActor" {
+		return nil, getError
+	}
+
+	// the returned event reports the limit sent to the repository in its id
+	return []entities.AuditEvent{{Id: int64(filter.Limit), UrlId: 1, Action: entities.AuditCreate, Actor: filter.Actor}}, nil
+}
+
+func (r *RepositoryMock) IncrementCountersBatch(ctx context.Context, batchId string, clicks map[entities.Click]int64) error {
+	return nil
+}
+
+func (r *RepositoryMock) CountClick(ctx context.Context, click entities.Click) error {
+	return nil
+}
+
+func (r *RepositoryMock) FlushCounters(ctx context.Context) (map[entities.Click]int64, error) {
+	return nil, nil
+}
+
+func (r *RepositoryMock) CounterBatchSaved(ctx context.Context, batchId string) (bool, error) {
+	return false, nil
+}
+
+func (r *RepositoryMock) GetCounters(ctx context.Context, codes []string) (map[string]int64, error) {
+	return nil, nil
+}
+
+func (r *RepositoryMock) AddWebhook(ctx context.Context, w *entities.Webhook) error {
+	return nil
+}
+
+func (r *RepositoryMock) GetWebhooks(ctx context.Context) ([]entities.Webhook, error) {
+	return nil, nil
+}
+
+func (r *RepositoryMock) DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	return false, nil
+}
+
+func (r *RepositoryMock) AddDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	return nil
+}
+
+func (r *RepositoryMock) UpdateDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	return nil
+}
+
+func (r *RepositoryMock) GetDeadLetter(ctx context.Context, id int64) (entities.DeadLetter, error) {
+	return entities.DeadLetter{}, nil
+}
+
+func (r *RepositoryMock) GetDeadLetters(ctx context.Context, webhookId int64) ([]entities.DeadLetter, error) {
+	return nil, nil
+}
+
+func (r *RepositoryMock) DeleteDeadLetter(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (r *RepositoryMock) ExportUrls(ctx context.Context, filter entities.ExportFilter, fn func(entities.Url) error) error {
+	return nil
+}
+
+func (r *RepositoryMock) Rekey(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	if u.Code == "r3k3yErr" {
+		return updateError
+	}
+
+	return nil
+}
+
+func (r *RepositoryMock) Overwrite(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	return nil
+}
+
+func (r *RepositoryMock) IncrementCounters(ctx context.Context, clicks map[entities.Click]int64) error {
+	if _, ok := clicks[entities.Click{}]; ok {
 		return counterError
 	}
 
@@ -92,7 +260,7 @@ func (r *RepositoryMock) IncrementCounter(url string) error {
 
 func TestDelete(t *testing.T) {
 	r := &RepositoryMock{}
-	s := NewService(r, 0, "http://localhost")
+	s := NewService(r, CounterOptions{}, "http://localhost")
 
 	testCases := []struct {
 		name          string
@@ -113,7 +281,7 @@ func TestDelete(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			err := s.Delete(tc.input)
+			err := s.Delete(context.Background(), tc.input, testActor)
 
 			if !errors.Is(err, tc.expectedError) {
 				t.Errorf("expected error (%v), got (%v)", tc.expectedError, err.Error())
@@ -124,7 +292,7 @@ func TestDelete(t *testing.T) {
 
 func TestGetUrlByCode(t *testing.T) {
 	r := &RepositoryMock{}
-	s := NewService(r, 0, "http://localhost")
+	s := NewService(r, CounterOptions{}, "http://localhost")
 
 	testCases := []struct {
 		name          string
@@ -145,7 +313,7 @@ func TestGetUrlByCode(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := s.GetUrlByCode(tc.input)
+			_, err := s.GetUrlByCode(context.Background(), tc.input)
 
 			if !errors.Is(err, tc.expectedError) {
 				t.Errorf("expected error (%v), got (%v)", tc.expectedError, err.Error())
@@ -154,9 +322,41 @@ func TestGetUrlByCode(t *testing.T) {
 	}
 }
 
+func TestGetByCode(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name          string
+		input         string
+		expectedError error
+	}{
+		{
+			name:          "valid code, no error",
+			input:         "84gfj4i9",
+			expectedError: nil,
+		},
+		{
+			name:          "invalid code, error",
+			input:         "invalidCode",
+			expectedError: getError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := s.GetByCode(context.Background(), tc.input)
+
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+		})
+	}
+}
+
 func TestGetById(t *testing.T) {
 	r := &RepositoryMock{}
-	s := NewService(r, 0, "http://localhost")
+	s := NewService(r, CounterOptions{}, "http://localhost")
 
 	testCases := []struct {
 		name          string
@@ -177,7 +377,7 @@ func TestGetById(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := s.GetById(tc.input)
+			_, err := s.GetById(context.Background(), tc.input)
 
 			if !errors.Is(err, tc.expectedError) {
 				t.Errorf("expected error (%v), got (%v)", tc.expectedError, err.Error())
@@ -188,7 +388,7 @@ func TestGetById(t *testing.T) {
 
 func TestCreate(t *testing.T) {
 	r := &RepositoryMock{}
-	s := NewService(r, 0, "http://localhost")
+	s := NewService(r, CounterOptions{}, "http://localhost")
 
 	testCases := []struct {
 		name    string
@@ -234,7 +434,7 @@ func TestCreate(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T){
-			err := s.Create(tc.input)
+			err := s.Create(context.Background(), tc.input, testActor)
 
 			if (err != nil) != tc.isError {
 				t.Errorf("expected error (%v), got error (%v)", tc.isError, err)
@@ -242,3 +442,938 @@ func TestCreate(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateClickLimited(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name      string
+		input     *entities.Url
+		maxClicks int64
+	}{
+		{
+			name:      "burn after reading",
+			input:     &entities.Url{Url: "http://www.existingUrl.com", BurnAfterReading: true},
+			maxClicks: 1,
+		},
+		{
+			name:      "max clicks",
+			input:     &entities.Url{Url: "http://www.existingUrl.com", MaxClicks: 3},
+			maxClicks: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := s.Create(context.Background(), tc.input, testActor); err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			// the existing url with the same destination must not be reused
+			if tc.input.Code == "84gfj4i9" {
+				t.Errorf("expected a new code, got the existing url")
+			}
+
+			if tc.input.MaxClicks != tc.maxClicks {
+				t.Errorf("expected max clicks (%d), got (%d)", tc.maxClicks, tc.input.MaxClicks)
+			}
+
+			if tc.input.BurnAfterReading {
+				t.Errorf("expected burn after reading to be converted into max clicks")
+			}
+
+			if tc.input.Owner != testActor.Name {
+				t.Errorf("expected owner (%s), got (%s)", testActor.Name, tc.input.Owner)
+			}
+		})
+	}
+}
+
+func TestCreateWithOptions(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	from := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := from.Add(24 * time.Hour)
+
+	testCases := []struct {
+		name   string
+		input  *entities.Url
+		actor  entities.Actor
+		reused bool
+	}{
+		{
+			name:   "no options",
+			input:  &entities.Url{Url: "http://www.existingUrl.com"},
+			actor:  testActor,
+			reused: true,
+		},
+		{
+			name:   "same options",
+			input:  &entities.Url{Url: "http://www.existingUrl.com", RedirectType: entities.RedirectFound},
+			actor:  testActor,
+			reused: true,
+		},
+		{
+			name: "scheduled",
+			input: &entities.Url{Url: "http://www.existingUrl.com", ActiveFrom: &from, ActiveUntil: &until,
+				FallbackUrl: "https://fallback.com"},
+			actor: testActor,
+		},
+		{
+			name:  "redirect type",
+			input: &entities.Url{Url: "http://www.existingUrl.com", RedirectType: entities.RedirectPermanent},
+			actor: testActor,
+		},
+		{
+			name:  "forward query",
+			input: &entities.Url{Url: "http://www.existingUrl.com", ForwardQuery: true},
+			actor: testActor,
+		},
+		{
+			name: "rules",
+			input: &entities.Url{Url: "http://www.existingUrl.com",
+				Rules: []entities.Rule{{Url: "https://ios.com", Platforms: []string{"ios"}}}},
+			actor: testActor,
+		},
+		{
+			name:  "tags",
+			input: &entities.Url{Url: "http://www.existingUrl.com", Tags: []string{"newsletter"}},
+			actor: testActor,
+		},
+		{
+			name:  "other owner",
+			input: &entities.Url{Url: "http://www.existingUrl.com"},
+			actor: entities.Actor{Name: "key:reporting"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			want := *tc.input
+
+			if err := s.Create(context.Background(), tc.input, tc.actor); err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if (tc.input.Code == "84gfj4i9") != tc.reused {
+				t.Fatalf("expected the existing url to be reused (%v), got code (%s)", tc.reused, tc.input.Code)
+			}
+
+			if tc.reused {
+				return
+			}
+
+			// the options of a new url must be kept
+			if !sameTime(tc.input.ActiveFrom, want.ActiveFrom) || !sameTime(tc.input.ActiveUntil, want.ActiveUntil) ||
+				tc.input.FallbackUrl != want.FallbackUrl || tc.input.ForwardQuery != want.ForwardQuery ||
+				len(tc.input.Rules) != len(want.Rules) || len(tc.input.Tags) != len(want.Tags) {
+				t.Errorf("expected the options (%+v), got (%+v)", want, *tc.input)
+			}
+
+			if tc.input.Owner != tc.actor.Name {
+				t.Errorf("expected owner (%s), got (%s)", tc.actor.Name, tc.input.Owner)
+			}
+		})
+	}
+}
+
+func TestRekey(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name          string
+		id            int64
+		code          string
+		expectedCode  string
+		expectedError error
+	}{
+		{name: "fetch error", id: 0, expectedError: getError},
+		{name: "url not found", id: 2, expectedError: ErrUrlNotFound},
+		{name: "given code", id: 1, code: "n3wc0d3x", expectedCode: "n3wc0d3x"},
+		{name: "generated code", id: 1},
+		{name: "existing code", id: 1, code: "d3l3t3d0", expectedError: ErrCodeAlreadyExists},
+		{name: "invalid code", id: 1, code: "short", expectedError: ErrInvalidUrl},
+		{name: "rekey error", id: 1, code: "r3k3yErr", expectedError: updateError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := s.Rekey(context.Background(), tc.id, tc.code, testActor)
+			if tc.expectedError != nil {
+				if err == nil || (!errors.Is(err, tc.expectedError) && !strings.Contains(err.Error(), tc.expectedError.Error())) {
+					t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if len(u.Code) != 8 || u.Code == "84gfj4i9" || (tc.expectedCode != "" && u.Code != tc.expectedCode) {
+				t.Errorf("expected a new code (%s), got (%s)", tc.expectedCode, u.Code)
+			}
+
+			if u.ShortUrl != "http://localhost/"+u.Code {
+				t.Errorf("expected the short url of the new code, got (%s)", u.ShortUrl)
+			}
+		})
+	}
+}
+
+// rekeyCounterRepositoryMock records the clicks saved when the code of a url changes, the change signals started and
+// waits for resume when they are set
+type rekeyCounterRepositoryMock struct {
+	CounterRepositoryMock
+	savedOnRekey map[entities.Click]int64
+	started      chan struct{}
+	resume       chan struct{}
+}
+
+func (r *rekeyCounterRepositoryMock) Rekey(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	r.savedOnRekey, _ = r.totals()
+
+	if r.started != nil {
+		close(r.started)
+		<-r.resume
+	}
+
+	return r.CounterRepositoryMock.Rekey(ctx, u, actor)
+}
+
+func TestRekeySavesClicks(t *testing.T) {
+	testCases := []struct {
+		name string
+		mode string
+	}{
+		{name: "memory", mode: CounterModeMemory},
+		{name: "redis", mode: CounterModeRedis},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &rekeyCounterRepositoryMock{}
+			s := NewService(r, CounterOptions{FlushInterval: time.Hour, Mode: tc.mode}, "http://localhost")
+			defer s.Close(context.Background())
+
+			s.IncrementCounter(context.Background(), entities.Click{Code: "84gfj4i9"})
+			s.IncrementCounter(context.Background(), entities.Click{Code: "84gfj4i9", VariantId: 10})
+
+			if _, err := s.Rekey(context.Background(), 1, "n3wc0d3x", testActor); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if r.savedOnRekey[entities.Click{Code: "84gfj4i9"}] != 1 || r.savedOnRekey[entities.Click{Code: "84gfj4i9", VariantId: 10}] != 1 {
+				t.Errorf("expected the clicks of the previous code to be saved before the code changes, got (%v)", r.savedOnRekey)
+			}
+
+			// the code isn't changed while the clicks can't be saved
+			r.setFailing(true)
+			r.savedOnRekey = nil
+			s.IncrementCounter(context.Background(), entities.Click{Code: "84gfj4i9"})
+
+			if _, err := s.Rekey(context.Background(), 1, "n3wc0d3x", testActor); err == nil || r.savedOnRekey != nil {
+				t.Errorf("expected the rekey to fail before the code changes, got (%v)", err)
+			}
+
+			r.setFailing(false)
+		})
+	}
+}
+
+func TestRekeyWaitingClicks(t *testing.T) {
+	r := &rekeyCounterRepositoryMock{started: make(chan struct{}), resume: make(chan struct{})}
+	s := NewService(r, CounterOptions{FlushInterval: time.Hour}, "http://localhost")
+
+	rekeyed := make(chan error, 1)
+	go func() {
+		_, err := s.Rekey(context.Background(), 1, "n3wc0d3x", testActor)
+		rekeyed <- err
+	}()
+	<-r.started
+
+	// the clicks of the other codes are counted while the code changes
+	counted := make(chan struct{})
+	go func() {
+		s.IncrementCounter(context.Background(), entities.Click{Code: "d3l3t3d0"})
+		close(counted)
+	}()
+
+	select {
+	case <-counted:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the clicks of the other codes not to wait for the rekey")
+	}
+
+	// the clicks of the previous code wait for the new code
+	counted = make(chan struct{})
+	go func() {
+		s.IncrementCounter(context.Background(), entities.Click{Code: "84gfj4i9"})
+		close(counted)
+	}()
+
+	select {
+	case <-counted:
+		t.Fatalf("expected the clicks of the previous code to wait for the rekey")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(r.resume)
+	if err := <-rekeyed; err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	<-counted
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	totals, _ := r.totals()
+	if totals[entities.Click{Code: "n3wc0d3x"}] != 1 || totals[entities.Click{Code: "84gfj4i9"}] != 0 || totals[entities.Click{Code: "d3l3t3d0"}] != 1 {
+		t.Errorf("expected the waiting click to be counted under the new code, got (%v)", totals)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	windowStart := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	windowEnd := windowStart.Add(24 * time.Hour)
+
+	testCases := []struct {
+		name          string
+		input         *entities.Url
+		expected      entities.Url
+		expectedError error
+	}{
+		{
+			name:          "fetch error",
+			input:         &entities.Url{Id: 0},
+			expectedError: getError,
+		},
+		{
+			name:          "url not found",
+			input:         &entities.Url{Id: 2},
+			expectedError: ErrUrlNotFound,
+		},
+		{
+			name:  "new url without scheme, default redirect type",
+			input: &entities.Url{Id: 1, Url: "www.validUrl.com"},
+			expected: entities.Url{
+				Id:           1,
+				Code:         "84gfj4i9",
+				Url:          "http://www.validUrl.com",
+				ShortUrl:     "http://localhost/84gfj4i9",
+				Domain:       "http://localhost",
+				Counter:      1,
+				RedirectType: entities.RedirectFound,
+				Variants:     []entities.Variant{{Id: 10, Url: "https://google.com/a", Weight: 100, Counter: 5}},
+			},
+		},
+		{
+			name:  "new redirect type and options",
+			input: &entities.Url{Id: 1, Url: "https://google.com", RedirectType: entities.RedirectPermanent, ForwardQuery: true, PrefixMode: true, MaxClicks: 5},
+			expected: entities.Url{
+				Id:           1,
+				Code:         "84gfj4i9",
+				Url:          "https://google.com",
+				ShortUrl:     "http://localhost/84gfj4i9",
+				Domain:       "http://localhost",
+				Counter:      1,
+				RedirectType: entities.RedirectPermanent,
+				ForwardQuery: true,
+				PrefixMode:   true,
+				MaxClicks:    5,
+				Variants:     []entities.Variant{{Id: 10, Url: "https://google.com/a", Weight: 100, Counter: 5}},
+			},
+		},
+		{
+			name:  "activation window with fallback url",
+			input: &entities.Url{Id: 1, Url: "https://google.com", ActiveFrom: &windowStart, ActiveUntil: &windowEnd, FallbackUrl: "www.fallback.com"},
+			expected: entities.Url{
+				Id:           1,
+				Code:         "84gfj4i9",
+				Url:          "https://google.com",
+				ShortUrl:     "http://localhost/84gfj4i9",
+				Domain:       "http://localhost",
+				Counter:      1,
+				RedirectType: entities.RedirectFound,
+				ActiveFrom:   &windowStart,
+				ActiveUntil:  &windowEnd,
+				FallbackUrl:  "http://www.fallback.com",
+				Variants:     []entities.Variant{{Id: 10, Url: "https://google.com/a", Weight: 100, Counter: 5}},
+			},
+		},
+		{
+			name:  "tags are replaced, owner is kept",
+			input: &entities.Url{Id: 1, Url: "https://google.com", Owner: "apikey:0123456789abcdef", Tags: []string{"newsletter"}},
+			expected: entities.Url{
+				Id:           1,
+				Code:         "84gfj4i9",
+				Url:          "https://google.com",
+				ShortUrl:     "http://localhost/84gfj4i9",
+				Domain:       "http://localhost",
+				Counter:      1,
+				RedirectType: entities.RedirectFound,
+				Tags:         []string{"newsletter"},
+				Variants:     []entities.Variant{{Id: 10, Url: "https://google.com/a", Weight: 100, Counter: 5}},
+			},
+		},
+		{
+			name:          "invalid activation window",
+			input:         &entities.Url{Id: 1, Url: "https://google.com", ActiveFrom: &windowEnd, ActiveUntil: &windowStart},
+			expectedError: fmt.Errorf("activeUntil"),
+		},
+		{
+			name:  "nothing set keeps the url",
+			input: &entities.Url{Id: 1},
+			expected: entities.Url{
+				Id:           1,
+				Code:         "84gfj4i9",
+				Url:          "https://google.com",
+				ShortUrl:     "http://localhost/84gfj4i9",
+				Domain:       "http://localhost",
+				Counter:      1,
+				RedirectType: entities.RedirectFound,
+				Variants:     []entities.Variant{{Id: 10, Url: "https://google.com/a", Weight: 100, Counter: 5}},
+			},
+		},
+		{
+			name:          "update error",
+			input:         &entities.Url{Id: 1, Url: "http://www.invalidUrl.com"},
+			expectedError: updateError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.Update(context.Background(), tc.input, testActor)
+
+			if tc.expectedError != nil {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError.Error()) {
+					t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if !reflect.DeepEqual(*tc.input, tc.expected) {
+				t.Errorf("expected url (%v), got (%v)", tc.expected, *tc.input)
+			}
+		})
+	}
+}
+
+// storedUrlRepositoryMock serves a url 1 with every editable field set
+type storedUrlRepositoryMock struct {
+	RepositoryMock
+	windowStart time.Time
+	windowEnd   time.Time
+}
+
+func (r *storedUrlRepositoryMock) GetById(ctx context.Context, id int64) (entities.Url, error) {
+	if id != 1 {
+		return r.RepositoryMock.GetById(ctx, id)
+	}
+
+	return entities.Url{
+		Id:           1,
+		Code:         "84gfj4i9",
+		Url:          "https://google.com",
+		ShortUrl:     "http://localhost/84gfj4i9",
+		Domain:       "http://localhost",
+		RedirectType: entities.RedirectPermanent,
+		ForwardQuery: true,
+		PrefixMode:   true,
+		MaxClicks:    5,
+		ActiveFrom:   &r.windowStart,
+		ActiveUntil:  &r.windowEnd,
+		FallbackUrl:  "https://fallback.com",
+		Owner:        "anonymous",
+		Tags:         []string{"newsletter"},
+	}, nil
+}
+
+func TestUpdateKeepsUnsetFields(t *testing.T) {
+	r := &storedUrlRepositoryMock{windowStart: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r.windowEnd = r.windowStart.Add(24 * time.Hour)
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	u := &entities.Url{Id: 1, RedirectType: entities.RedirectTemporary}
+	if err := s.Update(context.Background(), u, testActor); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	expected, _ := r.GetById(context.Background(), 1)
+	expected.RedirectType = entities.RedirectTemporary
+	if !reflect.DeepEqual(*u, expected) {
+		t.Errorf("expected only the redirect type to change (%v), got (%v)", expected, *u)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	r := &storedUrlRepositoryMock{windowStart: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r.windowEnd = r.windowStart.Add(24 * time.Hour)
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name          string
+		input         *entities.Url
+		expected      entities.Url
+		expectedError error
+	}{
+		{
+			name:          "fetch error",
+			input:         &entities.Url{Id: 0},
+			expectedError: getError,
+		},
+		{
+			name:          "url not found",
+			input:         &entities.Url{Id: 2},
+			expectedError: ErrUrlNotFound,
+		},
+		{
+			name:          "empty url",
+			input:         &entities.Url{Id: 1},
+			expectedError: fmt.Errorf("Url"),
+		},
+		{
+			name:  "unset fields are removed, owner is kept",
+			input: &entities.Url{Id: 1, Url: "www.validUrl.com", Owner: "apikey:0123456789abcdef"},
+			expected: entities.Url{
+				Id:           1,
+				Code:         "84gfj4i9",
+				Url:          "http://www.validUrl.com",
+				ShortUrl:     "http://localhost/84gfj4i9",
+				Domain:       "http://localhost",
+				RedirectType: entities.RedirectFound,
+				Owner:        "anonymous",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.Replace(context.Background(), tc.input, testActor)
+
+			if tc.expectedError != nil {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError.Error()) {
+					t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if !reflect.DeepEqual(*tc.input, tc.expected) {
+				t.Errorf("expected url (%v), got (%v)", tc.expected, *tc.input)
+			}
+		})
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name          string
+		id            int64
+		input         []entities.Rule
+		expectedError error
+	}{
+		{
+			name:          "fetch error",
+			id:            0,
+			input:         []entities.Rule{{Url: "https://example.com"}},
+			expectedError: getError,
+		},
+		{
+			name:          "url not found",
+			id:            2,
+			input:         []entities.Rule{{Url: "https://example.com"}},
+			expectedError: ErrUrlNotFound,
+		},
+		{
+			name:          "invalid rule",
+			id:            1,
+			input:         []entities.Rule{{Url: "https://example.com", Platforms: []string{"symbian"}}},
+			expectedError: fmt.Errorf("Platforms"),
+		},
+		{
+			name:  "valid rules",
+			id:    1,
+			input: []entities.Rule{{Url: "apps.apple.com/app", Platforms: []string{entities.PlatformIOS}}},
+		},
+		{
+			name:  "remove rules",
+			id:    1,
+			input: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := s.SetRules(context.Background(), tc.id, tc.input, testActor)
+
+			if tc.expectedError != nil {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError.Error()) {
+					t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if len(u.Rules) != len(tc.input) {
+				t.Fatalf("expected (%d) rules, got (%d)", len(tc.input), len(u.Rules))
+			}
+
+			for _, rule := range u.Rules {
+				if !strings.HasPrefix(rule.Url, "http") {
+					t.Errorf("expected rule url with scheme, got (%s)", rule.Url)
+				}
+			}
+		})
+	}
+}
+
+func TestSetVariants(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name            string
+		id              int64
+		input           []entities.Variant
+		expectedCounter []int64
+		expectedError   error
+	}{
+		{
+			name:          "fetch error",
+			id:            0,
+			input:         []entities.Variant{{Url: "https://example.com", Weight: 1}},
+			expectedError: getError,
+		},
+		{
+			name:          "url not found",
+			id:            2,
+			input:         []entities.Variant{{Url: "https://example.com", Weight: 1}},
+			expectedError: ErrUrlNotFound,
+		},
+		{
+			name:          "unknown variant",
+			id:            1,
+			input:         []entities.Variant{{Id: 11, Url: "https://example.com", Weight: 1}},
+			expectedError: ErrVariantNotFound,
+		},
+		{
+			name:          "zero total weight",
+			id:            1,
+			input:         []entities.Variant{{Id: 10, Url: "https://google.com/a"}},
+			expectedError: fmt.Errorf("weight"),
+		},
+		{
+			name:            "change weights and add a variant",
+			id:              1,
+			input:           []entities.Variant{{Id: 10, Url: "https://google.com/a", Weight: 70, Counter: 99}, {Url: "google.com/b", Weight: 30, Counter: 99}},
+			expectedCounter: []int64{5, 0},
+		},
+		{
+			name:            "remove variants",
+			id:              1,
+			input:           nil,
+			expectedCounter: []int64{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := s.SetVariants(context.Background(), tc.id, tc.input, testActor)
+
+			if tc.expectedError != nil {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError.Error()) {
+					t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if len(u.Variants) != len(tc.expectedCounter) {
+				t.Fatalf("expected (%d) variants, got (%d)", len(tc.expectedCounter), len(u.Variants))
+			}
+
+			for i, v := range u.Variants {
+				if !strings.HasPrefix(v.Url, "http") {
+					t.Errorf("expected variant url with scheme, got (%s)", v.Url)
+				}
+
+				if v.Counter != tc.expectedCounter[i] {
+					t.Errorf("expected variant counter (%d), got (%d)", tc.expectedCounter[i], v.Counter)
+				}
+			}
+		})
+	}
+}
+
+func TestConsumeClick(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name          string
+		input         entities.Click
+		expectedError error
+	}{
+		{
+			name:          "repository error",
+			input:         entities.Click{},
+			expectedError: counterError,
+		},
+		{
+			name:          "exhausted",
+			input:         entities.Click{Code: "exhausted"},
+			expectedError: ErrClicksExhausted,
+		},
+		{
+			name:  "last click",
+			input: entities.Click{Code: "84gfj4i9"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := s.ConsumeClick(context.Background(), tc.input)
+
+			if err != tc.expectedError {
+				t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestIncrementCounter(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{FlushInterval: time.Hour}, "http://localhost")
+
+	if !s.IncrementCounter(context.Background(), entities.Click{Code: "84gfj4i9"}) {
+		t.Errorf("expected the click to be counted")
+	}
+
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// the clicks are dropped once the service is closed
+	if s.IncrementCounter(context.Background(), entities.Click{Code: "84gfj4i9"}) {
+		t.Errorf("expected the click to be dropped")
+	}
+}
+
+func TestRemainingClicks(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name          string
+		input         string
+		left          int64
+		expectedError error
+	}{
+		{name: "repository error", input: "", expectedError: counterError},
+		{name: "exhausted", input: "exhausted", left: 0},
+		{name: "clicks left", input: "84gfj4i9", left: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			left, err := s.RemainingClicks(context.Background(), tc.input)
+
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+
+			if left != tc.left {
+				t.Errorf("expected (%d) clicks left, got (%d)", tc.left, left)
+			}
+		})
+	}
+}
+
+func TestRestore(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name          string
+		input         int64
+		expectedError error
+	}{
+		{
+			name:          "restore error",
+			input:         0,
+			expectedError: updateError,
+		},
+		{
+			name:          "no deleted url",
+			input:         2,
+			expectedError: ErrUrlNotFound,
+		},
+		{
+			name:  "deleted url",
+			input: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			u, err := s.Restore(context.Background(), tc.input, testActor)
+
+			if tc.expectedError != nil {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError.Error()) {
+					t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if u.Id != tc.input {
+				t.Errorf("expected url (%d), got (%d)", tc.input, u.Id)
+			}
+		})
+	}
+}
+
+func TestCreateDeletedCode(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	// the code of a url in the trash can't be reused until the url is purged
+	err := s.Create(context.Background(), &entities.Url{Url: "http://www.validUrl.com", Code: "d3l3t3d0"}, testActor)
+	if err != ErrCodeAlreadyExists {
+		t.Errorf("expected error (%v), got (%v)", ErrCodeAlreadyExists, err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	r := &RepositoryMock{}
+	now := time.Date(2022, 1, 31, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name      string
+		retention time.Duration
+		purged    int64
+	}{
+		{
+			name:      "deleted urls older than the retention",
+			retention: 24 * time.Hour,
+			purged:    1,
+		},
+		{
+			name:      "deleted urls within the retention",
+			retention: DefaultTrashRetention,
+			purged:    0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			n, err := purgeTrash(r, tc.retention, now)
+			if err != nil {
+				t.Fatalf("expected no error, got (%v)", err)
+			}
+
+			if n != tc.purged {
+				t.Errorf("expected (%d) purged urls, got (%d)", tc.purged, n)
+			}
+		})
+	}
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	r := &RepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	from := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := from.Add(time.Hour)
+
+	testCases := []struct {
+		name          string
+		input         entities.AuditFilter
+		limit         int64
+		expectedError error
+	}{
+		{
+			name:  "default limit",
+			input: entities.AuditFilter{UrlId: 1},
+			limit: DefaultAuditLimit,
+		},
+		{
+			name:  "limit",
+			input: entities.AuditFilter{Limit: 10},
+			limit: 10,
+		},
+		{
+			name:  "limit over the maximum",
+			input: entities.AuditFilter{Limit: MaxAuditLimit + 1},
+			limit: MaxAuditLimit,
+		},
+		{
+			name:  "time range",
+			input: entities.AuditFilter{From: &from, Until: &until},
+			limit: DefaultAuditLimit,
+		},
+		{
+			name:          "invalid time range",
+			input:         entities.AuditFilter{From: &until, Until: &from},
+			expectedError: ErrInvalidAuditFilter,
+		},
+		{
+			name:          "negative limit",
+			input:         entities.AuditFilter{Limit: -1},
+			expectedError: ErrInvalidAuditLimit,
+		},
+		{
+			name:          "repository error",
+			input:         entities.AuditFilter{Actor: "invalidActor"},
+			expectedError: getError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			events, err := s.GetAuditEvents(context.Background(), tc.input)
+
+			if err != tc.expectedError {
+				t.Fatalf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+
+			if err == nil && events[0].Id != tc.limit {
+				t.Errorf("expected limit (%d), got (%d)", tc.limit, events[0].Id)
+			}
+		})
+	}
+}
@@ -0,0 +1,509 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/norby7/shortening-service/entities"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transferColumns are the csv columns of the exported urls, in order
+// The imported csv files only need the url column, their columns can be in any order and the unknown columns are ignored
+var transferColumns = []string{"code", "url", "counter", "createdAt", "redirectType", "forwardQuery", "prefixMode", "maxClicks",
+	"activeFrom", "activeUntil", "fallbackUrl", "owner", "tags", "rules", "variants", "deletedAt"}
+
+// maxImportLine is the longest json line of an imported file
+const maxImportLine = 1 << 20
+
+// urlEncoder writes the exported urls in a file format
+type urlEncoder interface {
+	Encode(entities.Url) error
+	// Flush writes the buffered urls
+	Flush() error
+}
+
+// jsonlEncoder writes every url as a JSON line
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlEncoder) Encode(u entities.Url) error {
+	return e.enc.Encode(u)
+}
+
+func (e *jsonlEncoder) Flush() error {
+	return nil
+}
+
+// csvEncoder writes every url as a csv row under the transferColumns header
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) Encode(u entities.Url) error {
+	rules, err := encodeList(len(u.Rules), u.Rules)
+	if err != nil {
+		return err
+	}
+
+	variants, err := encodeList(len(u.Variants), u.Variants)
+	if err != nil {
+		return err
+	}
+
+	return e.w.Write([]string{
+		u.Code,
+		u.Url,
+		strconv.FormatInt(u.Counter, 10),
+		formatTime(&u.CreatedAt),
+		strconv.Itoa(u.RedirectType),
+		strconv.FormatBool(u.ForwardQuery),
+		strconv.FormatBool(u.PrefixMode),
+		strconv.FormatInt(u.MaxClicks, 10),
+		formatTime(u.ActiveFrom),
+		formatTime(u.ActiveUntil),
+		u.FallbackUrl,
+		u.Owner,
+		strings.Join(u.Tags, ";"),
+		rules,
+		variants,
+		formatTime(u.DeletedAt),
+	})
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// newUrlEncoder returns the encoder of the format, the csv encoder writes the header right away
+func newUrlEncoder(format string, w io.Writer) (urlEncoder, error) {
+	switch format {
+	case entities.FormatJSONL:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+
+		return &jsonlEncoder{enc: enc}, nil
+	case entities.FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(transferColumns); err != nil {
+			return nil, err
+		}
+
+		return &csvEncoder{w: cw}, nil
+	}
+
+	return nil, fmt.Errorf("format must be %s or %s, got (%s)", entities.FormatCSV, entities.FormatJSONL, format)
+}
+
+// encodeList returns the JSON of a list of n items, an empty string if it has none
+func encodeList(n int, list interface{}) (string, error) {
+	if n == 0 {
+		return "", nil
+	}
+
+	b, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode the url: %s", err.Error())
+	}
+
+	return string(b), nil
+}
+
+// formatTime returns the RFC 3339 UTC time, an empty string for no time
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// urlRecord is an imported url with its line, or the error that made the line unreadable
+type urlRecord struct {
+	line int
+	url  entities.Url
+	err  error
+}
+
+// decodeUrls reads every url of the file, the unreadable urls are returned with their error
+// It returns an error if the file itself can't be read, e.g. a csv file without a url column
+func decodeUrls(format string, r io.Reader) ([]urlRecord, error) {
+	switch format {
+	case entities.FormatJSONL:
+		return decodeJsonl(r)
+	case entities.FormatCSV:
+		return decodeCsv(r)
+	}
+
+	return nil, fmt.Errorf("format must be %s or %s, got (%s)", entities.FormatCSV, entities.FormatJSONL, format)
+}
+
+// decodeJsonl reads a url from every line that isn't blank
+func decodeJsonl(r io.Reader) ([]urlRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxImportLine)
+
+	var records []urlRecord
+	for line := 1; scanner.Scan(); line++ {
+		b := scanner.Bytes()
+		if strings.TrimSpace(string(b)) == "" {
+			continue
+		}
+
+		rec := urlRecord{line: line}
+		if err := json.Unmarshal(b, &rec.url); err != nil {
+			rec.err = fmt.Errorf("invalid json: %s", err.Error())
+		}
+
+		records = append(records, rec)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read the file: %s", err.Error())
+	}
+
+	return records, nil
+}
+
+// decodeCsv reads a url from every row under the header
+func decodeCsv(r io.Reader) ([]urlRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file has no header")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the header: %s", err.Error())
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// spreadsheets often start the file with a byte order mark
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("duplicate column (%s)", name)
+		}
+
+		columns[name] = i
+	}
+
+	if _, ok := columns["url"]; !ok {
+		return nil, fmt.Errorf("the header has no url column")
+	}
+
+	var records []urlRecord
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the file: %s", err.Error())
+		}
+
+		line, _ := cr.FieldPos(0)
+		rec := urlRecord{line: line}
+		if len(row) != len(header) {
+			rec.err = fmt.Errorf("expected %d columns, got %d", len(header), len(row))
+		} else {
+			rec.url, rec.err = csvUrl(columns, row)
+		}
+
+		records = append(records, rec)
+	}
+}
+
+// csvUrl returns the url of a csv row, the empty cells keep the field zero value
+func csvUrl(columns map[string]int, row []string) (entities.Url, error) {
+	var u entities.Url
+	for _, name := range transferColumns {
+		i, ok := columns[name]
+		if !ok {
+			continue
+		}
+
+		v := strings.TrimSpace(row[i])
+		if v == "" {
+			continue
+		}
+
+		var err error
+		switch name {
+		case "code":
+			u.Code = v
+		case "url":
+			u.Url = v
+		case "counter":
+			u.Counter, err = strconv.ParseInt(v, 10, 64)
+		case "createdAt":
+			u.CreatedAt, err = time.Parse(time.RFC3339Nano, v)
+		case "redirectType":
+			u.RedirectType, err = strconv.Atoi(v)
+		case "forwardQuery":
+			u.ForwardQuery, err = strconv.ParseBool(v)
+		case "prefixMode":
+			u.PrefixMode, err = strconv.ParseBool(v)
+		case "maxClicks":
+			u.MaxClicks, err = strconv.ParseInt(v, 10, 64)
+		case "activeFrom":
+			u.ActiveFrom, err = parseTime(v)
+		case "activeUntil":
+			u.ActiveUntil, err = parseTime(v)
+		case "fallbackUrl":
+			u.FallbackUrl = v
+		case "owner":
+			u.Owner = v
+		case "tags":
+			for _, tag := range strings.Split(v, ";") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					u.Tags = append(u.Tags, tag)
+				}
+			}
+		case "rules":
+			err = json.Unmarshal([]byte(v), &u.Rules)
+		case "variants":
+			err = json.Unmarshal([]byte(v), &u.Variants)
+		case "deletedAt":
+			u.DeletedAt, err = parseTime(v)
+		}
+
+		if err != nil {
+			return entities.Url{}, fmt.Errorf("invalid %s (%s): %s", name, v, err.Error())
+		}
+	}
+
+	return u, nil
+}
+
+// parseTime parses a RFC 3339 time
+func parseTime(v string) (*time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// Export writes the urls selected by the filter in the format, csv or jsonl, and returns the number of written urls
+// The urls are written as they are read from the storage so the export doesn't hold them in memory
+func (s *Service) Export(ctx context.Context, filter entities.ExportFilter, format string, w io.Writer) (int, error) {
+	if filter.From != nil && filter.Until != nil && !filter.Until.After(*filter.From) {
+		return 0, ErrInvalidExport.Wrap(fmt.Errorf("until must be after from"))
+	}
+
+	enc, err := newUrlEncoder(format, w)
+	if err != nil {
+		return 0, ErrInvalidExport.Wrap(err)
+	}
+
+	n := 0
+	err = s.Repo.ExportUrls(ctx, filter, func(u entities.Url) error {
+		if err := enc.Encode(u); err != nil {
+			return fmt.Errorf("unable to write the url (%s): %s", u.Code, err.Error())
+		}
+
+		n++
+
+		return nil
+	})
+	if err != nil {
+		return n, repositoryError("unable to export the urls", err)
+	}
+
+	if err = enc.Flush(); err != nil {
+		return n, fmt.Errorf("unable to write the urls: %s", err.Error())
+	}
+
+	return n, nil
+}
+
+// Import adds the urls of a csv or jsonl file, like the files written by Export, and returns the result of every url
+// The counters, creation times and owners of the urls are kept, the urls without an owner belong to the actor and the
+// urls without a code get a new one. The imported urls are never in the trash and their shortUrl uses the service domain
+// The invalid urls fail without stopping the import, the urls whose code exists follow the conflict policy: with
+// ConflictFail nothing is imported and ErrImportConflict is returned together with the report
+// A dry run reports the same results without saving the urls, the codes it generates are not reserved
+// The imported urls don't send webhook events
+func (s *Service) Import(ctx context.Context, r io.Reader, o entities.ImportOptions, actor entities.Actor) (entities.ImportReport, error) {
+	report := entities.ImportReport{DryRun: o.DryRun, Results: []entities.ImportResult{}}
+	if err := o.Validate(); err != nil {
+		return report, ErrInvalidImport.Wrap(err)
+	}
+
+	if o.Conflict == "" {
+		o.Conflict = entities.ConflictFail
+	}
+
+	records, err := decodeUrls(o.Format, r)
+	if err != nil {
+		return report, ErrInvalidImport.Wrap(err)
+	}
+
+	results := make([]entities.ImportResult, len(records))
+	// the line of every imported code, a code can only be imported once
+	seen := make(map[string]int, len(records))
+	conflicts := 0
+
+	for i := range records {
+		rec := &records[i]
+		res := &results[i]
+		res.Line = rec.line
+		res.Code = rec.url.Code
+
+		if rec.err != nil {
+			res.Action, res.Error = entities.ImportFailed, rec.err.Error()
+			continue
+		}
+
+		u := &rec.url
+		if u.Code == "" {
+			if u.Code, err = s.generateImportCode(ctx, seen); err != nil {
+				return report, err
+			}
+
+			res.Code = u.Code
+		}
+
+		s.prepareImport(u, actor)
+		if err = u.Validate(); err != nil {
+			res.Action, res.Error = entities.ImportFailed, invalidUrl(err).Error()
+			continue
+		}
+
+		if line, ok := seen[u.Code]; ok {
+			res.Action, res.Error = entities.ImportFailed, fmt.Sprintf("code already imported at line %d", line)
+			continue
+		}
+
+		seen[u.Code] = rec.line
+
+		exists, err := s.codeExists(ctx, u.Code)
+		if err != nil {
+			return report, err
+		}
+
+		if !exists {
+			res.Action = entities.ImportCreated
+			continue
+		}
+
+		switch o.Conflict {
+		case entities.ConflictSkip:
+			res.Action, res.Error = entities.ImportSkipped, ErrCodeAlreadyExists.Error()
+		case entities.ConflictOverwrite:
+			res.Action = entities.ImportOverwritten
+		default:
+			res.Action, res.Error = entities.ImportFailed, ErrCodeAlreadyExists.Error()
+			conflicts++
+		}
+	}
+
+	if conflicts > 0 {
+		// the urls that would have been saved are reported as skipped since nothing is imported
+		for i := range results {
+			if results[i].Action == entities.ImportCreated || results[i].Action == entities.ImportOverwritten {
+				results[i].Action, results[i].Error = entities.ImportSkipped, "not imported, other urls have existing codes"
+			}
+		}
+	}
+
+	for i := range results {
+		if !o.DryRun && conflicts == 0 {
+			s.saveImport(ctx, &records[i].url, &results[i], actor)
+		}
+
+		report.Add(results[i])
+	}
+
+	if conflicts > 0 {
+		return report, ErrImportConflict
+	}
+
+	return report, nil
+}
+
+// prepareImport completes an imported url the way Create does, except for the counters, creation time and owner that
+// are kept when they are set
+func (s *Service) prepareImport(u *entities.Url, actor entities.Actor) {
+	u.Id = 0
+	u.DeletedAt = nil
+	if u.Url != "" {
+		u.Url = withScheme(u.Url)
+	}
+
+	for i := range u.Rules {
+		u.Rules[i].Url = withScheme(u.Rules[i].Url)
+	}
+
+	for i := range u.Variants {
+		u.Variants[i].Id = 0
+		u.Variants[i].Url = withScheme(u.Variants[i].Url)
+	}
+
+	if u.FallbackUrl != "" {
+		u.FallbackUrl = withScheme(u.FallbackUrl)
+	}
+
+	if u.BurnAfterReading {
+		u.MaxClicks = 1
+		u.BurnAfterReading = false
+	}
+
+	u.ShortUrl = s.Domain + "/" + u.Code
+	u.Domain = s.Domain
+
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now().UTC()
+	}
+
+	if u.Owner == "" {
+		u.Owner = actor.Name
+	}
+
+	if u.RedirectType == 0 {
+		u.RedirectType = entities.RedirectFound
+	}
+}
+
+// generateImportCode returns a new unique code that isn't used by the other urls of the import either
+func (s *Service) generateImportCode(ctx context.Context, seen map[string]int) (string, error) {
+	for {
+		code, err := s.generateNewUniqueCode(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		if _, ok := seen[code]; !ok {
+			return code, nil
+		}
+	}
+}
+
+// saveImport adds or overwrites the url of a created or overwritten result, the result fails if the url can't be saved
+func (s *Service) saveImport(ctx context.Context, u *entities.Url, res *entities.ImportResult, actor entities.Actor) {
+	var err error
+	switch res.Action {
+	case entities.ImportCreated:
+		err = s.Repo.Add(ctx, u, actor)
+	case entities.ImportOverwritten:
+		err = s.Repo.Overwrite(ctx, u, actor)
+	default:
+		return
+	}
+
+	if err != nil {
+		res.Action, res.Error = entities.ImportFailed, repositoryError("unable to save the url", err).Error()
+	}
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/norby7/shortening-service/entities"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TransferRepositoryMock keeps the urls in memory by code, in the order they were added
+type TransferRepositoryMock struct {
+	RepositoryMock
+	urls      []entities.Url
+	exportErr error
+}
+
+func (r *TransferRepositoryMock) Add(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	if u.Url == "http://www.invalidUrl.com" {
+		return addError
+	}
+
+	u.Id = int64(len(r.urls) + 1)
+	r.urls = append(r.urls, *u)
+
+	return nil
+}
+
+func (r *TransferRepositoryMock) Overwrite(ctx context.Context, u *entities.Url, actor entities.Actor) error {
+	for i := range r.urls {
+		if r.urls[i].Code == u.Code {
+			u.Id = r.urls[i].Id
+			r.urls[i] = *u
+			return nil
+		}
+	}
+
+	return updateError
+}
+
+func (r *TransferRepositoryMock) CodeExists(ctx context.Context, code string) (bool, error) {
+	for _, u := range r.urls {
+		if u.Code == code {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *TransferRepositoryMock) ExportUrls(ctx context.Context, filter entities.ExportFilter, fn func(entities.Url) error) error {
+	for i := range r.urls {
+		if !filter.Matches(&r.urls[i]) {
+			continue
+		}
+
+		if err := fn(r.urls[i]); err != nil {
+			return err
+		}
+	}
+
+	return r.exportErr
+}
+
+// transferUrls are the urls of the transfer tests
+func transferUrls() []entities.Url {
+	createdAt := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
+	until := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	return []entities.Url{
+		{
+			Id:           1,
+			Code:         "84gfj4i9",
+			Url:          "https://google.com",
+			ShortUrl:     "http://localhost/84gfj4i9",
+			Domain:       "http://localhost",
+			Counter:      42,
+			CreatedAt:    createdAt,
+			RedirectType: entities.RedirectPermanent,
+			ForwardQuery: true,
+			Rules:        []entities.Rule{{Url: "https://google.ro", Countries: []string{"RO"}}},
+			Variants:     []entities.Variant{{Id: 10, Url: "https://google.com/a", Weight: 100, Counter: 40}},
+			ActiveUntil:  &until,
+			FallbackUrl:  "https://google.com/expired",
+			Owner:        "apikey:1a2b3c",
+			Tags:         []string{"newsletter", "launch"},
+		},
+		{
+			Id:           2,
+			Code:         "a1b2c3d4",
+			Url:          "https://example.com",
+			ShortUrl:     "http://localhost/a1b2c3d4",
+			Domain:       "http://localhost",
+			CreatedAt:    createdAt.Add(time.Hour),
+			RedirectType: entities.RedirectFound,
+			MaxClicks:    5,
+			Owner:        entities.AnonymousActor,
+		},
+	}
+}
+
+func TestExport(t *testing.T) {
+	testCases := []struct {
+		name          string
+		filter        entities.ExportFilter
+		format        string
+		exportErr     error
+		expected      string
+		expectedCount int
+		expectedError error
+	}{
+		{
+			name:   "csv",
+			format: entities.FormatCSV,
+			expected: "code,url,counter,createdAt,redirectType,forwardQuery,prefixMode,maxClicks,activeFrom,activeUntil,fallbackUrl,owner,tags,rules,variants,deletedAt\n" +
+				`84gfj4i9,https://google.com,42,2022-01-01T10:00:00Z,308,true,false,0,,2023-01-01T00:00:00Z,https://google.com/expired,apikey:1a2b3c,newsletter;launch,"[{""url"":""https://google.ro"",""countries"":[""RO""]}]","[{""id"":10,""url"":""https://google.com/a"",""weight"":100,""counter"":40}]",` + "\n" +
+				"a1b2c3d4,https://example.com,0,2022-01-01T11:00:00Z,302,false,false,5,,,,anonymous,,,,\n",
+			expectedCount: 2,
+		},
+		{
+			name:   "jsonl with filter",
+			filter: entities.ExportFilter{Owner: entities.AnonymousActor},
+			format: entities.FormatJSONL,
+			expected: `{"id":2,"code":"a1b2c3d4","url":"https://example.com","shortUrl":"http://localhost/a1b2c3d4","domain":"http://localhost","counter":0,` +
+				`"createdAt":"2022-01-01T11:00:00Z","redirectType":302,"forwardQuery":false,"prefixMode":false,"maxClicks":5,"owner":"anonymous"}` + "\n",
+			expectedCount: 1,
+		},
+		{
+			name:          "unknown format",
+			format:        "xml",
+			expectedError: ErrInvalidExport,
+		},
+		{
+			name:          "until before from",
+			filter:        entities.ExportFilter{From: &time.Time{}, Until: &time.Time{}},
+			format:        entities.FormatJSONL,
+			expectedError: ErrInvalidExport,
+		},
+		{
+			name:          "storage error",
+			format:        entities.FormatJSONL,
+			exportErr:     getError,
+			expectedCount: 2,
+			expectedError: getError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &TransferRepositoryMock{urls: transferUrls(), exportErr: tc.exportErr}
+			s := NewService(r, CounterOptions{}, "http://localhost")
+
+			var buf bytes.Buffer
+			n, err := s.Export(context.Background(), tc.filter, tc.format, &buf)
+			if tc.expectedError != nil {
+				if err == nil || (!errors.Is(err, tc.expectedError) && !strings.Contains(err.Error(), tc.expectedError.Error())) {
+					t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if n != tc.expectedCount {
+				t.Errorf("expected (%d) exported urls, got (%d)", tc.expectedCount, n)
+			}
+
+			if tc.expected != "" && buf.String() != tc.expected {
+				t.Errorf("expected export\n%s\ngot\n%s", tc.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	csvHeader := "url,code,counter,owner,tags\n"
+
+	testCases := []struct {
+		name            string
+		options         entities.ImportOptions
+		input           string
+		expectedActions []string
+		expectedUrls    int
+		expectedError   error
+	}{
+		{
+			name:            "csv created",
+			options:         entities.ImportOptions{Format: entities.FormatCSV},
+			input:           csvHeader + "https://go.dev,g0d3v000,7,apikey:9f8e7d,docs;go\nwww.golang.org,,,,\n",
+			expectedActions: []string{entities.ImportCreated, entities.ImportCreated},
+			expectedUrls:    4,
+		},
+		{
+			name:            "jsonl created",
+			options:         entities.ImportOptions{Format: entities.FormatJSONL},
+			input:           `{"code":"g0d3v000","url":"https://go.dev","counter":7}` + "\n\n" + `{"url":"https://golang.org"}` + "\n",
+			expectedActions: []string{entities.ImportCreated, entities.ImportCreated},
+			expectedUrls:    4,
+		},
+		{
+			name:    "invalid urls",
+			options: entities.ImportOptions{Format: entities.FormatCSV, Conflict: entities.ConflictSkip},
+			input:   csvHeader + "https://go.dev,short,,,\nhttps://go.dev,g0d3v000,many,,\nhttps://go.dev,g0d3v000,,\nhttps://go.dev,g0d3v001,,,\nhttps://go.dev/2,g0d3v001,,,\n",
+			expectedActions: []string{entities.ImportFailed, entities.ImportFailed, entities.ImportFailed, entities.ImportCreated,
+				entities.ImportFailed},
+			expectedUrls: 3,
+		},
+		{
+			name:            "conflict skip",
+			options:         entities.ImportOptions{Format: entities.FormatCSV, Conflict: entities.ConflictSkip},
+			input:           csvHeader + "https://google.ro,84gfj4i9,,,\nhttps://go.dev,g0d3v000,,,\n",
+			expectedActions: []string{entities.ImportSkipped, entities.ImportCreated},
+			expectedUrls:    3,
+		},
+		{
+			name:            "conflict overwrite",
+			options:         entities.ImportOptions{Format: entities.FormatCSV, Conflict: entities.ConflictOverwrite},
+			input:           csvHeader + "https://google.ro,84gfj4i9,,,\nhttps://go.dev,g0d3v000,,,\n",
+			expectedActions: []string{entities.ImportOverwritten, entities.ImportCreated},
+			expectedUrls:    3,
+		},
+		{
+			name:            "conflict fail",
+			options:         entities.ImportOptions{Format: entities.FormatCSV},
+			input:           csvHeader + "https://google.ro,84gfj4i9,,,\nhttps://go.dev,g0d3v000,,,\n",
+			expectedActions: []string{entities.ImportFailed, entities.ImportSkipped},
+			expectedUrls:    2,
+			expectedError:   ErrImportConflict,
+		},
+		{
+			name:            "dry run",
+			options:         entities.ImportOptions{Format: entities.FormatCSV, Conflict: entities.ConflictOverwrite, DryRun: true},
+			input:           csvHeader + "https://google.ro,84gfj4i9,,,\nhttps://go.dev,g0d3v000,,,\n",
+			expectedActions: []string{entities.ImportOverwritten, entities.ImportCreated},
+			expectedUrls:    2,
+		},
+		{
+			name:            "save error",
+			options:         entities.ImportOptions{Format: entities.FormatJSONL},
+			input:           `{"url":"http://www.invalidUrl.com"}` + "\n",
+			expectedActions: []string{entities.ImportFailed},
+			expectedUrls:    2,
+		},
+		{
+			name:          "no url column",
+			options:       entities.ImportOptions{Format: entities.FormatCSV},
+			input:         "code,counter\n84gfj4i9,1\n",
+			expectedError: ErrInvalidImport,
+			expectedUrls:  2,
+		},
+		{
+			name:          "unknown conflict policy",
+			options:       entities.ImportOptions{Format: entities.FormatCSV, Conflict: "merge"},
+			input:         csvHeader,
+			expectedError: ErrInvalidImport,
+			expectedUrls:  2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &TransferRepositoryMock{urls: transferUrls()}
+			s := NewService(r, CounterOptions{}, "http://localhost")
+
+			report, err := s.Import(context.Background(), strings.NewReader(tc.input), tc.options, testActor)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+
+			var actions []string
+			for _, res := range report.Results {
+				actions = append(actions, res.Action)
+			}
+
+			if !reflect.DeepEqual(actions, tc.expectedActions) {
+				t.Errorf("expected actions (%v), got (%v) (%+v)", tc.expectedActions, actions, report.Results)
+			}
+
+			if report.Total != len(tc.expectedActions) || report.DryRun != tc.options.DryRun {
+				t.Errorf("expected (%d) results, got report (%+v)", len(tc.expectedActions), report)
+			}
+
+			if len(r.urls) != tc.expectedUrls {
+				t.Errorf("expected (%d) urls, got (%d)", tc.expectedUrls, len(r.urls))
+			}
+		})
+	}
+}
+
+func TestImportedUrl(t *testing.T) {
+	r := &TransferRepositoryMock{}
+	s := NewService(r, CounterOptions{}, "http://short.ly")
+
+	input := "url,code,counter,createdAt,owner,tags,variants,deletedAt\n" +
+		`google.com,84gfj4i9,42,2022-01-01T10:00:00Z,apikey:1a2b3c,newsletter; launch,"[{""id"":10,""url"":""google.com/a"",""weight"":100,""counter"":40}]",2022-02-01T00:00:00Z` + "\n" +
+		"https://go.dev,,,,,,,\n"
+
+	report, err := s.Import(context.Background(), strings.NewReader(input), entities.ImportOptions{Format: entities.FormatCSV}, testActor)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if report.Created != 2 || report.Results[0].Line != 2 || report.Results[1].Line != 3 {
+		t.Fatalf("expected 2 created urls on lines (2, 3), got (%+v)", report)
+	}
+
+	u := r.urls[0]
+	expected := entities.Url{
+		Id:           1,
+		Code:         "84gfj4i9",
+		Url:          "http://google.com",
+		ShortUrl:     "http://short.ly/84gfj4i9",
+		Domain:       "http://short.ly",
+		Counter:      42,
+		CreatedAt:    time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC),
+		RedirectType: entities.RedirectFound,
+		Variants:     []entities.Variant{{Url: "http://google.com/a", Weight: 100, Counter: 40}},
+		Owner:        "apikey:1a2b3c",
+		Tags:         []string{"newsletter", "launch"},
+	}
+	if !reflect.DeepEqual(u, expected) {
+		t.Errorf("expected url (%+v), got (%+v)", expected, u)
+	}
+
+	generated := r.urls[1]
+	if len(generated.Code) != 8 || generated.Code != report.Results[1].Code || generated.Owner != testActor.Name || generated.CreatedAt.IsZero() {
+		t.Errorf("expected a generated code, the actor owner and a creation time, got (%+v)", generated)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []string{entities.FormatCSV, entities.FormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			src := NewService(&TransferRepositoryMock{urls: transferUrls()}, CounterOptions{}, "http://localhost")
+
+			var buf bytes.Buffer
+			if _, err := src.Export(context.Background(), entities.ExportFilter{}, format, &buf); err != nil {
+				t.Fatalf("unable to export: %s", err.Error())
+			}
+
+			r := &TransferRepositoryMock{}
+			dst := NewService(r, CounterOptions{}, "http://localhost")
+			if _, err := dst.Import(context.Background(), &buf, entities.ImportOptions{Format: format}, testActor); err != nil {
+				t.Fatalf("unable to import: %s", err.Error())
+			}
+
+			// the imported variants get new ids
+			expected := transferUrls()
+			expected[0].Variants[0].Id = 0
+			if !reflect.DeepEqual(r.urls, expected) {
+				t.Errorf("expected urls (%+v), got (%+v)", expected, r.urls)
+			}
+		})
+	}
+}
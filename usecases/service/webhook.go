@@ -0,0 +1,353 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/norby7/shortening-service/entities"
+	"github.com/norby7/shortening-service/usecases/repository"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// headers of the webhook deliveries
+const (
+	// WebhookIdHeader is the id of the event, the retries and replays of an event have the same id
+	WebhookIdHeader = "X-Webhook-Id"
+	// WebhookEventHeader is the type of the event
+	WebhookEventHeader = "X-Webhook-Event"
+	// WebhookTimestampHeader is the unix time of the delivery, it's part of the signed content
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	// WebhookSignatureHeader is "sha256=" followed by the hex HMAC-SHA256 of the timestamp, a dot and the body,
+	// keyed with the webhook secret
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// DefaultWebhookWorkers is the number of concurrent deliveries when the options have no workers
+const DefaultWebhookWorkers = 4
+
+// DefaultWebhookQueueSize is the number of deliveries waiting for a worker when the options have no queue size
+const DefaultWebhookQueueSize = 1000
+
+// DefaultWebhookMaxAttempts is the number of deliveries of an event before it's dead lettered when the options have no attempts
+const DefaultWebhookMaxAttempts = 5
+
+// DefaultWebhookRetryBackoff is the wait before the first retry when the options have no backoff, it doubles on every retry
+const DefaultWebhookRetryBackoff = time.Second
+
+// DefaultWebhookMaxBackoff is the longest wait between two retries when the options have no maximum backoff
+const DefaultWebhookMaxBackoff = time.Minute
+
+// DefaultWebhookTimeout is the maximum duration of a delivery when the options have no timeout
+const DefaultWebhookTimeout = 5 * time.Second
+
+// webhookCacheTTL is the time the webhooks are kept in memory before they are fetched again, the webhooks added or
+// deleted by this instance are seen right away
+const webhookCacheTTL = 10 * time.Second
+
+// webhookMetrics are the webhook metrics published by expvar: queued and dropped deliveries, delivered events,
+// failed deliveries, dead lettered events and replayed dead letters
+var webhookMetrics = expvar.NewMap("webhooks")
+
+// WebhookOptions configure how the webhook events are delivered, the zero values use the defaults
+type WebhookOptions struct {
+	// number of concurrent deliveries
+	Workers int
+	// size of the deliveries queue, the events are dropped when it's full
+	QueueSize int
+	// number of deliveries of an event before it's dead lettered
+	MaxAttempts int
+	// wait before the first retry, doubled on every retry up to MaxBackoff
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	// maximum duration of a delivery
+	Timeout time.Duration
+}
+
+// WebhookDispatcher posts the url events to the webhooks subscribed to them, in the background
+// Every event is signed with the secret of its webhook and retried with an exponential backoff, the events that
+// can't be delivered after every attempt are saved as dead letters that can be replayed
+type WebhookDispatcher struct {
+	repo       repository.Repository
+	options    WebhookOptions
+	client     *http.Client
+	deliveries chan webhookDelivery
+	// mu guards closed, Dispatch holds it for reading so the deliveries are never sent on the closed channel
+	mu     sync.RWMutex
+	closed bool
+	// closed on Close so the deliveries waiting for a retry are dead lettered instead
+	stop chan struct{}
+	wg   sync.WaitGroup
+	// cacheMu guards the cached webhooks and the time they were fetched
+	cacheMu   sync.Mutex
+	webhooks  []entities.Webhook
+	fetchedAt time.Time
+}
+
+// webhookDelivery is an event to post to a webhook
+type webhookDelivery struct {
+	webhook entities.Webhook
+	event   entities.WebhookEvent
+}
+
+// NewWebhookDispatcher returns a WebhookDispatcher with its workers started, Close stops them
+func NewWebhookDispatcher(r repository.Repository, o WebhookOptions) *WebhookDispatcher {
+	if o.Workers <= 0 {
+		o.Workers = DefaultWebhookWorkers
+	}
+
+	if o.QueueSize <= 0 {
+		o.QueueSize = DefaultWebhookQueueSize
+	}
+
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultWebhookMaxAttempts
+	}
+
+	if o.RetryBackoff <= 0 {
+		o.RetryBackoff = DefaultWebhookRetryBackoff
+	}
+
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = DefaultWebhookMaxBackoff
+	}
+
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultWebhookTimeout
+	}
+
+	d := &WebhookDispatcher{
+		repo:       r,
+		options:    o,
+		client:     &http.Client{Timeout: o.Timeout},
+		deliveries: make(chan webhookDelivery, o.QueueSize),
+		stop:       make(chan struct{}),
+	}
+
+	d.wg.Add(o.Workers)
+	for i := 0; i < o.Workers; i++ {
+		go d.worker()
+	}
+
+	return d
+}
+
+// Subscribed checks if a webhook is sent the events of the given type
+func (d *WebhookDispatcher) Subscribed(ctx context.Context, eventType string) bool {
+	return len(d.subscribers(ctx, eventType)) > 0
+}
+
+// Dispatch queues the event for every webhook subscribed to its type, it doesn't wait for the deliveries
+// The event is dropped for a webhook when the queue is full or after the dispatcher is closed
+func (d *WebhookDispatcher) Dispatch(ctx context.Context, e entities.WebhookEvent) {
+	webhooks := d.subscribers(ctx, e.Type)
+	if len(webhooks) == 0 {
+		return
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for _, w := range webhooks {
+		if d.closed {
+			webhookMetrics.Add("dropped", 1)
+			continue
+		}
+
+		select {
+		case d.deliveries <- webhookDelivery{webhook: w, event: e}:
+			webhookMetrics.Add("queued", 1)
+		default:
+			webhookMetrics.Add("dropped", 1)
+			slog.WarnContext(ctx, "webhook queue is full, the event is dropped", "webhook", w.Id, "event", e.Id, "type", e.Type)
+		}
+	}
+}
+
+// Send posts the event to the webhook once and returns an error if the webhook doesn't answer with a 2xx status
+func (d *WebhookDispatcher) Send(ctx context.Context, w entities.Webhook, e entities.WebhookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("unable to encode the event: %s", err.Error())
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to create the request: %s", err.Error())
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIdHeader, e.Id)
+	req.Header.Set(WebhookEventHeader, e.Type)
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(w.Secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// the body is drained so the connection is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	_ = res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status (%d)", res.StatusCode)
+	}
+
+	return nil
+}
+
+// Invalidate drops the cached webhooks, the next event fetches them again
+func (d *WebhookDispatcher) Invalidate() {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+
+	d.webhooks = nil
+	d.fetchedAt = time.Time{}
+}
+
+// Close stops accepting events and waits until the queued deliveries are done or the context is done
+// The deliveries waiting for a retry, or failing once the dispatcher is closed, are dead lettered
+func (d *WebhookDispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.stop)
+		close(d.deliveries)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("unable to deliver the queued webhook events: %s", ctx.Err().Error())
+	}
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of the timestamp, a dot and the body keyed with the secret, the receivers
+// compute it to check the WebhookSignatureHeader
+func SignWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// subscribers returns the webhooks subscribed to the event type, from the cache if it's not older than webhookCacheTTL
+// The previous webhooks are used when they can't be fetched
+func (d *WebhookDispatcher) subscribers(ctx context.Context, eventType string) []entities.Webhook {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+
+	if d.fetchedAt.IsZero() || time.Since(d.fetchedAt) > webhookCacheTTL {
+		webhooks, err := d.repo.GetWebhooks(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "unable to fetch the webhooks", "error", err.Error())
+		} else {
+			d.webhooks = webhooks
+			d.fetchedAt = time.Now()
+		}
+	}
+
+	var subscribers []entities.Webhook
+	for _, w := range d.webhooks {
+		if w.Subscribes(eventType) {
+			subscribers = append(subscribers, w)
+		}
+	}
+
+	return subscribers
+}
+
+// worker delivers the queued events until the queue is closed
+func (d *WebhookDispatcher) worker() {
+	defer d.wg.Done()
+
+	for dl := range d.deliveries {
+		d.deliver(dl)
+	}
+}
+
+// deliver posts the event until it succeeds or MaxAttempts deliveries failed, waiting for the backoff between two
+// attempts, and saves the event as a dead letter if it couldn't be delivered
+func (d *WebhookDispatcher) deliver(dl webhookDelivery) {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), d.options.Timeout)
+		err := d.Send(ctx, dl.webhook, dl.event)
+		cancel()
+
+		if err == nil {
+			webhookMetrics.Add("delivered", 1)
+			return
+		}
+
+		webhookMetrics.Add("failed", 1)
+		slog.Warn("unable to deliver the webhook event", "webhook", dl.webhook.Id, "event", dl.event.Id, "attempt", attempt, "error", err.Error())
+
+		if attempt >= d.options.MaxAttempts {
+			d.deadLetter(dl, attempt, err)
+			return
+		}
+
+		select {
+		case <-time.After(d.backoff(attempt)):
+		case <-d.stop:
+			d.deadLetter(dl, attempt, err)
+			return
+		}
+	}
+}
+
+// backoff returns the wait after the given failed attempt, RetryBackoff doubled on every attempt up to MaxBackoff
+func (d *WebhookDispatcher) backoff(attempt int) time.Duration {
+	b := d.options.RetryBackoff
+	for i := 1; i < attempt && b < d.options.MaxBackoff; i++ {
+		b *= 2
+	}
+
+	if b > d.options.MaxBackoff {
+		b = d.options.MaxBackoff
+	}
+
+	return b
+}
+
+// deadLetter saves the event that couldn't be delivered after the given attempts
+// The dead letter doesn't depend on a request so it only uses the repository timeouts
+func (d *WebhookDispatcher) deadLetter(dl webhookDelivery, attempts int, cause error) {
+	letter := &entities.DeadLetter{
+		WebhookId: dl.webhook.Id,
+		Event:     dl.event,
+		Attempts:  attempts,
+		LastError: cause.Error(),
+		FailedAt:  time.Now().UTC(),
+	}
+
+	if err := d.repo.AddDeadLetter(context.Background(), letter); err != nil {
+		slog.Error("unable to save the webhook dead letter", "webhook", dl.webhook.Id, "event", dl.event.Id, "error", err.Error())
+		return
+	}
+
+	webhookMetrics.Add("deadLetters", 1)
+}
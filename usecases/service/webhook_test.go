@@ -0,0 +1,458 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/norby7/shortening-service/entities"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// WebhookRepositoryMock keeps the webhooks and dead letters in memory, GetCounters returns the counters field
+type WebhookRepositoryMock struct {
+	RepositoryMock
+	mu          sync.Mutex
+	webhooks    []entities.Webhook
+	deadLetters []entities.DeadLetter
+	counters    map[string]int64
+}
+
+func (r *WebhookRepositoryMock) AddWebhook(ctx context.Context, w *entities.Webhook) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w.Id = int64(len(r.webhooks) + 1)
+	r.webhooks = append(r.webhooks, *w)
+
+	return nil
+}
+
+func (r *WebhookRepositoryMock) GetWebhooks(ctx context.Context) ([]entities.Webhook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]entities.Webhook(nil), r.webhooks...), nil
+}
+
+func (r *WebhookRepositoryMock) DeleteWebhook(ctx context.Context, id int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, w := range r.webhooks {
+		if w.Id == id {
+			r.webhooks = append(r.webhooks[:i], r.webhooks[i+1:]...)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (r *WebhookRepositoryMock) AddDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d.Id = int64(len(r.deadLetters) + 1)
+	r.deadLetters = append(r.deadLetters, *d)
+
+	return nil
+}
+
+func (r *WebhookRepositoryMock) UpdateDeadLetter(ctx context.Context, d *entities.DeadLetter) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.deadLetters {
+		if r.deadLetters[i].Id == d.Id {
+			r.deadLetters[i] = *d
+		}
+	}
+
+	return nil
+}
+
+func (r *WebhookRepositoryMock) GetDeadLetter(ctx context.Context, id int64) (entities.DeadLetter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, d := range r.deadLetters {
+		if d.Id == id {
+			return d, nil
+		}
+	}
+
+	return entities.DeadLetter{}, nil
+}
+
+func (r *WebhookRepositoryMock) GetDeadLetters(ctx context.Context, webhookId int64) ([]entities.DeadLetter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]entities.DeadLetter(nil), r.deadLetters...), nil
+}
+
+func (r *WebhookRepositoryMock) DeleteDeadLetter(ctx context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, d := range r.deadLetters {
+		if d.Id == id {
+			r.deadLetters = append(r.deadLetters[:i], r.deadLetters[i+1:]...)
+		}
+	}
+
+	return nil
+}
+
+func (r *WebhookRepositoryMock) GetCounters(ctx context.Context, codes []string) (map[string]int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counters := make(map[string]int64)
+	for _, code := range codes {
+		if n, ok := r.counters[code]; ok {
+			counters[code] = n
+		}
+	}
+
+	return counters, nil
+}
+
+// deadLetterCount returns the number of saved dead letters
+func (r *WebhookRepositoryMock) deadLetterCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.deadLetters)
+}
+
+// webhookReceiver is an httptest server that checks the signature of the deliveries and sends the received events
+// on its events channel, it answers with its status
+type webhookReceiver struct {
+	*httptest.Server
+	events chan entities.WebhookEvent
+	mu     sync.Mutex
+	status int
+}
+
+func newWebhookReceiver(t *testing.T, secret string) *webhookReceiver {
+	r := &webhookReceiver{events: make(chan entities.WebhookEvent, 10), status: http.StatusOK}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+
+		expected := "sha256=" + SignWebhook(secret, req.Header.Get(WebhookTimestampHeader), body)
+		if got := req.Header.Get(WebhookSignatureHeader); got != expected {
+			t.Errorf("expected signature (%s), got (%s)", expected, got)
+		}
+
+		var e entities.WebhookEvent
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Errorf("unable to decode the event: %s", err.Error())
+		}
+
+		if req.Header.Get(WebhookIdHeader) != e.Id || req.Header.Get(WebhookEventHeader) != e.Type {
+			t.Errorf("expected the event headers to match the event (%+v)", e)
+		}
+
+		r.mu.Lock()
+		status := r.status
+		r.mu.Unlock()
+
+		w.WriteHeader(status)
+		r.events <- e
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *webhookReceiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = status
+}
+
+// next returns the next received event, it fails the test if none is received in time
+func (r *webhookReceiver) next(t *testing.T) entities.WebhookEvent {
+	select {
+	case e := <-r.events:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected a webhook event")
+	}
+
+	return entities.WebhookEvent{}
+}
+
+// webhookService returns a service with its webhooks started and a webhook of the receiver subscribed to the events
+func webhookService(t *testing.T, r *WebhookRepositoryMock, o WebhookOptions, events ...string) (*Service, *webhookReceiver) {
+	receiver := newWebhookReceiver(t, "s3cr3t")
+
+	s := NewService(r, CounterOptions{FlushInterval: time.Hour}, "http://localhost")
+	s.StartWebhooks(o)
+	t.Cleanup(func() { _ = s.Close(context.Background()) })
+
+	if err := s.AddWebhook(context.Background(), &entities.Webhook{Url: receiver.URL, Secret: "s3cr3t", Events: events}); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, receiver
+}
+
+func TestWebhookEvents(t *testing.T) {
+	r := &WebhookRepositoryMock{}
+	s, receiver := webhookService(t, r, WebhookOptions{}, entities.WebhookEventTypes...)
+	ctx := context.Background()
+
+	u := &entities.Url{Url: "https://google.com"}
+	if err := s.Create(ctx, u, testActor); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := receiver.next(t); e.Type != entities.WebhookUrlCreated || e.Url.Code != u.Code {
+		t.Errorf("expected a url.created event for (%s), got (%+v)", u.Code, e)
+	}
+
+	if err := s.Delete(ctx, 1, testActor); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := receiver.next(t); e.Type != entities.WebhookUrlDeleted || e.Url.Code != "84gfj4i9" {
+		t.Errorf("expected a url.deleted event for (84gfj4i9), got (%+v)", e)
+	}
+
+	// the mock has no click left after every consumed click
+	if err := s.ConsumeClick(ctx, entities.Click{Code: "84gfj4i9"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := receiver.next(t); e.Type != entities.WebhookUrlExpired || e.Url.Id != 1 {
+		t.Errorf("expected a url.expired event for (1), got (%+v)", e)
+	}
+}
+
+func TestWebhookMilestones(t *testing.T) {
+	r := &WebhookRepositoryMock{counters: map[string]int64{"84gfj4i9": 1002, "other123": 50}}
+	s, receiver := webhookService(t, r, WebhookOptions{}, entities.WebhookUrlMilestone)
+
+	s.clicksFlushed(map[entities.Click]int64{
+		{Code: "84gfj4i9"}:                3,
+		{Code: "84gfj4i9", VariantId: 10}: 2,
+		{Code: "other123"}:                10,
+	})
+
+	e := receiver.next(t)
+	if e.Type != entities.WebhookUrlMilestone || e.Url.Code != "84gfj4i9" || e.Milestone != 1000 {
+		t.Errorf("expected the 1000 milestone of (84gfj4i9), got (%+v)", e)
+	}
+
+	select {
+	case e = <-receiver.events:
+		t.Errorf("expected a single milestone event, got (%+v)", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	r := &WebhookRepositoryMock{}
+	s, receiver := webhookService(t, r, WebhookOptions{MaxAttempts: 3, RetryBackoff: 50 * time.Millisecond}, entities.WebhookUrlCreated)
+	receiver.setStatus(http.StatusInternalServerError)
+
+	if err := s.Create(context.Background(), &entities.Url{Url: "https://google.com"}, testActor); err != nil {
+		t.Fatal(err)
+	}
+
+	first := receiver.next(t)
+	if retried := receiver.next(t); retried.Id != first.Id {
+		t.Errorf("expected the retry to send the event (%s), got (%s)", first.Id, retried.Id)
+	}
+
+	receiver.setStatus(http.StatusNoContent)
+	receiver.next(t)
+
+	if n := r.deadLetterCount(); n != 0 {
+		t.Errorf("expected no dead letter after a successful retry, got (%d)", n)
+	}
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	r := &WebhookRepositoryMock{}
+	s, receiver := webhookService(t, r, WebhookOptions{MaxAttempts: 2, RetryBackoff: time.Millisecond}, entities.WebhookUrlCreated)
+	receiver.setStatus(http.StatusBadGateway)
+	ctx := context.Background()
+
+	if err := s.Create(ctx, &entities.Url{Url: "https://google.com"}, testActor); err != nil {
+		t.Fatal(err)
+	}
+
+	event := receiver.next(t)
+	receiver.next(t)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.deadLetterCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a dead letter after every attempt failed")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	letters, _ := s.GetDeadLetters(ctx, 0)
+	if letters[0].Attempts != 2 || letters[0].Event.Id != event.Id || letters[0].LastError == "" {
+		t.Fatalf("unexpected dead letter (%+v)", letters[0])
+	}
+
+	// a failed replay is counted in the dead letter
+	if err := s.ReplayDeadLetter(ctx, letters[0].Id); !errors.Is(err, ErrWebhookDeliveryFailed) {
+		t.Errorf("expected error (%v), got (%v)", ErrWebhookDeliveryFailed, err)
+	}
+	receiver.next(t)
+
+	if l, _ := r.GetDeadLetter(ctx, letters[0].Id); l.Attempts != 3 {
+		t.Errorf("expected the failed replay to be counted, got (%d) attempts", l.Attempts)
+	}
+
+	receiver.setStatus(http.StatusOK)
+	if err := s.ReplayDeadLetter(ctx, letters[0].Id); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if replayed := receiver.next(t); replayed.Id != event.Id {
+		t.Errorf("expected the replay to send the event (%s), got (%s)", event.Id, replayed.Id)
+	}
+
+	if n := r.deadLetterCount(); n != 0 {
+		t.Errorf("expected the replayed dead letter to be removed, got (%d)", n)
+	}
+
+	if err := s.ReplayDeadLetter(ctx, letters[0].Id); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("expected error (%v), got (%v)", ErrDeadLetterNotFound, err)
+	}
+}
+
+func TestWebhookCloseDeadLetters(t *testing.T) {
+	r := &WebhookRepositoryMock{}
+	s, receiver := webhookService(t, r, WebhookOptions{MaxAttempts: 5, RetryBackoff: time.Hour}, entities.WebhookUrlCreated)
+	receiver.setStatus(http.StatusServiceUnavailable)
+
+	if err := s.Create(context.Background(), &entities.Url{Url: "https://google.com"}, testActor); err != nil {
+		t.Fatal(err)
+	}
+
+	receiver.next(t)
+
+	// the delivery waiting for its retry is dead lettered on close
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("expected no error, got (%v)", err)
+	}
+
+	if n := r.deadLetterCount(); n != 1 {
+		t.Errorf("expected the pending delivery to be dead lettered, got (%d)", n)
+	}
+}
+
+func TestAddWebhook(t *testing.T) {
+	testCases := []struct {
+		name          string
+		input         entities.Webhook
+		expectedError error
+	}{
+		{name: "valid webhook", input: entities.Webhook{Url: "https://hooks.example.com", Events: []string{entities.WebhookUrlCreated}}},
+		{name: "invalid url", input: entities.Webhook{Url: "hooks.example.com", Events: []string{entities.WebhookUrlCreated}}, expectedError: ErrInvalidWebhook},
+		{name: "unknown event", input: entities.Webhook{Url: "https://hooks.example.com", Events: []string{"url.updated"}}, expectedError: ErrInvalidWebhook},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &WebhookRepositoryMock{}
+			s := NewService(r, CounterOptions{}, "http://localhost")
+
+			err := s.AddWebhook(context.Background(), &tc.input)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if tc.input.Id == 0 || tc.input.Secret == "" {
+				t.Errorf("expected the saved webhook with a generated secret, got (%+v)", tc.input)
+			}
+
+			webhooks, _ := s.GetWebhooks(context.Background())
+			if len(webhooks) != 1 || webhooks[0].Secret != "" {
+				t.Errorf("expected the webhook without its secret, got (%+v)", webhooks)
+			}
+		})
+	}
+}
+
+func TestDeleteWebhook(t *testing.T) {
+	r := &WebhookRepositoryMock{webhooks: []entities.Webhook{{Id: 1, Url: "https://hooks.example.com"}}}
+	s := NewService(r, CounterOptions{}, "http://localhost")
+
+	testCases := []struct {
+		name          string
+		input         int64
+		expectedError error
+	}{
+		{name: "existing webhook", input: 1},
+		{name: "already deleted", input: 1, expectedError: ErrWebhookNotFound},
+		{name: "unknown webhook", input: 7, expectedError: ErrWebhookNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := s.DeleteWebhook(context.Background(), tc.input); !errors.Is(err, tc.expectedError) {
+				t.Errorf("expected error (%v), got (%v)", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestReplayDeadLetterDisabled(t *testing.T) {
+	s := NewService(&WebhookRepositoryMock{}, CounterOptions{}, "http://localhost")
+
+	if err := s.ReplayDeadLetter(context.Background(), 1); !errors.Is(err, ErrWebhooksDisabled) {
+		t.Errorf("expected error (%v), got (%v)", ErrWebhooksDisabled, err)
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	d := &WebhookDispatcher{options: WebhookOptions{RetryBackoff: time.Second, MaxBackoff: 10 * time.Second}}
+
+	testCases := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 1, expected: time.Second},
+		{attempt: 2, expected: 2 * time.Second},
+		{attempt: 4, expected: 8 * time.Second},
+		{attempt: 5, expected: 10 * time.Second},
+		{attempt: 60, expected: 10 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		if got := d.backoff(tc.attempt); got != tc.expected {
+			t.Errorf("expected backoff (%s) after attempt (%d), got (%s)", tc.expected, tc.attempt, got)
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// HMAC-SHA256 of "1700000000.{}" keyed with "s3cr3t"
+	expected := "dd8508e44d9a9f82f2690fb7dff1da8a6ae99700d98a23a4e7e1c307af3cb6cb"
+	if got := SignWebhook("s3cr3t", "1700000000", []byte("{}")); got != expected {
+		t.Errorf("expected signature (%s), got (%s)", expected, got)
+	}
+}